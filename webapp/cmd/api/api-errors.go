@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"webapp/pkg/repository"
+	"webapp/pkg/validator"
+)
+
+// problemContentType is the media type of RFC 7807 problem details
+const problemContentType = "application/problem+json"
+
+// apiError is an error whose code and message are safe to send to clients. Any other error is
+// answered with a generic message, so that database and library internals never leak.
+type apiError struct {
+	Code    string
+	Message string
+	Err     error
+}
+
+func newAPIError(code, message string) *apiError {
+	return &apiError{Code: code, Message: message}
+}
+
+func (e *apiError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Err)
+	}
+
+	return e.Message
+}
+
+func (e *apiError) Unwrap() error {
+	return e.Err
+}
+
+// wrap returns a copy of e that keeps err as the cause, for logging only
+func (e *apiError) wrap(err error) *apiError {
+	return &apiError{Code: e.Code, Message: e.Message, Err: err}
+}
+
+// Is lets errors.Is match wrapped copies of the same error
+func (e *apiError) Is(target error) bool {
+	t, ok := target.(*apiError)
+	return ok && t.Code == e.Code
+}
+
+var (
+	errInvalidCredentials = newAPIError("invalid_credentials", "invalid email or password")
+	errInvalidToken       = newAPIError("invalid_token", "the token is invalid or has expired")
+	errTokenNotDue        = newAPIError("token_not_due", "refresh token does not need renewed yet")
+	errNoRefreshCookie    = newAPIError("no_refresh_cookie", "no refresh cookie found")
+	errUnknownUser        = newAPIError("unknown_user", "unknown user")
+	errInvalidUserID      = newAPIError("invalid_id", "the user id must be a number")
+	errValidation         = newAPIError("validation_failed", "the request contains invalid fields")
+	errUserNotFound       = newAPIError("not_found", "the user does not exist")
+	errDuplicateEmail     = newAPIError("duplicate_email", "a user with this email address already exists")
+	errConflict           = newAPIError("conflict", "the request conflicts with the current state of the data")
+	errVersionMismatch    = newAPIError("precondition_failed", "the user was modified in the meantime; fetch it again and retry")
+	errInvalidIfMatch     = newAPIError("invalid_if_match", `If-Match must be "*" or the quoted version of the record`)
+	errUnavailable        = newAPIError("service_unavailable", "the service is temporarily unavailable, try again later")
+	errAdminRequired      = newAPIError("admin_required", "only admins may do this")
+	errTooManyRequests    = newAPIError("too_many_requests", "too many requests, retry after the delay in Retry-After")
+	errInsufficientScope  = newAPIError("insufficient_scope", "the API key or token was not granted the scope this request requires")
+
+	errNoBearerToken    = newAPIError("no_token", "the Authorization header must carry a Bearer token")
+	errTokenMalformed   = newAPIError("token_malformed", "the token is not a JWT")
+	errTokenSignature   = newAPIError("token_signature_invalid", "the token was not signed by this api")
+	errTokenType        = newAPIError("token_type_invalid", "the token is not of the type expected here")
+	errTokenIssuer      = newAPIError("token_issuer_invalid", "the token was not issued by this api")
+	errTokenAudience    = newAPIError("token_audience_invalid", "the token is not meant for this api")
+	errTokenClaims      = newAPIError("token_claims_missing", "the token lacks its exp, iat or jti claim")
+	errTokenExpired     = newAPIError("token_expired", "the token has expired")
+	errTokenNotValidYet = newAPIError("token_not_valid_yet", "the token is not valid yet")
+	errTokenRevoked     = newAPIError("token_revoked", "the token was revoked; log in again")
+	errTokenRequired    = newAPIError("token_required", "only the requests made with a token can log out")
+
+	errInvalidServiceAccountID = newAPIError("invalid_id", "the service account id must be a number")
+	errInvalidAPIKeyID         = newAPIError("invalid_id", "the API key id must be a number")
+	errServiceAccountNotFound  = newAPIError("not_found", "the service account does not exist")
+	errAPIKeyNotFound          = newAPIError("not_found", "the API key does not exist or was revoked")
+	errDuplicateServiceAccount = newAPIError("duplicate_name", "a service account with this name already exists")
+	errInvalidAPIKey           = newAPIError("invalid_api_key", "the API key is malformed, unknown, expired or revoked")
+
+	errSSOFailed        = newAPIError("sso_failed", "single sign-on failed; start again from /auth/oidc")
+	errEmailNotVerified = newAPIError("email_not_verified", "the identity provider has not verified the email address")
+	errAccountDeleted   = newAPIError("account_deleted", "the account linked to this identity was deleted; an admin can restore it")
+
+	errOAuthClientNotFound = newAPIError("not_found", "the OAuth client does not exist")
+	errUnknownClient       = newAPIError("invalid_client", "the client_id is not registered")
+	errInvalidRedirectURI  = newAPIError("invalid_redirect_uri", "the redirect_uri is not registered for this client")
+	errUserTokenRequired   = newAPIError("user_token_required", "only the user may do this, with a token of their own")
+
+	errUserRequired  = newAPIError("user_required", "only users have a profile; API keys and tokens of clients do not")
+	errWrongPassword = newAPIError("wrong_password", "the current password is wrong")
+	errInvalidUpload = newAPIError("invalid_upload", "post the picture as a multipart form of at most 5 MB")
+	errNotImage      = newAPIError("invalid_image", "the picture must be a PNG, JPEG, GIF or WebP image")
+)
+
+// repositoryErrorJSON maps the repository errors onto HTTP statuses: 404 for missing records,
+// 409 for duplicates and conflicts, 412 for stale versions, 503 when the database is unavailable and 500 otherwise
+func (app *application) repositoryErrorJSON(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		app.errorJSON(w, r, errUserNotFound.wrap(err), http.StatusNotFound)
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		app.errorJSON(w, r, errDuplicateEmail.wrap(err), http.StatusConflict)
+	case errors.Is(err, repository.ErrVersionMismatch):
+		app.errorJSON(w, r, errVersionMismatch.wrap(err), http.StatusPreconditionFailed)
+	case errors.Is(err, repository.ErrConflict):
+		app.errorJSON(w, r, errConflict.wrap(err), http.StatusConflict)
+	case errors.Is(err, repository.ErrUnavailable):
+		w.Header().Set("Retry-After", "5")
+		app.errorJSON(w, r, errUnavailable.wrap(err), http.StatusServiceUnavailable)
+	default:
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+// problem is an RFC 7807 problem details document, extended with a machine readable code and
+// the validation errors per field
+type problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Code   string       `json:"code"`
+	Errors []fieldError `json:"errors,omitempty"`
+}
+
+type fieldError struct {
+	Field   string         `json:"field"`
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Params  map[string]any `json:"params,omitempty"`
+}
+
+// newProblem maps err to the problem document sent for status
+func (app *application) newProblem(err error, status int) problem {
+	p := problem{
+		Title:  http.StatusText(status),
+		Status: status,
+	}
+
+	var apiErr *apiError
+	var validationErrors validator.Errors
+
+	switch {
+	case errors.As(err, &validationErrors):
+		p.Code = errValidation.Code
+		p.Detail = errValidation.Message
+		p.Errors = fieldErrors(validationErrors)
+
+	case errors.As(err, &apiErr):
+		p.Code = apiErr.Code
+		p.Detail = apiErr.Message
+
+	default:
+		p.Code = codeForStatus(status)
+		p.Detail = genericMessage(status)
+	}
+
+	p.Type = fmt.Sprintf("https://%s/problems/%s", app.Domain, strings.ReplaceAll(p.Code, "_", "-"))
+
+	return p
+}
+
+// fieldErrors flattens validation errors into a stable, sorted list
+func fieldErrors(errs validator.Errors) []fieldError {
+	var out []fieldError
+
+	for field, messages := range errs {
+		for _, m := range messages {
+			out = append(out, fieldError{
+				Field:   field,
+				Code:    m.Key,
+				Message: m.String(),
+				Params:  m.Params,
+			})
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Field < out[j].Field })
+
+	return out
+}
+
+// codeForStatus turns a status into a code such as not_found
+func codeForStatus(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+}
+
+// genericMessage is used for errors which are not meant for clients
+func genericMessage(status int) string {
+	if status >= http.StatusInternalServerError {
+		return "the server encountered a problem and could not process your request"
+	}
+
+	return "the request could not be processed"
+}
+
+// logError records errors that are hidden from the client
+func (app *application) logError(r *http.Request, err error, status int) {
+	var apiErr *apiError
+	var validationErrors validator.Errors
+
+	if errors.As(err, &validationErrors) {
+		return
+	}
+
+	if errors.As(err, &apiErr) && status < http.StatusInternalServerError {
+		return
+	}
+
+	slog.ErrorContext(r.Context(), "api error", "status", status, "error", err)
+}
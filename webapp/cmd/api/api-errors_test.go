@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/validator"
+)
+
+func Test_api_app_errorJSON(t *testing.T) {
+
+	validationErrors := validator.Errors{}
+	validationErrors.Add("email", "form.email")
+
+	testCases := []struct {
+		name           string
+		err            error
+		status         int
+		expectedCode   string
+		expectedDetail string
+		expectedFields int
+	}{
+		{"client error", errUnknownUser, http.StatusBadRequest, "unknown_user", "unknown user", 0},
+		{"wrapped client error", errInvalidToken.wrap(errors.New("signature is invalid")), http.StatusBadRequest, "invalid_token", errInvalidToken.Message, 0},
+		{"validation errors", validationErrors, http.StatusBadRequest, "validation_failed", errValidation.Message, 1},
+		{"internal error", errors.New(`pq: relation "users" does not exist`), http.StatusInternalServerError, "internal_server_error", genericMessage(http.StatusInternalServerError), 0},
+		{"unknown error with client status", errors.New("sql: no rows in result set"), http.StatusNotFound, "not_found", genericMessage(http.StatusNotFound), 0},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			app.errorJSON(rr, httptest.NewRequest(http.MethodGet, "/", nil), tt.err, tt.status)
+
+			if rr.Code != tt.status {
+				t.Errorf("expect status %d; got %d", tt.status, rr.Code)
+			}
+
+			if rr.Header().Get("Content-Type") != problemContentType {
+				t.Errorf("expect content type %s; got %s", problemContentType, rr.Header().Get("Content-Type"))
+			}
+
+			var p problem
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Code != tt.expectedCode {
+				t.Errorf("expect code %s; got %s", tt.expectedCode, p.Code)
+			}
+
+			if p.Detail != tt.expectedDetail {
+				t.Errorf("expect detail %q; got %q", tt.expectedDetail, p.Detail)
+			}
+
+			if p.Status != tt.status || p.Title != http.StatusText(tt.status) || p.Type == "" {
+				t.Errorf("incomplete problem document: %+v", p)
+			}
+
+			if len(p.Errors) != tt.expectedFields {
+				t.Errorf("expect %d field errors; got %v", tt.expectedFields, p.Errors)
+			}
+		})
+	}
+}
+
+func Test_api_app_readJSON_errors(t *testing.T) {
+
+	testCases := []struct {
+		name         string
+		body         string
+		expectedCode string
+	}{
+		{"badly formed", `{"email": }`, "invalid_json"},
+		{"unexpected end", `{"email": "a@b.com"`, "invalid_json"},
+		{"wrong type", `{"email": 1}`, "invalid_json"},
+		{"empty", ``, "invalid_json"},
+		{"unknown field", `{"foo": "bar"}`, "unknown_field"},
+		{"two values", `{"email": "a@b.com"}{}`, "invalid_json"},
+		{"too large", `{"email": "` + strings.Repeat("a", 1024*1024) + `"}`, "body_too_large"},
+		{"invalid data", `{"email": "not-an-email"}`, "validation_failed"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+
+			var user data.User
+			err := app.readJSON(rr, req, &user)
+			if err == nil {
+				t.Fatal("expect an error")
+			}
+
+			if p := app.newProblem(err, http.StatusBadRequest); p.Code != tt.expectedCode {
+				t.Errorf("expect code %s; got %s (%s)", tt.expectedCode, p.Code, err)
+			}
+		})
+	}
+}
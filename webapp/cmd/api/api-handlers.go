@@ -2,13 +2,14 @@ package main
 
 import (
 	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/repository"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v4"
 )
 
 type Credentials struct {
@@ -21,40 +22,40 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
 	err := app.readJSON(w, r, &creds)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	user, err := app.DB.GetUserByEmail(creds.Username)
-	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+	user, err := app.DB.GetUserByEmail(r.Context(), creds.Username)
+	if errors.Is(err, repository.ErrUnavailable) {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	} else if err != nil {
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed, Email: creds.Username})
+		app.Metrics.AuthAttempt(false)
+		app.errorJSON(w, r, errInvalidCredentials.wrap(err), http.StatusUnauthorized)
 		return
 	}
 
 	if matches, err := user.PasswordMatches(creds.Password); err != nil || !matches {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed, TargetID: user.ID, Email: creds.Username})
+		app.Metrics.AuthAttempt(false)
+		app.errorJSON(w, r, errInvalidCredentials, http.StatusUnauthorized)
 		return
 	}
 
 	tokenPairs, err := app.generateTokenPair(user)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized: token error"), http.StatusUnauthorized)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "__Host-refresh_token",
-		Path:     "/",
-		Value:    tokenPairs.RefreshToken,
-		Expires:  time.Now().Add(refreshTokenExpiry),
-		MaxAge:   int(refreshTokenExpiry.Seconds()),
-		SameSite: http.SameSiteNoneMode,
-		Domain:   "localhost",
-		HttpOnly: true,
-		Secure:   true,
-	})
+	app.audit(r, data.AuditEvent{Action: data.AuditLogin, ActorID: user.ID, TargetID: user.ID, Email: user.Email})
+	app.Metrics.AuthAttempt(true)
 
-	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
+	http.SetCookie(w, refreshCookie(tokenPairs.RefreshToken, http.SameSiteNoneMode))
+
+	_ = app.writeJSON(w, r, http.StatusOK, tokenPairs)
 
 }
 
@@ -68,53 +69,44 @@ func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 
 	refreshToken := r.Form.Get("refresh_token")
 
-	claims := &Claims{}
-
-	_, err = jwt.ParseWithClaims(refreshToken, claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte(app.JWTSecret), nil
-	})
-
+	claims, err := app.verifyRefreshToken(refreshToken)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
 	if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30*time.Second {
-		app.errorJSON(w, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
+		app.errorJSON(w, r, errTokenNotDue, http.StatusTooEarly)
 		return
 	}
 
 	userID, err := strconv.Atoi(claims.Subject)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, errInvalidToken.wrap(err), http.StatusBadRequest)
 		return
 	}
 
-	user, err := app.DB.GetUser(userID)
-	if err != nil {
-		app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		app.errorJSON(w, r, errUnknownUser.wrap(err), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		app.repositoryErrorJSON(w, r, err)
 		return
 	}
 
 	tokenParis, err := app.generateTokenPair(user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "__Host-refresh_token",
-		Path:     "/",
-		Value:    tokenParis.RefreshToken,
-		Expires:  time.Now().Add(refreshTokenExpiry),
-		MaxAge:   int(refreshTokenExpiry.Seconds()),
-		SameSite: http.SameSiteStrictMode,
-		Domain:   "locahost",
-		HttpOnly: true,
-		Secure:   true,
-	})
+	app.audit(r, data.AuditEvent{Action: data.AuditTokenRefreshed, ActorID: user.ID, TargetID: user.ID})
+	app.Metrics.TokenRefreshed()
 
-	_ = app.writeJSON(w, http.StatusOK, tokenParis)
+	http.SetCookie(w, refreshCookie(tokenParis.RefreshToken, http.SameSiteStrictMode))
+
+	_ = app.writeJSON(w, r, http.StatusOK, tokenParis)
 
 }
 
@@ -123,7 +115,7 @@ func (app *application) refreshUsingCookie(w http.ResponseWriter, r *http.Reques
 	var cookie *http.Cookie
 
 	for _, c := range r.Cookies() {
-		if c.Name == "__Host-refresh_token" {
+		if c.Name == refreshCookieName {
 			cookie = c
 			break
 		}
@@ -131,120 +123,288 @@ func (app *application) refreshUsingCookie(w http.ResponseWriter, r *http.Reques
 
 	if cookie != nil {
 
-		claims := &Claims{}
-		refreshToken := cookie.Value
-
-		_, err := jwt.ParseWithClaims(refreshToken, claims, func(t *jwt.Token) (interface{}, error) {
-			return []byte(app.JWTSecret), nil
-		})
-
+		claims, err := app.verifyRefreshToken(cookie.Value)
 		if err != nil {
-			app.errorJSON(w, err, http.StatusBadRequest)
+			app.errorJSON(w, r, err, http.StatusBadRequest)
 			return
 		}
 
 		// if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30*time.Second {
-		// 	app.errorJSON(w, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
+		// 	app.errorJSON(w, r, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
 		// 	return
 		// }
 
 		userID, err := strconv.Atoi(claims.Subject)
 		if err != nil {
-			app.errorJSON(w, err, http.StatusBadRequest)
+			app.errorJSON(w, r, errInvalidToken.wrap(err), http.StatusBadRequest)
 			return
 		}
 
-		user, err := app.DB.GetUser(userID)
-		if err != nil {
-			app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
+		user, err := app.DB.GetUser(r.Context(), userID)
+		if errors.Is(err, repository.ErrNotFound) {
+			app.errorJSON(w, r, errUnknownUser.wrap(err), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			app.repositoryErrorJSON(w, r, err)
 			return
 		}
 
 		tokenParis, err := app.generateTokenPair(user)
 		if err != nil {
-			app.errorJSON(w, err, http.StatusBadRequest)
+			app.errorJSON(w, r, err, http.StatusInternalServerError)
 			return
 		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     "__Host-refresh_token",
-			Path:     "/",
-			Value:    tokenParis.RefreshToken,
-			Expires:  time.Now().Add(refreshTokenExpiry),
-			MaxAge:   int(refreshTokenExpiry.Seconds()),
-			SameSite: http.SameSiteLaxMode,
-			Domain:   "locahost",
-			HttpOnly: true,
-			Secure:   true,
-		})
-
-		_ = app.writeJSON(w, http.StatusOK, tokenParis)
+		app.audit(r, data.AuditEvent{Action: data.AuditTokenRefreshed, ActorID: user.ID, TargetID: user.ID})
+		app.Metrics.TokenRefreshed()
+
+		http.SetCookie(w, refreshCookie(tokenParis.RefreshToken, http.SameSiteLaxMode))
+
+		_ = app.writeJSON(w, r, http.StatusOK, tokenParis)
 		return
 	}
 
-	app.errorJSON(w, errors.New("no cookie found"), http.StatusBadRequest)
+	app.errorJSON(w, r, errNoRefreshCookie, http.StatusBadRequest)
 }
 
 func (app *application) allUsers(w http.ResponseWriter, r *http.Request) {
 
-	users, err := app.DB.AllUsers()
+	users, err := app.DB.AllUsers(r.Context())
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.repositoryErrorJSON(w, r, err)
 		return
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, users)
+	_ = app.writeJSON(w, r, http.StatusOK, users)
 
 }
 
 func (app *application) getUser(w http.ResponseWriter, r *http.Request) {
 	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, errInvalidUserID, http.StatusBadRequest)
 		return
 	}
 
-	user, err := app.DB.GetUser(userId)
+	user, err := app.DB.GetUser(r.Context(), userId)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.repositoryErrorJSON(w, r, err)
 		return
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, user)
+	w.Header().Set("ETag", etag(user.Version))
+	_ = app.writeJSON(w, r, http.StatusOK, user)
 }
 
+// updateUser applies a JSON merge patch (RFC 7396) or a JSON patch (RFC 6902) to the user in the
+// URL, so that fields left out of the patch keep their value. The updated user is returned.
 func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, errInvalidUserID, http.StatusBadRequest)
+		return
+	}
 
-	var user data.User
-	err := app.readJSON(w, r, &user)
+	updated, ok := app.patchUser(w, r, userId, patchableUserFields, isAdmin(r))
+	if !ok {
+		return
+	}
+
+	w.Header().Set("ETag", etag(updated.Version))
+	_ = app.writeJSON(w, r, http.StatusOK, updated)
+}
+
+// patchUser applies the patch of the request to the user userId, when it only touches the
+// allowed fields, and returns the updated user. It answers the request itself when it fails.
+func (app *application) patchUser(w http.ResponseWriter, r *http.Request, userId int, allowed map[string]bool, admin bool) (*data.User, bool) {
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+
+	apply, fields, status, err := app.readPatch(w, r)
+	if err != nil {
+		app.errorJSON(w, r, err, status)
+		return nil, false
+	}
+
+	if status, err := checkPatchFields(fields, allowed, admin); err != nil {
+		app.errorJSON(w, r, err, status)
+		return nil, false
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userId)
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return nil, false
+	}
+
+	// without If-Match we still make sure nobody changed the user since we read it
+	if version == 0 {
+		version = user.Version
+	}
+
+	doc, err := toDocument(user)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if err := apply(doc); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errPatchTestFailed) {
+			status = http.StatusConflict
+		}
+		app.errorJSON(w, r, err, status)
+		return nil, false
+	}
+
+	var patched data.User
+	if err := fromDocument(doc, &patched); err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+
+	validationErrors, err := app.Validator.Struct(&patched, "json")
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if len(validationErrors) > 0 {
+		app.errorJSON(w, r, validationErrors, http.StatusBadRequest)
+		return nil, false
+	}
+
+	patched.ID = user.ID
+	patched.Version = version
+
+	err = app.DB.UpdateUser(r.Context(), patched)
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return nil, false
+	}
+
+	updated, err := app.DB.GetUser(r.Context(), userId)
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return nil, false
+	}
+
+	app.audit(r, data.AuditEvent{Action: data.AuditUserUpdated, TargetID: userId, Changes: data.DiffUsers(*user, *updated)})
+
+	return updated, true
+}
+
+func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, errInvalidUserID, http.StatusBadRequest)
 		return
 	}
 
-	err = app.DB.UpdateUser(user)
+	app.softDeleteUser(w, r, userId)
+}
+
+// softDeleteUser deletes the user userId, at the version in If-Match if any, and revokes the
+// tokens of the user
+func (app *application) softDeleteUser(w http.ResponseWriter, r *http.Request, userId int) {
+	version, err := ifMatchVersion(r)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
+	err = app.DB.DeleteUser(r.Context(), userId, version)
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{Action: data.AuditUserDeleted, TargetID: userId})
+
+	// the user is deleted either way; the tokens would only live until they expire
+	if err := app.Revocations.RevokeUser(r.Context(), userId); err != nil {
+		slog.ErrorContext(r.Context(), "unable to revoke the tokens of a deleted user", "user_id", userId, "error", err)
+	}
+
 	w.WriteHeader(http.StatusNoContent)
+}
 
+// forceLogout revokes every token issued to a user until now, e.g. when a device was lost. The
+// user has to log in again.
+func (app *application) forceLogout(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.errorJSON(w, r, errInvalidUserID, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := app.DB.GetUser(r.Context(), userId); err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	if err := app.Revocations.RevokeUser(r.Context(), userId); err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{Action: data.AuditForcedLogout, TargetID: userId})
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
+// logout revokes the access token of the request, and the refresh_token posted with it if it
+// belongs to the same user
+func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		app.errorJSON(w, r, errTokenRequired, http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := app.Revocations.RevokeToken(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	// a refresh token which is invalid or already revoked needs no revoking
+	if refresh, err := app.verifyRefreshToken(r.PostForm.Get("refresh_token")); err == nil && refresh.Subject == claims.Subject {
+		if err := app.Revocations.RevokeToken(r.Context(), refresh.ID, refresh.ExpiresAt.Time); err != nil {
+			app.repositoryErrorJSON(w, r, err)
+			return
+		}
+	}
+
+	userID, _ := strconv.Atoi(claims.Subject)
+	app.audit(r, data.AuditEvent{Action: data.AuditLogout, ActorID: userID, TargetID: userID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreUser undoes the soft delete of a user, until it has been purged
+func (app *application) restoreUser(w http.ResponseWriter, r *http.Request) {
 	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, errInvalidUserID, http.StatusBadRequest)
 		return
 	}
 
-	err = app.DB.DeleteUser(userId)
+	err = app.DB.RestoreUser(r.Context(), userId)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.repositoryErrorJSON(w, r, err)
 		return
 	}
 
+	app.audit(r, data.AuditEvent{Action: data.AuditUserRestored, TargetID: userId})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -252,33 +412,64 @@ func (app *application) insertUser(w http.ResponseWriter, r *http.Request) {
 	var user data.User
 	err := app.readJSON(w, r, &user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.errorJSON(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	_, err = app.DB.InsertUser(user)
+	newID, err := app.DB.InsertUser(r.Context(), user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.repositoryErrorJSON(w, r, err)
 		return
 	}
 
+	app.audit(r, data.AuditEvent{Action: data.AuditUserCreated, TargetID: newID, Changes: data.DiffUsers(data.User{}, user)})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (app *application) deleteRefreshCookie(w http.ResponseWriter, r *http.Request) {
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "__Host-refresh_token",
+	// the refresh token is revoked, so that a copy of the cookie is of no use either
+	if cookie, err := r.Cookie(refreshCookieName); err == nil {
+		if claims, err := app.verifyRefreshToken(cookie.Value); err == nil {
+			if err := app.Revocations.RevokeToken(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+				app.repositoryErrorJSON(w, r, err)
+				return
+			}
+
+			userID, _ := strconv.Atoi(claims.Subject)
+			app.audit(r, data.AuditEvent{Action: data.AuditLogout, ActorID: userID, TargetID: userID})
+		}
+	}
+
+	http.SetCookie(w, refreshCookie("", http.SameSiteStrictMode))
+
+	w.WriteHeader(http.StatusAccepted)
+
+}
+
+// refreshCookieName is the cookie of the refresh token of the web routes
+const refreshCookieName = "__Host-refresh_token"
+
+// refreshCookie returns the cookie keeping the refresh token of the web routes, or deleting it
+// when value is empty. The __Host- prefix binds the cookie to the host of the api: browsers
+// only accept it with Secure, Path / and no Domain.
+func refreshCookie(value string, sameSite http.SameSite) *http.Cookie {
+	c := &http.Cookie{
+		Name:     refreshCookieName,
 		Path:     "/",
-		Value:    "",
-		Expires:  time.Unix(0, 0),
-		MaxAge:   -1,
-		SameSite: http.SameSiteStrictMode,
-		Domain:   "localhost",
+		Value:    value,
+		Expires:  time.Now().Add(refreshTokenExpiry),
+		MaxAge:   int(refreshTokenExpiry.Seconds()),
+		SameSite: sameSite,
 		HttpOnly: true,
 		Secure:   true,
-	})
+	}
 
-	w.WriteHeader(http.StatusAccepted)
+	if value == "" {
+		c.Expires = time.Unix(0, 0)
+		c.MaxAge = -1
+	}
 
+	return c
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -9,6 +10,7 @@ import (
 	"testing"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/revocation"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -107,6 +109,11 @@ func Test_api_app_refresh(t *testing.T) {
 
 func Test_api_app_userHandlers(t *testing.T) {
 
+	// deleting user 1 revokes its tokens, which the other tests use
+	oldRevocations := app.Revocations
+	defer func() { app.Revocations = oldRevocations }()
+	app.Revocations = revocation.New(app.DB, refreshTokenExpiry)
+
 	testCases := []struct {
 		name           string
 		method         string
@@ -118,24 +125,27 @@ func Test_api_app_userHandlers(t *testing.T) {
 		{"allUsers", http.MethodGet, "", "", app.allUsers, http.StatusOK},
 		{"deleteUser", http.MethodDelete, "", "1", app.deleteUser, http.StatusNoContent},
 		{"deleteUser bad param", http.MethodDelete, "", "s", app.deleteUser, http.StatusBadRequest},
-		{"deleteUser invalid user id", http.MethodDelete, "", "2", app.deleteUser, http.StatusBadRequest},
+		{"deleteUser invalid user id", http.MethodDelete, "", "2", app.deleteUser, http.StatusNotFound},
+		{"restoreUser", http.MethodPost, "", "2", app.restoreUser, http.StatusNoContent},
+		{"restoreUser not deleted", http.MethodPost, "", "1", app.restoreUser, http.StatusNotFound},
+		{"restoreUser bad param", http.MethodPost, "", "s", app.restoreUser, http.StatusBadRequest},
 		{"getUser valid", http.MethodGet, "", "1", app.getUser, http.StatusOK},
-		{"getUser invalid id", http.MethodGet, "", "2", app.getUser, http.StatusBadRequest},
+		{"getUser invalid id", http.MethodGet, "", "2", app.getUser, http.StatusNotFound},
 		{"getUser invalid param", http.MethodGet, "", "s", app.getUser, http.StatusBadRequest},
 		{
 			"UpdateUser valid",
 			http.MethodPatch,
-			`{"id": 1, "first_name": "Admin New",  "last_name": "User new", "email": "admin@example.com"}`,
-			"",
+			`{"first_name": "Admin New",  "last_name": "User new", "email": "admin@example.com"}`,
+			"1",
 			app.updateUser,
-			http.StatusNoContent,
+			http.StatusOK,
 		},
 
 		{
 			"UpdateUser invalid json",
 			http.MethodPatch,
-			`{"id": 1, "first_name": "Admin New",  "last_name": "User new", "email": admin@example.com"}`,
-			"",
+			`{"first_name": "Admin New",  "last_name": "User new", "email": admin@example.com"}`,
+			"1",
 			app.updateUser,
 			http.StatusBadRequest,
 		},
@@ -143,8 +153,17 @@ func Test_api_app_userHandlers(t *testing.T) {
 		{
 			"UpdateUser invalid user",
 			http.MethodPatch,
-			`{"id": 9999, "first_name": "Admin New",  "last_name": "User new", "email": "admin@example.com"}`,
-			"",
+			`{"first_name": "Admin New",  "last_name": "User new", "email": "admin@example.com"}`,
+			"9999",
+			app.updateUser,
+			http.StatusNotFound,
+		},
+
+		{
+			"UpdateUser invalid param",
+			http.MethodPatch,
+			`{"first_name": "Admin New"}`,
+			"s",
 			app.updateUser,
 			http.StatusBadRequest,
 		},
@@ -167,6 +186,15 @@ func Test_api_app_userHandlers(t *testing.T) {
 			http.StatusBadRequest,
 		},
 
+		{
+			"insertUser invalid email",
+			http.MethodPut,
+			`{"first_name": "Jack",  "last_name": "Neo", "email": "neo"}`,
+			"",
+			app.insertUser,
+			http.StatusBadRequest,
+		},
+
 		{
 			"insertUser invalid json",
 			http.MethodPut,
@@ -182,7 +210,25 @@ func Test_api_app_userHandlers(t *testing.T) {
 			`{"first_name": "Jack",  "last_name": "Neo", "email": "invalid@example.com"}`,
 			"",
 			app.insertUser,
-			http.StatusBadRequest,
+			http.StatusInternalServerError,
+		},
+
+		{
+			"insertUser duplicate email",
+			http.MethodPut,
+			`{"first_name": "Jack",  "last_name": "Neo", "email": "admin@example.com"}`,
+			"",
+			app.insertUser,
+			http.StatusConflict,
+		},
+
+		{
+			"insertUser database unavailable",
+			http.MethodPut,
+			`{"first_name": "Jack",  "last_name": "Neo", "email": "unavailable@example.com"}`,
+			"",
+			app.insertUser,
+			http.StatusServiceUnavailable,
 		},
 	}
 
@@ -211,6 +257,71 @@ func Test_api_app_userHandlers(t *testing.T) {
 	}
 }
 
+func Test_api_app_userHandlers_ifMatch(t *testing.T) {
+
+	// deleting user 1 revokes its tokens, which the other tests use
+	oldRevocations := app.Revocations
+	defer func() { app.Revocations = oldRevocations }()
+	app.Revocations = revocation.New(app.DB, refreshTokenExpiry)
+
+	testCases := []struct {
+		name           string
+		method         string
+		json           string
+		ifMatch        string
+		handler        http.HandlerFunc
+		expectedStatus int
+	}{
+		{"update current version", http.MethodPatch, `{"email": "admin@example.com"}`, `"1"`, app.updateUser, http.StatusOK},
+		{"update weak tag", http.MethodPatch, `{"email": "admin@example.com"}`, `W/"1"`, app.updateUser, http.StatusOK},
+		{"update any version", http.MethodPatch, `{"email": "admin@example.com"}`, `*`, app.updateUser, http.StatusOK},
+		{"update stale version", http.MethodPatch, `{"email": "admin@example.com"}`, `"2"`, app.updateUser, http.StatusPreconditionFailed},
+		{"update malformed header", http.MethodPatch, `{"email": "admin@example.com"}`, `1`, app.updateUser, http.StatusBadRequest},
+		{"delete current version", http.MethodDelete, "", `"1"`, app.deleteUser, http.StatusNoContent},
+		{"delete stale version", http.MethodDelete, "", `"7"`, app.deleteUser, http.StatusPreconditionFailed},
+		{"delete malformed header", http.MethodDelete, "", `"x"`, app.deleteUser, http.StatusBadRequest},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, "/", strings.NewReader(tt.json))
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("userID", "1")
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status code %d; got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func Test_api_app_getUser_etag(t *testing.T) {
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.getUser).ServeHTTP(rr, req)
+
+	if rr.Header().Get("ETag") != `"1"` {
+		t.Errorf("expect ETag \"1\"; got %s", rr.Header().Get("ETag"))
+	}
+
+	if !strings.Contains(rr.Body.String(), `"version":1`) {
+		t.Errorf("expect version in response body; got %s", rr.Body.String())
+	}
+}
+
 func Test_api_app_refreshUsingCookie(t *testing.T) {
 
 	testUser := data.User{
@@ -229,7 +340,6 @@ func Test_api_app_refreshUsingCookie(t *testing.T) {
 		Expires:  time.Now().Add(refreshTokenExpiry),
 		MaxAge:   int(refreshTokenExpiry.Seconds()),
 		SameSite: http.SameSiteStrictMode,
-		Domain:   "localhost",
 		HttpOnly: true,
 		Secure:   true,
 	}
@@ -241,7 +351,6 @@ func Test_api_app_refreshUsingCookie(t *testing.T) {
 		Expires:  time.Now().Add(refreshTokenExpiry),
 		MaxAge:   int(refreshTokenExpiry.Seconds()),
 		SameSite: http.SameSiteStrictMode,
-		Domain:   "localhost",
 		HttpOnly: true,
 		Secure:   true,
 	}
@@ -299,6 +408,10 @@ func Test_api_app_deleteRefreshCookie(t *testing.T) {
 			if c.Expires.After(time.Now()) {
 				t.Errorf("cookie expiration in the future; the time should be in the past %v", c.Expires.UTC())
 			}
+			// browsers ignore a __Host- cookie with a Domain, which would then never be deleted
+			if c.Domain != "" {
+				t.Errorf("expect no Domain on a __Host- cookie; got %q", c.Domain)
+			}
 			break
 		}
 
@@ -308,3 +421,102 @@ func Test_api_app_deleteRefreshCookie(t *testing.T) {
 		t.Errorf("did not find cookie in response: %s", cookieName)
 	}
 }
+
+func Test_api_app_logout(t *testing.T) {
+
+	freshRateLimiter(t)
+
+	oldRevocations := app.Revocations
+	defer func() { app.Revocations = oldRevocations }()
+	app.Revocations = revocation.New(app.DB, refreshTokenExpiry)
+
+	routes := app.routes()
+
+	do := func(method, target, auth string, body url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(method, target, strings.NewReader(body.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr
+	}
+
+	admin, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1})
+	other, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1})
+
+	if rr := do(http.MethodPost, "/logout", admin.Token, url.Values{"refresh_token": {admin.RefreshToken}}); rr.Code != http.StatusNoContent {
+		t.Fatalf("expect status 204 logging out; got %d: %s", rr.Code, rr.Body)
+	}
+
+	rr := do(http.MethodGet, "/users/", admin.Token, nil)
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), errTokenRevoked.Code) {
+		t.Errorf("expect the access token to be revoked; got %d %s", rr.Code, rr.Body)
+	}
+
+	if rr := do(http.MethodPost, "/refresh-token", "", url.Values{"refresh_token": {admin.RefreshToken}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("expect the refresh token to be revoked; got %d", rr.Code)
+	}
+
+	// the other tokens of the user are not
+	if rr := do(http.MethodGet, "/users/", other.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("expect the other access token to stay valid; got %d", rr.Code)
+	}
+}
+
+func Test_api_app_forceLogout(t *testing.T) {
+
+	freshRateLimiter(t)
+
+	oldRevocations := app.Revocations
+	defer func() { app.Revocations = oldRevocations }()
+	app.Revocations = revocation.New(app.DB, refreshTokenExpiry)
+
+	routes := app.routes()
+
+	admin, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1})
+	user, _ := app.generateTokenPair(&data.User{ID: 2, FirstName: "Jane", LastName: "Doe"})
+
+	do := func(method, target, auth string) int {
+		t.Helper()
+
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+auth)
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	testCases := []struct {
+		name           string
+		target         string
+		token          string
+		expectedStatus int
+	}{
+		{"not an admin", "/users/1/logout", user.Token, http.StatusForbidden},
+		{"unknown user", "/users/2/logout", admin.Token, http.StatusNotFound},
+		{"bad param", "/users/x/logout", admin.Token, http.StatusBadRequest},
+		{"admin", "/users/1/logout", admin.Token, http.StatusNoContent},
+		// the admin logged themselves out
+		{"after the logout", "/users/1/logout", admin.Token, http.StatusUnauthorized},
+	}
+
+	for _, tt := range testCases {
+		if status := do(http.MethodPost, tt.target, tt.token); status != tt.expectedStatus {
+			t.Errorf("%s: expect status %d; got %d", tt.name, tt.expectedStatus, status)
+		}
+	}
+
+	if _, err := app.verifyRefreshToken(admin.RefreshToken); !errors.Is(err, errTokenRevoked) {
+		t.Errorf("expect the refresh token of the user to be revoked; got %v", err)
+	}
+
+	if status := do(http.MethodGet, "/users/", user.Token); status != http.StatusOK {
+		t.Errorf("expect the tokens of the other users to stay valid; got %d", status)
+	}
+}
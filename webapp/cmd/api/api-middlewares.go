@@ -1,19 +1,138 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/clientip"
+	"webapp/pkg/data"
+	"webapp/pkg/logging"
+	"webapp/pkg/repository"
 )
 
-func (app *application) enableCORS(next http.Handler) http.Handler {
+type contextKey string
+
+const (
+	contextClaimsKey contextKey = "claims"
+	contextAPIKeyKey contextKey = "api_key"
+)
+
+// claimsFromContext returns the claims of the token verified by authRequired
+func claimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextClaimsKey).(*Claims)
+	return claims, ok
+}
+
+// apiKeyFromContext returns the API key verified by authRequired
+func apiKeyFromContext(ctx context.Context) (*data.APIKey, bool) {
+	key, ok := ctx.Value(contextAPIKeyKey).(*data.APIKey)
+	return key, ok
+}
+
+// apiKeyFromHeader returns the API key sent in X-API-Key or as Authorization: ApiKey <key>
+func apiKeyFromHeader(r *http.Request) string {
+	if scheme, value, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "ApiKey") {
+		return strings.TrimSpace(value)
+	}
+
+	return r.Header.Get("X-API-Key")
+}
+
+// authRequired lets through the requests carrying a valid access token or API key
+func (app *application) authRequired(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if key := apiKeyFromHeader(r); key != "" {
+			app.apiKeyRequired(next, key).ServeHTTP(w, r)
+			return
+		}
+
+		_, claims, err := app.getTokenFromHeaderAndVerify(w, r)
+		if err != nil {
+			// the problem code tells clients why, e.g. token_expired when they should refresh
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			app.errorJSON(w, r, err, http.StatusUnauthorized)
+			return
+		}
+
+		logging.SetUserID(r.Context(), claims.Subject)
+
+		ctx := context.WithValue(r.Context(), contextClaimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// apiKeyRequired authenticates the request with key, which must be active, and records when
+// the key was used
+func (app *application) apiKeyRequired(next http.Handler, key string) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		// the same problem whatever is wrong with the key, so that keys can not be probed
+		unauthorized := func() {
+			w.Header().Set("WWW-Authenticate", "ApiKey")
+			app.errorJSON(w, r, errInvalidAPIKey, http.StatusUnauthorized)
+		}
+
+		prefix, ok := data.APIKeyLookupPrefix(key)
+		if !ok {
+			unauthorized()
+			return
+		}
+
+		apiKey, err := app.DB.GetAPIKeyByPrefix(r.Context(), prefix)
+		if errors.Is(err, repository.ErrNotFound) {
+			unauthorized()
+			return
+		} else if err != nil {
+			app.repositoryErrorJSON(w, r, err)
+			return
+		}
+
+		now := time.Now()
+		if !apiKey.Matches(key) || !apiKey.Active(now) {
+			unauthorized()
+			return
+		}
+
+		// the request must not fail because the last use could not be recorded
+		if err := app.DB.TouchAPIKey(r.Context(), apiKey.ID, now); err != nil {
+			slog.ErrorContext(r.Context(), "unable to record the use of an API key", "api_key", apiKey.Prefix, "error", err)
+		}
+
+		logging.SetUserID(r.Context(), "service_account:"+strconv.Itoa(apiKey.ServiceAccountID))
+
+		ctx := context.WithValue(r.Context(), contextAPIKeyKey, apiKey)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// isAdmin tells whether the request was made by an admin, or with an API key having the admin
+// scope. A token issued to an OAuth client also needs the admin scope.
+func isAdmin(r *http.Request) bool {
+	if key, ok := apiKeyFromContext(r.Context()); ok {
+		return key.HasScope(data.ScopeAdmin)
+	}
+
+	claims, ok := claimsFromContext(r.Context())
+	return ok && claims.Admin && (!claims.Delegated() || claims.HasScope(data.ScopeAdmin))
+}
+
+// adminRequired only lets admins through; it must run after authRequired
+func (app *application) adminRequired(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		w.Header().Set("Access-Control-Allow-Origin", fmt.Sprintf("http://localhost:%d", app.Port))
-		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authroization")
+		if !isAdmin(r) {
+			app.errorJSON(w, r, errAdminRequired, http.StatusForbidden)
 			return
 		}
 
@@ -21,16 +140,75 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) authRequired(next http.Handler) http.Handler {
+// userRequired only lets through the tokens about a user, refusing API keys and the tokens OAuth
+// clients got for themselves; it must run after authRequired
+func (app *application) userRequired(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		_, _, err := app.getTokenFromHeaderAndVerify(w, r)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+		if actorID(r) == 0 {
+			app.errorJSON(w, r, errUserRequired, http.StatusForbidden)
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// ownTokenRequired refuses the tokens issued to OAuth clients, for what only the user may do,
+// e.g. changing the password; it must run after authRequired
+func (app *application) ownTokenRequired(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if claims, ok := claimsFromContext(r.Context()); !ok || claims.Delegated() {
+			app.errorJSON(w, r, errUserTokenRequired, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scopeRequired refuses the requests made with an API key, or a token issued to an OAuth client,
+// lacking scope. Users are not limited by scopes; it must run after authRequired.
+func (app *application) scopeRequired(scope string) func(http.Handler) http.Handler {
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			if key, ok := apiKeyFromContext(r.Context()); ok && !key.HasScope(scope) {
+				app.errorJSON(w, r, errInsufficientScope, http.StatusForbidden)
+				return
+			}
+
+			if claims, ok := claimsFromContext(r.Context()); ok && claims.Delegated() && !claims.HasScope(scope) {
+				app.errorJSON(w, r, errInsufficientScope, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey counts the requests of a verified user against that user, those of a verified
+// API key against the key, and the others against the client IP. The API key header alone is not
+// enough: before apiKeyRequired has checked it, a client could send a new key with every request
+// to get a new bucket each time.
+func (app *application) rateLimitKey(r *http.Request) string {
+	if claims, ok := claimsFromContext(r.Context()); ok {
+		return "user:" + claims.Subject
+	}
+
+	if key, ok := apiKeyFromContext(r.Context()); ok {
+		return "key:" + strconv.Itoa(key.ID)
+	}
+
+	return "ip:" + clientip.Get(r)
+}
+
+// tooManyRequests answers the requests refused by the rate limiter
+func (app *application) tooManyRequests(w http.ResponseWriter, r *http.Request) {
+	app.errorJSON(w, r, errTooManyRequests, http.StatusTooManyRequests)
+}
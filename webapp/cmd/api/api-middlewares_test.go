@@ -1,41 +1,58 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/ratelimit"
+	"webapp/pkg/repository/dbrepo"
+
+	"github.com/golang-jwt/jwt/v4"
 )
 
-func Test_api_app_enableCORS(t *testing.T) {
+func Test_api_app_cors(t *testing.T) {
 
-	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	freshRateLimiter(t)
+	routes := app.routes()
 
 	testCases := []struct {
-		name         string
-		method       string
-		expectHeader bool
+		name              string
+		url               string
+		requestHeaders    string
+		expectOrigin      string
+		expectCredentials string
 	}{
-		{"preflight", http.MethodOptions, true},
-		{"get", http.MethodGet, false},
+		{"users", "/users/", "Authorization, Content-Type", "http://localhost:8081", ""},
+		{"web route allows cookies", "/web/refresh-token", "Content-Type", "http://localhost:8081", "true"},
+		{"header not allowed", "/users/", "X-Unknown", "", ""},
 	}
 
 	for _, tt := range testCases {
 		t.Run(tt.name, func(t *testing.T) {
-			handlerToTest := app.enableCORS(next)
-
-			req := httptest.NewRequest(tt.method, "http://test", nil)
+			req := httptest.NewRequest(http.MethodOptions, tt.url, nil)
+			req.Header.Set("Origin", "http://localhost:8081")
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			req.Header.Set("Access-Control-Request-Headers", tt.requestHeaders)
 			rr := httptest.NewRecorder()
 
-			handlerToTest.ServeHTTP(rr, req)
+			routes.ServeHTTP(rr, req)
 
-			if tt.expectHeader && rr.Header().Get("Access-Control-Allow-Credentials") == "" {
-				t.Error("expect to get header: Access-Control-Allow-Credentials")
+			if rr.Code != http.StatusNoContent {
+				t.Errorf("expect status 204; got %d", rr.Code)
 			}
 
-			if !tt.expectHeader && rr.Header().Get("Access-Control-Allow-Credentials") != "" {
-				t.Error("expect no headers, but gone one: Access-Control-Allow-Credentials")
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.expectOrigin {
+				t.Errorf("expect allowed origin %q; got %q", tt.expectOrigin, got)
+			}
+
+			if got := rr.Header().Get("Access-Control-Allow-Credentials"); got != tt.expectCredentials {
+				t.Errorf("expect allow credentials %q; got %q", tt.expectCredentials, got)
 			}
 		})
 	}
@@ -86,3 +103,225 @@ func Test_api_app_authRequired(t *testing.T) {
 		})
 	}
 }
+
+func Test_api_app_adminRequired(t *testing.T) {
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	testCases := []struct {
+		name           string
+		claims         *Claims
+		expectedStatus int
+	}{
+		{"admin", &Claims{Admin: true}, http.StatusOK},
+		{"not an admin", &Claims{Admin: false}, http.StatusForbidden},
+		{"no claims", nil, http.StatusForbidden},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/", nil)
+			if tt.claims != nil {
+				req = req.WithContext(context.WithValue(req.Context(), contextClaimsKey, tt.claims))
+			}
+
+			rr := httptest.NewRecorder()
+			app.adminRequired(next).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expect status %d; got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func Test_api_app_rateLimit(t *testing.T) {
+
+	oldLimits := app.RateLimit
+	defer func() { app.RateLimit = oldLimits }()
+
+	app.RateLimit.Auth = ratelimit.Every(2, time.Hour)
+	freshRateLimiter(t)
+
+	routes := app.routes()
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodPost, "/auth", strings.NewReader(`{"email": "admin@example.com", "password": "secret"}`))
+		// a new, unverified API key with every request must not get the client a new bucket
+		req.Header.Set("X-API-Key", fmt.Sprintf("random-key-%d", i))
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != expected {
+			t.Fatalf("request %d: expect status %d; got %d", i, expected, rr.Code)
+		}
+
+		if rr.Header().Get("RateLimit-Limit") != "2" {
+			t.Errorf("request %d: expect RateLimit-Limit 2; got %q", i, rr.Header().Get("RateLimit-Limit"))
+		}
+
+		if expected != http.StatusTooManyRequests {
+			continue
+		}
+
+		if rr.Header().Get("Retry-After") == "" {
+			t.Error("expect a Retry-After header")
+		}
+
+		var p problem
+		if err := json.NewDecoder(rr.Body).Decode(&p); err != nil || p.Code != errTooManyRequests.Code {
+			t.Errorf("expect a %s problem; got %+v (%v)", errTooManyRequests.Code, p, err)
+		}
+	}
+}
+
+func Test_api_app_rateLimitKey(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		header   string
+		value    string
+		claims   *Claims
+		key      *data.APIKey
+		expected string
+	}{
+		{"anonymous", "", "", nil, nil, "ip:192.0.2.1"},
+		{"user", "", "", &Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "7"}}, nil, "user:7"},
+		{"verified api key", "X-API-Key", "secret", nil, &data.APIKey{ID: 3}, "key:3"},
+		{"unverified api key header", "X-API-Key", "secret", nil, nil, "ip:192.0.2.1"},
+		{"unverified api key authorization", "Authorization", "ApiKey secret", nil, nil, "ip:192.0.2.1"},
+		{"bearer token", "Authorization", "Bearer token", nil, nil, "ip:192.0.2.1"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			if tt.claims != nil {
+				req = req.WithContext(context.WithValue(req.Context(), contextClaimsKey, tt.claims))
+			}
+			if tt.key != nil {
+				req = req.WithContext(context.WithValue(req.Context(), contextAPIKeyKey, tt.key))
+			}
+
+			if got := app.rateLimitKey(req); got != tt.expected {
+				t.Errorf("expect key %s; got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func Test_api_app_authRequired_apiKey(t *testing.T) {
+
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	db := &dbrepo.MockDBRepo{}
+	app.DB = db
+
+	past := time.Now().Add(-time.Hour)
+	seed := func(expiresAt, revokedAt *time.Time) string {
+		key, prefix, hash, _ := data.NewAPIKey()
+		_, _ = db.InsertAPIKey(context.Background(), data.APIKey{ServiceAccountID: 1, Prefix: prefix, Hash: hash, ExpiresAt: expiresAt, RevokedAt: revokedAt})
+		return key
+	}
+
+	active := seed(nil, nil)
+	expired := seed(&past, nil)
+	revoked := seed(nil, &past)
+	wrongSecret := active[:len(active)-1] + "x"
+	if strings.HasSuffix(active, "x") {
+		wrongSecret = active[:len(active)-1] + "y"
+	}
+
+	testCases := []struct {
+		name           string
+		header         string
+		value          string
+		expectedStatus int
+	}{
+		{"X-API-Key", "X-API-Key", active, http.StatusOK},
+		{"authorization", "Authorization", "ApiKey " + active, http.StatusOK},
+		{"expired", "X-API-Key", expired, http.StatusUnauthorized},
+		{"revoked", "X-API-Key", revoked, http.StatusUnauthorized},
+		{"wrong secret", "X-API-Key", wrongSecret, http.StatusUnauthorized},
+		{"malformed", "X-API-Key", "not-a-key", http.StatusUnauthorized},
+		{"unknown", "X-API-Key", data.APIKeyPrefix + "000000000000_secret", http.StatusUnauthorized},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var key *data.APIKey
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				key, _ = apiKeyFromContext(r.Context())
+			})
+
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set(tt.header, tt.value)
+			rr := httptest.NewRecorder()
+
+			app.authRequired(next).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("expect status %d; got %d", tt.expectedStatus, rr.Code)
+			}
+
+			if tt.expectedStatus == http.StatusOK && (key == nil || key.ServiceAccountID != 1) {
+				t.Errorf("expect the key of service account 1 in the context; got %+v", key)
+			}
+
+			if tt.expectedStatus == http.StatusUnauthorized {
+				var p problem
+				_ = json.NewDecoder(rr.Body).Decode(&p)
+				if p.Code != errInvalidAPIKey.Code || rr.Header().Get("WWW-Authenticate") != "ApiKey" {
+					t.Errorf("expect an invalid_api_key problem with WWW-Authenticate: ApiKey; got %q and %q", p.Code, rr.Header().Get("WWW-Authenticate"))
+				}
+			}
+		})
+	}
+
+	keys, _ := db.APIKeys(context.Background(), 1)
+	for _, k := range keys {
+		if used := k.LastUsedAt != nil; used != (k.RevokedAt == nil && k.ExpiresAt == nil) {
+			t.Errorf("key %s: expect last use to be recorded only for the active key; got %v", k.Prefix, k.LastUsedAt)
+		}
+	}
+}
+
+func Test_api_app_scopeRequired(t *testing.T) {
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	testCases := []struct {
+		name           string
+		claims         *Claims
+		key            *data.APIKey
+		expectedStatus int
+	}{
+		{"user", &Claims{}, nil, http.StatusOK},
+		{"key with scope", nil, &data.APIKey{Scopes: []string{data.ScopeUsersRead}}, http.StatusOK},
+		{"key without scope", nil, &data.APIKey{Scopes: []string{data.ScopeUsersWrite}}, http.StatusForbidden},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			if tt.claims != nil {
+				req = req.WithContext(context.WithValue(req.Context(), contextClaimsKey, tt.claims))
+			}
+			if tt.key != nil {
+				req = req.WithContext(context.WithValue(req.Context(), contextAPIKeyKey, tt.key))
+			}
+
+			rr := httptest.NewRecorder()
+			app.scopeRequired(data.ScopeUsersRead)(next).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expect status %d; got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
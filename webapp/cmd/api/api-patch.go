@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// patchableUserFields lists the fields of data.User clients may patch, and whether changing them
+// requires an admin
+var patchableUserFields = map[string]bool{
+	"first_name": false,
+	"last_name":  false,
+	"email":      false,
+	"is_admin":   true,
+}
+
+// patchableProfileFields lists the fields users may patch on their own profile
+var patchableProfileFields = map[string]bool{
+	"first_name": false,
+	"last_name":  false,
+	"email":      false,
+}
+
+var (
+	errUnsupportedPatch = newAPIError("unsupported_media_type", fmt.Sprintf("use %s or %s", mergePatchContentType, jsonPatchContentType))
+	errInvalidPatch     = newAPIError("invalid_patch", "the patch document is invalid")
+	errPatchTestFailed  = newAPIError("patch_test_failed", "a test operation of the patch failed")
+	errAdminOnlyField   = newAPIError("forbidden_field", "only admins may change is_admin")
+)
+
+// patchOperation is one operation of an RFC 6902 JSON Patch
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// readPatch reads the request body as a merge patch or a JSON patch, depending on its content
+// type, and returns a function applying it to a JSON document together with the fields it touches
+func (app *application) readPatch(w http.ResponseWriter, r *http.Request) (func(map[string]any) error, []string, int, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "" {
+		contentType, _, _ = mime.ParseMediaType(contentType)
+	}
+
+	switch contentType {
+	case "", "application/json", mergePatchContentType:
+		var patch map[string]any
+		if err := app.readJSON(w, r, &patch); err != nil {
+			return nil, nil, http.StatusBadRequest, err
+		}
+
+		if patch == nil {
+			return nil, nil, http.StatusBadRequest, newAPIError(errInvalidPatch.Code, "a merge patch must be a JSON object")
+		}
+
+		fields := make([]string, 0, len(patch))
+		for field := range patch {
+			fields = append(fields, field)
+		}
+
+		return func(doc map[string]any) error {
+			mergePatch(doc, patch)
+			return nil
+		}, fields, 0, nil
+
+	case jsonPatchContentType:
+		var ops []patchOperation
+		if err := app.readJSON(w, r, &ops); err != nil {
+			return nil, nil, http.StatusBadRequest, err
+		}
+
+		var fields []string
+		for _, op := range ops {
+			for _, p := range []string{op.Path, op.From} {
+				if p == "" {
+					continue
+				}
+
+				field, err := pointerField(p)
+				if err != nil {
+					return nil, nil, http.StatusBadRequest, err
+				}
+
+				// test and the source of copy only read a field
+				if op.Op == "test" || (op.Op == "copy" && p == op.From) {
+					continue
+				}
+				fields = append(fields, field)
+			}
+		}
+
+		return func(doc map[string]any) error {
+			return applyJSONPatch(doc, ops)
+		}, fields, 0, nil
+	}
+
+	return nil, nil, http.StatusUnsupportedMediaType, errUnsupportedPatch
+}
+
+// checkPatchFields makes sure a patch only touches allowed fields
+func checkPatchFields(fields []string, allowed map[string]bool, isAdmin bool) (int, error) {
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		adminOnly, ok := allowed[field]
+		if !ok {
+			return http.StatusBadRequest, newAPIError("field_not_patchable", fmt.Sprintf("field %q can not be changed", field))
+		}
+
+		if adminOnly && !isAdmin {
+			return http.StatusForbidden, errAdminOnlyField
+		}
+	}
+
+	return 0, nil
+}
+
+// mergePatch applies an RFC 7396 merge patch to doc: null removes a member, objects are merged
+// recursively and any other value replaces the member
+func mergePatch(doc map[string]any, patch map[string]any) {
+	for key, value := range patch {
+		if value == nil {
+			delete(doc, key)
+			continue
+		}
+
+		if patchObject, ok := value.(map[string]any); ok {
+			target, ok := doc[key].(map[string]any)
+			if !ok {
+				target = map[string]any{}
+			}
+			mergePatch(target, patchObject)
+			doc[key] = target
+			continue
+		}
+
+		doc[key] = value
+	}
+}
+
+// applyJSONPatch applies RFC 6902 operations to doc. Our resources are flat, so only pointers to
+// top level members are supported.
+func applyJSONPatch(doc map[string]any, ops []patchOperation) error {
+	for i, op := range ops {
+		field, err := pointerField(op.Path)
+		if err != nil {
+			return err
+		}
+
+		var value any
+		if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+			if len(op.Value) == 0 {
+				return newAPIError(errInvalidPatch.Code, fmt.Sprintf("operation %d: value is required", i))
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return newAPIError(errInvalidPatch.Code, fmt.Sprintf("operation %d: invalid value", i))
+			}
+		}
+
+		_, exists := doc[field]
+
+		switch op.Op {
+		case "add":
+			doc[field] = value
+
+		case "replace", "remove":
+			if !exists {
+				return newAPIError(errInvalidPatch.Code, fmt.Sprintf("operation %d: %s does not exist", i, op.Path))
+			}
+			if op.Op == "remove" {
+				delete(doc, field)
+			} else {
+				doc[field] = value
+			}
+
+		case "test":
+			if !exists || !jsonEqual(doc[field], value) {
+				return errPatchTestFailed
+			}
+
+		case "move", "copy":
+			from, err := pointerField(op.From)
+			if err != nil {
+				return err
+			}
+			v, ok := doc[from]
+			if !ok {
+				return newAPIError(errInvalidPatch.Code, fmt.Sprintf("operation %d: %s does not exist", i, op.From))
+			}
+			if op.Op == "move" {
+				delete(doc, from)
+			}
+			doc[field] = v
+
+		default:
+			return newAPIError(errInvalidPatch.Code, fmt.Sprintf("operation %d: unknown op %q", i, op.Op))
+		}
+	}
+
+	return nil
+}
+
+// pointerField returns the member named by a single segment JSON pointer such as /first_name
+func pointerField(pointer string) (string, error) {
+	if !strings.HasPrefix(pointer, "/") || strings.Count(pointer, "/") != 1 {
+		return "", newAPIError(errInvalidPatch.Code, fmt.Sprintf("unsupported path %q", pointer))
+	}
+
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(pointer[1:]), nil
+}
+
+// jsonEqual compares two decoded JSON values
+func jsonEqual(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// toDocument turns v into a generic JSON document
+func toDocument(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]any
+	err = json.Unmarshal(b, &doc)
+	return doc, err
+}
+
+// fromDocument decodes a generic JSON document into v
+func fromDocument(doc map[string]any, v any) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return decodeError(err, len(b))
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func Test_mergePatch(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		doc      string
+		patch    string
+		expected string
+	}{
+		{"replace", `{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{"add", `{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{"remove", `{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{"left alone", `{"a": "b", "b": "c"}`, `{}`, `{"a": "b", "b": "c"}`},
+		{"nested", `{"a": {"b": "c", "d": "e"}}`, `{"a": {"d": null, "f": "g"}}`, `{"a": {"b": "c", "f": "g"}}`},
+		{"object replaces scalar", `{"a": "b"}`, `{"a": {"c": "d"}}`, `{"a": {"c": "d"}}`},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc, patch, expected map[string]any
+			_ = json.Unmarshal([]byte(tt.doc), &doc)
+			_ = json.Unmarshal([]byte(tt.patch), &patch)
+			_ = json.Unmarshal([]byte(tt.expected), &expected)
+
+			mergePatch(doc, patch)
+
+			if !reflect.DeepEqual(doc, expected) {
+				t.Errorf("expect %v; got %v", expected, doc)
+			}
+		})
+	}
+}
+
+func Test_applyJSONPatch(t *testing.T) {
+
+	testCases := []struct {
+		name        string
+		ops         string
+		expected    string
+		expectError bool
+	}{
+		{"replace", `[{"op": "replace", "path": "/a", "value": 2}]`, `{"a": 2, "b": "x"}`, false},
+		{"add", `[{"op": "add", "path": "/c", "value": "y"}]`, `{"a": 1, "b": "x", "c": "y"}`, false},
+		{"remove", `[{"op": "remove", "path": "/b"}]`, `{"a": 1}`, false},
+		{"move", `[{"op": "move", "from": "/b", "path": "/c"}]`, `{"a": 1, "c": "x"}`, false},
+		{"copy", `[{"op": "copy", "from": "/b", "path": "/c"}]`, `{"a": 1, "b": "x", "c": "x"}`, false},
+		{"test passes", `[{"op": "test", "path": "/a", "value": 1}, {"op": "replace", "path": "/a", "value": 3}]`, `{"a": 3, "b": "x"}`, false},
+		{"test fails", `[{"op": "test", "path": "/a", "value": 2}]`, "", true},
+		{"replace missing", `[{"op": "replace", "path": "/z", "value": 2}]`, "", true},
+		{"missing value", `[{"op": "add", "path": "/z"}]`, "", true},
+		{"nested path", `[{"op": "add", "path": "/a/b", "value": 1}]`, "", true},
+		{"unknown op", `[{"op": "frobnicate", "path": "/a"}]`, "", true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := map[string]any{"a": float64(1), "b": "x"}
+
+			var ops []patchOperation
+			if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+				t.Fatal(err)
+			}
+
+			err := applyJSONPatch(doc, ops)
+			if tt.expectError {
+				if err == nil {
+					t.Error("expect an error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var expected map[string]any
+			_ = json.Unmarshal([]byte(tt.expected), &expected)
+
+			if !reflect.DeepEqual(doc, expected) {
+				t.Errorf("expect %v; got %v", expected, doc)
+			}
+		})
+	}
+}
+
+func Test_api_app_updateUser_patch(t *testing.T) {
+
+	testCases := []struct {
+		name           string
+		contentType    string
+		body           string
+		admin          bool
+		expectedStatus int
+		expectedFirst  string
+		expectedLast   string
+	}{
+		{"merge patch keeps omitted fields", mergePatchContentType, `{"first_name": "Jane"}`, false, http.StatusOK, "Jane", "User"},
+		{"plain json is a merge patch", "application/json; charset=utf-8", `{"last_name": "Doe"}`, false, http.StatusOK, "Admin", "Doe"},
+		{"merge patch null blanks a field", mergePatchContentType, `{"last_name": null}`, false, http.StatusOK, "Admin", ""},
+		{"json patch", jsonPatchContentType, `[{"op": "replace", "path": "/first_name", "value": "Jane"}]`, false, http.StatusOK, "Jane", "User"},
+		{"json patch failing test", jsonPatchContentType, `[{"op": "test", "path": "/first_name", "value": "Nope"}]`, false, http.StatusConflict, "", ""},
+		{"json patch test does not need permission", jsonPatchContentType, `[{"op": "test", "path": "/id", "value": 1}]`, false, http.StatusOK, "Admin", "User"},
+		{"unsupported content type", "text/plain", `first_name=Jane`, false, http.StatusUnsupportedMediaType, "", ""},
+		{"field not in allow-list", mergePatchContentType, `{"id": 5}`, false, http.StatusBadRequest, "", ""},
+		{"is_admin by non-admin", mergePatchContentType, `{"is_admin": 1}`, false, http.StatusForbidden, "", ""},
+		{"is_admin by admin", mergePatchContentType, `{"is_admin": 1}`, true, http.StatusOK, "Admin", "User"},
+		{"invalid result", mergePatchContentType, `{"email": "not-an-email"}`, false, http.StatusBadRequest, "", ""},
+		{"wrong type", mergePatchContentType, `{"first_name": 5}`, false, http.StatusBadRequest, "", ""},
+		{"not an object", mergePatchContentType, `null`, false, http.StatusBadRequest, "", ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("userID", "1")
+			ctx := context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx)
+			ctx = context.WithValue(ctx, contextClaimsKey, &Claims{Admin: tt.admin})
+			req = req.WithContext(ctx)
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(app.updateUser).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("expect status %d; got %d: %s", tt.expectedStatus, rr.Code, rr.Body.String())
+			}
+
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			if rr.Header().Get("ETag") == "" {
+				t.Error("expect an ETag on the updated user")
+			}
+
+			var user struct {
+				ID int `json:"id"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&user); err != nil || user.ID != 1 {
+				t.Errorf("expect the updated user in the response; got %v", err)
+			}
+		})
+	}
+}
@@ -1,38 +1,119 @@
 package main
 
 import (
+	"log/slog"
 	"net/http"
+	"webapp/pkg/data"
+	"webapp/pkg/health"
+	"webapp/pkg/logging"
+	"webapp/pkg/tracing"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 )
 
 func (app *application) routes() http.Handler {
 
 	mux := chi.NewRouter()
-	mux.Use(middleware.Recoverer)
-	mux.Use(app.enableCORS)
+	mux.Use(tracing.Middleware)
+	mux.Use(logging.RequestID)
+	mux.Use(app.ClientIP.Middleware)
+	mux.Use(logging.AccessLog(slog.Default()))
+	mux.Use(logging.Recover)
+	mux.Use(app.Metrics.Middleware)
+	mux.Use(app.CORS.Handler)
 
 	mux.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("./html/"))))
+	mux.HandleFunc("/healthz", health.Live)
+	mux.Handle("/readyz", app.Health)
+
+	authLimit := tracing.Stage("rateLimit", app.RateLimiter.Middleware("auth", app.RateLimit.Auth, app.rateLimitKey))
 
 	// web
 	mux.Route("/web", func(mux chi.Router) {
-		mux.Post("/auth", app.authenticate)
-		mux.Get("/refresh-token", app.refreshUsingCookie)
+		mux.With(authLimit).Post("/auth", app.authenticate)
+		mux.With(authLimit).Get("/refresh-token", app.refreshUsingCookie)
 		mux.Get("/logout", app.deleteRefreshCookie)
 	})
 
 	// routes
-	mux.Post("/auth", app.authenticate)
-	mux.Post("/refresh-token", app.refresh)
+	mux.With(authLimit).Post("/auth", app.authenticate)
+	mux.With(authLimit).Post("/refresh-token", app.refresh)
+	mux.With(tracing.Stage("authRequired", app.authRequired)).Post("/logout", app.logout)
+
+	if app.OIDC != nil {
+		mux.With(authLimit).Get("/auth/oidc", app.oidcLogin)
+		mux.With(authLimit).Get("/auth/oidc/callback", app.oidcCallback)
+	}
+
+	if app.OAuth != nil {
+		mux.Get("/.well-known/openid-configuration", app.oauthMetadata)
+		mux.Get("/.well-known/oauth-authorization-server", app.oauthMetadata)
+
+		mux.Route("/oauth", func(mux chi.Router) {
+			mux.Get("/jwks", app.oauthJWKS)
+			mux.With(authLimit).Get("/authorize", app.oauthAuthorize)
+			mux.With(authLimit, tracing.Stage("authRequired", app.authRequired)).Post("/authorize", app.oauthConsent)
+			mux.With(authLimit).Post("/token", app.oauthToken)
+			mux.With(authLimit).Post("/introspect", app.oauthIntrospect)
+
+			userinfo := mux.With(tracing.Stage("authRequired", app.authRequired))
+			userinfo.Get("/userinfo", app.oauthUserinfo)
+			userinfo.Post("/userinfo", app.oauthUserinfo)
+
+			mux.Route("/clients", func(mux chi.Router) {
+				mux.Use(tracing.Stage("authRequired", app.authRequired), tracing.Stage("adminRequired", app.adminRequired))
+				mux.Get("/", app.allOAuthClients)
+				mux.Post("/", app.insertOAuthClient)
+				mux.Delete("/{clientID}", app.deleteOAuthClient)
+			})
+		})
+	}
 
 	mux.Route("/users", func(mux chi.Router) {
-		mux.Use(app.authRequired)
-		mux.Get("/", app.allUsers)
-		mux.Get("/{userID}", app.getUser)
-		mux.Delete("/{userID}", app.deleteUser)
-		mux.Put("/", app.insertUser)
-		mux.Patch("/", app.updateUser)
+		mux.Use(tracing.Stage("authRequired", app.authRequired))
+		mux.Use(tracing.Stage("rateLimit", app.RateLimiter.Middleware("users", app.RateLimit.Users, app.rateLimitKey)))
+
+		read := mux.With(app.scopeRequired(data.ScopeUsersRead))
+		read.Get("/", app.allUsers)
+		read.Get("/{userID}", app.getUser)
+
+		write := mux.With(app.scopeRequired(data.ScopeUsersWrite))
+		write.Delete("/{userID}", app.deleteUser)
+		write.Put("/", app.insertUser)
+		write.Patch("/{userID}", app.updateUser)
+		write.With(tracing.Stage("adminRequired", app.adminRequired)).Post("/{userID}/restore", app.restoreUser)
+		write.With(tracing.Stage("adminRequired", app.adminRequired)).Post("/{userID}/logout", app.forceLogout)
+	})
+
+	// the user of the token
+	mux.Route("/me", func(mux chi.Router) {
+		mux.Use(tracing.Stage("authRequired", app.authRequired), tracing.Stage("userRequired", app.userRequired))
+		mux.Use(tracing.Stage("rateLimit", app.RateLimiter.Middleware("users", app.RateLimit.Users, app.rateLimitKey)))
+
+		mux.With(app.scopeRequired(data.ScopeUsersRead)).Get("/", app.getMe)
+
+		write := mux.With(app.scopeRequired(data.ScopeUsersWrite))
+		write.Patch("/", app.updateMe)
+		write.Post("/picture", app.uploadMyPicture)
+
+		own := mux.With(tracing.Stage("ownTokenRequired", app.ownTokenRequired))
+		own.With(authLimit).Put("/password", app.changeMyPassword)
+		own.Delete("/", app.deleteMe)
+	})
+
+	mux.Route("/audit", func(mux chi.Router) {
+		mux.Use(tracing.Stage("authRequired", app.authRequired), tracing.Stage("adminRequired", app.adminRequired))
+		mux.Get("/", app.auditLog)
+	})
+
+	mux.Route("/service-accounts", func(mux chi.Router) {
+		mux.Use(tracing.Stage("authRequired", app.authRequired), tracing.Stage("adminRequired", app.adminRequired))
+		mux.Get("/", app.allServiceAccounts)
+		mux.Post("/", app.insertServiceAccount)
+		mux.Get("/{serviceAccountID}/keys", app.apiKeys)
+		mux.Post("/{serviceAccountID}/keys", app.insertAPIKey)
+		mux.Post("/{serviceAccountID}/keys/{keyID}/rotate", app.rotateAPIKey)
+		mux.Delete("/{serviceAccountID}/keys/{keyID}", app.revokeAPIKey)
 	})
 
 	return mux
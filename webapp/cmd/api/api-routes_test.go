@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/tracing"
 
 	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func Test_api_app_routes(t *testing.T) {
@@ -16,11 +25,28 @@ func Test_api_app_routes(t *testing.T) {
 	}{
 		{"/auth", "POST"},
 		{"/refresh-token", "POST"},
+		{"/logout", "POST"},
 		{"/users/", "GET"},
-		{"/users/", "PATCH"},
 		{"/users/", "PUT"},
 		{"/users/{userID}", "GET"},
 		{"/users/{userID}", "DELETE"},
+		{"/users/{userID}", "PATCH"},
+		{"/users/{userID}/restore", "POST"},
+		{"/users/{userID}/logout", "POST"},
+		{"/me/", "GET"},
+		{"/me/", "PATCH"},
+		{"/me/", "DELETE"},
+		{"/me/password", "PUT"},
+		{"/me/picture", "POST"},
+		{"/healthz", "GET"},
+		{"/readyz", "GET"},
+		{"/audit/", "GET"},
+		{"/service-accounts/", "GET"},
+		{"/service-accounts/", "POST"},
+		{"/service-accounts/{serviceAccountID}/keys", "GET"},
+		{"/service-accounts/{serviceAccountID}/keys", "POST"},
+		{"/service-accounts/{serviceAccountID}/keys/{keyID}/rotate", "POST"},
+		{"/service-accounts/{serviceAccountID}/keys/{keyID}", "DELETE"},
 	}
 
 	mux := app.routes()
@@ -33,6 +59,50 @@ func Test_api_app_routes(t *testing.T) {
 		}
 	}
 
+	// the metrics are served on their own listener, see metrics_addr
+	if routeExists("/metrics", "GET", chiRoutes) {
+		t.Error("expect no GET /metrics in the public routes")
+	}
+
+}
+
+func Test_api_app_routes_tracing(t *testing.T) {
+
+	freshRateLimiter(t)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := tracing.NewProvider("api", sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(previous)
+
+	oldDB := app.DB
+	app.DB = repository.Instrument(&dbrepo.MockDBRepo{}, tracing.ObserveRepo)
+	defer func() { app.DB = oldDB }()
+
+	tokens, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", Email: "admin@example.com"})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	rr := httptest.NewRecorder()
+
+	app.routes().ServeHTTP(rr, req)
+	_ = tp.ForceFlush(context.Background())
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expect status 200; got %d", rr.Code)
+	}
+
+	spans := map[string]bool{}
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = true
+	}
+
+	for _, name := range []string{"GET /users", "middleware authRequired", "jwt.verify", "DatabaseRepo.AllUsers", "writeJSON"} {
+		if !spans[name] {
+			t.Errorf("expect a span %q; got %v", name, spans)
+		}
+	}
 }
 
 func routeExists(testRoute string, testMethod string, chiRoutes chi.Routes) bool {
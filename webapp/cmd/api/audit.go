@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"webapp/pkg/clientip"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/validator"
+)
+
+// maxAuditPageSize caps the page_size clients may ask for
+const maxAuditPageSize = 100
+
+// audit records e with the client's IP address and user agent, and the user or service account
+// that made the request. A failure to record is logged, but never fails the request.
+func (app *application) audit(r *http.Request, e data.AuditEvent) {
+	e.IP = clientip.Get(r)
+	e.UserAgent = r.UserAgent()
+
+	if e.ActorID == 0 {
+		e.ActorID = actorID(r)
+	}
+
+	if key, ok := apiKeyFromContext(r.Context()); ok && e.ServiceAccountID == 0 {
+		e.ServiceAccountID = key.ServiceAccountID
+	}
+
+	if _, err := app.DB.InsertAuditEvent(r.Context(), e); err != nil {
+		slog.ErrorContext(r.Context(), "unable to record audit event", "action", e.Action, "error", err)
+	}
+}
+
+// actorID returns the id of the user authenticated by authRequired, or 0
+func actorID(r *http.Request) int {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok {
+		return 0
+	}
+
+	id, _ := strconv.Atoi(claims.Subject)
+	return id
+}
+
+// auditPage is the response of GET /audit
+type auditPage struct {
+	Events   []*data.AuditEvent `json:"events"`
+	Metadata auditMetadata      `json:"metadata"`
+}
+
+type auditMetadata struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+// auditLog lets admins page through the audit log, optionally filtered by action, actor_id
+// and target_id
+func (app *application) auditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	errs := validator.Errors{}
+
+	filter := repository.AuditFilter{
+		Action:   q.Get("action"),
+		ActorID:  queryInt(q.Get("actor_id"), 0, "actor_id", errs),
+		TargetID: queryInt(q.Get("target_id"), 0, "target_id", errs),
+		Page:     queryInt(q.Get("page"), 1, "page", errs),
+		PageSize: queryInt(q.Get("page_size"), repository.DefaultAuditPageSize, "page_size", errs),
+	}
+
+	if filter.Page < 1 {
+		errs.Add("page", "form.min", "min", 1)
+	}
+
+	if filter.PageSize < 1 || filter.PageSize > maxAuditPageSize {
+		errs.Add("page_size", "form.between", "min", 1, "max", maxAuditPageSize)
+	}
+
+	if len(errs) > 0 {
+		app.errorJSON(w, r, errs, http.StatusBadRequest)
+		return
+	}
+
+	events, total, err := app.DB.AuditEvents(r.Context(), filter)
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	if events == nil {
+		events = []*data.AuditEvent{}
+	}
+
+	_ = app.writeJSON(w, r, http.StatusOK, auditPage{
+		Events: events,
+		Metadata: auditMetadata{
+			CurrentPage:  filter.Page,
+			PageSize:     filter.PageSize,
+			LastPage:     (total + filter.PageSize - 1) / filter.PageSize,
+			TotalRecords: total,
+		},
+	})
+}
+
+// queryInt parses a query string parameter, returning def when it is empty; invalid numbers
+// are added to errs
+func queryInt(value string, def int, field string, errs validator.Errors) int {
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		errs.Add(field, "form.number")
+		return def
+	}
+
+	return n
+}
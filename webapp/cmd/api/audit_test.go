@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/revocation"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func Test_api_app_audit_events(t *testing.T) {
+
+	// deleting user 1 revokes its tokens, which the other tests use
+	oldRevocations := app.Revocations
+	defer func() { app.Revocations = oldRevocations }()
+	app.Revocations = revocation.New(app.DB, refreshTokenExpiry)
+
+	testCases := []struct {
+		name           string
+		method         string
+		body           string
+		paramID        string
+		handler        http.HandlerFunc
+		expectedAction string
+		expectedActor  int
+		expectedTarget int
+		expectChanges  bool
+	}{
+		{"login", http.MethodPost, `{"email": "admin@example.com", "password": "secret"}`, "", app.authenticate, data.AuditLogin, 1, 1, false},
+		{"failed login", http.MethodPost, `{"email": "admin@example.com", "password": "wrong"}`, "", app.authenticate, data.AuditLoginFailed, 0, 1, false},
+		{"failed login unknown user", http.MethodPost, `{"email": "invalid@sql.com", "password": "wrong"}`, "", app.authenticate, data.AuditLoginFailed, 0, 0, false},
+		{"insert", http.MethodPut, `{"first_name": "Neo", "email": "neo@example.com"}`, "", app.insertUser, data.AuditUserCreated, 7, 1, true},
+		{"update", http.MethodPatch, `{"first_name": "Jane"}`, "1", app.updateUser, data.AuditUserUpdated, 7, 1, false},
+		{"delete", http.MethodDelete, "", "1", app.deleteUser, data.AuditUserDeleted, 7, 1, false},
+		{"restore", http.MethodPost, "", "2", app.restoreUser, data.AuditUserRestored, 7, 2, false},
+	}
+
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			app.DB = &dbrepo.MockDBRepo{}
+
+			req, _ := http.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("User-Agent", "audit-test")
+
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("userID", tt.paramID)
+			ctx := context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx)
+			// authenticate is not behind authRequired, so only the other handlers get claims
+			if tt.expectedActor == 7 {
+				claims := &Claims{Admin: true, RegisteredClaims: jwt.RegisteredClaims{Subject: "7"}}
+				ctx = context.WithValue(ctx, contextClaimsKey, claims)
+			}
+			req = req.WithContext(ctx)
+
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, req)
+
+			events, total, _ := app.DB.AuditEvents(context.Background(), repository.AuditFilter{})
+			if total != 1 {
+				t.Fatalf("expect one audit event; got %d (status %d)", total, rr.Code)
+			}
+
+			e := events[0]
+			if e.Action != tt.expectedAction {
+				t.Errorf("expect action %s; got %s", tt.expectedAction, e.Action)
+			}
+
+			if e.ActorID != tt.expectedActor {
+				t.Errorf("expect actor %d; got %d", tt.expectedActor, e.ActorID)
+			}
+
+			if e.TargetID != tt.expectedTarget {
+				t.Errorf("expect target %d; got %d", tt.expectedTarget, e.TargetID)
+			}
+
+			if e.IP != "10.0.0.1" || e.UserAgent != "audit-test" {
+				t.Errorf("expect the client's IP and user agent; got %q and %q", e.IP, e.UserAgent)
+			}
+
+			if tt.expectChanges && len(e.Changes) == 0 {
+				t.Error("expect the changed fields to be recorded")
+			}
+
+			if _, ok := e.Changes["password"]; ok {
+				t.Error("expect passwords never to be recorded")
+			}
+		})
+	}
+}
+
+func Test_api_app_auditLog(t *testing.T) {
+
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	db := &dbrepo.MockDBRepo{}
+	app.DB = db
+
+	for i := 0; i < 5; i++ {
+		_, _ = db.InsertAuditEvent(context.Background(), data.AuditEvent{Action: data.AuditLogin, ActorID: 1, TargetID: 1})
+	}
+	_, _ = db.InsertAuditEvent(context.Background(), data.AuditEvent{Action: data.AuditUserDeleted, ActorID: 1, TargetID: 2})
+
+	testCases := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedEvents int
+		expectedTotal  int
+		expectedLast   int
+	}{
+		{"first page", "", http.StatusOK, 6, 6, 1},
+		{"paginated", "?page=2&page_size=4", http.StatusOK, 2, 6, 2},
+		{"past the end", "?page=3&page_size=4", http.StatusOK, 0, 6, 2},
+		{"by action", "?action=user.deleted", http.StatusOK, 1, 1, 1},
+		{"by target", "?target_id=1", http.StatusOK, 5, 5, 1},
+		{"bad page", "?page=x", http.StatusBadRequest, 0, 0, 0},
+		{"page zero", "?page=0", http.StatusBadRequest, 0, 0, 0},
+		{"page size too large", "?page_size=1000", http.StatusBadRequest, 0, 0, 0},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "/audit/"+tt.query, nil)
+			rr := httptest.NewRecorder()
+
+			http.HandlerFunc(app.auditLog).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("expect status %d; got %d", tt.expectedStatus, rr.Code)
+			}
+
+			if rr.Code != http.StatusOK {
+				return
+			}
+
+			var page auditPage
+			if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(page.Events) != tt.expectedEvents {
+				t.Errorf("expect %d events; got %d", tt.expectedEvents, len(page.Events))
+			}
+
+			if page.Metadata.TotalRecords != tt.expectedTotal {
+				t.Errorf("expect %d records in total; got %d", tt.expectedTotal, page.Metadata.TotalRecords)
+			}
+
+			if page.Metadata.LastPage != tt.expectedLast {
+				t.Errorf("expect last page %d; got %d", tt.expectedLast, page.Metadata.LastPage)
+			}
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/tracing"
 
 	"github.com/golang-jwt/jwt/v4"
 )
@@ -19,82 +23,222 @@ type TokenPairs struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+// Token types, in the typ claim, so that a token is refused where another type is expected:
+// a refresh token as an access token, or a signed flow cookie as either.
+const (
+	tokenTypeAccess   = "access"
+	tokenTypeRefresh  = "refresh"
+	tokenTypeOIDCFlow = "oidc_flow"
+)
+
 type Claims struct {
-	UserName string `json:"name"`
+	Type     string `json:"typ"`
+	UserName string `json:"name,omitempty"`
+	Admin    bool   `json:"admin,omitempty"`
+	// ClientID and Scope are set on the tokens issued to OAuth clients, which may only do what
+	// the scopes allow
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 	jwt.RegisteredClaims
 }
 
+func (c *Claims) registered() (string, *jwt.RegisteredClaims) {
+	return c.Type, &c.RegisteredClaims
+}
+
+// Delegated tells whether the token was issued to an OAuth client
+func (c *Claims) Delegated() bool {
+	return c.ClientID != ""
+}
+
+// HasScope tells whether the token was granted scope
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signedClaims are the claims of the tokens the api signs with its secret
+type signedClaims interface {
+	jwt.Claims
+	// registered returns the typ claim and the registered claims
+	registered() (string, *jwt.RegisteredClaims)
+}
+
 func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Request) (string, *Claims, error) {
 
+	_, span := tracing.Start(r.Context(), "jwt.verify")
+	defer span.End()
+
 	w.Header().Add("Vary", "Authorization")
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		return "", nil, errors.New("no auth header")
+		return "", nil, errNoBearerToken
 	}
 
 	headersParts := strings.Split(authHeader, " ")
-	if len(headersParts) != 2 {
-		return "", nil, errors.New("invalid auth header")
-	}
-
-	if headersParts[0] != "Bearer" {
-		return "", nil, errors.New("unauthorized: no Bearer")
+	if len(headersParts) != 2 || headersParts[0] != "Bearer" {
+		return "", nil, errNoBearerToken
 	}
 
 	token := headersParts[1]
 
-	claims := &Claims{}
-
-	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+	claims, err := app.verifyAccessToken(token)
+	if err != nil {
+		return "", nil, err
+	}
 
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unable signing method %v", t.Header["alg"])
-		}
+	return token, claims, nil
+}
 
+// verifyToken is the validator of every token the api signs with its secret. It parses token
+// into claims and checks, in order:
+//   - the HS256 signature, which no other algorithm may replace;
+//   - the typ claim, which must be typ;
+//   - the issuer and the audience, which must both be app.Domain;
+//   - the exp, iat and jti claims, which are required;
+//   - exp, nbf and iat against the clock, allowing app.JWTLeeway of skew;
+//   - the revocation list, by jti and by the user of the subject.
+//
+// The errors are the errToken values, wrapping the cause.
+func (app *application) verifyToken(token, typ string, claims signedClaims) error {
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithoutClaimsValidation())
+
+	_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
 		return []byte(app.JWTSecret), nil
 	})
 
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return errTokenMalformed.wrap(err)
+	case err != nil:
+		return errTokenSignature.wrap(err)
+	}
+
+	tokenType, rc := claims.registered()
+	now := time.Now()
+
+	switch {
+	case tokenType != typ:
+		return errTokenType.wrap(fmt.Errorf("expected a token of type %q; got %q", typ, tokenType))
+	case !rc.VerifyIssuer(app.Domain, true):
+		return errTokenIssuer.wrap(fmt.Errorf("issuer %q", rc.Issuer))
+	case !rc.VerifyAudience(app.Domain, true):
+		return errTokenAudience.wrap(fmt.Errorf("audience %q", rc.Audience))
+	case rc.ExpiresAt == nil || rc.IssuedAt == nil || rc.ID == "":
+		return errTokenClaims
+	case !rc.VerifyExpiresAt(now.Add(-app.JWTLeeway), true):
+		return errTokenExpired.wrap(fmt.Errorf("expired at %s", rc.ExpiresAt.Time))
+	case !rc.VerifyNotBefore(now.Add(app.JWTLeeway), false):
+		return errTokenNotValidYet.wrap(fmt.Errorf("not before %s", rc.NotBefore.Time))
+	case !rc.VerifyIssuedAt(now.Add(app.JWTLeeway), true):
+		return errTokenNotValidYet.wrap(fmt.Errorf("issued at %s", rc.IssuedAt.Time))
+	}
+
+	// the subject of the tokens of OAuth clients is their client_id, which no user has
+	userID, err := strconv.Atoi(rc.Subject)
 	if err != nil {
-		if strings.HasPrefix(err.Error(), "token is expired by") {
-			return "", nil, errors.New("expired token")
-		}
+		userID = 0
+	}
+	if app.Revocations.Revoked(rc.ID, userID, rc.IssuedAt.Time) {
+		return errTokenRevoked
+	}
 
-		return "", nil, err
+	return nil
+}
+
+// verifyAccessToken returns the claims of an access token issued by the api
+func (app *application) verifyAccessToken(token string) (*Claims, error) {
+	claims := &Claims{}
+	if err := app.verifyToken(token, tokenTypeAccess, claims); err != nil {
+		return nil, err
 	}
 
-	if claims.Issuer != app.Domain {
-		return "", nil, errors.New("incorrect issuer")
+	return claims, nil
+}
+
+// verifyRefreshToken returns the claims of a refresh token issued to a user by generateTokenPair
+func (app *application) verifyRefreshToken(token string) (*Claims, error) {
+	claims := &Claims{}
+	if err := app.verifyToken(token, tokenTypeRefresh, claims); err != nil {
+		return nil, err
 	}
 
-	return token, claims, nil
+	// the refresh tokens of OAuth clients keep their scopes; only /oauth/token renews them
+	if claims.Delegated() {
+		return nil, errTokenType.wrap(errors.New("the refresh token of an OAuth client"))
+	}
+
+	return claims, nil
 }
 
-func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
+// registeredClaims returns the registered claims of a token of subject, issued now by the api
+// for itself and expiring after expiry, with a unique id
+func (app *application) registeredClaims(subject string, expiry time.Duration) (jwt.RegisteredClaims, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return jwt.RegisteredClaims{}, err
+	}
+
+	now := time.Now()
+
+	return jwt.RegisteredClaims{
+		ID:        id,
+		Issuer:    app.Domain,
+		Audience:  jwt.ClaimStrings{app.Domain},
+		Subject:   subject,
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
+	}, nil
+}
+
+// signToken signs claims with the secret of the api
+func (app *application) signToken(claims signedClaims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(app.JWTSecret))
+}
+
+// newTokenID returns a random jti
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
 
-	token := jwt.New(jwt.SigningMethodHS256)
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
-	claims["sub"] = fmt.Sprintf("%d", user.ID)
-	claims["aud"] = app.Domain
-	claims["iss"] = app.Domain
-	claims["admin"] = user.IsAdmin == 1
+func (app *application) generateTokenPair(user *data.User) (TokenPairs, error) {
 
-	claims["exp"] = time.Now().Add(jwtTokenExpiry).Unix()
+	subject := fmt.Sprintf("%d", user.ID)
 
-	signedAccessToken, err := token.SignedString([]byte(app.JWTSecret))
+	accessClaims, err := app.registeredClaims(subject, jwtTokenExpiry)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	signedAccessToken, err := app.signToken(&Claims{
+		Type:             tokenTypeAccess,
+		UserName:         fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Admin:            user.IsAdmin == 1,
+		RegisteredClaims: accessClaims,
+	})
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
 	// create refresh token
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
-	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
-	refreshTokenClaims["sub"] = fmt.Sprintf("%d", user.ID)
-	refreshTokenClaims["exp"] = time.Now().Add(refreshTokenExpiry).Unix()
+	refreshClaims, err := app.registeredClaims(subject, refreshTokenExpiry)
+	if err != nil {
+		return TokenPairs{}, err
+	}
 
-	signedRefreshToken, err := refreshToken.SignedString([]byte(app.JWTSecret))
+	signedRefreshToken, err := app.signToken(&Claims{Type: tokenTypeRefresh, RegisteredClaims: refreshClaims})
 	if err != nil {
 		return TokenPairs{}, err
 	}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 	"webapp/pkg/data"
+
+	"github.com/golang-jwt/jwt/v4"
 )
 
 func Test_api_app_getTokenFromHeaderAndVerify(t *testing.T) {
@@ -67,3 +72,103 @@ func Test_api_app_getTokenFromHeaderAndVerify(t *testing.T) {
 		})
 	}
 }
+
+func Test_api_app_verifyToken(t *testing.T) {
+
+	oldLeeway := app.JWTLeeway
+	defer func() { app.JWTLeeway = oldLeeway }()
+	app.JWTLeeway = 30 * time.Second
+
+	tokens, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1})
+
+	// sign returns an access token of user 1, changed by edit
+	sign := func(edit func(c *Claims)) string {
+		registered, _ := app.registeredClaims("1", time.Minute)
+		c := &Claims{Type: tokenTypeAccess, RegisteredClaims: registered}
+		edit(c)
+		token, _ := app.signToken(c)
+		return token
+	}
+
+	ago := func(d time.Duration) *jwt.NumericDate { return jwt.NewNumericDate(time.Now().Add(-d)) }
+
+	hs512, _ := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.MapClaims{"typ": tokenTypeAccess}).SignedString([]byte(app.JWTSecret))
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"typ": tokenTypeAccess}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	otherSecret, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"typ": tokenTypeAccess}).SignedString([]byte("another secret"))
+
+	testCases := []struct {
+		name        string
+		token       string
+		expectedErr error
+	}{
+		{"valid", tokens.Token, nil},
+		{"expired within the leeway", sign(func(c *Claims) { c.ExpiresAt = ago(10 * time.Second) }), nil},
+		{"issued ahead within the leeway", sign(func(c *Claims) { c.IssuedAt, c.NotBefore = ago(-10*time.Second), ago(-10*time.Second) }), nil},
+		{"expired", sign(func(c *Claims) { c.ExpiresAt = ago(time.Minute) }), errTokenExpired},
+		{"fixture expired", expiredToken, errTokenType},
+		{"not valid yet", sign(func(c *Claims) { c.NotBefore = ago(-time.Minute) }), errTokenNotValidYet},
+		{"issued in the future", sign(func(c *Claims) { c.IssuedAt = ago(-time.Minute) }), errTokenNotValidYet},
+		{"refresh token", tokens.RefreshToken, errTokenType},
+		{"flow cookie", sign(func(c *Claims) { c.Type = tokenTypeOIDCFlow }), errTokenType},
+		{"no type", sign(func(c *Claims) { c.Type = "" }), errTokenType},
+		{"other issuer", sign(func(c *Claims) { c.Issuer = "other.example.com" }), errTokenIssuer},
+		{"other audience", sign(func(c *Claims) { c.Audience = jwt.ClaimStrings{"other.example.com"} }), errTokenAudience},
+		{"no audience", sign(func(c *Claims) { c.Audience = nil }), errTokenAudience},
+		{"no jti", sign(func(c *Claims) { c.ID = "" }), errTokenClaims},
+		{"no exp", sign(func(c *Claims) { c.ExpiresAt = nil }), errTokenClaims},
+		{"no iat", sign(func(c *Claims) { c.IssuedAt = nil }), errTokenClaims},
+		{"HS512", hs512, errTokenSignature},
+		{"alg none", none, errTokenSignature},
+		{"other secret", otherSecret, errTokenSignature},
+		{"malformed", "not.a.jwt", errTokenMalformed},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := app.verifyAccessToken(tt.token)
+
+			if tt.expectedErr == nil && err != nil {
+				t.Errorf("expect no error; got %s", err)
+			}
+
+			if tt.expectedErr != nil && !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expect %v; got %v", tt.expectedErr, err)
+			}
+		})
+	}
+
+	// each token has its own id
+	other, _ := app.generateTokenPair(&data.User{ID: 1})
+	first, _ := app.verifyAccessToken(tokens.Token)
+	second, _ := app.verifyAccessToken(other.Token)
+	if first.ID == "" || first.ID == second.ID {
+		t.Errorf("expect unique jti; got %q and %q", first.ID, second.ID)
+	}
+
+	// refresh tokens are not accepted as access tokens, nor the reverse
+	if _, err := app.verifyRefreshToken(tokens.Token); !errors.Is(err, errTokenType) {
+		t.Errorf("expect an access token to be refused as a refresh token; got %v", err)
+	}
+
+	if _, err := app.verifyRefreshToken(tokens.RefreshToken); err != nil {
+		t.Errorf("expect the refresh token to be valid; got %s", err)
+	}
+}
+
+func Test_api_app_authRequired_problem(t *testing.T) {
+
+	tokens, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.RefreshToken)
+	rr := httptest.NewRecorder()
+
+	app.authRequired(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+
+	var p problem
+	_ = json.NewDecoder(rr.Body).Decode(&p)
+
+	if rr.Code != http.StatusUnauthorized || p.Code != errTokenType.Code || rr.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expect 401 with code %s and a challenge; got %d %+v", errTokenType.Code, rr.Code, p)
+	}
+}
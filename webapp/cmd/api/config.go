@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+	"webapp/pkg/config"
+	"webapp/pkg/cors"
+	"webapp/pkg/oidc"
+	"webapp/pkg/purge"
+	"webapp/pkg/ratelimit"
+)
+
+// apiConfig holds the settings of the api, loaded by package config
+type apiConfig struct {
+	config.Base `yaml:",inline"`
+	config.Auth `yaml:",inline"`
+	// deleted users are purged by the api as well as the web app, so either can run alone
+	purge.Config `yaml:",inline"`
+
+	UploadPath string `yaml:"upload_path" toml:"upload_path" env:"UPLOAD_PATH" flag:"upload-path" usage:"directory where uploaded profile pictures are stored, shared with the web app"`
+
+	CORS      cors.Config   `yaml:"cors" toml:"cors"`
+	RateLimit apiRateLimits `yaml:"rate_limit" toml:"rate_limit"`
+	OIDC      oidc.Config   `yaml:"oidc" toml:"oidc"`
+	OAuth     oauthConfig   `yaml:"oauth" toml:"oauth"`
+}
+
+// oauthConfig makes the api an OAuth 2.0 authorization server and OpenID Connect provider for
+// the registered clients; it is disabled while Issuer is empty
+type oauthConfig struct {
+	Issuer         string `yaml:"issuer" toml:"issuer" env:"OAUTH_ISSUER" flag:"oauth-issuer" usage:"public URL of the api as an OAuth authorization server, e.g. https://api.example.com; empty disables it"`
+	SigningKeyFile string `yaml:"signing_key_file" toml:"signing_key_file" env:"OAUTH_SIGNING_KEY_FILE" flag:"oauth-signing-key-file" usage:"PEM file of the RSA or P-256 private key signing the ID tokens; in dev mode a key is generated at start"`
+}
+
+// Enabled tells whether the authorization server is configured
+func (c oauthConfig) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// Validate checks the authorization server settings; plain http issuers and generated keys are
+// only accepted in dev mode
+func (c oauthConfig) Validate(dev bool) error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	var errs []error
+
+	u, err := url.Parse(c.Issuer)
+	switch {
+	case err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http"):
+		errs = append(errs, fmt.Errorf("oauth.issuer must be an http(s) URL; got %q", c.Issuer))
+	case u.Scheme == "http" && !dev:
+		errs = append(errs, errors.New("oauth.issuer must use https outside dev mode"))
+	case u.RawQuery != "" || u.Fragment != "":
+		errs = append(errs, errors.New("oauth.issuer must not have a query or a fragment"))
+	}
+
+	if c.SigningKeyFile == "" && !dev {
+		errs = append(errs, errors.New("oauth.signing_key_file is required outside dev mode"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// apiRateLimits are the limits of the route groups of the api
+type apiRateLimits struct {
+	ratelimit.Config `yaml:",inline"`
+
+	Auth  ratelimit.Limit `yaml:"auth" toml:"auth" env:"RATE_LIMIT_AUTH" flag:"rate-limit-auth" usage:"requests per client IP to /auth and /refresh-token, e.g. 10/1m, or off"`
+	Users ratelimit.Limit `yaml:"users" toml:"users" env:"RATE_LIMIT_USERS" flag:"rate-limit-users" usage:"requests per user or API key to /users and /me, e.g. 300/1m, or off"`
+}
+
+func defaultConfig() apiConfig {
+	credentials := true
+
+	return apiConfig{
+		Base:       config.NewBase("api", ":8081", "localhost:9081"),
+		Auth:       config.NewAuth(),
+		Config:     purge.NewConfig(),
+		UploadPath: "./static/img",
+		CORS: cors.Config{
+			AllowedOrigins: []string{"http://localhost:8081", "https://localhost:8081"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "If-Match", "X-API-Key", "X-CSRF-Token", "X-Request-ID", "traceparent"},
+			ExposedHeaders: []string{"ETag", "Location", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "X-Request-ID"},
+			MaxAge:         10 * time.Minute,
+			// the web routes keep the refresh token in a cookie
+			Routes: []cors.Route{{Path: "/web", AllowCredentials: &credentials}},
+		},
+		RateLimit: apiRateLimits{
+			Config: ratelimit.Config{Store: ratelimit.StoreMemory},
+			Auth:   ratelimit.Every(10, time.Minute),
+			Users:  ratelimit.Every(300, time.Minute),
+		},
+	}
+}
+
+func (c *apiConfig) Validate() error {
+	errs := []error{c.Base.Validate(), c.Auth.Validate(c.Dev), c.Config.Validate()}
+
+	if c.UploadPath == "" {
+		errs = append(errs, errors.New("upload_path is required"))
+	}
+
+	errs = append(errs, c.CORS.Validate(), c.RateLimit.Validate(), c.OIDC.Validate(), c.OAuth.Validate(c.Dev))
+
+	return errors.Join(errs...)
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"database/sql"
-	"log"
+	"log/slog"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -30,7 +30,7 @@ func (app *application) connectToDB() (*sql.DB, error) {
 		return nil, err
 	}
 
-	log.Println("connected to postgres...")
+	slog.Info("connected to postgres")
 
 	return con, nil
 }
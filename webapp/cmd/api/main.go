@@ -1,49 +1,179 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+	"webapp/pkg/clientip"
+	"webapp/pkg/config"
+	"webapp/pkg/cors"
+	"webapp/pkg/health"
+	"webapp/pkg/logging"
+	"webapp/pkg/metrics"
+	"webapp/pkg/oidc"
+	"webapp/pkg/purge"
+	"webapp/pkg/ratelimit"
 	"webapp/pkg/repository"
 	"webapp/pkg/repository/dbrepo"
-)
-
-const (
-	port = 8081
+	"webapp/pkg/revocation"
+	"webapp/pkg/server"
+	"webapp/pkg/tracing"
+	"webapp/pkg/validator"
 )
 
 type application struct {
 	DSN       string
-	Port      int
+	ClientIP  *clientip.Resolver
+	CORS      *cors.CORS
 	DB        repository.DatabaseRepo
 	Domain    string
 	JWTSecret string
+	JWTLeeway time.Duration
+	Validator *validator.Validator
+	Metrics   *metrics.Metrics
+	Health    *health.Checker
+	// UploadPath is where the profile pictures are stored
+	UploadPath string
+
+	RateLimit   apiRateLimits
+	RateLimiter *ratelimit.Limiter
+
+	// Revocations are the tokens refused before they expire
+	Revocations *revocation.List
+
+	// OIDC is the provider of single sign-on, nil when it is disabled
+	OIDC *oidc.Provider
+	// OAuth is the authorization server for other apps, nil when it is disabled
+	OAuth *authorizationServer
 }
 
 func main() {
 	var app application
 
-	app.Port = port
-	flag.StringVar(&app.Domain, "domain", "example.com", "Domain for the application e.g example.com")
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "postres connection string")
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "b2xlIjoiQWRtaW4iLCJJc3N1ZXIiOiJJc3N1ZXIiLCJVc2VybmFtZSI6IkphdmFJblVzZSIsImV4cCI6MTY2OTY4MjE1NiwiaWF0IjoxNjY5NjgyMTU2fQ", "JWT Secret")
-	flag.Parse()
+	cfg := defaultConfig()
+	printConfig := flag.Bool("print-config", false, "print the effective settings, secrets redacted, and exit")
+	if err := config.Load(&cfg, flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *printConfig {
+		if err := config.Print(os.Stdout, &cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	app.DSN = cfg.DSN
+	app.Domain = cfg.Domain
+	app.JWTSecret = cfg.JWTSecret
+	app.JWTLeeway = cfg.JWTLeeway
+	app.UploadPath = cfg.UploadPath
+
+	// the settings were validated, so the resolver and the policy compile
+	app.ClientIP, _ = clientip.New(cfg.ClientIP)
+	app.CORS, _ = cors.New(cfg.CORS)
+	app.RateLimit = cfg.RateLimit
+
+	if cfg.OIDC.Enabled() {
+		app.OIDC = oidc.New(cfg.OIDC, nil)
+	}
+
+	slog.SetDefault(logging.New(os.Stdout, cfg.LogLevel))
+
+	if cfg.OAuth.Enabled() {
+		if cfg.OAuth.SigningKeyFile == "" {
+			slog.Warn("no oauth.signing_key_file: the ID tokens are signed with a key generated for this run")
+		}
+
+		key, err := loadSigningKey(cfg.OAuth.SigningKeyFile)
+		if err != nil {
+			slog.Error("unable to load the signing key of the ID tokens", "error", err)
+			os.Exit(1)
+		}
+
+		app.OAuth, err = newAuthorizationServer(cfg.OAuth.Issuer, key)
+		if err != nil {
+			slog.Error("unable to set up the authorization server", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Error("unable to set up tracing", "error", err)
+		os.Exit(1)
+	}
 
 	conn, err := app.connectToDB()
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("unable to connect to postgres", "error", err)
+		os.Exit(1)
 	}
 
-	defer conn.Close()
+	if err := dbrepo.Migrate(conn); err != nil {
+		slog.Error("unable to migrate the database", "error", err)
+		os.Exit(1)
+	}
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	app.Metrics = metrics.New()
+	app.Metrics.RegisterDB(conn, "users")
+	app.DB = repository.Instrument(&dbrepo.PostgresDBRepo{DB: conn}, tracing.ObserveRepo, app.Metrics.ObserveRepo)
+	app.Validator = validator.New()
 
-	log.Printf("starting api on port %d...", port)
+	app.Health = health.New(health.DefaultTimeout)
+	app.Health.Add("database", health.Ping(conn))
+	app.Health.Add("migrations", health.Migrations(conn))
+	app.Health.Add("uploads", health.Writable(app.UploadPath))
 
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
+	app.RateLimiter = ratelimit.New(cfg.RateLimit.NewStore(conn), cfg.RateLimit.Auth, cfg.RateLimit.Users)
+	app.RateLimiter.Denied = app.tooManyRequests
+	app.RateLimiter.Failed = app.Metrics.RateLimitFailed
 
-	if err != nil {
-		log.Fatal(err)
+	app.Revocations = revocation.New(app.DB, refreshTokenExpiry+app.JWTLeeway)
+	if err := app.Revocations.Sync(context.Background()); err != nil {
+		slog.Error("unable to load the revoked tokens", "error", err)
+		os.Exit(1)
+	}
+
+	srv := server.New(cfg.HTTP, app.routes())
+
+	if cfg.MetricsAddr != "" {
+		metricsLn, err := net.Listen("tcp", cfg.MetricsAddr)
+		if err != nil {
+			slog.Error("unable to listen for the metrics", "error", err)
+			os.Exit(1)
+		}
+
+		srv.Go(func(ctx context.Context) {
+			if err := app.Metrics.Serve(ctx, metricsLn); err != nil {
+				slog.Error("metrics server failed", "error", err)
+			}
+		})
+		slog.Info("serving metrics", "addr", metricsLn.Addr().String())
+	}
+
+	// closers run in reverse: the database is closed before the last spans are flushed
+	srv.OnShutdown("tracing", shutdownTracing)
+	srv.OnShutdown("database", func(context.Context) error { return conn.Close() })
+
+	srv.Go(func(ctx context.Context) {
+		purge.Run(ctx, app.DB, app.UploadPath, cfg.Config)
+	})
+	srv.Go(func(ctx context.Context) {
+		app.RateLimiter.Sweep(ctx, ratelimit.DefaultSweepInterval)
+	})
+	srv.Go(func(ctx context.Context) {
+		app.Revocations.Run(ctx, revocation.DefaultSyncInterval)
+	})
+
+	if err := srv.Run(context.Background()); err != nil {
+		slog.Error("api stopped", "error", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"webapp/pkg/data"
+	"webapp/pkg/upload"
+)
+
+// profile is the user of the request as returned by /me, with the profile picture
+type profile struct {
+	*data.User
+	ProfilePicture string `json:"profile_picture,omitempty"`
+}
+
+func newProfile(user *data.User) profile {
+	return profile{User: user, ProfilePicture: user.ProfilePic.FileName}
+}
+
+// passwordRequest is the body of PUT /me/password
+type passwordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=72"`
+}
+
+// getMe returns the user of the token
+func (app *application) getMe(w http.ResponseWriter, r *http.Request) {
+	user, err := app.DB.GetUser(r.Context(), actorID(r))
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(user.Version))
+	_ = app.writeJSON(w, r, http.StatusOK, newProfile(user))
+}
+
+// updateMe patches the name and email of the user of the token, like updateUser
+func (app *application) updateMe(w http.ResponseWriter, r *http.Request) {
+	updated, ok := app.patchUser(w, r, actorID(r), patchableProfileFields, false)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("ETag", etag(updated.Version))
+	_ = app.writeJSON(w, r, http.StatusOK, newProfile(updated))
+}
+
+// changeMyPassword sets the password of the user of the token, once the current one was
+// confirmed. Every token of the user is revoked, this one included, so the user logs in again.
+func (app *application) changeMyPassword(w http.ResponseWriter, r *http.Request) {
+	var req passwordRequest
+	if err := app.readJSON(w, r, &req); err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	validationErrors, err := app.Validator.Struct(&req, "json")
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if len(validationErrors) > 0 {
+		app.errorJSON(w, r, validationErrors, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), actorID(r))
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	if valid, err := user.PasswordMatches(req.CurrentPassword); err != nil || !valid {
+		app.errorJSON(w, r, errWrongPassword, http.StatusForbidden)
+		return
+	}
+
+	if err := app.DB.ResetPassword(r.Context(), user.ID, req.NewPassword); err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{Action: data.AuditPasswordChanged, TargetID: user.ID})
+
+	// the password is changed either way; the old sessions would only live until they expire
+	if err := app.Revocations.RevokeUser(r.Context(), user.ID); err != nil {
+		slog.ErrorContext(r.Context(), "unable to revoke the tokens after a password change", "user_id", user.ID, "error", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadMyPicture stores the picture posted in the image field of a multipart form as the profile
+// picture of the user of the token, like the profile page of the web app, and returns the
+// updated user
+func (app *application) uploadMyPicture(w http.ResponseWriter, r *http.Request) {
+	user, err := app.DB.GetUser(r.Context(), actorID(r))
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	file, err := upload.Image(r, app.UploadPath, "image")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			app.errorJSON(w, r, errInvalidUpload.wrap(err), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, upload.ErrNotImage):
+			app.errorJSON(w, r, errNotImage.wrap(err), http.StatusUnsupportedMediaType)
+		default:
+			app.errorJSON(w, r, errInvalidUpload.wrap(err), http.StatusBadRequest)
+		}
+		return
+	}
+
+	app.Metrics.Uploaded(file.FileSize)
+
+	_, err = app.DB.InsertUserImage(r.Context(), data.UserImage{UserID: user.ID, FileName: file.FileName})
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	// the new picture has a name of its own, so the replaced one would be left behind
+	if err := upload.Remove(app.UploadPath, user.ProfilePic.FileName); err != nil {
+		slog.WarnContext(r.Context(), "unable to remove the replaced profile picture", "file", user.ProfilePic.FileName, "error", err)
+	}
+
+	updated, err := app.DB.GetUser(r.Context(), user.ID)
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{Action: data.AuditPictureUploaded, TargetID: user.ID, Changes: data.DiffUsers(*user, *updated)})
+
+	w.Header().Set("ETag", etag(updated.Version))
+	_ = app.writeJSON(w, r, http.StatusOK, newProfile(updated))
+}
+
+// deleteMe deletes the user of the token, like deleteUser
+func (app *application) deleteMe(w http.ResponseWriter, r *http.Request) {
+	app.softDeleteUser(w, r, actorID(r))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/revocation"
+)
+
+func Test_api_app_me(t *testing.T) {
+
+	oldRevocations, oldUploadPath := app.Revocations, app.UploadPath
+	defer func() { app.Revocations, app.UploadPath = oldRevocations, oldUploadPath }()
+
+	freshRateLimiter(t)
+	app.Revocations = revocation.New(app.DB, refreshTokenExpiry)
+	app.UploadPath = t.TempDir()
+
+	routes := app.routes()
+
+	user, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1})
+	unknown, _ := app.generateTokenPair(&data.User{ID: 2, FirstName: "Jane", LastName: "Doe"})
+	client := &data.OAuthClient{ClientID: "reports", Name: "Reports"}
+	delegated, _ := app.oauthAccessToken(client, "1", "Admin User", true, []string{data.ScopeUsersRead})
+	clientOnly, _ := app.oauthAccessToken(client, "reports", "Reports", false, []string{data.ScopeUsersRead})
+
+	testCases := []struct {
+		name           string
+		method         string
+		target         string
+		token          string
+		body           string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"get", http.MethodGet, "/me/", user.Token, "", http.StatusOK, ""},
+		{"no token", http.MethodGet, "/me/", "", "", http.StatusUnauthorized, "no_token"},
+		{"deleted user", http.MethodGet, "/me/", unknown.Token, "", http.StatusNotFound, "not_found"},
+		{"token of a client", http.MethodGet, "/me/", clientOnly, "", http.StatusForbidden, "user_required"},
+		{"get with a delegated token", http.MethodGet, "/me/", delegated, "", http.StatusOK, ""},
+		{"patch", http.MethodPatch, "/me/", user.Token, `{"first_name": "Neo"}`, http.StatusOK, ""},
+		{"patch is_admin", http.MethodPatch, "/me/", user.Token, `{"is_admin": 0}`, http.StatusBadRequest, "field_not_patchable"},
+		{"patch invalid email", http.MethodPatch, "/me/", user.Token, `{"email": "neo"}`, http.StatusBadRequest, "validation_failed"},
+		{"patch without the write scope", http.MethodPatch, "/me/", delegated, `{"first_name": "Neo"}`, http.StatusForbidden, "insufficient_scope"},
+		{"wrong current password", http.MethodPut, "/me/password", user.Token, `{"current_password": "wrong", "new_password": "new secret"}`, http.StatusForbidden, "wrong_password"},
+		{"short new password", http.MethodPut, "/me/password", user.Token, `{"current_password": "secret", "new_password": "short"}`, http.StatusBadRequest, "validation_failed"},
+		{"password with a delegated token", http.MethodPut, "/me/password", delegated, `{"current_password": "secret", "new_password": "new secret"}`, http.StatusForbidden, "user_token_required"},
+		{"delete with a delegated token", http.MethodDelete, "/me/", delegated, "", http.StatusForbidden, "user_token_required"},
+		{"picture not in a form", http.MethodPost, "/me/picture", user.Token, `{}`, http.StatusBadRequest, "invalid_upload"},
+	}
+
+	for _, tt := range testCases {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedStatus {
+			t.Errorf("%s: expect status %d; got %d: %s", tt.name, tt.expectedStatus, rr.Code, rr.Body)
+			continue
+		}
+
+		if tt.expectedCode != "" {
+			var p problem
+			_ = json.NewDecoder(rr.Body).Decode(&p)
+			if p.Code != tt.expectedCode {
+				t.Errorf("%s: expect problem code %s; got %s", tt.name, tt.expectedCode, p.Code)
+			}
+			continue
+		}
+
+		var got map[string]any
+		_ = json.NewDecoder(rr.Body).Decode(&got)
+		if got["id"] != float64(1) || rr.Header().Get("ETag") == "" {
+			t.Errorf("%s: expect the profile of the user with its ETag; got %v", tt.name, got)
+		}
+	}
+}
+
+func Test_api_app_uploadMyPicture(t *testing.T) {
+
+	freshRateLimiter(t)
+
+	oldUploadPath := app.UploadPath
+	defer func() { app.UploadPath = oldUploadPath }()
+	app.UploadPath = t.TempDir()
+
+	user, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1})
+
+	var picture bytes.Buffer
+	_ = png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+
+	testCases := []struct {
+		name           string
+		content        string
+		expectedStatus int
+		expectedFiles  int
+	}{
+		{"not an image", "<script>alert(1)</script>", http.StatusUnsupportedMediaType, 0},
+		{"png", picture.String(), http.StatusOK, 1},
+	}
+
+	for _, tt := range testCases {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		part, _ := mw.CreateFormFile("image", "me.png")
+		_, _ = io.WriteString(part, tt.content)
+		_ = mw.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/me/picture", body)
+		req.Header.Set("Authorization", "Bearer "+user.Token)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectedStatus {
+			t.Errorf("%s: expect status %d; got %d: %s", tt.name, tt.expectedStatus, rr.Code, rr.Body)
+		}
+
+		// the picture is saved under a name of its own, so that it can not replace another one
+		entries, _ := os.ReadDir(app.UploadPath)
+		if len(entries) != tt.expectedFiles || (len(entries) > 0 && entries[0].Name() == "me.png") {
+			t.Errorf("%s: expect %d pictures under generated names; got %v", tt.name, tt.expectedFiles, entries)
+		}
+	}
+}
+
+func Test_api_app_changeMyPassword(t *testing.T) {
+
+	oldRevocations := app.Revocations
+	defer func() { app.Revocations = oldRevocations }()
+
+	freshRateLimiter(t)
+	app.Revocations = revocation.New(app.DB, refreshTokenExpiry)
+
+	routes := app.routes()
+	user, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1})
+
+	req := httptest.NewRequest(http.MethodPut, "/me/password", strings.NewReader(`{"current_password": "secret", "new_password": "new secret"}`))
+	req.Header.Set("Authorization", "Bearer "+user.Token)
+
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expect status 204; got %d: %s", rr.Code, rr.Body)
+	}
+
+	// the other sessions end with the old password
+	if _, err := app.verifyRefreshToken(user.RefreshToken); err == nil {
+		t.Error("expect the refresh token to be revoked after the password change")
+	}
+}
+
+func Test_api_app_deleteMe(t *testing.T) {
+
+	freshRateLimiter(t)
+
+	oldRevocations := app.Revocations
+	defer func() { app.Revocations = oldRevocations }()
+	app.Revocations = revocation.New(app.DB, refreshTokenExpiry)
+
+	routes := app.routes()
+	user, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1})
+
+	do := func() int {
+		req := httptest.NewRequest(http.MethodDelete, "/me/", nil)
+		req.Header.Set("Authorization", "Bearer "+user.Token)
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if status := do(); status != http.StatusNoContent {
+		t.Fatalf("expect status 204; got %d", status)
+	}
+
+	if status := do(); status != http.StatusUnauthorized {
+		t.Errorf("expect the token of the deleted user to be revoked; got %d", status)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// oauthClientRequest is the body of POST /oauth/clients. Public clients, e.g. single page or
+// native apps, get no secret.
+type oauthClientRequest struct {
+	Name         string   `json:"name" validate:"required,max=100"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	Public       bool     `json:"public"`
+}
+
+// newOAuthClientResponse shows the client secret, which is never shown again
+type newOAuthClientResponse struct {
+	*data.OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// insertOAuthClient registers an OAuth client. The response is the only place its secret can be
+// read.
+func (app *application) insertOAuthClient(w http.ResponseWriter, r *http.Request) {
+	var req oauthClientRequest
+	if err := app.readJSON(w, r, &req); err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	errs := validator.Errors{}
+	checkScopes(req.Scopes, data.OAuthScopes, errs)
+	checkGrantTypes(req.GrantTypes, req.Public, errs)
+	if validator.In(data.GrantAuthorizationCode, req.GrantTypes...) {
+		checkRedirectURIs(req.RedirectURIs, errs)
+	}
+
+	if len(errs) > 0 {
+		app.errorJSON(w, r, errs, http.StatusBadRequest)
+		return
+	}
+
+	clientID, err := data.NewOAuthClientID()
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	client := data.OAuthClient{
+		ClientID:     clientID,
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+		GrantTypes:   req.GrantTypes,
+		CreatedBy:    actorID(r),
+	}
+
+	var secret string
+	if !req.Public {
+		secret, client.SecretHash, err = data.NewOAuthClientSecret()
+		if err != nil {
+			app.errorJSON(w, r, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := app.DB.InsertOAuthClient(r.Context(), client); err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	created, err := app.DB.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{
+		Action:  data.AuditOAuthClientCreated,
+		Changes: map[string]data.AuditChange{"client_id": {To: clientID}, "scopes": {To: strings.Join(client.Scopes, " ")}},
+	})
+
+	w.Header().Set("Location", "/oauth/clients/"+clientID)
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.writeJSON(w, r, http.StatusCreated, newOAuthClientResponse{OAuthClient: created, ClientSecret: secret})
+}
+
+func (app *application) allOAuthClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := app.DB.AllOAuthClients(r.Context())
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	if clients == nil {
+		clients = []*data.OAuthClient{}
+	}
+
+	_ = app.writeJSON(w, r, http.StatusOK, clients)
+}
+
+// deleteOAuthClient unregisters a client; the tokens it holds stay valid until they expire
+func (app *application) deleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	clientID := chi.URLParam(r, "clientID")
+
+	err := app.DB.DeleteOAuthClient(r.Context(), clientID)
+	if errors.Is(err, repository.ErrNotFound) {
+		app.errorJSON(w, r, errOAuthClientNotFound.wrap(err), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{
+		Action:  data.AuditOAuthClientDeleted,
+		Changes: map[string]data.AuditChange{"client_id": {From: clientID}},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkGrantTypes adds an error to errs unless grantTypes has at least one grant type, all of
+// them known. Public clients can not authenticate, so they can not use client_credentials.
+func checkGrantTypes(grantTypes []string, public bool, errs validator.Errors) {
+	if len(grantTypes) == 0 {
+		errs.Add("grant_types", "form.required")
+		return
+	}
+
+	permitted := data.GrantTypes
+	if public {
+		permitted = []string{data.GrantAuthorizationCode, data.GrantRefreshToken}
+	}
+
+	for _, g := range grantTypes {
+		if !validator.In(g, permitted...) {
+			errs.Add("grant_types", "form.in", "values", strings.Join(permitted, ", "))
+			return
+		}
+	}
+}
+
+// checkRedirectURIs adds an error to errs unless there is at least one redirect URI, all of them
+// absolute and without fragment (RFC 6749 section 3.1.2). Plain http is only accepted for the
+// loopback interface, which native apps and development servers listen on.
+func checkRedirectURIs(uris []string, errs validator.Errors) {
+	if len(uris) == 0 {
+		errs.Add("redirect_uris", "form.required")
+		return
+	}
+
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" || strings.ContainsAny(uri, " \t") {
+			errs.Add("redirect_uris", "form.redirect_uri")
+			return
+		}
+
+		if u.Scheme != "https" && !(u.Scheme == "http" && isLoopback(u.Hostname())) {
+			errs.Add("redirect_uris", "form.redirect_uri")
+			return
+		}
+	}
+}
+
+// isLoopback tells whether host is localhost or a loopback address
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
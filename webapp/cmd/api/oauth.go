@@ -0,0 +1,854 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/oidc"
+	"webapp/pkg/repository"
+	"webapp/pkg/validator"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// authorizationCodeExpiry is how long a client has to exchange a code
+	authorizationCodeExpiry = time.Minute
+	// consentPage is the page of ./html/ where the user logs in and consents; it posts the
+	// authorization request back to /oauth/authorize with the user's token
+	consentPage = "/consent.html"
+)
+
+// authorizationServer is what the api needs to act as an OAuth 2.0 authorization server and
+// OpenID Connect provider. Access and refresh tokens are signed with the JWT secret, as those of
+// /auth; ID tokens are signed with Key, so that clients can check them with the public key.
+type authorizationServer struct {
+	Issuer string
+	Key    crypto.Signer
+	jwk    oidc.JWK
+	method jwt.SigningMethod
+}
+
+// newAuthorizationServer returns the server of issuer, signing ID tokens with key, which is an
+// RSA or a P-256 key
+func newAuthorizationServer(issuer string, key crypto.Signer) (*authorizationServer, error) {
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	// the key id changes with the key, so that clients fetch the new key after a rotation
+	sum := sha256.Sum256(der)
+	jwk, err := oidc.NewJWK(base64.RawURLEncoding.EncodeToString(sum[:12]), key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &authorizationServer{
+		Issuer: strings.TrimSuffix(issuer, "/"),
+		Key:    key,
+		jwk:    jwk,
+		method: jwt.GetSigningMethod(jwk.Algorithm),
+	}, nil
+}
+
+// loadSigningKey reads the PEM private key at path; without a path it generates a key, which
+// only lives until the api stops
+func loadSigningKey(path string) (crypto.Signer, error) {
+	if path == "" {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		if k.Curve == elliptic.P256() {
+			return k, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s: only RSA and P-256 keys are supported", path)
+}
+
+// oauthError is an error of RFC 6749, sent as the error and error_description parameters of
+// the redirect URI, or as the JSON body of the token endpoint
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func newOAuthError(code, description string) *oauthError {
+	return &oauthError{Code: code, Description: description}
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+var (
+	errOAuthInvalidClient = newOAuthError("invalid_client", "client authentication failed")
+	errOAuthInvalidGrant  = newOAuthError("invalid_grant", "the code or refresh token is invalid, expired or was used")
+	errOAuthInvalidScope  = newOAuthError("invalid_scope", "the scope is empty, unknown or not allowed for this client")
+)
+
+// writeOAuthError answers a request of a client with err; a failed client authentication gets a
+// 401, as RFC 6749 section 5.2 requires
+func (app *application) writeOAuthError(w http.ResponseWriter, r *http.Request, err *oauthError) {
+	status := http.StatusBadRequest
+	if err.Code == errOAuthInvalidClient.Code {
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	app.logError(r, err, status)
+
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.writeJSON(w, r, status, err)
+}
+
+// oauthMetadata serves the discovery document of the authorization server (RFC 8414 and OpenID
+// Connect Discovery 1.0)
+func (app *application) oauthMetadata(w http.ResponseWriter, r *http.Request) {
+	issuer := app.OAuth.Issuer
+
+	_ = app.writeJSON(w, r, http.StatusOK, oidc.Metadata{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserinfoEndpoint:                  issuer + "/oauth/userinfo",
+		JWKSURI:                           issuer + "/oauth/jwks",
+		IntrospectionEndpoint:             issuer + "/oauth/introspect",
+		ScopesSupported:                   data.OAuthScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               data.GrantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{app.OAuth.method.Alg()},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+	})
+}
+
+// oauthJWKS serves the public key of the ID tokens
+func (app *application) oauthJWKS(w http.ResponseWriter, r *http.Request) {
+	_ = app.writeJSON(w, r, http.StatusOK, oidc.JWKS{Keys: []oidc.JWK{app.OAuth.jwk}})
+}
+
+// authorizeRequest is an authorization request: the query of GET /oauth/authorize, and the body
+// of POST /oauth/authorize with the user's Consent
+type authorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	Nonce               string `json:"nonce"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+	Consent             *bool  `json:"consent"`
+}
+
+func authorizeRequestFromQuery(q url.Values) authorizeRequest {
+	return authorizeRequest{
+		ResponseType:        q.Get("response_type"),
+		ClientID:            q.Get("client_id"),
+		RedirectURI:         q.Get("redirect_uri"),
+		Scope:               q.Get("scope"),
+		State:               q.Get("state"),
+		Nonce:               q.Get("nonce"),
+		CodeChallenge:       q.Get("code_challenge"),
+		CodeChallengeMethod: q.Get("code_challenge_method"),
+	}
+}
+
+// query returns the request as the query of the consent page
+func (req *authorizeRequest) query() url.Values {
+	q := url.Values{}
+
+	for name, value := range map[string]string{
+		"response_type":         req.ResponseType,
+		"client_id":             req.ClientID,
+		"redirect_uri":          req.RedirectURI,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+	} {
+		if value != "" {
+			q.Set(name, value)
+		}
+	}
+
+	return q
+}
+
+// authorizeResponse is the answer of POST /oauth/authorize: the page either asks the user to
+// consent to Scopes for Client, or sends the browser to RedirectTo
+type authorizeResponse struct {
+	RedirectTo      string         `json:"redirect_to,omitempty"`
+	ConsentRequired bool           `json:"consent_required,omitempty"`
+	Client          *consentClient `json:"client,omitempty"`
+	Scopes          []string       `json:"scopes,omitempty"`
+}
+
+// consentClient is what the consent page shows of the client
+type consentClient struct {
+	ClientID string `json:"client_id"`
+	Name     string `json:"name"`
+}
+
+// checkAuthorization checks an authorization request, filling in the redirect URI of clients
+// having a single one, and returns the client and the scopes asked for. Until the redirect URI
+// is known to belong to the client, the errors are *apiError, which must be shown to the user;
+// from then on they are *oauthError, which are sent to the client at its redirect URI.
+func (app *application) checkAuthorization(r *http.Request, req *authorizeRequest) (*data.OAuthClient, []string, error) {
+	client, err := app.DB.GetOAuthClient(r.Context(), req.ClientID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, errUnknownClient.wrap(err)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if req.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		req.RedirectURI = client.RedirectURIs[0]
+	}
+
+	if !client.HasRedirectURI(req.RedirectURI) {
+		return nil, nil, errInvalidRedirectURI
+	}
+
+	switch {
+	case req.ResponseType != "code":
+		return nil, nil, newOAuthError("unsupported_response_type", "only the code response type is supported")
+	case !client.AllowsGrant(data.GrantAuthorizationCode):
+		return nil, nil, newOAuthError("unauthorized_client", "the client may not use the authorization code grant")
+	// PKCE is required of every client, as OAuth 2.1 does (RFC 7636)
+	case req.CodeChallengeMethod != "S256" || len(req.CodeChallenge) != 43:
+		return nil, nil, newOAuthError("invalid_request", "PKCE with the S256 code_challenge_method is required")
+	}
+
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 || !client.AllowsScopes(scopes) {
+		return nil, nil, errOAuthInvalidScope
+	}
+
+	return client, scopes, nil
+}
+
+// redirectURL returns the redirect URI of req with params, the state and the issuer (RFC 9207)
+func (app *application) redirectURL(req *authorizeRequest, params url.Values) string {
+	// the URI was registered, so it parses
+	u, _ := url.Parse(req.RedirectURI)
+
+	q := u.Query()
+	for name, values := range params {
+		q[name] = values
+	}
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	q.Set("iss", app.OAuth.Issuer)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// oauthAuthorize checks the authorization request of a client, and sends the browser to the
+// consent page
+func (app *application) oauthAuthorize(w http.ResponseWriter, r *http.Request) {
+	req := authorizeRequestFromQuery(r.URL.Query())
+
+	_, _, err := app.checkAuthorization(r, &req)
+
+	var oauthErr *oauthError
+	var apiErr *apiError
+
+	switch {
+	case errors.As(err, &oauthErr):
+		http.Redirect(w, r, app.redirectURL(&req, url.Values{"error": {oauthErr.Code}, "error_description": {oauthErr.Description}}), http.StatusFound)
+	case errors.As(err, &apiErr):
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+	case err != nil:
+		app.repositoryErrorJSON(w, r, err)
+	default:
+		http.Redirect(w, r, consentPage+"?"+req.query().Encode(), http.StatusFound)
+	}
+}
+
+// oauthConsent completes an authorization request for the user of the token. The user is asked
+// to consent to the scopes not granted to the client yet; once they are, a code is issued.
+func (app *application) oauthConsent(w http.ResponseWriter, r *http.Request) {
+	// a client must not consent on behalf of the user with a token it was given
+	claims, ok := claimsFromContext(r.Context())
+	if !ok || claims.Delegated() {
+		app.errorJSON(w, r, errUserTokenRequired, http.StatusForbidden)
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, r, errInvalidToken.wrap(err), http.StatusUnauthorized)
+		return
+	}
+
+	var req authorizeRequest
+	if err := app.readJSON(w, r, &req); err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	client, scopes, err := app.checkAuthorization(r, &req)
+
+	var oauthErr *oauthError
+	var apiErr *apiError
+
+	switch {
+	case errors.As(err, &oauthErr):
+		_ = app.writeJSON(w, r, http.StatusOK, authorizeResponse{
+			RedirectTo: app.redirectURL(&req, url.Values{"error": {oauthErr.Code}, "error_description": {oauthErr.Description}}),
+		})
+		return
+	case errors.As(err, &apiErr):
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	case err != nil:
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	granted, err := app.DB.OAuthConsent(r.Context(), userID, client.ClientID)
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	missing := missingScopes(scopes, granted)
+
+	switch {
+	case req.Consent != nil && !*req.Consent:
+		_ = app.writeJSON(w, r, http.StatusOK, authorizeResponse{
+			RedirectTo: app.redirectURL(&req, url.Values{"error": {"access_denied"}, "error_description": {"the user denied the request"}}),
+		})
+		return
+
+	case len(missing) > 0 && req.Consent == nil:
+		_ = app.writeJSON(w, r, http.StatusOK, authorizeResponse{
+			ConsentRequired: true,
+			Client:          &consentClient{ClientID: client.ClientID, Name: client.Name},
+			Scopes:          scopes,
+		})
+		return
+
+	case len(missing) > 0:
+		consented := append(append([]string{}, granted...), missing...)
+		if err := app.DB.SaveOAuthConsent(r.Context(), userID, client.ClientID, consented); err != nil {
+			app.repositoryErrorJSON(w, r, err)
+			return
+		}
+
+		app.audit(r, data.AuditEvent{
+			Action:   data.AuditOAuthConsent,
+			TargetID: userID,
+			Changes:  map[string]data.AuditChange{"client_id": {To: client.ClientID}, "scopes": {From: strings.Join(granted, " "), To: strings.Join(consented, " ")}},
+		})
+	}
+
+	code, err := oidc.RandomString()
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.InsertAuthorizationCode(r.Context(), data.AuthorizationCode{
+		Hash:          data.HashAPIKey(code),
+		ClientID:      client.ClientID,
+		UserID:        userID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+		ExpiresAt:     time.Now().Add(authorizationCodeExpiry),
+	})
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	_ = app.writeJSON(w, r, http.StatusOK, authorizeResponse{RedirectTo: app.redirectURL(&req, url.Values{"code": {code}})})
+}
+
+// missingScopes returns the scopes which are not granted
+func missingScopes(scopes, granted []string) []string {
+	var missing []string
+
+	for _, s := range scopes {
+		if !validator.In(s, granted...) {
+			missing = append(missing, s)
+		}
+	}
+
+	return missing
+}
+
+// tokenResponse is the answer of the token endpoint (RFC 6749 section 5.1)
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// oauthToken is the token endpoint: it authenticates the client, and issues tokens for the
+// grant it presents
+func (app *application) oauthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		app.writeOAuthError(w, r, newOAuthError("invalid_request", "the body must be form encoded"))
+		return
+	}
+
+	client, err := app.authenticateClient(r)
+	if err != nil {
+		app.oauthErrorJSON(w, r, err)
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+
+	switch {
+	case !validator.In(grantType, data.GrantTypes...):
+		app.writeOAuthError(w, r, newOAuthError("unsupported_grant_type", "the grant_type must be one of "+strings.Join(data.GrantTypes, ", ")))
+		return
+	case !client.AllowsGrant(grantType):
+		app.writeOAuthError(w, r, newOAuthError("unauthorized_client", "the client may not use this grant type"))
+		return
+	}
+
+	var tokens *tokenResponse
+
+	switch grantType {
+	case data.GrantAuthorizationCode:
+		tokens, err = app.authorizationCodeGrant(r, client)
+	case data.GrantRefreshToken:
+		tokens, err = app.refreshTokenGrant(r, client)
+	case data.GrantClientCredentials:
+		tokens, err = app.clientCredentialsGrant(r, client)
+	}
+
+	if err != nil {
+		app.oauthErrorJSON(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	_ = app.writeJSON(w, r, http.StatusOK, tokens)
+}
+
+// oauthErrorJSON answers a request of a client with err, an *oauthError or a repository error
+func (app *application) oauthErrorJSON(w http.ResponseWriter, r *http.Request, err error) {
+	var oauthErr *oauthError
+	if errors.As(err, &oauthErr) {
+		app.writeOAuthError(w, r, oauthErr)
+		return
+	}
+
+	app.repositoryErrorJSON(w, r, err)
+}
+
+// authenticateClient returns the client of the request. Confidential clients authenticate with
+// client_secret_basic or client_secret_post; public clients only send their client_id.
+func (app *application) authenticateClient(r *http.Request) (*data.OAuthClient, error) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form encodes the credentials before encoding them in base64
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if clientID == "" {
+		return nil, errOAuthInvalidClient
+	}
+
+	client, err := app.DB.GetOAuthClient(r.Context(), clientID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errOAuthInvalidClient
+	} else if err != nil {
+		return nil, err
+	}
+
+	if client.Confidential() != (secret != "") || (client.Confidential() && !client.SecretMatches(secret)) {
+		return nil, errOAuthInvalidClient
+	}
+
+	return client, nil
+}
+
+// authorizationCodeGrant exchanges a code issued by oauthConsent, once, for the tokens of the user
+func (app *application) authorizationCodeGrant(r *http.Request, client *data.OAuthClient) (*tokenResponse, error) {
+	code, err := app.DB.ConsumeAuthorizationCode(r.Context(), data.HashAPIKey(r.PostForm.Get("code")))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errOAuthInvalidGrant
+	} else if err != nil {
+		return nil, err
+	}
+
+	redirectURI := r.PostForm.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+
+	verifier := r.PostForm.Get("code_verifier")
+
+	switch {
+	case code.ClientID != client.ClientID, time.Now().After(code.ExpiresAt):
+		return nil, errOAuthInvalidGrant
+	case redirectURI != code.RedirectURI:
+		return nil, newOAuthError("invalid_grant", "the redirect_uri does not match the authorization request")
+	case subtle.ConstantTimeCompare([]byte(oidc.S256Challenge(verifier)), []byte(code.CodeChallenge)) != 1:
+		return nil, newOAuthError("invalid_grant", "the code_verifier does not match the code_challenge")
+	}
+
+	user, err := app.DB.GetUser(r.Context(), code.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errOAuthInvalidGrant
+	} else if err != nil {
+		return nil, err
+	}
+
+	tokens, err := app.userTokens(client, user, code.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	if validator.In(data.ScopeOpenID, code.Scopes...) {
+		tokens.IDToken, err = app.idToken(client, user, code.Scopes, code.Nonce)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	app.audit(r, data.AuditEvent{Action: data.AuditLogin, ActorID: user.ID, TargetID: user.ID, Email: user.Email})
+
+	return tokens, nil
+}
+
+// refreshTokenGrant renews the tokens of a user, for the scopes granted with the refresh token
+// or fewer of them
+func (app *application) refreshTokenGrant(r *http.Request, client *data.OAuthClient) (*tokenResponse, error) {
+	claims, err := app.verifyClientRefreshToken(r.PostForm.Get("refresh_token"))
+	if err != nil || claims.ClientID != client.ClientID {
+		return nil, errOAuthInvalidGrant
+	}
+
+	scopes := strings.Fields(claims.Scope)
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		if len(missingScopes(requested, scopes)) > 0 {
+			return nil, errOAuthInvalidScope
+		}
+		scopes = requested
+	}
+
+	// the client may have lost scopes since the refresh token was issued
+	if !client.AllowsScopes(scopes) {
+		return nil, errOAuthInvalidScope
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, errOAuthInvalidGrant
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errOAuthInvalidGrant
+	} else if err != nil {
+		return nil, err
+	}
+
+	tokens, err := app.userTokens(client, user, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	app.audit(r, data.AuditEvent{Action: data.AuditTokenRefreshed, ActorID: user.ID, TargetID: user.ID})
+	app.Metrics.TokenRefreshed()
+
+	return tokens, nil
+}
+
+// clientCredentialsGrant issues an access token to the client itself, for the scopes of the
+// API keys. The client is the subject of the token, which is never an admin.
+func (app *application) clientCredentialsGrant(r *http.Request, client *data.OAuthClient) (*tokenResponse, error) {
+	if !client.Confidential() {
+		return nil, newOAuthError("unauthorized_client", "public clients can not use client_credentials")
+	}
+
+	scopes := strings.Fields(r.PostForm.Get("scope"))
+	if len(scopes) == 0 {
+		for _, s := range client.Scopes {
+			if validator.In(s, data.Scopes...) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+
+	// the OpenID Connect scopes are about a user, which there is none of
+	if len(scopes) == 0 || len(missingScopes(scopes, data.Scopes)) > 0 || !client.AllowsScopes(scopes) {
+		return nil, errOAuthInvalidScope
+	}
+
+	accessToken, err := app.oauthAccessToken(client, client.ClientID, client.Name, false, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(jwtTokenExpiry.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// userTokens issues the access token of user limited to scopes, and a refresh token if the
+// client may use them
+func (app *application) userTokens(client *data.OAuthClient, user *data.User, scopes []string) (*tokenResponse, error) {
+	subject := strconv.Itoa(user.ID)
+
+	accessToken, err := app.oauthAccessToken(client, subject, fmt.Sprintf("%s %s", user.FirstName, user.LastName), user.IsAdmin == 1, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := &tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(jwtTokenExpiry.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}
+
+	if !client.AllowsGrant(data.GrantRefreshToken) {
+		return tokens, nil
+	}
+
+	refreshClaims, err := app.registeredClaims(subject, refreshTokenExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens.RefreshToken, err = app.signToken(&Claims{
+		Type:             tokenTypeRefresh,
+		ClientID:         client.ClientID,
+		Scope:            tokens.Scope,
+		RegisteredClaims: refreshClaims,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// oauthAccessToken signs an access token of subject for client, which authRequired accepts as
+// it accepts those of generateTokenPair, limited to scopes
+func (app *application) oauthAccessToken(client *data.OAuthClient, subject, name string, admin bool, scopes []string) (string, error) {
+	registered, err := app.registeredClaims(subject, jwtTokenExpiry)
+	if err != nil {
+		return "", err
+	}
+
+	return app.signToken(&Claims{
+		Type:             tokenTypeAccess,
+		UserName:         name,
+		Admin:            admin,
+		ClientID:         client.ClientID,
+		Scope:            strings.Join(scopes, " "),
+		RegisteredClaims: registered,
+	})
+}
+
+// idToken signs the ID token of user for client, with the claims released by scopes
+func (app *application) idToken(client *data.OAuthClient, user *data.User, scopes []string, nonce string) (string, error) {
+	now := time.Now()
+
+	claims := userClaims(user, scopes)
+	claims.Nonce = nonce
+	claims.Issuer = app.OAuth.Issuer
+	claims.Audience = jwt.ClaimStrings{client.ClientID}
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(jwtTokenExpiry))
+
+	token := jwt.NewWithClaims(app.OAuth.method, claims)
+	token.Header["kid"] = app.OAuth.jwk.KeyID
+
+	return token.SignedString(app.OAuth.Key)
+}
+
+// userClaims returns the claims about user released by scopes, for ID tokens and /oauth/userinfo
+func userClaims(user *data.User, scopes []string) *oidc.IDToken {
+	claims := &oidc.IDToken{RegisteredClaims: jwt.RegisteredClaims{Subject: strconv.Itoa(user.ID)}}
+
+	if validator.In(data.ScopeProfile, scopes...) {
+		claims.Name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		claims.GivenName = user.FirstName
+		claims.FamilyName = user.LastName
+	}
+
+	if validator.In(data.ScopeEmail, scopes...) {
+		claims.Email = user.Email
+	}
+
+	return claims
+}
+
+// verifyClientRefreshToken returns the claims of a refresh token issued to an OAuth client
+func (app *application) verifyClientRefreshToken(token string) (*Claims, error) {
+	claims := &Claims{}
+	if err := app.verifyToken(token, tokenTypeRefresh, claims); err != nil {
+		return nil, err
+	}
+
+	if !claims.Delegated() {
+		return nil, errTokenType.wrap(errors.New("the refresh token of a user"))
+	}
+
+	return claims, nil
+}
+
+// oauthUserinfo returns the claims about the user of a token granted the openid scope
+func (app *application) oauthUserinfo(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFromContext(r.Context())
+	if !ok || !claims.Delegated() || !claims.HasScope(data.ScopeOpenID) {
+		app.errorJSON(w, r, errInsufficientScope, http.StatusForbidden)
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, r, errInsufficientScope, http.StatusForbidden)
+		return
+	}
+
+	user, err := app.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.writeJSON(w, r, http.StatusOK, userClaims(user, strings.Fields(claims.Scope)))
+}
+
+// introspection is the answer of the introspection endpoint (RFC 7662); inactive tokens only
+// get Active false
+type introspection struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+}
+
+func newIntrospection(claims *Claims) introspection {
+	i := introspection{
+		Active:   true,
+		Scope:    claims.Scope,
+		ClientID: claims.ClientID,
+		Username: claims.UserName,
+		Subject:  claims.Subject,
+		Audience: claims.Audience,
+		Issuer:   claims.Issuer,
+	}
+
+	if claims.ExpiresAt != nil {
+		i.ExpiresAt = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		i.IssuedAt = claims.IssuedAt.Unix()
+	}
+
+	return i
+}
+
+// oauthIntrospect tells a confidential client whether a token is active, and what it grants.
+// Access tokens are described to any client, e.g. a resource server; refresh tokens only to the
+// client they were issued to.
+func (app *application) oauthIntrospect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		app.writeOAuthError(w, r, newOAuthError("invalid_request", "the body must be form encoded"))
+		return
+	}
+
+	client, err := app.authenticateClient(r)
+	if err != nil {
+		app.oauthErrorJSON(w, r, err)
+		return
+	}
+
+	if !client.Confidential() {
+		app.writeOAuthError(w, r, errOAuthInvalidClient)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	result := introspection{}
+
+	if claims, err := app.verifyAccessToken(token); err == nil {
+		result = newIntrospection(claims)
+		result.TokenType = "Bearer"
+	} else if claims, err := app.verifyClientRefreshToken(token); err == nil && claims.ClientID == client.ClientID {
+		result = newIntrospection(claims)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.writeJSON(w, r, http.StatusOK, result)
+}
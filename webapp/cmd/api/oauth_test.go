@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/oidc"
+	"webapp/pkg/ratelimit"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_api_app_oauth(t *testing.T) {
+
+	oldDB, oldOAuth := app.DB, app.OAuth
+	oldLimits := app.RateLimit
+	defer func() {
+		app.DB, app.OAuth = oldDB, oldOAuth
+		app.RateLimit = oldLimits
+	}()
+
+	app.DB = &dbrepo.MockDBRepo{}
+	app.RateLimit.Auth = ratelimit.Every(1000, time.Minute)
+	freshRateLimiter(t)
+
+	// the issuer is the URL of the test server, which is only known once it listens
+	var routes http.Handler
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { routes.ServeHTTP(w, r) }))
+	defer srv.Close()
+
+	key, err := loadSigningKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.OAuth, err = newAuthorizationServer(srv.URL, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes = app.routes()
+
+	admin, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1})
+	user, _ := app.generateTokenPair(&data.User{ID: 2, FirstName: "Jane", LastName: "Doe"})
+
+	do := func(method, target, auth, contentType, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr
+	}
+
+	const form = "application/x-www-form-urlencoded"
+	const redirectURI = "https://app.example.com/callback"
+
+	// registration
+	invalid := []struct {
+		name string
+		body string
+	}{
+		{"no name", `{"redirect_uris": ["https://app.example.com/callback"], "scopes": ["openid"], "grant_types": ["authorization_code"]}`},
+		{"public client credentials", `{"name": "spa", "public": true, "scopes": ["users:read"], "grant_types": ["client_credentials"]}`},
+		{"plain http", `{"name": "app", "redirect_uris": ["http://app.example.com/callback"], "scopes": ["openid"], "grant_types": ["authorization_code"]}`},
+		{"fragment", `{"name": "app", "redirect_uris": ["https://app.example.com/callback#top"], "scopes": ["openid"], "grant_types": ["authorization_code"]}`},
+		{"unknown scope", `{"name": "app", "redirect_uris": ["https://app.example.com/callback"], "scopes": ["root"], "grant_types": ["authorization_code"]}`},
+		{"unknown grant", `{"name": "app", "redirect_uris": ["https://app.example.com/callback"], "scopes": ["openid"], "grant_types": ["password"]}`},
+	}
+
+	for _, tt := range invalid {
+		if rr := do(http.MethodPost, "/oauth/clients/", admin.Token, "", tt.body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expect status 400; got %d", tt.name, rr.Code)
+		}
+	}
+
+	body := `{"name": "Reports", "redirect_uris": ["` + redirectURI + `"], "scopes": ["openid", "profile", "email", "users:read"], "grant_types": ["authorization_code", "refresh_token", "client_credentials"]}`
+
+	if rr := do(http.MethodPost, "/oauth/clients/", user.Token, "", body); rr.Code != http.StatusForbidden {
+		t.Fatalf("expect users who are not admins to get 403; got %d", rr.Code)
+	}
+
+	rr := do(http.MethodPost, "/oauth/clients/", admin.Token, "", body)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expect status 201 registering a client; got %d: %s", rr.Code, rr.Body)
+	}
+
+	var client newOAuthClientResponse
+	_ = json.NewDecoder(rr.Body).Decode(&client)
+	if client.ClientID == "" || client.ClientSecret == "" || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expect a client id and a secret which are not stored; got %+v", client)
+	}
+
+	// discovery
+	rr = do(http.MethodGet, "/.well-known/openid-configuration", "", "", "")
+	var metadata oidc.Metadata
+	_ = json.NewDecoder(rr.Body).Decode(&metadata)
+	if metadata.Issuer != srv.URL || metadata.TokenEndpoint != srv.URL+"/oauth/token" || metadata.IntrospectionEndpoint != srv.URL+"/oauth/introspect" {
+		t.Errorf("expect the endpoints under the issuer; got %+v", metadata)
+	}
+
+	// a relying party using our own OpenID Connect client
+	rp := oidc.New(oidc.Config{Issuer: srv.URL, ClientID: client.ClientID, ClientSecret: client.ClientSecret, RedirectURL: redirectURI}, srv.Client())
+	flow, _ := oidc.NewFlow()
+
+	authURL, err := rp.AuthCodeURL(context.Background(), flow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = do(http.MethodGet, authURL, "", "", "")
+	consent, _ := url.Parse(rr.Header().Get("Location"))
+	if rr.Code != http.StatusFound || consent.Path != consentPage {
+		t.Fatalf("expect a redirect to the consent page; got %d %s", rr.Code, consent)
+	}
+
+	request := authorizeRequestFromQuery(consent.Query())
+	authorize := func(token string, consent *bool) (int, authorizeResponse) {
+		t.Helper()
+
+		req := request
+		req.Consent = consent
+		b, _ := json.Marshal(req)
+
+		rr := do(http.MethodPost, "/oauth/authorize", token, "", string(b))
+		var resp authorizeResponse
+		_ = json.NewDecoder(rr.Body).Decode(&resp)
+		return rr.Code, resp
+	}
+
+	yes, no := true, false
+
+	if code, resp := authorize(admin.Token, nil); code != http.StatusOK || !resp.ConsentRequired || resp.RedirectTo != "" || resp.Client.Name != "Reports" {
+		t.Fatalf("expect the user to be asked for consent; got %d %+v", code, resp)
+	}
+
+	if _, resp := authorize(admin.Token, &no); !strings.Contains(resp.RedirectTo, "error=access_denied") {
+		t.Errorf("expect a denied request to send the error to the client; got %+v", resp)
+	}
+
+	code, resp := authorize(admin.Token, &yes)
+	callback, _ := url.Parse(resp.RedirectTo)
+	if code != http.StatusOK || callback.Query().Get("code") == "" || callback.Query().Get("state") != flow.State || callback.Query().Get("iss") != srv.URL {
+		t.Fatalf("expect a redirect with a code, the state and the issuer; got %d %+v", code, resp)
+	}
+
+	idToken, err := rp.Callback(context.Background(), httptest.NewRequest(http.MethodGet, resp.RedirectTo, nil), flow)
+	if err != nil {
+		t.Fatalf("expect the relying party to verify the ID token: %s", err)
+	}
+	if idToken.Subject != "1" || idToken.Email != "admin@example.com" || idToken.Nonce != flow.Nonce {
+		t.Errorf("expect the claims of user 1; got %+v", idToken)
+	}
+
+	// consent is remembered, so the code is issued at once
+	code, resp = authorize(admin.Token, nil)
+	callback, _ = url.Parse(resp.RedirectTo)
+	if code != http.StatusOK || resp.ConsentRequired || callback.Query().Get("code") == "" {
+		t.Fatalf("expect no second consent; got %d %+v", code, resp)
+	}
+
+	token := func(auth bool, values url.Values) (int, tokenResponse, oauthError) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", form)
+		if auth {
+			req.SetBasicAuth(client.ClientID, client.ClientSecret)
+		}
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		var tokens tokenResponse
+		var oauthErr oauthError
+		_ = json.Unmarshal(rr.Body.Bytes(), &tokens)
+		_ = json.Unmarshal(rr.Body.Bytes(), &oauthErr)
+		return rr.Code, tokens, oauthErr
+	}
+
+	exchange := url.Values{"grant_type": {"authorization_code"}, "code": {callback.Query().Get("code")}, "code_verifier": {"wrong" + flow.Verifier[5:]}}
+
+	if status, _, _ := token(false, exchange); status != http.StatusUnauthorized {
+		t.Errorf("expect a confidential client without its secret to get 401; got %d", status)
+	}
+
+	if _, _, e := token(true, exchange); e.Code != "invalid_grant" {
+		t.Errorf("expect a wrong code_verifier to get invalid_grant; got %+v", e)
+	}
+
+	// the code was used by the failed attempt
+	exchange.Set("code_verifier", flow.Verifier)
+	if _, _, e := token(true, exchange); e.Code != "invalid_grant" {
+		t.Errorf("expect a code to be exchanged only once; got %+v", e)
+	}
+
+	_, resp = authorize(admin.Token, nil)
+	callback, _ = url.Parse(resp.RedirectTo)
+	exchange.Set("code", callback.Query().Get("code"))
+
+	status, tokens, _ := token(true, exchange)
+	if status != http.StatusOK || tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.IDToken == "" || tokens.Scope != "openid email profile" {
+		t.Fatalf("expect access, refresh and ID tokens; got %d %+v", status, tokens)
+	}
+
+	// the access token is limited to the granted scopes, though the user is an admin
+	if rr := do(http.MethodGet, "/users/", tokens.AccessToken, "", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expect a token without users:read not to list users; got %d", rr.Code)
+	}
+
+	if rr := do(http.MethodGet, "/oauth/clients/", tokens.AccessToken, "", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expect a token without the admin scope not to act as an admin; got %d", rr.Code)
+	}
+
+	if code, _ := authorize(tokens.AccessToken, &yes); code != http.StatusForbidden {
+		t.Errorf("expect a client not to consent with the token it was given; got %d", code)
+	}
+
+	rr = do(http.MethodGet, "/oauth/userinfo", tokens.AccessToken, "", "")
+	var userinfo oidc.IDToken
+	_ = json.NewDecoder(rr.Body).Decode(&userinfo)
+	if rr.Code != http.StatusOK || userinfo.Subject != "1" || userinfo.Email != "admin@example.com" || userinfo.GivenName != "Admin" {
+		t.Errorf("expect the claims of user 1; got %d %s", rr.Code, rr.Body)
+	}
+
+	if rr := do(http.MethodGet, "/oauth/userinfo", admin.Token, "", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expect userinfo to need a token granted openid; got %d", rr.Code)
+	}
+
+	// refresh tokens of clients are only renewed at the token endpoint
+	if rr := do(http.MethodPost, "/refresh-token", "", "", `{"refresh_token": "`+tokens.RefreshToken+`"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expect /refresh-token to refuse the refresh token of a client; got %d", rr.Code)
+	}
+
+	if _, _, e := token(true, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}, "scope": {"openid users:read"}}); e.Code != "invalid_scope" {
+		t.Errorf("expect a refresh not to widen the scopes; got %+v", e)
+	}
+
+	status, refreshed, _ := token(true, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}, "scope": {"openid"}})
+	if status != http.StatusOK || refreshed.AccessToken == "" || refreshed.Scope != "openid" {
+		t.Errorf("expect a refresh to narrow the scopes; got %d %+v", status, refreshed)
+	}
+
+	if _, _, e := token(true, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {admin.RefreshToken}}); e.Code != "invalid_grant" {
+		t.Errorf("expect the refresh token of a user to get invalid_grant; got %+v", e)
+	}
+
+	// introspection
+	introspect := func(tok string) introspection {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(url.Values{"token": {tok}}.Encode()))
+		req.Header.Set("Content-Type", form)
+		req.SetBasicAuth(client.ClientID, client.ClientSecret)
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		var i introspection
+		_ = json.NewDecoder(rr.Body).Decode(&i)
+		return i
+	}
+
+	if i := introspect(tokens.AccessToken); !i.Active || i.ClientID != client.ClientID || i.Subject != "1" || i.TokenType != "Bearer" || i.Scope != tokens.Scope {
+		t.Errorf("expect the access token to be active; got %+v", i)
+	}
+
+	if i := introspect(tokens.RefreshToken); !i.Active || i.TokenType != "" {
+		t.Errorf("expect the refresh token to be active for its client; got %+v", i)
+	}
+
+	if i := introspect("garbage"); i.Active || i.Subject != "" {
+		t.Errorf("expect an invalid token to be inactive only; got %+v", i)
+	}
+
+	// client credentials
+	status, machine, _ := token(true, url.Values{"grant_type": {"client_credentials"}})
+	if status != http.StatusOK || machine.Scope != "users:read" || machine.RefreshToken != "" {
+		t.Fatalf("expect a token for the API scopes of the client; got %d %+v", status, machine)
+	}
+
+	if rr := do(http.MethodGet, "/users/", machine.AccessToken, "", ""); rr.Code != http.StatusOK {
+		t.Errorf("expect the client to list users; got %d", rr.Code)
+	}
+
+	if _, _, e := token(true, url.Values{"grant_type": {"client_credentials"}, "scope": {"openid"}}); e.Code != "invalid_scope" {
+		t.Errorf("expect client credentials not to be granted openid; got %+v", e)
+	}
+
+	// errors at the authorization endpoint
+	if rr := do(http.MethodGet, "/oauth/authorize?response_type=code&client_id=unknown", "", "", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expect an unknown client to be shown an error; got %d", rr.Code)
+	}
+
+	q := request.query()
+	q.Set("redirect_uri", "https://evil.example.com/callback")
+	if rr := do(http.MethodGet, "/oauth/authorize?"+q.Encode(), "", "", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("expect an unregistered redirect_uri to be shown an error; got %d", rr.Code)
+	}
+
+	q = request.query()
+	q.Set("code_challenge_method", "plain")
+	rr = do(http.MethodGet, "/oauth/authorize?"+q.Encode(), "", "", "")
+	if location := rr.Header().Get("Location"); rr.Code != http.StatusFound || !strings.HasPrefix(location, redirectURI+"?") || !strings.Contains(location, "error=invalid_request") {
+		t.Errorf("expect a plain code challenge to be refused at the redirect URI; got %d %s", rr.Code, location)
+	}
+
+	if rr := do(http.MethodDelete, "/oauth/clients/"+client.ClientID, admin.Token, "", ""); rr.Code != http.StatusNoContent {
+		t.Errorf("expect the client to be deleted; got %d", rr.Code)
+	}
+
+	if status, _, e := token(true, url.Values{"grant_type": {"client_credentials"}}); status != http.StatusUnauthorized || e.Code != "invalid_client" {
+		t.Errorf("expect a deleted client to get invalid_client; got %d %+v", status, e)
+	}
+}
+
+func Test_oauthConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  oauthConfig
+		dev     bool
+		wantErr bool
+	}{
+		{"disabled", oauthConfig{}, false, false},
+		{"https", oauthConfig{Issuer: "https://api.example.com", SigningKeyFile: "key.pem"}, false, false},
+		{"no key", oauthConfig{Issuer: "https://api.example.com"}, false, true},
+		{"generated key in dev", oauthConfig{Issuer: "http://localhost:8090"}, true, false},
+		{"plain http", oauthConfig{Issuer: "http://api.example.com", SigningKeyFile: "key.pem"}, false, true},
+		{"query", oauthConfig{Issuer: "https://api.example.com?tenant=1", SigningKeyFile: "key.pem"}, false, true},
+		{"not a URL", oauthConfig{Issuer: "api.example.com", SigningKeyFile: "key.pem"}, false, true},
+	}
+
+	for _, tt := range testCases {
+		if err := tt.config.Validate(tt.dev); (err != nil) != tt.wantErr {
+			t.Errorf("%s: expect error %v; got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
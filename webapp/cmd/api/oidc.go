@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/oidc"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// oidcFlowCookie keeps the secrets of the login in progress between /auth/oidc and the
+	// callback, since the api has no session
+	oidcFlowCookie = "__Host-oidc_flow"
+	oidcFlowExpiry = 10 * time.Minute
+)
+
+// oidcFlowClaims is the content of the signed flow cookie
+type oidcFlowClaims struct {
+	Type string `json:"typ"`
+	oidc.Flow
+	jwt.RegisteredClaims
+}
+
+func (c *oidcFlowClaims) registered() (string, *jwt.RegisteredClaims) {
+	return c.Type, &c.RegisteredClaims
+}
+
+// oidcLogin redirects to the login page of the OpenID Connect provider
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := app.OIDC.AuthCodeURL(r.Context(), flow)
+	if err != nil {
+		app.errorJSON(w, r, errSSOFailed.wrap(err), http.StatusBadGateway)
+		return
+	}
+
+	// the flow is signed but not encrypted: it only holds secrets of this browser's own login
+	registered, err := app.registeredClaims("", oidcFlowExpiry)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	cookie, err := app.signToken(&oidcFlowClaims{Type: tokenTypeOIDCFlow, Flow: flow, RegisteredClaims: registered})
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   oidcFlowCookie,
+		Path:   "/",
+		Value:  cookie,
+		MaxAge: int(oidcFlowExpiry.Seconds()),
+		// the provider sends the browser back with a top-level GET, which Lax allows
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallback completes the login started by oidcLogin and answers with a token pair, as
+// /auth does, for the user linked to the identity
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	// a flow is used once, whatever the outcome
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+		Secure:   true,
+	})
+
+	flow, err := app.oidcFlow(r)
+	if err != nil {
+		app.errorJSON(w, r, errSSOFailed.wrap(err), http.StatusBadRequest)
+		return
+	}
+
+	token, err := app.OIDC.Callback(r.Context(), r, flow)
+	if err != nil {
+		slog.WarnContext(r.Context(), "single sign-on failed", "error", err)
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed})
+		app.Metrics.AuthAttempt(false)
+		app.errorJSON(w, r, errSSOFailed.wrap(err), http.StatusUnauthorized)
+		return
+	}
+
+	user, linked, created, err := app.OIDC.SignIn(r.Context(), app.DB, token)
+	if errors.Is(err, oidc.ErrEmailNotVerified) {
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed, Email: token.Email})
+		app.Metrics.AuthAttempt(false)
+		app.errorJSON(w, r, errEmailNotVerified.wrap(err), http.StatusForbidden)
+		return
+	} else if errors.Is(err, oidc.ErrAccountDeleted) {
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed, Email: token.Email})
+		app.Metrics.AuthAttempt(false)
+		app.errorJSON(w, r, errAccountDeleted.wrap(err), http.StatusForbidden)
+		return
+	} else if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	tokenPairs, err := app.generateTokenPair(user)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if created {
+		app.audit(r, data.AuditEvent{Action: data.AuditUserCreated, ActorID: user.ID, TargetID: user.ID, Email: user.Email})
+	}
+	if linked {
+		app.audit(r, data.AuditEvent{Action: data.AuditIdentityLinked, ActorID: user.ID, TargetID: user.ID, Email: token.Email})
+	}
+	app.audit(r, data.AuditEvent{Action: data.AuditLogin, ActorID: user.ID, TargetID: user.ID, Email: user.Email})
+	app.Metrics.AuthAttempt(true)
+
+	http.SetCookie(w, refreshCookie(tokenPairs.RefreshToken, http.SameSiteNoneMode))
+
+	_ = app.writeJSON(w, r, http.StatusOK, tokenPairs)
+}
+
+// oidcFlow returns the flow kept in the cookie set by oidcLogin
+func (app *application) oidcFlow(r *http.Request) (oidc.Flow, error) {
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil {
+		return oidc.Flow{}, err
+	}
+
+	claims := &oidcFlowClaims{}
+	if err := app.verifyToken(cookie.Value, tokenTypeOIDCFlow, claims); err != nil {
+		return oidc.Flow{}, err
+	}
+
+	return claims.Flow, nil
+}
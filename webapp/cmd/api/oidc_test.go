@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/oidc"
+	"webapp/pkg/oidc/oidctest"
+	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_api_app_oidcLogin(t *testing.T) {
+
+	provider := oidctest.NewServer()
+	defer provider.Close()
+
+	oldDB, oldOIDC := app.DB, app.OIDC
+	defer func() { app.DB, app.OIDC = oldDB, oldOIDC }()
+
+	freshRateLimiter(t)
+	app.OIDC = oidc.New(provider.Config("https://api.example.com/auth/oidc/callback"), provider.Client())
+
+	testCases := []struct {
+		name           string
+		user           oidctest.User
+		tamper         func(callback *url.URL)
+		withoutCookie  bool
+		expectedStatus int
+		expectedUserID int
+		expectedAudits []string
+	}{
+		{"existing user", oidctest.User{Subject: "1", Email: "admin@example.com", EmailVerified: true}, nil, false, http.StatusOK, 1, []string{data.AuditIdentityLinked, data.AuditLogin}},
+		{"new user", oidctest.User{Subject: "2", Email: "jane@example.com", EmailVerified: true, GivenName: "Jane"}, nil, false, http.StatusOK, 100, []string{data.AuditUserCreated, data.AuditIdentityLinked, data.AuditLogin}},
+		{"unverified email", oidctest.User{Subject: "3", Email: "admin@example.com"}, nil, false, http.StatusForbidden, 0, []string{data.AuditLoginFailed}},
+		{"forged state", oidctest.User{Subject: "1", Email: "admin@example.com", EmailVerified: true}, func(callback *url.URL) {
+			q := callback.Query()
+			q.Set("state", "forged")
+			callback.RawQuery = q.Encode()
+		}, false, http.StatusUnauthorized, 0, []string{data.AuditLoginFailed}},
+		{"no flow cookie", oidctest.User{Subject: "1", Email: "admin@example.com", EmailVerified: true}, nil, true, http.StatusBadRequest, 0, nil},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			app.DB = &dbrepo.MockDBRepo{}
+			routes := app.routes()
+			provider.Login(tt.user)
+
+			rr := httptest.NewRecorder()
+			routes.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/auth/oidc", nil))
+
+			if rr.Code != http.StatusFound {
+				t.Fatalf("expect a redirect to the provider; got %d", rr.Code)
+			}
+
+			callback, err := provider.Authorize(rr.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.tamper != nil {
+				tt.tamper(callback)
+			}
+
+			// the browser comes back with the flow cookie
+			req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+			if !tt.withoutCookie {
+				for _, c := range rr.Result().Cookies() {
+					req.AddCookie(c)
+				}
+			}
+
+			rr = httptest.NewRecorder()
+			routes.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("expect status %d; got %d: %s", tt.expectedStatus, rr.Code, rr.Body)
+			}
+
+			if tt.expectedStatus == http.StatusOK {
+				var tokens TokenPairs
+				_ = json.NewDecoder(rr.Body).Decode(&tokens)
+
+				verify := httptest.NewRequest(http.MethodGet, "/", nil)
+				verify.Header.Set("Authorization", "Bearer "+tokens.Token)
+
+				_, claims, err := app.getTokenFromHeaderAndVerify(httptest.NewRecorder(), verify)
+				if err != nil || claims.Subject != strconv.Itoa(tt.expectedUserID) {
+					t.Fatalf("expect an access token of user %d; got %v %v", tt.expectedUserID, claims, err)
+				}
+
+				// browsers refuse a __Host- cookie with a Domain
+				found := false
+				for _, c := range rr.Result().Cookies() {
+					if c.Name == refreshCookieName {
+						found = c.Value == tokens.RefreshToken && c.Domain == "" && c.Secure && c.Path == "/"
+					}
+				}
+				if !found {
+					t.Errorf("expect the refresh token in a valid %s cookie", refreshCookieName)
+				}
+			}
+
+			events, _, _ := app.DB.AuditEvents(context.Background(), repository.AuditFilter{})
+			if len(events) != len(tt.expectedAudits) {
+				t.Fatalf("expect audit events %v; got %d", tt.expectedAudits, len(events))
+			}
+
+			// the audit log lists the newest event first
+			for i, action := range tt.expectedAudits {
+				if got := events[len(events)-1-i].Action; got != action {
+					t.Errorf("expect audit event %d to be %s; got %s", i, action, got)
+				}
+			}
+		})
+	}
+}
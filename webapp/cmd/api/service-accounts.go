@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/validator"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// defaultAPIKeyLifetime applies to the keys created without expires_in_days
+	defaultAPIKeyLifetime = 90
+	maxAPIKeyLifetime     = 365
+
+	// defaultRotationGracePeriod is how long a rotated key keeps working, so that its clients
+	// can switch to the new one
+	defaultRotationGracePeriod = 24
+	maxRotationGracePeriod     = 7 * 24
+)
+
+// apiKeyRequest is the body of POST /service-accounts/{id}/keys
+type apiKeyRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// rotateRequest is the optional body of POST /service-accounts/{id}/keys/{keyID}/rotate.
+// GracePeriodHours is a pointer so that 0, which ends the old key at once, can be told apart
+// from a missing value.
+type rotateRequest struct {
+	ExpiresInDays    int  `json:"expires_in_days"`
+	GracePeriodHours *int `json:"grace_period_hours"`
+}
+
+// newAPIKeyResponse shows the key itself, which is never shown again
+type newAPIKeyResponse struct {
+	*data.APIKey
+	Key string `json:"key"`
+}
+
+func (app *application) insertServiceAccount(w http.ResponseWriter, r *http.Request) {
+	var account data.ServiceAccount
+	if err := app.readJSON(w, r, &account); err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	account.CreatedBy = actorID(r)
+
+	id, err := app.DB.InsertServiceAccount(r.Context(), account)
+	if errors.Is(err, repository.ErrConflict) {
+		app.errorJSON(w, r, errDuplicateServiceAccount.wrap(err), http.StatusConflict)
+		return
+	} else if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	created, err := app.DB.GetServiceAccount(r.Context(), id)
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{Action: data.AuditServiceAccountCreated, ServiceAccountID: id})
+
+	w.Header().Set("Location", "/service-accounts/"+strconv.Itoa(id))
+	_ = app.writeJSON(w, r, http.StatusCreated, created)
+}
+
+func (app *application) allServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := app.DB.AllServiceAccounts(r.Context())
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	if accounts == nil {
+		accounts = []*data.ServiceAccount{}
+	}
+
+	_ = app.writeJSON(w, r, http.StatusOK, accounts)
+}
+
+// apiKeys lists the keys of a service account, including the expired and revoked ones
+func (app *application) apiKeys(w http.ResponseWriter, r *http.Request) {
+	account, ok := app.serviceAccountFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := app.DB.APIKeys(r.Context(), account.ID)
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	if keys == nil {
+		keys = []*data.APIKey{}
+	}
+
+	_ = app.writeJSON(w, r, http.StatusOK, keys)
+}
+
+// insertAPIKey creates a key for a service account. The response is the only place the key
+// can be read.
+func (app *application) insertAPIKey(w http.ResponseWriter, r *http.Request) {
+	account, ok := app.serviceAccountFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var req apiKeyRequest
+	if err := app.readJSON(w, r, &req); err != nil {
+		app.errorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	errs := validator.Errors{}
+	checkScopes(req.Scopes, data.Scopes, errs)
+	days := lifetime(req.ExpiresInDays, errs)
+
+	if len(errs) > 0 {
+		app.errorJSON(w, r, errs, http.StatusBadRequest)
+		return
+	}
+
+	key, apiKey, err := newAPIKey(days)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	apiKey.ServiceAccountID = account.ID
+	apiKey.Name = req.Name
+	apiKey.Scopes = req.Scopes
+
+	apiKey.ID, err = app.DB.InsertAPIKey(r.Context(), *apiKey)
+	if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{
+		Action:           data.AuditAPIKeyCreated,
+		ServiceAccountID: account.ID,
+		Changes:          map[string]data.AuditChange{"prefix": {To: apiKey.Prefix}, "scopes": {To: strings.Join(apiKey.Scopes, " ")}},
+	})
+
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.writeJSON(w, r, http.StatusCreated, newAPIKeyResponse{APIKey: apiKey, Key: key})
+}
+
+// rotateAPIKey replaces a key with a new one having the same name and scopes. The old key keeps
+// working for the grace period, so that clients can switch without downtime.
+func (app *application) rotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	account, ok := app.serviceAccountFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := strconv.Atoi(chi.URLParam(r, "keyID"))
+	if err != nil {
+		app.errorJSON(w, r, errInvalidAPIKeyID, http.StatusBadRequest)
+		return
+	}
+
+	var req rotateRequest
+	if r.ContentLength != 0 {
+		if err := app.readJSON(w, r, &req); err != nil {
+			app.errorJSON(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	errs := validator.Errors{}
+	days := lifetime(req.ExpiresInDays, errs)
+
+	grace := defaultRotationGracePeriod
+	if req.GracePeriodHours != nil {
+		grace = *req.GracePeriodHours
+		if grace < 0 || grace > maxRotationGracePeriod {
+			errs.Add("grace_period_hours", "form.between", "min", 0, "max", maxRotationGracePeriod)
+		}
+	}
+
+	if len(errs) > 0 {
+		app.errorJSON(w, r, errs, http.StatusBadRequest)
+		return
+	}
+
+	key, apiKey, err := newAPIKey(days)
+	if err != nil {
+		app.errorJSON(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	graceUntil := time.Now().Add(time.Duration(grace) * time.Hour)
+
+	rotated, err := app.DB.RotateAPIKey(r.Context(), account.ID, keyID, graceUntil, *apiKey)
+	if errors.Is(err, repository.ErrNotFound) {
+		app.errorJSON(w, r, errAPIKeyNotFound.wrap(err), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{
+		Action:           data.AuditAPIKeyRotated,
+		ServiceAccountID: account.ID,
+		Changes:          map[string]data.AuditChange{"key_id": {From: keyID, To: rotated.ID}},
+	})
+
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.writeJSON(w, r, http.StatusCreated, newAPIKeyResponse{APIKey: rotated, Key: key})
+}
+
+// revokeAPIKey stops a key from working at once
+func (app *application) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	account, ok := app.serviceAccountFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := strconv.Atoi(chi.URLParam(r, "keyID"))
+	if err != nil {
+		app.errorJSON(w, r, errInvalidAPIKeyID, http.StatusBadRequest)
+		return
+	}
+
+	err = app.DB.RevokeAPIKey(r.Context(), account.ID, keyID)
+	if errors.Is(err, repository.ErrNotFound) {
+		app.errorJSON(w, r, errAPIKeyNotFound.wrap(err), http.StatusNotFound)
+		return
+	} else if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditEvent{
+		Action:           data.AuditAPIKeyRevoked,
+		ServiceAccountID: account.ID,
+		Changes:          map[string]data.AuditChange{"key_id": {From: keyID}},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serviceAccountFromURL loads the service account in the URL, answering the request when it
+// can not
+func (app *application) serviceAccountFromURL(w http.ResponseWriter, r *http.Request) (*data.ServiceAccount, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "serviceAccountID"))
+	if err != nil {
+		app.errorJSON(w, r, errInvalidServiceAccountID, http.StatusBadRequest)
+		return nil, false
+	}
+
+	account, err := app.DB.GetServiceAccount(r.Context(), id)
+	if errors.Is(err, repository.ErrNotFound) {
+		app.errorJSON(w, r, errServiceAccountNotFound.wrap(err), http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		app.repositoryErrorJSON(w, r, err)
+		return nil, false
+	}
+
+	return account, true
+}
+
+// newAPIKey generates a key expiring in days, and returns it with the record to store
+func newAPIKey(days int) (string, *data.APIKey, error) {
+	key, prefix, hash, err := data.NewAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	expiresAt := time.Now().AddDate(0, 0, days)
+
+	return key, &data.APIKey{Prefix: prefix, Hash: hash, ExpiresAt: &expiresAt}, nil
+}
+
+// checkScopes adds an error to errs unless scopes has at least one scope, all of them permitted
+func checkScopes(scopes, permitted []string, errs validator.Errors) {
+	if len(scopes) == 0 {
+		errs.Add("scopes", "form.required")
+		return
+	}
+
+	for _, s := range scopes {
+		if !validator.In(s, permitted...) {
+			errs.Add("scopes", "form.in", "values", strings.Join(permitted, ", "))
+			return
+		}
+	}
+}
+
+// lifetime returns the number of days a new key lives, defaulting to defaultAPIKeyLifetime; keys
+// always expire
+func lifetime(days int, errs validator.Errors) int {
+	if days == 0 {
+		return defaultAPIKeyLifetime
+	}
+
+	if days < 1 || days > maxAPIKeyLifetime {
+		errs.Add("expires_in_days", "form.between", "min", 1, "max", maxAPIKeyLifetime)
+	}
+
+	return days
+}
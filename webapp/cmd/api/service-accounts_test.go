@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_api_app_serviceAccounts(t *testing.T) {
+
+	freshRateLimiter(t)
+
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+	app.DB = &dbrepo.MockDBRepo{}
+
+	routes := app.routes()
+
+	admin, _ := app.generateTokenPair(&data.User{ID: 1, FirstName: "Admin", LastName: "User", IsAdmin: 1})
+	user, _ := app.generateTokenPair(&data.User{ID: 2, FirstName: "Jane", LastName: "Doe"})
+
+	do := func(method, url, auth, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if strings.HasPrefix(auth, data.APIKeyPrefix) {
+			req.Header.Set("X-API-Key", auth)
+		} else if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do(http.MethodPost, "/service-accounts/", user.Token, `{"name": "batch"}`); rr.Code != http.StatusForbidden {
+		t.Fatalf("expect users who are not admins to get 403; got %d", rr.Code)
+	}
+
+	rr := do(http.MethodPost, "/service-accounts/", admin.Token, `{"name": "batch", "description": "nightly import"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expect status 201 creating an account; got %d: %s", rr.Code, rr.Body)
+	}
+
+	var account data.ServiceAccount
+	_ = json.NewDecoder(rr.Body).Decode(&account)
+	if account.ID == 0 || account.CreatedBy != 1 {
+		t.Errorf("expect the account to be created by user 1; got %+v", account)
+	}
+
+	if rr := do(http.MethodPost, "/service-accounts/", admin.Token, `{"name": "batch"}`); rr.Code != http.StatusConflict {
+		t.Errorf("expect a duplicate name to get 409; got %d", rr.Code)
+	}
+
+	keysURL := fmt.Sprintf("/service-accounts/%d/keys", account.ID)
+
+	invalid := []struct {
+		name string
+		body string
+	}{
+		{"no name", `{"scopes": ["users:read"]}`},
+		{"no scopes", `{"name": "reader", "scopes": []}`},
+		{"unknown scope", `{"name": "reader", "scopes": ["users:read", "root"]}`},
+		{"too long", `{"name": "reader", "scopes": ["users:read"], "expires_in_days": 400}`},
+	}
+
+	for _, tt := range invalid {
+		if rr := do(http.MethodPost, keysURL, admin.Token, tt.body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expect status 400; got %d", tt.name, rr.Code)
+		}
+	}
+
+	if rr := do(http.MethodPost, "/service-accounts/99/keys", admin.Token, `{"name": "reader", "scopes": ["users:read"]}`); rr.Code != http.StatusNotFound {
+		t.Errorf("expect an unknown account to get 404; got %d", rr.Code)
+	}
+
+	rr = do(http.MethodPost, keysURL, admin.Token, `{"name": "reader", "scopes": ["users:read"]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expect status 201 creating a key; got %d: %s", rr.Code, rr.Body)
+	}
+
+	if rr.Header().Get("Cache-Control") != "no-store" {
+		t.Error("expect the response showing a key not to be stored")
+	}
+
+	var created newAPIKeyResponse
+	_ = json.NewDecoder(rr.Body).Decode(&created)
+	if !strings.HasPrefix(created.Key, created.Prefix+"_") || created.ExpiresAt == nil {
+		t.Fatalf("expect a key starting with its prefix and expiring; got %+v", created)
+	}
+
+	// the key may read users but not change them
+	if rr := do(http.MethodGet, "/users/", created.Key, ""); rr.Code != http.StatusOK {
+		t.Errorf("expect the key to read users; got %d", rr.Code)
+	}
+
+	if rr := do(http.MethodDelete, "/users/1", created.Key, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expect the key not to delete users; got %d", rr.Code)
+	}
+
+	if rr := do(http.MethodGet, keysURL, created.Key, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expect the key not to manage service accounts; got %d", rr.Code)
+	}
+
+	rr = do(http.MethodGet, keysURL, admin.Token, "")
+	var keys []data.APIKey
+	_ = json.NewDecoder(rr.Body).Decode(&keys)
+	if len(keys) != 1 || keys[0].LastUsedAt == nil || strings.Contains(rr.Body.String(), created.Key) {
+		t.Errorf("expect one used key, without its secret; got %s", rr.Body)
+	}
+
+	// rotating without a grace period ends the old key at once
+	rotateURL := fmt.Sprintf("%s/%d/rotate", keysURL, created.ID)
+	rr = do(http.MethodPost, rotateURL, admin.Token, `{"grace_period_hours": 0}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expect status 201 rotating a key; got %d: %s", rr.Code, rr.Body)
+	}
+
+	var rotated newAPIKeyResponse
+	_ = json.NewDecoder(rr.Body).Decode(&rotated)
+	if rotated.Name != "reader" || !rotated.HasScope(data.ScopeUsersRead) {
+		t.Errorf("expect the new key to keep the name and scopes; got %+v", rotated)
+	}
+
+	if rr := do(http.MethodGet, "/users/", created.Key, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expect the rotated key to stop working; got %d", rr.Code)
+	}
+
+	if rr := do(http.MethodGet, "/users/", rotated.Key, ""); rr.Code != http.StatusOK {
+		t.Errorf("expect the new key to work; got %d", rr.Code)
+	}
+
+	revokeURL := fmt.Sprintf("%s/%d", keysURL, rotated.ID)
+	if rr := do(http.MethodDelete, revokeURL, admin.Token, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("expect status 204 revoking a key; got %d", rr.Code)
+	}
+
+	if rr := do(http.MethodDelete, revokeURL, admin.Token, ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expect revoking twice to get 404; got %d", rr.Code)
+	}
+
+	if rr := do(http.MethodGet, "/users/", rotated.Key, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expect the revoked key to stop working; got %d", rr.Code)
+	}
+
+	events, _, _ := app.DB.AuditEvents(context.Background(), repository.AuditFilter{})
+	actions := map[string]int{}
+	for _, e := range events {
+		if e.ServiceAccountID == account.ID {
+			actions[e.Action]++
+		}
+	}
+
+	for _, action := range []string{data.AuditServiceAccountCreated, data.AuditAPIKeyCreated, data.AuditAPIKeyRotated, data.AuditAPIKeyRevoked} {
+		if actions[action] != 1 {
+			t.Errorf("expect one %s event for the account; got %d", action, actions[action])
+		}
+	}
+}
+
+func Test_api_app_rotateAPIKey_gracePeriod(t *testing.T) {
+
+	freshRateLimiter(t)
+
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+	app.DB = &dbrepo.MockDBRepo{}
+
+	routes := app.routes()
+	admin, _ := app.generateTokenPair(&data.User{ID: 1, IsAdmin: 1})
+
+	id, _ := app.DB.InsertServiceAccount(context.Background(), data.ServiceAccount{Name: "batch"})
+	key, old, _ := newAPIKey(defaultAPIKeyLifetime)
+	old.ServiceAccountID = id
+	old.Scopes = []string{data.ScopeUsersRead}
+	old.ID, _ = app.DB.InsertAPIKey(context.Background(), *old)
+
+	testCases := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"negative", `{"grace_period_hours": -1}`, http.StatusBadRequest},
+		{"too long", `{"grace_period_hours": 1000}`, http.StatusBadRequest},
+		{"default", "", http.StatusCreated},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/service-accounts/%d/keys/%d/rotate", id, old.ID), strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+admin.Token)
+			rr := httptest.NewRecorder()
+
+			routes.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expect status %d; got %d: %s", tt.expectedStatus, rr.Code, rr.Body)
+			}
+		})
+	}
+
+	// within the grace period, the old key still works
+	req := httptest.NewRequest(http.MethodGet, "/users/", nil)
+	req.Header.Set("Authorization", "ApiKey "+key)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expect the old key to work during the grace period; got %d", rr.Code)
+	}
+}
@@ -3,7 +3,14 @@ package main
 import (
 	"os"
 	"testing"
+	"webapp/pkg/clientip"
+	"webapp/pkg/cors"
+	"webapp/pkg/health"
+	"webapp/pkg/metrics"
+	"webapp/pkg/ratelimit"
 	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/revocation"
+	"webapp/pkg/validator"
 )
 
 var app application
@@ -13,8 +20,31 @@ func TestMain(m *testing.M) {
 
 	app.DB = &dbrepo.MockDBRepo{}
 	app.Domain = "example.com"
+	app.ClientIP, _ = clientip.New(defaultConfig().ClientIP)
+	app.CORS, _ = cors.New(defaultConfig().CORS)
+	app.Validator = validator.New()
+	app.Metrics = metrics.New()
+	app.Health = health.New(health.DefaultTimeout)
+	app.RateLimit = defaultConfig().RateLimit
+	app.RateLimiter = ratelimit.New(ratelimit.NewMemoryStore())
+	app.Revocations = revocation.New(app.DB, refreshTokenExpiry)
 	app.JWTSecret = "b2xlIjoiQWRtaW4iLCJJc3N1ZXIiOiJJc3N1ZXIiLCJVc2VybmFtZSI6IkphdmFJblVzZSIsImV4cCI6MTY2OTY4MjE1NiwiaWF0IjoxNjY5NjgyMTU2fQ"
 
 	os.Exit(m.Run())
 
 }
+
+// freshRateLimiter gives the test a rate limiter of its own, put back when the test ends. Every
+// test serving requests through app.routes() calls it: the requests of all the tests come from
+// the same client IP, so a shared limiter would refuse them once the earlier tests used it up.
+func freshRateLimiter(t *testing.T) *ratelimit.Limiter {
+	t.Helper()
+
+	old := app.RateLimiter
+	t.Cleanup(func() { app.RateLimiter = old })
+
+	app.RateLimiter = ratelimit.New(ratelimit.NewMemoryStore())
+	app.RateLimiter.Denied = app.tooManyRequests
+
+	return app.RateLimiter
+}
@@ -3,11 +3,18 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"webapp/pkg/tracing"
 )
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, wrap ...string) error {
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data interface{}, wrap ...string) error {
+	_, span := tracing.Start(r.Context(), "writeJSON")
+	defer span.End()
+
 	// out will hold the final version of the json to send to the client
 	var out []byte
 
@@ -30,8 +37,10 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 		out = jsonBytes
 	}
 
-	// set the content type & status
-	w.Header().Set("Content-Type", "application/json")
+	// set the content type & status, unless the caller chose a more specific one
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(status)
 
 	// write the json out
@@ -42,21 +51,18 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) {
+// errorJSON sends err as an RFC 7807 problem document. Only *apiError and validator.Errors are
+// shown to the client as they are; any other error gets a generic message and is logged.
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) {
 	statusCode := http.StatusBadRequest
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
 
-	type jsonError struct {
-		Message string `json:"message"`
-	}
-
-	theError := jsonError{
-		Message: err.Error(),
-	}
+	app.logError(r, err, statusCode)
 
-	_ = app.writeJSON(w, statusCode, theError, "error")
+	w.Header().Set("Content-Type", problemContentType)
+	_ = app.writeJSON(w, r, statusCode, app.newProblem(err, statusCode))
 }
 
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
@@ -68,14 +74,83 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data in
 	// attempt to decode the data
 	err := dec.Decode(data)
 	if err != nil {
-		return err
+		return decodeError(err, maxBytes)
 	}
 
 	// make sure only one JSON value in payload
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must only contain a single JSON value")
+		return newAPIError("invalid_json", "body must only contain a single JSON value")
+	}
+
+	// apply the same `validate` rules as the web forms; invalid data is returned as validator.Errors
+	validationErrors, err := app.Validator.Struct(data, "json")
+	if err != nil {
+		return err
+	}
+
+	if len(validationErrors) > 0 {
+		return validationErrors
 	}
 
 	return nil
 }
+
+// etag returns the entity tag of a record at version
+func etag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatchVersion returns the version in the If-Match header, or 0 if the header is missing or *
+func ifMatchVersion(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+
+	// we compare versions, so weak and strong tags mean the same
+	tag := strings.TrimPrefix(header, "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, errInvalidIfMatch
+	}
+
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version < 1 {
+		return 0, errInvalidIfMatch
+	}
+
+	return version, nil
+}
+
+// decodeError turns the errors of the JSON decoder into messages that are safe for clients
+func decodeError(err error, maxBytes int) error {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &syntaxError):
+		return newAPIError("invalid_json", fmt.Sprintf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)).wrap(err)
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return newAPIError("invalid_json", "body contains badly-formed JSON").wrap(err)
+
+	case errors.As(err, &typeError):
+		if typeError.Field != "" {
+			return newAPIError("invalid_json", fmt.Sprintf("body contains an incorrect JSON type for field %q", typeError.Field)).wrap(err)
+		}
+		return newAPIError("invalid_json", fmt.Sprintf("body contains an incorrect JSON type (at character %d)", typeError.Offset)).wrap(err)
+
+	case errors.Is(err, io.EOF):
+		return newAPIError("invalid_json", "body must not be empty").wrap(err)
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return newAPIError("unknown_field", fmt.Sprintf("body contains unknown field %s", field)).wrap(err)
+
+	case errors.As(err, &maxBytesError):
+		return newAPIError("body_too_large", fmt.Sprintf("body must not be larger than %d bytes", maxBytes)).wrap(err)
+	}
+
+	return err
+}
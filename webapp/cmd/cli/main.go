@@ -4,14 +4,21 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"time"
+	"webapp/pkg/config"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
 type application struct {
-	JWTSecret string
-	Action    string
+	config.Auth `yaml:",inline"`
+	Action      string `yaml:"-" toml:"-"`
+}
+
+// Validate accepts the development secret; the tokens are for manual testing only
+func (app *application) Validate() error {
+	return app.Auth.Validate(true)
 }
 
 // This is used to generate a token, so that we can test our api. Run this with go run ./cmd/cli and copy
@@ -20,10 +27,11 @@ type application struct {
 // go run ./cmd/cli -action=expired   // will produce an expired token
 
 func main() {
-	var app application
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "b2xlIjoiQWRtaW4iLCJJc3N1ZXIiOiJJc3N1ZXIiLCJVc2VybmFtZSI6IkphdmFJblVzZSIsImV4cCI6MTY2OTY4MjE1NiwiaWF0IjoxNjY5NjgyMTU2fQ", "secret")
+	app := application{Auth: config.NewAuth()}
 	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired")
-	flag.Parse()
+	if err := config.Load(&app, flag.CommandLine, os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
 
 	// generate a token
 	token := jwt.New(jwt.SigningMethodHS256)
@@ -33,13 +41,18 @@ func main() {
 	claims["name"] = "John Doe"
 	claims["sub"] = "1"
 	claims["admin"] = true
-	claims["aud"] = "example.com"
-	claims["iss"] = "example.com"
+	claims["aud"] = app.Domain
+	claims["iss"] = app.Domain
+	// the api only accepts access tokens with a type and a unique id
+	claims["typ"] = "access"
+	claims["jti"] = fmt.Sprintf("cli-%d", time.Now().UnixNano())
 	// leave this to 3 days, for easy manual testing
 	if app.Action == "valid" {
+		claims["iat"] = time.Now().UTC().Unix()
 		expires := time.Now().UTC().Add(time.Hour * 72)
 		claims["exp"] = expires.Unix()
 	} else {
+		claims["iat"] = time.Now().UTC().Add(time.Hour * 101 * -1).Unix()
 		expires := time.Now().UTC().Add(time.Hour * 100 * -1)
 		claims["exp"] = expires.Unix()
 	}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"webapp/pkg/clientip"
+	"webapp/pkg/data"
+)
+
+// audit records e with the client's IP address and user agent. A failure to record is logged,
+// but never fails the request.
+func (app *application) audit(r *http.Request, e data.AuditEvent) {
+	e.IP = clientip.Get(r)
+	e.UserAgent = r.UserAgent()
+
+	if _, err := app.DB.InsertAuditEvent(r.Context(), e); err != nil {
+		slog.ErrorContext(r.Context(), "unable to record audit event", "action", e.Action, "error", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"time"
+	"webapp/pkg/config"
+	"webapp/pkg/oidc"
+	"webapp/pkg/purge"
+	"webapp/pkg/ratelimit"
+)
+
+// webConfig holds the settings of the web application, loaded by package config
+type webConfig struct {
+	config.Base `yaml:",inline"`
+
+	purge.Config `yaml:",inline"`
+
+	TemplatePath string `yaml:"template_path" toml:"template_path" env:"TEMPLATE_PATH" flag:"template-path" usage:"directory of the templates, read in dev mode only"`
+	UploadPath   string `yaml:"upload_path" toml:"upload_path" env:"UPLOAD_PATH" flag:"upload-path" usage:"directory where uploaded profile pictures are stored"`
+
+	RateLimit webRateLimits `yaml:"rate_limit" toml:"rate_limit"`
+	OIDC      oidc.Config   `yaml:"oidc" toml:"oidc"`
+}
+
+// webRateLimits are the limits of the route groups of the web application
+type webRateLimits struct {
+	ratelimit.Config `yaml:",inline"`
+
+	Login ratelimit.Limit `yaml:"login" toml:"login" env:"RATE_LIMIT_LOGIN" flag:"rate-limit-login" usage:"login attempts per client IP, e.g. 10/1m, or off"`
+}
+
+func defaultConfig() webConfig {
+	return webConfig{
+		Base:         config.NewBase("web", ":8080", "localhost:9080"),
+		Config:       purge.NewConfig(),
+		TemplatePath: "./template/",
+		UploadPath:   "./static/img",
+		RateLimit: webRateLimits{
+			Config: ratelimit.Config{Store: ratelimit.StoreMemory},
+			Login:  ratelimit.Every(10, time.Minute),
+		},
+	}
+}
+
+func (c *webConfig) Validate() error {
+	errs := []error{c.Base.Validate()}
+
+	if c.UploadPath == "" {
+		errs = append(errs, errors.New("upload_path is required"))
+	}
+
+	if c.Dev && c.TemplatePath == "" {
+		errs = append(errs, errors.New("template_path is required in dev mode"))
+	}
+
+	errs = append(errs, c.Config.Validate(), c.RateLimit.Validate(), c.OIDC.Validate())
+
+	return errors.Join(errs...)
+}
@@ -1,35 +1,24 @@
 package main
 
 import (
+	"fmt"
 	"net/url"
+	"regexp"
 	"strings"
+	"webapp/pkg/validator"
 )
 
-type errors map[string][]string
-
-// Get the first error in the error slice
-func (e errors) Get(field string) string {
-	errorSlice := e[field]
-	if len(errorSlice) == 0 {
-		return ""
-	}
-
-	return errorSlice[0]
-}
-
-func (e errors) Add(field, message string) {
-	e[field] = append(e[field], message)
-}
-
+// Form wraps posted form data and the validation errors found in it. The errors are message
+// keys with parameters, so that they can be rendered in the user's language.
 type Form struct {
 	Data   url.Values
-	Errors errors
+	Errors validator.Errors
 }
 
 func NewForm(data url.Values) *Form {
 	return &Form{
 		Data:   data,
-		Errors: map[string][]string{},
+		Errors: validator.Errors{},
 	}
 }
 
@@ -41,18 +30,108 @@ func (f *Form) Has(field string) bool {
 func (f *Form) Required(fields ...string) {
 	for _, field := range fields {
 		value := f.Data.Get(field)
-		if strings.TrimSpace(value) == "" {
-			f.Errors.Add(field, "This field cannot be blank")
+		if !validator.NotBlank(value) {
+			f.Errors.Add(field, "form.required")
 		}
 	}
 }
 
-func (f *Form) Check(ok bool, field, message string) {
+func (f *Form) Check(ok bool, field, key string, args ...any) {
 	if !ok {
-		f.Errors.Add(field, message)
+		f.Errors.Add(field, key, args...)
+	}
+}
+
+// The rules below only check fields that are present, so combine them with Required
+// for mandatory fields.
+
+// Email checks that field holds an email address
+func (f *Form) Email(field string) {
+	if f.Has(field) {
+		f.Check(validator.IsEmail(strings.TrimSpace(f.Data.Get(field))), field, "form.email")
 	}
 }
 
+// MinLength checks that field has at least n characters
+func (f *Form) MinLength(field string, n int) {
+	if f.Has(field) {
+		f.Check(validator.MinLength(f.Data.Get(field), n), field, "form.min_length", "min", n)
+	}
+}
+
+// MaxLength checks that field has at most n characters
+func (f *Form) MaxLength(field string, n int) {
+	if f.Has(field) {
+		f.Check(validator.MaxLength(f.Data.Get(field), n), field, "form.max_length", "max", n)
+	}
+}
+
+// Between checks that field is a number between min and max, inclusive
+func (f *Form) Between(field string, min, max float64) {
+	if f.Has(field) {
+		f.Check(validator.InRange(f.Data.Get(field), min, max), field, "form.between", "min", min, "max", max)
+	}
+}
+
+// MatchesPattern checks that field matches rx
+func (f *Form) MatchesPattern(field string, rx *regexp.Regexp) {
+	if f.Has(field) {
+		f.Check(validator.Matches(f.Data.Get(field), rx), field, "form.matches")
+	}
+}
+
+// EqualTo checks that field has the same value as other, e.g. a password confirmation
+func (f *Form) EqualTo(field, other string) {
+	f.Check(f.Data.Get(field) == f.Data.Get(other), field, "form.equal_to", "field", other)
+}
+
+// In checks that field is one of the permitted values
+func (f *Form) In(field string, permitted ...string) {
+	if f.Has(field) {
+		f.Check(validator.In(f.Data.Get(field), permitted...), field, "form.in", "values", strings.Join(permitted, ", "))
+	}
+}
+
+// Unique checks with isUnique, typically a database lookup, that the value of field is not in use yet
+func (f *Form) Unique(field string, isUnique validator.UniqueFunc) error {
+	if !f.Has(field) {
+		return nil
+	}
+
+	ok, err := isUnique(f.Data.Get(field))
+	if err != nil {
+		return err
+	}
+
+	f.Check(ok, field, "form.unique")
+	return nil
+}
+
+// Bind copies the form data into the struct dst points to, using its `form` tags, and checks
+// the rules in its `validate` tags with v. It returns an error only if dst could not be bound
+// or a rule could not be evaluated; invalid data is reported through f.Errors.
+func (f *Form) Bind(dst any, v *validator.Validator) error {
+	errs, err := validator.Decode(f.Data, dst)
+	if err != nil {
+		return err
+	}
+	f.Errors.Merge(errs)
+
+	errs, err = v.Struct(dst, "form")
+	if err != nil {
+		return fmt.Errorf("bind: %w", err)
+	}
+
+	// keep conversion errors from Decode, they are more helpful than rule violations
+	for field, messages := range errs {
+		if _, ok := f.Errors[field]; !ok {
+			f.Errors[field] = messages
+		}
+	}
+
+	return nil
+}
+
 func (f *Form) Valid() bool {
 	return len(f.Errors) == 0
 }
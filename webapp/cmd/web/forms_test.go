@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"regexp"
 	"testing"
 )
 
@@ -84,3 +85,93 @@ func TestForm_ErrorGet(t *testing.T) {
 		t.Errorf("we expect Errors.Get() to return empty string for invalid-fields; got %v", s)
 	}
 }
+
+func TestForm_ErrorTranslate(t *testing.T) {
+
+	form := NewForm(nil)
+	form.Required("name")
+
+	if got := form.Errors.Get("name"); got != "This field cannot be blank" {
+		t.Errorf("expect english message; got '%s'", got)
+	}
+
+	fr := app.Translations.Localizer("fr")
+	if got := form.Errors.Translate("name", fr); got != "Ce champ ne peut pas être vide" {
+		t.Errorf("expect french message; got '%s'", got)
+	}
+
+	if got := form.Errors.Translate("invalid-field", fr); got != "" {
+		t.Errorf("expect empty string for invalid-fields; got '%s'", got)
+	}
+}
+
+func TestForm_rules(t *testing.T) {
+
+	postedData := url.Values{
+		"email":    {"john@@doe"},
+		"name":     {"jo"},
+		"age":      {"200"},
+		"code":     {"abc"},
+		"password": {"secret"},
+		"confirm":  {"secrets"},
+		"plan":     {"gold"},
+	}
+
+	form := NewForm(postedData)
+	form.Email("email")
+	form.MinLength("name", 3)
+	form.MaxLength("code", 2)
+	form.Between("age", 0, 130)
+	form.MatchesPattern("code", regexp.MustCompile("^[A-Z]+$"))
+	form.EqualTo("confirm", "password")
+	form.In("plan", "free", "pro")
+	_ = form.Unique("email", func(value string) (bool, error) { return false, nil })
+
+	expected := map[string]int{"email": 2, "name": 1, "age": 1, "code": 2, "confirm": 1, "plan": 1}
+
+	for field, count := range expected {
+		if len(form.Errors[field]) != count {
+			t.Errorf("expect %d error(s) for %s; got %v", count, field, form.Errors[field])
+		}
+	}
+
+	// missing fields are left to Required
+	form = NewForm(url.Values{})
+	form.Email("email")
+	form.MinLength("name", 3)
+	form.Between("age", 0, 130)
+
+	if !form.Valid() {
+		t.Errorf("expect rules to skip missing fields; got %v", form.Errors)
+	}
+}
+
+func TestForm_Bind(t *testing.T) {
+
+	var dst struct {
+		Email string `form:"email" validate:"required,email"`
+		Age   int    `form:"age" validate:"min=18"`
+	}
+
+	form := NewForm(url.Values{"email": {"john@doe.com"}, "age": {"21"}})
+	if err := form.Bind(&dst, app.Validator); err != nil {
+		t.Fatal(err)
+	}
+
+	if !form.Valid() || dst.Email != "john@doe.com" || dst.Age != 21 {
+		t.Errorf("expect valid bound form; got %+v, %v", dst, form.Errors)
+	}
+
+	form = NewForm(url.Values{"email": {"john"}, "age": {"old"}})
+	if err := form.Bind(&dst, app.Validator); err != nil {
+		t.Fatal(err)
+	}
+
+	if form.Errors.Get("email") != "Enter a valid email address" {
+		t.Errorf("unexpected email error '%s'", form.Errors.Get("email"))
+	}
+
+	if form.Errors.Get("age") != "Must be a number" {
+		t.Errorf("unexpected age error '%s'", form.Errors.Get("age"))
+	}
+}
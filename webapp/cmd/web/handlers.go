@@ -1,19 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"html/template"
 	"io"
+	"log/slog"
 	"net/http"
-	"os"
-	"path"
-	"path/filepath"
+	"runtime/debug"
 	"time"
 	"webapp/pkg/data"
-)
+	"webapp/pkg/tracing"
+	"webapp/pkg/upload"
 
-var (
-	templatePath = "./template/"
-	uploadPath   = "./static/img"
+	"github.com/go-chi/chi/v5"
 )
 
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
@@ -31,38 +31,88 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 
 type templateData struct {
 	IP, Flash, Error string
-	Data             map[string]any
-	User             data.User
+	Lang             string
+	// SSO shows the link to sign in with the OpenID Connect provider
+	SSO  bool
+	Data map[string]any
+	User data.User
 }
 
 func (app *application) render(w http.ResponseWriter, r *http.Request, t string, td *templateData) error {
+	_, span := tracing.Start(r.Context(), "render "+t)
+	defer span.End()
 
-	tmpFiles := []string{
-		path.Join(templatePath, t),
-		path.Join(templatePath, "base.layout.gohtml"),
+	cached, ok := app.Templates.get(t)
+	if !ok {
+		err := fmt.Errorf("template %s does not exist", t)
+		app.serverError(w, r, err)
+		return err
 	}
-	parsedTemplate, err := template.ParseFiles(tmpFiles...)
+
+	localizer := app.localizerFromContext(r.Context())
+
+	parsedTemplate, err := localize(cached, localizer.T)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		app.serverError(w, r, err)
 		return err
 	}
 
 	td.IP = app.ipFromContext(r.Context())
-	td.Error = app.Session.PopString(r.Context(), "error")
-	td.Flash = app.Session.GetString(r.Context(), "flash")
+	td.Lang = localizer.Lang()
+	td.SSO = app.OIDC != nil
+	td.Error = localizer.T(app.Session.PopString(r.Context(), "error"))
+	td.Flash = localizer.T(app.Session.GetString(r.Context(), "flash"))
 
 	if app.Session.Exists(r.Context(), "user") {
 		td.User = app.Session.Get(r.Context(), "user").(data.User)
 	}
 
-	err = parsedTemplate.Execute(w, td)
+	// render into a buffer first, so that a failing template does not send half a page
+	buf := new(bytes.Buffer)
 
+	err = parsedTemplate.ExecuteTemplate(buf, t, td)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		app.serverError(w, r, err)
 		return err
 	}
 
-	return nil
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// localize returns a copy of the cached template t whose "t" function translates with translate
+func localize(t *template.Template, translate func(string, ...any) string) (*template.Template, error) {
+	clone, err := t.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	return clone.Funcs(template.FuncMap{"t": translate}), nil
+}
+
+// serverError logs err with a stack trace and sends the user a generic 500 page
+func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "server error", "error", err, "stack", string(debug.Stack()))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+
+	localizer := app.localizerFromContext(r.Context())
+
+	if cached, ok := app.Templates.get("500.gohtml"); ok {
+		if t, err := localize(cached, localizer.T); err == nil {
+			if err := t.ExecuteTemplate(w, "500.gohtml", &templateData{Lang: localizer.Lang()}); err == nil {
+				return
+			}
+		}
+	}
+
+	_, _ = io.WriteString(w, http.StatusText(http.StatusInternalServerError))
+}
+
+type loginForm struct {
+	Email    string `form:"email" validate:"required,email"`
+	Password string `form:"password" validate:"required"`
 }
 
 func (app *application) login(w http.ResponseWriter, r *http.Request) {
@@ -73,31 +123,40 @@ func (app *application) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var credentials loginForm
+
 	form := NewForm(r.PostForm)
-	form.Required("email", "password")
+	if err := form.Bind(&credentials, app.Validator); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 
 	if !form.Valid() {
-		app.redirectWithError(w, r, "/", "invalid login")
+		app.redirectWithError(w, r, "/", "login.invalid")
 		return
 	}
 
-	email := r.PostForm.Get("email")
-	password := r.PostForm.Get("password")
-
-	user, err := app.DB.GetUserByEmail(email)
+	user, err := app.DB.GetUserByEmail(r.Context(), credentials.Email)
 	if err != nil {
-		app.redirectWithError(w, r, "/", "invalid login")
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed, Email: credentials.Email})
+		app.Metrics.AuthAttempt(false)
+		app.redirectWithError(w, r, "/", "login.invalid")
 		return
 	}
 
-	if !app.authenticate(w, r, user, password) {
-		app.redirectWithError(w, r, "/", "invalid login")
+	if !app.authenticate(w, r, user, credentials.Password) {
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed, TargetID: user.ID, Email: credentials.Email})
+		app.Metrics.AuthAttempt(false)
+		app.redirectWithError(w, r, "/", "login.invalid")
 		return
 	}
 
+	app.audit(r, data.AuditEvent{Action: data.AuditLogin, ActorID: user.ID, TargetID: user.ID, Email: user.Email})
+	app.Metrics.AuthAttempt(true)
+
 	_ = app.Session.RenewToken(r.Context())
 
-	app.redirectWithMessage(w, r, "/user/profile", "flash", "successfully logged in!")
+	app.redirectWithMessage(w, r, "/user/profile", "flash", "login.success")
 
 }
 
@@ -105,6 +164,26 @@ func (app *application) profilePage(w http.ResponseWriter, r *http.Request) {
 	app.render(w, r, "profile.gohtml", &templateData{})
 }
 
+// setLanguage stores the chosen language in a cookie, overriding Accept-Language
+func (app *application) setLanguage(w http.ResponseWriter, r *http.Request) {
+	lang := chi.URLParam(r, "lang")
+	if !app.Translations.Supports(lang) {
+		http.Error(w, "unsupported language", http.StatusBadRequest)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     languageCookie,
+		Value:    lang,
+		Path:     "/",
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+	})
+
+	app.redirect(w, r, "/")
+}
+
 func (app *application) redirect(w http.ResponseWriter, r *http.Request, to string) {
 	http.Redirect(w, r, to, http.StatusSeeOther)
 }
@@ -130,26 +209,40 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request, use
 
 func (app *application) uploadProfilePicture(w http.ResponseWriter, r *http.Request) {
 
-	files, err := app.uploadFiles(r, uploadPath)
+	file, err := upload.Image(r, app.UploadPath, "image")
 	if err != nil {
 		app.redirectWithMessage(w, r, "/user/profile", "error", err.Error())
 		return
 	}
 
+	app.Metrics.Uploaded(file.FileSize)
+
 	user := app.Session.Get(r.Context(), "user").(data.User)
 
+	// the picture in the session may be stale, e.g. after an upload through the api
+	current, err := app.DB.GetUser(r.Context(), user.ID)
+	if err != nil {
+		app.redirectWithError(w, r, "/user/profile", err.Error())
+		return
+	}
+
 	var userImg = data.UserImage{
 		UserID:   user.ID,
-		FileName: files[0].OriginalFileName,
+		FileName: file.FileName,
 	}
 
-	_, err = app.DB.InsertUserImage(userImg)
+	_, err = app.DB.InsertUserImage(r.Context(), userImg)
 	if err != nil {
 		app.redirectWithError(w, r, "/user/profile", err.Error())
 		return
 	}
 
-	updatedUser, err := app.DB.GetUser(user.ID)
+	// the new picture has a name of its own, so the replaced one would be left behind
+	if err := upload.Remove(app.UploadPath, current.ProfilePic.FileName); err != nil {
+		slog.WarnContext(r.Context(), "unable to remove the replaced profile picture", "file", current.ProfilePic.FileName, "error", err)
+	}
+
+	updatedUser, err := app.DB.GetUser(r.Context(), user.ID)
 	if err != nil {
 		app.redirectWithError(w, r, "/user/profile", err.Error())
 		return
@@ -157,60 +250,13 @@ func (app *application) uploadProfilePicture(w http.ResponseWriter, r *http.Requ
 
 	app.Session.Put(r.Context(), "user", updatedUser)
 
-	app.redirectWithMessage(w, r, "/user/profile", "flash", "profile photo uploaded!")
-
-}
-
-type UploadedFile struct {
-	OriginalFileName string
-	FileSize         int64
-}
-
-func (app *application) uploadFiles(r *http.Request, uploadDir string) ([]*UploadedFile, error) {
+	app.audit(r, data.AuditEvent{
+		Action:   data.AuditPictureUploaded,
+		ActorID:  user.ID,
+		TargetID: user.ID,
+		Changes:  data.DiffUsers(*current, *updatedUser),
+	})
 
-	var uploadedFiles []*UploadedFile
-
-	err := r.ParseMultipartForm(int64(1024 * 1024 * 5))
-	if err != nil {
-		return nil, err
-	}
-
-	for _, fHeaders := range r.MultipartForm.File {
-		for _, hdr := range fHeaders {
-			uploadedFiles, err = func(uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
-
-				var uploadeFile UploadedFile
-				infile, err := hdr.Open()
-				if err != nil {
-					return nil, err
-				}
-
-				defer infile.Close()
-				uploadeFile.OriginalFileName = hdr.Filename
-
-				var outfile *os.File
-				defer outfile.Close()
-
-				if outfile, err = os.Create(filepath.Join(uploadDir, uploadeFile.OriginalFileName)); err != nil {
-					return nil, err
-				} else {
-					fileSize, err := io.Copy(outfile, infile)
-					if err != nil {
-						return nil, err
-					}
-					uploadeFile.FileSize = fileSize
-				}
-
-				uploadedFiles = append(uploadedFiles, &uploadeFile)
-				return uploadedFiles, nil
-
-			}(uploadedFiles)
-
-			if err != nil {
-				return uploadedFiles, err
-			}
-		}
-	}
+	app.redirectWithMessage(w, r, "/user/profile", "flash", "profile.photo_uploaded")
 
-	return uploadedFiles, nil
 }
@@ -4,20 +4,23 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
-	"fmt"
-	"image"
-	"image/png"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
-	"path"
+	"path/filepath"
 	"strings"
-	"sync"
 	"testing"
+	"time"
+	"webapp/pkg/clientip"
 	"webapp/pkg/data"
+	"webapp/pkg/ratelimit"
+	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
+
+	"github.com/go-chi/chi/v5"
 )
 
 func Test_application_handlers(t *testing.T) {
@@ -119,23 +122,87 @@ func TestApp_Home(t *testing.T) {
 
 func TestApp_render_bad_template(t *testing.T) {
 
-	oldTemplatePath := templatePath
-	templatePath = "./../../testdata/"
+	_, err := newTemplateCache(os.DirFS("./../../testdata/"))
+	if err == nil {
+		t.Error("expected newTemplateCache() to return error for bad templates")
+	}
 
 	req, _ := http.NewRequest("GET", "/", nil)
+	req = addContextAndSessiontToRequest(req, app)
 
 	rr := httptest.NewRecorder()
 
-	err := app.render(rr, req, "bad-template.gohtml", &templateData{})
+	err = app.render(rr, req, "missing-template.gohtml", &templateData{})
 	if err == nil {
-		t.Error("expected render() to return error for bad templates")
+		t.Error("expected render() to return error for missing templates")
+	}
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expect status code %d; got %d", http.StatusInternalServerError, rr.Code)
+	}
+
+}
+
+func Test_application_setLanguage(t *testing.T) {
+
+	testCases := []struct {
+		name           string
+		lang           string
+		expectedStatus int
+		expectCookie   bool
+	}{
+		{"supported", "fr", http.StatusSeeOther, true},
+		{"unsupported", "xx", http.StatusBadRequest, false},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/lang/"+tt.lang, nil)
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("lang", tt.lang)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(app.setLanguage).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expect status code %d; got %d", tt.expectedStatus, rr.Code)
+			}
+
+			found := false
+			for _, c := range rr.Result().Cookies() {
+				if c.Name == languageCookie && c.Value == tt.lang {
+					found = true
+				}
+			}
+
+			if found != tt.expectCookie {
+				t.Errorf("expect cookie to be set: %v; got %v", tt.expectCookie, found)
+			}
+		})
 	}
+}
+
+func TestApp_Home_localized(t *testing.T) {
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Language", "fr")
+	req = addContextAndSessiontToRequest(req, app)
+
+	rr := httptest.NewRecorder()
+	app.addLocaleToContext(http.HandlerFunc(app.home)).ServeHTTP(rr, req)
 
-	templatePath = oldTemplatePath
+	if !strings.Contains(rr.Body.String(), "Mot de passe") {
+		t.Errorf("expect french home page; got %s", rr.Body.String())
+	}
 
+	if !strings.Contains(rr.Body.String(), `<html lang="fr">`) {
+		t.Error("expect html lang attribute to be fr")
+	}
 }
+
 func getCtx(r *http.Request) context.Context {
-	return context.WithValue(r.Context(), contextUserKey, "user")
+	return clientip.NewContext(r.Context(), "user")
 }
 
 func addContextAndSessiontToRequest(req *http.Request, app application) *http.Request {
@@ -154,18 +221,24 @@ func Test_application_login(t *testing.T) {
 		expectedStatusCode int
 		expectedLoc        string
 		nillBody           bool
+		expectedAudit      string
 	}{
-		{name: "valid user", postedData: url.Values{"email": {"admin@example.com"}, "password": {"secret"}}, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/user/profile"},
-		{name: "in-valid email", postedData: url.Values{"email": {"invalid-email@example.com"}, "password": {"secret"}}, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/"},
+		{name: "valid user", postedData: url.Values{"email": {"admin@example.com"}, "password": {"secret"}}, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/user/profile", expectedAudit: data.AuditLogin},
+		{name: "in-valid email", postedData: url.Values{"email": {"invalid-email@example.com"}, "password": {"secret"}}, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/", expectedAudit: data.AuditLoginFailed},
 		{name: "empty email and password", postedData: nil, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/"},
-		{name: "invalid login", postedData: url.Values{"email": {"admin@example.com"}, "password": {"invalid-password"}}, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/"},
+		{name: "invalid login", postedData: url.Values{"email": {"admin@example.com"}, "password": {"invalid-password"}}, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/", expectedAudit: data.AuditLoginFailed},
 		{name: "invalid body", postedData: nil, expectedStatusCode: http.StatusBadRequest, expectedLoc: "/", nillBody: true},
-		{name: "invalid res from sql", postedData: url.Values{"email": {"invalid@sql.com"}, "password": {"invalid-password"}}, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/"},
+		{name: "invalid res from sql", postedData: url.Values{"email": {"invalid@sql.com"}, "password": {"invalid-password"}}, expectedStatusCode: http.StatusSeeOther, expectedLoc: "/", expectedAudit: data.AuditLoginFailed},
 	}
 
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
 	for _, tt := range testCases {
 
 		t.Run(tt.name, func(t *testing.T) {
+			app.DB = &dbrepo.MockDBRepo{}
+
 			req, err := http.NewRequest(http.MethodPost, "/login", strings.NewReader(tt.postedData.Encode()))
 			if err != nil {
 				t.Fatal(err)
@@ -192,81 +265,26 @@ func Test_application_login(t *testing.T) {
 			if len(tt.expectedLoc) > 0 && loc != nil && loc.String() != tt.expectedLoc {
 				t.Errorf("expect Location header to be %s, got %s", tt.expectedLoc, loc)
 			}
-		})
-	}
-
-}
-
-func Test_application_UploadFiles(t *testing.T) {
-
-	// set up some pips
-	pr, pw := io.Pipe()
 
-	// create a new writer. of the type *io.Writer
-	writer := multipart.NewWriter(pw)
-
-	// create a wg
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-
-	// simulate uploading a file using a gooutine and our writer
-	go simulatePNGUpload("./testdata/img/test.png", writer, t, wg)
-
-	// read frpm the pipe which receives the data
-	request := httptest.NewRequest("POST", "/", pr)
-	request.Header.Add("Content-Type", writer.FormDataContentType())
-
-	// call the app.UploadFiles
-	uploadedFiles, err := app.uploadFiles(request, "./testdata/uploads")
-	if err != nil {
-		t.Error(err)
-	}
-
-	// perform our tests
-	newUploadedFile := fmt.Sprintf("./testdata/uploads/%s", uploadedFiles[0].OriginalFileName)
-	if _, err = os.Stat(newUploadedFile); os.IsNotExist(err) {
-		t.Errorf("expected file to exists; %s", err.Error())
-	}
-
-	// clean up
-	_ = os.Remove(newUploadedFile)
-	wg.Wait()
-
-}
-
-func simulatePNGUpload(fileToUpload string, writer *multipart.Writer, t *testing.T, wg *sync.WaitGroup) {
-	defer writer.Close()
-	defer wg.Done()
-
-	part, err := writer.CreateFormFile("file", path.Base(fileToUpload))
-	if err != nil {
-		t.Error(err)
-	}
-
-	f, err := os.Open(fileToUpload)
-	if err != nil {
-		t.Error(err)
-	}
-	defer f.Close()
-
-	img, _, err := image.Decode(f)
-	if err != nil {
-		t.Error(err)
-	}
-
-	err = png.Encode(part, img)
-
-	if err != nil {
-		t.Error(err)
+			events, _, _ := app.DB.AuditEvents(context.Background(), repository.AuditFilter{})
+			switch {
+			case tt.expectedAudit == "" && len(events) > 0:
+				t.Errorf("expect no audit event; got %s", events[0].Action)
+			case tt.expectedAudit != "" && (len(events) != 1 || events[0].Action != tt.expectedAudit):
+				t.Errorf("expect audit event %s; got %v", tt.expectedAudit, events)
+			}
+		})
 	}
 
 }
 
 func Test_application_UploadProfilePic(t *testing.T) {
-	uploadPath = "./testdata/uploads"
+	oldUploadPath := app.UploadPath
+	defer func() { app.UploadPath = oldUploadPath }()
+	app.UploadPath = t.TempDir()
 	filePath := "./testdata/img/test.png"
 
-	fieldName := "file"
+	fieldName := "image"
 
 	body := new(bytes.Buffer)
 
@@ -300,5 +318,44 @@ func Test_application_UploadProfilePic(t *testing.T) {
 		t.Errorf("wrong status code; expect %d; got %d", http.StatusSeeOther, rr.Code)
 	}
 
-	_ = os.Remove(uploadPath + "/test.png")
+	// the picture is saved under a name of its own, never the one of the client
+	entries, _ := os.ReadDir(app.UploadPath)
+	if len(entries) != 1 || entries[0].Name() == "test.png" || filepath.Ext(entries[0].Name()) != ".png" {
+		t.Errorf("expect the picture under a generated name; got %v", entries)
+	}
+}
+
+func Test_application_login_rateLimit(t *testing.T) {
+
+	oldLimits, oldLimiter := app.RateLimit, app.RateLimiter
+	defer func() { app.RateLimit, app.RateLimiter = oldLimits, oldLimiter }()
+
+	app.RateLimit.Login = ratelimit.Every(1, time.Hour)
+	app.RateLimiter = ratelimit.New(ratelimit.NewMemoryStore())
+	app.RateLimiter.Denied = app.tooManyRequests
+
+	routes := app.routes()
+	form := url.Values{"email": {"admin@example.com"}, "password": {"invalid-password"}}
+
+	for i, expected := range []int{http.StatusSeeOther, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != expected {
+			t.Fatalf("request %d: expect status %d; got %d", i, expected, rr.Code)
+		}
+
+		if expected == http.StatusTooManyRequests {
+			if rr.Header().Get("Retry-After") == "" {
+				t.Error("expect a Retry-After header")
+			}
+
+			if !strings.Contains(rr.Body.String(), "Too many login attempts") {
+				t.Error("expect the home page with the rate limit error")
+			}
+		}
+	}
 }
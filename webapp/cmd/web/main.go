@@ -1,53 +1,175 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
-	"log"
-	"net/http"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+	"webapp/pkg/clientip"
+	"webapp/pkg/config"
 	"webapp/pkg/data"
+	"webapp/pkg/health"
+	"webapp/pkg/i18n"
+	"webapp/pkg/logging"
+	"webapp/pkg/metrics"
+	"webapp/pkg/oidc"
+	"webapp/pkg/purge"
+	"webapp/pkg/ratelimit"
 	"webapp/pkg/repository"
 	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/server"
+	"webapp/pkg/tracing"
+	"webapp/pkg/validator"
+	webtemplate "webapp/template"
 
 	"github.com/alexedwards/scs/v2"
 )
 
 type application struct {
-	Session *scs.SessionManager
-	DB      repository.DatabaseRepo
-	DSN     string
+	Session      *scs.SessionManager
+	DB           repository.DatabaseRepo
+	DSN          string
+	ClientIP     *clientip.Resolver
+	Dev          bool
+	TemplatePath string
+	UploadPath   string
+	Templates    *templateCache
+	Translations *i18n.Bundle
+	Validator    *validator.Validator
+	Metrics      *metrics.Metrics
+	Health       *health.Checker
+	RateLimit    webRateLimits
+	RateLimiter  *ratelimit.Limiter
+	// OIDC is the provider of single sign-on, nil when it is disabled
+	OIDC *oidc.Provider
 }
 
 func main() {
 
 	// register type
 	gob.Register(data.User{})
+	gob.Register(oidc.Flow{})
 	// setup an app config
 	app := application{}
 
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "postres connection string")
+	cfg := defaultConfig()
+	printConfig := flag.Bool("print-config", false, "print the effective settings, secrets redacted, and exit")
+	if err := config.Load(&cfg, flag.CommandLine, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *printConfig {
+		if err := config.Print(os.Stdout, &cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	app.DSN = cfg.DSN
+	app.Dev = cfg.Dev
+	app.TemplatePath = cfg.TemplatePath
+	app.UploadPath = cfg.UploadPath
+	app.RateLimit = cfg.RateLimit
+
+	if cfg.OIDC.Enabled() {
+		app.OIDC = oidc.New(cfg.OIDC, nil)
+	}
 
-	flag.Parse()
+	// the settings were validated, so the resolver compiles
+	app.ClientIP, _ = clientip.New(cfg.ClientIP)
+
+	slog.SetDefault(logging.New(os.Stdout, cfg.LogLevel))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Error("unable to set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	// templates are embedded in the binary, unless we are developing them
+	var templateFS fs.FS = webtemplate.Files
+	if app.Dev {
+		templateFS = os.DirFS(app.TemplatePath)
+	}
+
+	tc, err := newTemplateCache(templateFS)
+	if err != nil {
+		slog.Error("unable to parse templates", "error", err)
+		os.Exit(1)
+	}
+	app.Templates = tc
+	app.Translations = i18n.Default()
+	app.Validator = validator.New()
 
 	conn, err := app.connectToDB()
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("unable to connect to postgres", "error", err)
+		os.Exit(1)
 	}
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+
+	if err := dbrepo.Migrate(conn); err != nil {
+		slog.Error("unable to migrate the database", "error", err)
+		os.Exit(1)
+	}
+	app.Metrics = metrics.New()
+	app.Metrics.RegisterDB(conn, "users")
+	app.DB = repository.Instrument(&dbrepo.PostgresDBRepo{DB: conn}, tracing.ObserveRepo, app.Metrics.ObserveRepo)
 	app.Session = getSession()
 
-	defer conn.Close()
+	app.Health = health.New(health.DefaultTimeout)
+	app.Health.Add("database", health.Ping(conn))
+	app.Health.Add("migrations", health.Migrations(conn))
+	app.Health.Add("uploads", health.Writable(app.UploadPath))
 
-	// routes
-	mux := app.routes()
+	app.RateLimiter = ratelimit.New(cfg.RateLimit.NewStore(conn), cfg.RateLimit.Login)
+	app.RateLimiter.Denied = app.tooManyRequests
+	app.RateLimiter.Failed = app.Metrics.RateLimitFailed
 
-	// print out message
+	srv := server.New(cfg.HTTP, app.routes())
 
-	log.Println("starting server on port 8080")
+	if cfg.MetricsAddr != "" {
+		metricsLn, err := net.Listen("tcp", cfg.MetricsAddr)
+		if err != nil {
+			slog.Error("unable to listen for the metrics", "error", err)
+			os.Exit(1)
+		}
 
-	// start server
+		srv.Go(func(ctx context.Context) {
+			if err := app.Metrics.Serve(ctx, metricsLn); err != nil {
+				slog.Error("metrics server failed", "error", err)
+			}
+		})
+		slog.Info("serving metrics", "addr", metricsLn.Addr().String())
+	}
+
+	// closers run in reverse: the database is closed before the last spans are flushed
+	srv.OnShutdown("tracing", shutdownTracing)
+	srv.OnShutdown("database", func(context.Context) error { return conn.Close() })
+
+	srv.Go(func(ctx context.Context) {
+		purge.Run(ctx, app.DB, app.UploadPath, cfg.Config)
+	})
+
+	srv.Go(func(ctx context.Context) {
+		app.RateLimiter.Sweep(ctx, ratelimit.DefaultSweepInterval)
+	})
+
+	if app.Dev {
+		srv.Go(func(ctx context.Context) {
+			tc.watch(ctx, app.TemplatePath, time.Second)
+		})
+		slog.Info("watching templates for changes", "dir", app.TemplatePath)
+	}
 
-	if err := http.ListenAndServe(":8080", mux); err != nil {
-		log.Fatal(err)
+	if err := srv.Run(context.Background()); err != nil {
+		slog.Error("server stopped", "error", err)
+		os.Exit(1)
 	}
 }
@@ -2,45 +2,73 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"net"
 	"net/http"
+	"strconv"
+	"webapp/pkg/clientip"
+	"webapp/pkg/data"
+	"webapp/pkg/i18n"
+	"webapp/pkg/logging"
 )
 
 type contextKey string
 
-const contextUserKey contextKey = "user_ip"
+const contextLocaleKey contextKey = "locale"
 
+// languageCookie overrides the languages sent by the browser in Accept-Language
+const languageCookie = "lang"
+
+// ipFromContext returns the client IP resolved by the clientip middleware
 func (app *application) ipFromContext(ctx context.Context) string {
-	return ctx.Value(contextUserKey).(string)
+	ip, _ := clientip.FromContext(ctx)
+	return ip
 }
 
-func (app *application) addIpToContext(next http.Handler) http.Handler {
+// addLocaleToContext negotiates the user's language from the lang cookie or the Accept-Language header
+func (app *application) addLocaleToContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var ctx = context.Background()
-
-		// get the ip
-		ip, err := getIp(r)
-		if err != nil {
-			ip, _, _ = net.SplitHostPort(r.RemoteAddr)
-			if len(ip) == 0 {
-				ip = "unknown"
-			}
-			ctx = context.WithValue(r.Context(), contextUserKey, ip)
-		} else {
-			ctx = context.WithValue(r.Context(), contextUserKey, ip)
+		var preferences []string
+
+		if c, err := r.Cookie(languageCookie); err == nil {
+			preferences = append(preferences, c.Value)
 		}
+		preferences = append(preferences, r.Header.Get("Accept-Language"))
+
+		localizer := app.Translations.Localizer(preferences...)
 
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Content-Language", localizer.Lang())
+
+		ctx := context.WithValue(r.Context(), contextLocaleKey, localizer)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// localizerFromContext returns the negotiated localizer, or one for the default language
+func (app *application) localizerFromContext(ctx context.Context) *i18n.Localizer {
+	if l, ok := ctx.Value(contextLocaleKey).(*i18n.Localizer); ok {
+		return l
+	}
+
+	return app.Translations.Localizer()
+}
+
+// addUserToLog adds the logged in user to the access log
+func (app *application) addUserToLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, ok := app.Session.Get(r.Context(), "user").(data.User); ok {
+			logging.SetUserID(r.Context(), strconv.Itoa(user.ID))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) auth(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		if !app.Session.Exists(r.Context(), "user") {
-			app.Session.Put(r.Context(), "error", "Log in to continue")
+			app.Session.Put(r.Context(), "error", "auth.login_required")
 			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 			return
 		}
@@ -50,26 +78,16 @@ func (app *application) auth(next http.Handler) http.Handler {
 
 }
 
-func getIp(r *http.Request) (string, error) {
-
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return "unknow", err
-	}
-
-	userIP := net.ParseIP(ip)
-	if userIP == nil {
-		return "", fmt.Errorf("userip: %q is not a valid ip:port", r.RemoteAddr)
-	}
-
-	forward := r.Header.Get("X-Forwaded-For")
-	if len(forward) > 0 {
-		ip = forward
-	}
-	if len(ip) == 0 {
-		ip = "forward"
-	}
+// rateLimitKey counts the requests against the client IP
+func (app *application) rateLimitKey(r *http.Request) string {
+	return "ip:" + app.ipFromContext(r.Context())
+}
 
-	return ip, nil
+// tooManyRequests answers the requests refused by the rate limiter with the home page and an error
+func (app *application) tooManyRequests(w http.ResponseWriter, r *http.Request) {
+	app.Session.Put(r.Context(), "error", "login.too_many_attempts")
 
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+	_ = app.render(w, r, "home.gohtml", &templateData{Data: map[string]any{}})
 }
@@ -5,62 +5,63 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"webapp/pkg/clientip"
 	"webapp/pkg/data"
+	"webapp/pkg/logging"
 )
 
-func Test_application_addIpToContext(t *testing.T) {
+func Test_application_clientIP(t *testing.T) {
+
+	oldResolver := app.ClientIP
+	defer func() { app.ClientIP = oldResolver }()
+
+	resolver, err := clientip.New(clientip.Config{Header: clientip.HeaderXForwardedFor, TrustedProxies: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.ClientIP = resolver
 
 	testCases := []struct {
+		name        string
 		headerName  string
 		headerValue string
 		addr        string
-		emeptyAddr  bool
+		expected    string
 	}{
-		{"", "", "", false},
-		{"", "", "", true},
-		{"X-Forwaded-For", "198.3.2.1", "", false},
-		{"", "", "hello:world", false},
+		{"peer", "", "", "192.0.2.1:1234", "192.0.2.1"},
+		{"empty addr", "", "", "", clientip.Unknown},
+		{"invalid addr", "", "", "hello:world", clientip.Unknown},
+		{"untrusted proxy", "X-Forwarded-For", "198.3.2.1", "192.0.2.1:1234", "192.0.2.1"},
+		{"trusted proxy", "X-Forwarded-For", "198.3.2.1", "10.0.0.1:1234", "198.3.2.1"},
+		{"misspelled header", "X-Forwaded-For", "198.3.2.1", "10.0.0.1:1234", "10.0.0.1"},
 	}
-	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		val := r.Context().Value(contextUserKey)
-
-		if val == nil {
-			t.Error(contextUserKey, "not present")
-		}
-
-		ip, ok := val.(string)
-		if !ok {
-			t.Error("not a string")
-		}
-
-		t.Log(ip)
-	})
 
 	for _, tt := range testCases {
-		handlerToTest := app.addIpToContext(nextHandler)
-
-		req := httptest.NewRequest("GET", "http://testing", nil)
-
-		if tt.emeptyAddr {
-			req.RemoteAddr = ""
-		}
-
-		if len(tt.headerName) > 0 {
-			req.Header.Add(tt.headerName, tt.headerValue)
-		}
+		t.Run(tt.name, func(t *testing.T) {
+			var ip string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip = app.ipFromContext(r.Context())
+			})
 
-		if len(tt.addr) > 0 {
+			req := httptest.NewRequest("GET", "http://testing", nil)
 			req.RemoteAddr = tt.addr
-		}
+			if len(tt.headerName) > 0 {
+				req.Header.Add(tt.headerName, tt.headerValue)
+			}
+
+			app.ClientIP.Middleware(next).ServeHTTP(httptest.NewRecorder(), req)
 
-		handlerToTest.ServeHTTP(httptest.NewRecorder(), req)
+			if ip != tt.expected {
+				t.Errorf("expect client IP %s; got %s", tt.expected, ip)
+			}
+		})
 	}
 }
 
 func Test_application_iPFromContext(t *testing.T) {
 
 	expected := "127.0.0.1"
-	ctx := context.WithValue(context.Background(), contextUserKey, expected)
+	ctx := clientip.NewContext(context.Background(), expected)
 
 	result := app.ipFromContext(ctx)
 
@@ -69,6 +70,49 @@ func Test_application_iPFromContext(t *testing.T) {
 	}
 }
 
+func Test_application_addLocaleToContext(t *testing.T) {
+
+	testCases := []struct {
+		name           string
+		acceptLanguage string
+		cookie         string
+		expected       string
+	}{
+		{"default", "", "", "en"},
+		{"accept-language", "fr-FR,fr;q=0.9", "", "fr"},
+		{"cookie override", "fr-FR,fr;q=0.9", "en", "en"},
+		{"unsupported", "de-DE", "", "en"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var lang string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lang = app.localizerFromContext(r.Context()).Lang()
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: languageCookie, Value: tt.cookie})
+			}
+
+			rr := httptest.NewRecorder()
+			app.addLocaleToContext(next).ServeHTTP(rr, req)
+
+			if lang != tt.expected {
+				t.Errorf("expect language %s; got %s", tt.expected, lang)
+			}
+
+			if rr.Header().Get("Content-Language") != tt.expected {
+				t.Errorf("expect Content-Language %s; got %s", tt.expected, rr.Header().Get("Content-Language"))
+			}
+		})
+	}
+}
+
 func Test_application_auth(t *testing.T) {
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -106,3 +150,37 @@ func Test_application_auth(t *testing.T) {
 		})
 	}
 }
+
+func Test_application_addUserToLog(t *testing.T) {
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	testCases := []struct {
+		name       string
+		isAuth     bool
+		expectedID string
+	}{
+		{"logged in", true, "1"},
+		{"not logged in", false, ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req = addContextAndSessiontToRequest(req, app)
+
+			logReq := &logging.Request{ID: "test"}
+			req = req.WithContext(logging.NewContext(req.Context(), logReq))
+
+			if tt.isAuth {
+				app.Session.Put(req.Context(), "user", data.User{ID: 1})
+			}
+
+			app.addUserToLog(handler).ServeHTTP(httptest.NewRecorder(), req)
+
+			if logReq.UserID() != tt.expectedID {
+				t.Errorf("expect user id %q in the log; got %q", tt.expectedID, logReq.UserID())
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"webapp/pkg/data"
+	"webapp/pkg/oidc"
+)
+
+// oidcFlowKey is the session key of the login in progress
+const oidcFlowKey = "oidc_flow"
+
+// oidcLogin starts a login at the OpenID Connect provider; its secrets stay in the session
+// until the callback
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	authURL, err := app.OIDC.AuthCodeURL(r.Context(), flow)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "unable to start single sign-on", "error", err)
+		app.redirectWithError(w, r, "/", "login.sso_failed")
+		return
+	}
+
+	app.Session.Put(r.Context(), oidcFlowKey, flow)
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallback completes the login started by oidcLogin, and signs in the user linked to the
+// identity, linking or creating one by verified email address if needed
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	// a flow is used once, whatever the outcome
+	flow, ok := app.Session.Pop(r.Context(), oidcFlowKey).(oidc.Flow)
+	if !ok {
+		app.redirectWithError(w, r, "/", "login.sso_failed")
+		return
+	}
+
+	token, err := app.OIDC.Callback(r.Context(), r, flow)
+	if err != nil {
+		slog.WarnContext(r.Context(), "single sign-on failed", "error", err)
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed})
+		app.Metrics.AuthAttempt(false)
+		app.redirectWithError(w, r, "/", "login.sso_failed")
+		return
+	}
+
+	user, linked, created, err := app.OIDC.SignIn(r.Context(), app.DB, token)
+	if errors.Is(err, oidc.ErrEmailNotVerified) {
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed, Email: token.Email})
+		app.Metrics.AuthAttempt(false)
+		app.redirectWithError(w, r, "/", "login.sso_unverified")
+		return
+	} else if errors.Is(err, oidc.ErrAccountDeleted) {
+		app.audit(r, data.AuditEvent{Action: data.AuditLoginFailed, Email: token.Email})
+		app.Metrics.AuthAttempt(false)
+		app.redirectWithError(w, r, "/", "login.sso_deleted")
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "unable to sign in with single sign-on", "error", err)
+		app.redirectWithError(w, r, "/", "login.sso_failed")
+		return
+	}
+
+	if created {
+		app.audit(r, data.AuditEvent{Action: data.AuditUserCreated, ActorID: user.ID, TargetID: user.ID, Email: user.Email})
+	}
+	if linked {
+		app.audit(r, data.AuditEvent{Action: data.AuditIdentityLinked, ActorID: user.ID, TargetID: user.ID, Email: token.Email})
+	}
+	app.audit(r, data.AuditEvent{Action: data.AuditLogin, ActorID: user.ID, TargetID: user.ID, Email: user.Email})
+	app.Metrics.AuthAttempt(true)
+
+	_ = app.Session.RenewToken(r.Context())
+	app.Session.Put(r.Context(), "user", user)
+
+	app.redirectWithMessage(w, r, "/user/profile", "flash", "login.success")
+}
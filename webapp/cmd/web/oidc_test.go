@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/oidc"
+	"webapp/pkg/oidc/oidctest"
+	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_application_oidcLogin(t *testing.T) {
+
+	provider := oidctest.NewServer()
+	defer provider.Close()
+
+	oldDB, oldOIDC := app.DB, app.OIDC
+	defer func() { app.DB, app.OIDC = oldDB, oldOIDC }()
+
+	app.OIDC = oidc.New(provider.Config("https://app.example.com/login/oidc/callback"), provider.Client())
+
+	testCases := []struct {
+		name           string
+		user           oidctest.User
+		tamper         func(callback *url.URL)
+		expectedLoc    string
+		expectedAudits []string
+	}{
+		{"existing user", oidctest.User{Subject: "1", Email: "admin@example.com", EmailVerified: true}, nil, "/user/profile", []string{data.AuditIdentityLinked, data.AuditLogin}},
+		{"new user", oidctest.User{Subject: "2", Email: "jane@example.com", EmailVerified: true, GivenName: "Jane"}, nil, "/user/profile", []string{data.AuditUserCreated, data.AuditIdentityLinked, data.AuditLogin}},
+		{"unverified email", oidctest.User{Subject: "3", Email: "admin@example.com"}, nil, "/", []string{data.AuditLoginFailed}},
+		{"forged state", oidctest.User{Subject: "1", Email: "admin@example.com", EmailVerified: true}, func(callback *url.URL) {
+			q := callback.Query()
+			q.Set("state", "forged")
+			callback.RawQuery = q.Encode()
+		}, "/", []string{data.AuditLoginFailed}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			app.DB = &dbrepo.MockDBRepo{}
+			routes := app.routes()
+			provider.Login(tt.user)
+
+			rr := httptest.NewRecorder()
+			routes.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login/oidc", nil))
+
+			if rr.Code != http.StatusFound {
+				t.Fatalf("expect a redirect to the provider; got %d", rr.Code)
+			}
+
+			callback, err := provider.Authorize(rr.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.tamper != nil {
+				tt.tamper(callback)
+			}
+
+			// the browser comes back with the session cookie
+			req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+			for _, c := range rr.Result().Cookies() {
+				req.AddCookie(c)
+			}
+
+			rr = httptest.NewRecorder()
+			routes.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != tt.expectedLoc {
+				t.Fatalf("expect a redirect to %s; got %d %s", tt.expectedLoc, rr.Code, rr.Header().Get("Location"))
+			}
+
+			events, _, _ := app.DB.AuditEvents(context.Background(), repository.AuditFilter{})
+			if len(events) != len(tt.expectedAudits) {
+				t.Fatalf("expect audit events %v; got %d", tt.expectedAudits, len(events))
+			}
+
+			// the audit log lists the newest event first
+			for i, action := range tt.expectedAudits {
+				if got := events[len(events)-1-i].Action; got != action {
+					t.Errorf("expect audit event %d to be %s; got %s", i, action, got)
+				}
+			}
+		})
+	}
+}
+
+func Test_application_oidcCallback_withoutLogin(t *testing.T) {
+
+	provider := oidctest.NewServer()
+	defer provider.Close()
+
+	oldOIDC := app.OIDC
+	defer func() { app.OIDC = oldOIDC }()
+
+	app.OIDC = oidc.New(provider.Config("https://app.example.com/login/oidc/callback"), provider.Client())
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login/oidc/callback?code=x&state=y", nil))
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/" {
+		t.Errorf("expect a redirect home; got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+}
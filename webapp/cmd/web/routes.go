@@ -1,25 +1,44 @@
 package main
 
 import (
+	"log/slog"
 	"net/http"
+	"webapp/pkg/health"
+	"webapp/pkg/logging"
+	"webapp/pkg/tracing"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 )
 
 func (app *application) routes() http.Handler {
 
 	mux := chi.NewRouter()
-	mux.Use(middleware.Recoverer)
-	mux.Use(app.addIpToContext)
-	mux.Use(app.Session.LoadAndSave)
+	mux.Use(tracing.Middleware)
+	mux.Use(logging.RequestID)
+	mux.Use(app.ClientIP.Middleware)
+	mux.Use(logging.AccessLog(slog.Default()))
+	mux.Use(logging.Recover)
+	mux.Use(app.Metrics.Middleware)
+	mux.Use(app.addLocaleToContext)
+	mux.Use(tracing.Stage("session", app.Session.LoadAndSave))
+	mux.Use(app.addUserToLog)
+
+	loginLimit := tracing.Stage("rateLimit", app.RateLimiter.Middleware("login", app.RateLimit.Login, app.rateLimitKey))
 
 	// routes
 	mux.Get("/", app.home)
-	mux.Post("/login", app.login)
+	mux.HandleFunc("/healthz", health.Live)
+	mux.Handle("/readyz", app.Health)
+	mux.With(loginLimit).Post("/login", app.login)
+	mux.Get("/lang/{lang}", app.setLanguage)
+
+	if app.OIDC != nil {
+		mux.With(loginLimit).Get("/login/oidc", app.oidcLogin)
+		mux.With(loginLimit).Get("/login/oidc/callback", app.oidcCallback)
+	}
 
 	mux.Route("/user", func(mux chi.Router) {
-		mux.Use(app.auth)
+		mux.Use(tracing.Stage("auth", app.auth))
 		mux.Get("/profile", app.profilePage)
 		mux.Post("/upload-profile-pic", app.uploadProfilePicture)
 	})
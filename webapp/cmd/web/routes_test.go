@@ -16,6 +16,9 @@ func Test_application_routes(t *testing.T) {
 	}{
 		{"/", "GET"},
 		{"/login", "POST"},
+		{"/lang/{lang}", "GET"},
+		{"/healthz", "GET"},
+		{"/readyz", "GET"},
 		{"/user/profile", "GET"},
 		{"/static/*", "GET"},
 	}
@@ -30,6 +33,11 @@ func Test_application_routes(t *testing.T) {
 		}
 	}
 
+	// the metrics are served on their own listener, see metrics_addr
+	if routeExists("/metrics", "GET", chiRoutes) {
+		t.Error("expect no GET /metrics in the public routes")
+	}
+
 }
 
 func routeExists(testRoute string, testMethod string, chiRoutes chi.Routes) bool {
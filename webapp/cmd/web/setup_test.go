@@ -1,18 +1,44 @@
 package main
 
 import (
+	"encoding/gob"
+	"log"
 	"os"
 	"testing"
+	"webapp/pkg/clientip"
+	"webapp/pkg/data"
+	"webapp/pkg/health"
+	"webapp/pkg/i18n"
+	"webapp/pkg/metrics"
+	"webapp/pkg/oidc"
+	"webapp/pkg/ratelimit"
 	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/validator"
 )
 
 var app application
 
 func TestMain(m *testing.M) {
-	templatePath = "./../../template/"
+	gob.Register(data.User{})
+	gob.Register(oidc.Flow{})
+
+	app.TemplatePath = "./../../template/"
 
 	app.Session = getSession()
 
+	tc, err := newTemplateCache(os.DirFS(app.TemplatePath))
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Templates = tc
+	app.Translations = i18n.Default()
+	app.Validator = validator.New()
+	app.Metrics = metrics.New()
+	app.Health = health.New(health.DefaultTimeout)
+	app.ClientIP, _ = clientip.New(defaultConfig().ClientIP)
+	app.RateLimit = defaultConfig().RateLimit
+	app.RateLimiter = ratelimit.New(ratelimit.NewMemoryStore())
+
 	app.DB = &dbrepo.MockDBRepo{}
 	app.Session = getSession()
 
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const layoutPattern = "*.layout.gohtml"
+
+// functions are available to every template in the cache
+var functions = template.FuncMap{
+	"humanDate":  humanDate,
+	"formatDate": formatDate,
+	"humanSize":  humanSize,
+	// t is replaced with the request's localizer when a page is rendered
+	"t": func(key string, args ...any) string { return key },
+}
+
+// humanDate returns a nicely formatted string of a time.Time; zero times render as an empty string
+func humanDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format("02 Jan 2006 at 15:04")
+}
+
+// formatDate formats t using a Go layout string, e.g. {{formatDate .User.CreatedAt "2006-01-02"}}
+func formatDate(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(layout)
+}
+
+// humanSize turns a number of bytes into a short readable string such as 1.5 MB
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// templateCache holds every page parsed together with the layouts. It is safe for concurrent
+// use so that it can be reloaded while requests are being served.
+type templateCache struct {
+	mu    sync.RWMutex
+	fsys  fs.FS
+	pages map[string]*template.Template
+}
+
+// newTemplateCache parses all pages found in fsys; any parse error is returned so that
+// the server refuses to start with broken templates
+func newTemplateCache(fsys fs.FS) (*templateCache, error) {
+	tc := &templateCache{fsys: fsys}
+
+	if err := tc.reload(); err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
+
+// get returns the parsed template for the page name, e.g. home.gohtml
+func (tc *templateCache) get(name string) (*template.Template, bool) {
+	tc.mu.RLock()
+	defer tc.mu.RUnlock()
+
+	t, ok := tc.pages[name]
+	return t, ok
+}
+
+// reload parses the pages again; the old cache is kept if parsing fails
+func (tc *templateCache) reload() error {
+	pages, err := fs.Glob(tc.fsys, "*.gohtml")
+	if err != nil {
+		return err
+	}
+
+	layouts, err := fs.Glob(tc.fsys, layoutPattern)
+	if err != nil {
+		return err
+	}
+
+	cache := map[string]*template.Template{}
+
+	for _, page := range pages {
+		if strings.HasSuffix(page, ".layout.gohtml") {
+			continue
+		}
+
+		files := append([]string{page}, layouts...)
+
+		t, err := template.New(page).Funcs(functions).ParseFS(tc.fsys, files...)
+		if err != nil {
+			return err
+		}
+
+		cache[page] = t
+	}
+
+	tc.mu.Lock()
+	tc.pages = cache
+	tc.mu.Unlock()
+
+	return nil
+}
+
+// watch polls dir every interval and reloads the cache when a template was added, removed or
+// modified. It is only meant for development and stops when ctx is cancelled.
+func (tc *templateCache) watch(ctx context.Context, dir string, interval time.Duration) {
+	last := latestModTime(dir)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modified := latestModTime(dir)
+			if modified.Equal(last) {
+				continue
+			}
+			last = modified
+
+			if err := tc.reload(); err != nil {
+				slog.Error("unable to reload templates", "error", err)
+				continue
+			}
+
+			slog.Info("templates reloaded")
+		}
+	}
+}
+
+// latestModTime returns the most recent modification time of the templates in dir. The
+// directory's own mod time is included so that deleted files are noticed too.
+func latestModTime(dir string) time.Time {
+	var latest time.Time
+
+	if info, err := os.Stat(dir); err == nil {
+		latest = info.ModTime()
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.gohtml"))
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_humanSize(t *testing.T) {
+
+	testCases := []struct {
+		size     int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{5 * 1024 * 1024, "5.0 MB"},
+	}
+
+	for _, tt := range testCases {
+		if got := humanSize(tt.size); got != tt.expected {
+			t.Errorf("humanSize(%d): expect %s; got %s", tt.size, tt.expected, got)
+		}
+	}
+}
+
+func Test_humanDate(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		tm       time.Time
+		expected string
+	}{
+		{"zero", time.Time{}, ""},
+		{"utc", time.Date(2022, 11, 28, 10, 3, 0, 0, time.UTC), "28 Nov 2022 at 10:03"},
+		{"cet", time.Date(2022, 11, 28, 10, 3, 0, 0, time.FixedZone("CET", 60*60)), "28 Nov 2022 at 09:03"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := humanDate(tt.tm); got != tt.expected {
+				t.Errorf("expect %q; got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func Test_templateCache(t *testing.T) {
+
+	tc, err := newTemplateCache(os.DirFS(app.TemplatePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, page := range []string{"home.gohtml", "profile.gohtml", "500.gohtml"} {
+		if _, ok := tc.get(page); !ok {
+			t.Errorf("expect %s to be in the cache", page)
+		}
+	}
+
+	if _, ok := tc.get("base.layout.gohtml"); ok {
+		t.Error("layouts should not be cached as pages")
+	}
+}
+
+func Test_templateCache_watch(t *testing.T) {
+
+	dir := t.TempDir()
+	layout := `{{define "base"}}{{block "content" .}}{{end}}{{end}}`
+	page := filepath.Join(dir, "page.gohtml")
+
+	_ = os.WriteFile(filepath.Join(dir, "base.layout.gohtml"), []byte(layout), 0644)
+	_ = os.WriteFile(page, []byte(`{{template "base" .}}{{define "content"}}v1{{end}}`), 0644)
+
+	tc, err := newTemplateCache(os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tc.watch(ctx, dir, 10*time.Millisecond)
+
+	// make sure the modification time changes, even on coarse file systems
+	time.Sleep(20 * time.Millisecond)
+	_ = os.WriteFile(filepath.Join(dir, "new.gohtml"), []byte(`{{template "base" .}}`), 0644)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(filepath.Join(dir, "new.gohtml"), future, future)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := tc.get("new.gohtml"); ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Error("expect new template to be picked up by watch()")
+}
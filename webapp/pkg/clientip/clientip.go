@@ -0,0 +1,248 @@
+// Package clientip resolves the IP address of the client behind trusted reverse proxies, from
+// X-Forwarded-For or RFC 7239 Forwarded, and keeps it in the request context.
+package clientip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Headers the trusted proxies may set.
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderForwarded     = "Forwarded"
+)
+
+// Unknown is the client IP of requests whose peer address can not be parsed.
+const Unknown = "unknown"
+
+// Config lists the proxies trusted to report the client IP. The tags are read by package config.
+type Config struct {
+	// TrustedProxies are CIDRs or addresses; an empty list trusts no proxy, and the client IP is
+	// the peer address of the connection.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES" flag:"trusted-proxies" usage:"comma separated CIDRs or addresses of the reverse proxies allowed to report the client IP, e.g. 10.0.0.0/8"`
+	// Header is the one the proxies set. Only one is read: a proxy appending to X-Forwarded-For
+	// passes a Forwarded header forged by the client as is, and the other way round.
+	Header string `yaml:"header" toml:"header" env:"CLIENT_IP_HEADER" flag:"client-ip-header" usage:"header the trusted proxies set: X-Forwarded-For or Forwarded"`
+}
+
+// DefaultConfig trusts no proxy.
+func DefaultConfig() Config {
+	return Config{Header: HeaderXForwardedFor}
+}
+
+// Validate checks the header and the proxies.
+func (c Config) Validate() error {
+	_, err := New(c)
+	return err
+}
+
+// Resolver resolves client IPs.
+type Resolver struct {
+	header  string
+	trusted []netip.Prefix
+}
+
+// New returns the resolver of c.
+func New(c Config) (*Resolver, error) {
+	r := &Resolver{header: http.CanonicalHeaderKey(c.Header)}
+
+	if r.header != HeaderXForwardedFor && r.header != HeaderForwarded {
+		return nil, fmt.Errorf("client_ip.header must be %s or %s; got %q", HeaderXForwardedFor, HeaderForwarded, c.Header)
+	}
+
+	var errs []error
+	for _, p := range c.TrustedProxies {
+		prefix, err := parsePrefix(p)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("client_ip.trusted_proxies: %q is not a CIDR or an IP address", p))
+			continue
+		}
+		r.trusted = append(r.trusted, prefix)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return r, nil
+}
+
+// parsePrefix parses a CIDR, or an address as the prefix holding only itself
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if p.Addr().Is4In6() {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil || addr.Zone() != "" {
+		return netip.Prefix{}, fmt.Errorf("invalid address %q", s)
+	}
+	addr = addr.Unmap()
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Resolve returns the client IP of req. Unless the peer is a trusted proxy, it is the client.
+// Otherwise the hops of the header are read right to left, each added by the proxy before it,
+// and the first one that is not a trusted proxy is the client. An invalid hop stops the walk at
+// the last trusted proxy, as nothing left of it can be believed.
+func (r *Resolver) Resolve(req *http.Request) string {
+	ip, ok := parseHop(req.RemoteAddr)
+	if !ok {
+		return Unknown
+	}
+
+	if !r.isTrusted(ip) {
+		return ip.String()
+	}
+
+	hops := r.hops(req)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+		if !ok {
+			break
+		}
+
+		ip = hop
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+
+	return ip.String()
+}
+
+func (r *Resolver) isTrusted(ip netip.Addr) bool {
+	for _, p := range r.trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hops returns the addresses in the header, left to right, across all the header lines
+func (r *Resolver) hops(req *http.Request) []string {
+	var hops []string
+
+	for _, line := range req.Header.Values(r.header) {
+		for _, element := range splitQuoted(line, ',') {
+			if r.header == HeaderXForwardedFor {
+				hops = append(hops, element)
+				continue
+			}
+
+			hops = append(hops, forwardedFor(element))
+		}
+	}
+
+	return hops
+}
+
+// forwardedFor returns the for parameter of a Forwarded element such as
+// for="[2001:db8::17]:4711";proto=https, or "" when there is none
+func forwardedFor(element string) string {
+	for _, pair := range splitQuoted(element, ';') {
+		name, value, ok := strings.Cut(pair, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "for") {
+			return strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+
+	return ""
+}
+
+// splitQuoted splits s at sep outside of quoted strings
+func splitQuoted(s string, sep byte) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			i++
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// parseHop parses an address with an optional port: 192.0.2.1, 192.0.2.1:80, 2001:db8::1 or
+// [2001:db8::1]:80. Obfuscated identifiers such as unknown or _hidden are not addresses.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		addrPort, err := netip.ParseAddrPort(s)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = addrPort.Addr()
+	}
+
+	if addr.Zone() != "" {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
+
+type contextKey struct{}
+
+// Middleware resolves the client IP of each request and keeps it in the request context.
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		next.ServeHTTP(w, req.WithContext(NewContext(req.Context(), r.Resolve(req))))
+	})
+}
+
+// NewContext returns a copy of ctx holding the client IP.
+func NewContext(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, contextKey{}, ip)
+}
+
+// FromContext returns the client IP resolved by Middleware.
+func FromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(contextKey{}).(string)
+	return ip, ok
+}
+
+// Get returns the client IP resolved by Middleware, or the peer address when the middleware did
+// not run.
+func Get(req *http.Request) string {
+	if ip, ok := FromContext(req.Context()); ok {
+		return ip
+	}
+
+	if ip, ok := parseHop(req.RemoteAddr); ok {
+		return ip.String()
+	}
+
+	return Unknown
+}
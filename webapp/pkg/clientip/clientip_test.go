@@ -0,0 +1,143 @@
+package clientip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+
+	xff, err := New(Config{Header: "x-forwarded-for", TrustedProxies: []string{"10.0.0.0/8", "2001:db8:ffff::/48", "192.0.2.10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forwarded, err := New(Config{Header: HeaderForwarded, TrustedProxies: []string{"10.0.0.0/8", "2001:db8:ffff::/48"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	none, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		resolver   *Resolver
+		remoteAddr string
+		header     string
+		values     []string
+		expected   string
+	}{
+		{"no proxy trusted", none, "203.0.113.7:4711", "X-Forwarded-For", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"untrusted peer", xff, "203.0.113.7:4711", "X-Forwarded-For", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted peer without header", xff, "10.0.0.1:4711", "", nil, "10.0.0.1"},
+		{"one proxy", xff, "10.0.0.1:4711", "X-Forwarded-For", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed left hops", xff, "10.0.0.1:4711", "X-Forwarded-For", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", xff, "10.0.0.1:4711", "X-Forwarded-For", []string{"1.2.3.4, 198.51.100.1, 10.1.1.1, 192.0.2.10"}, "198.51.100.1"},
+		{"several header lines", xff, "10.0.0.1:4711", "X-Forwarded-For", []string{"1.2.3.4, 198.51.100.1", "10.1.1.1"}, "198.51.100.1"},
+		{"all hops trusted", xff, "10.0.0.1:4711", "X-Forwarded-For", []string{"10.2.2.2, 10.1.1.1"}, "10.2.2.2"},
+		{"invalid hop", xff, "10.0.0.1:4711", "X-Forwarded-For", []string{"198.51.100.1, not-an-ip, 10.1.1.1"}, "10.1.1.1"},
+		{"hop with port", xff, "10.0.0.1:4711", "X-Forwarded-For", []string{"198.51.100.1:1234"}, "198.51.100.1"},
+		{"ipv6 hop", xff, "[2001:db8:ffff::1]:4711", "X-Forwarded-For", []string{"2001:db8:cafe::17"}, "2001:db8:cafe::17"},
+		{"ipv4 mapped hop", xff, "10.0.0.1:4711", "X-Forwarded-For", []string{"::ffff:198.51.100.1"}, "198.51.100.1"},
+		{"ipv4 mapped peer", xff, "[::ffff:10.0.0.1]:4711", "X-Forwarded-For", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"misspelled header is ignored", xff, "10.0.0.1:4711", "X-Forwaded-For", []string{"198.51.100.1"}, "10.0.0.1"},
+		{"forwarded is ignored in xff mode", xff, "10.0.0.1:4711", "Forwarded", []string{"for=198.51.100.1"}, "10.0.0.1"},
+		{"forwarded", forwarded, "10.0.0.1:4711", "Forwarded", []string{"for=198.51.100.1;proto=https;by=10.0.0.1"}, "198.51.100.1"},
+		{"forwarded ipv6 with port", forwarded, "10.0.0.1:4711", "Forwarded", []string{`for="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"forwarded chain", forwarded, "10.0.0.1:4711", "Forwarded", []string{"for=1.2.3.4, For=198.51.100.1;proto=http, for=10.1.1.1"}, "198.51.100.1"},
+		{"forwarded obfuscated", forwarded, "10.0.0.1:4711", "Forwarded", []string{"for=198.51.100.1, for=_hidden"}, "10.0.0.1"},
+		{"forwarded unknown", forwarded, "10.0.0.1:4711", "Forwarded", []string{"for=unknown"}, "10.0.0.1"},
+		{"forwarded without for", forwarded, "10.0.0.1:4711", "Forwarded", []string{"proto=https"}, "10.0.0.1"},
+		{"forwarded quoted comma", forwarded, "10.0.0.1:4711", "Forwarded", []string{`for=198.51.100.1;host="a,b"`}, "198.51.100.1"},
+		{"xff is ignored in forwarded mode", forwarded, "10.0.0.1:4711", "X-Forwarded-For", []string{"198.51.100.1"}, "10.0.0.1"},
+		{"peer without port", none, "203.0.113.7", "", nil, "203.0.113.7"},
+		{"invalid peer", none, "hello:world", "", nil, Unknown},
+		{"empty peer", none, "", "", nil, Unknown},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.values {
+				req.Header.Add(tt.header, v)
+			}
+
+			if got := tt.resolver.Resolve(req); got != tt.expected {
+				t.Errorf("expect client IP %s; got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+
+	testCases := []struct {
+		name      string
+		cfg       Config
+		expectErr bool
+	}{
+		{"default", DefaultConfig(), false},
+		{"forwarded", Config{Header: "forwarded"}, false},
+		{"cidrs and addresses", Config{Header: HeaderXForwardedFor, TrustedProxies: []string{"10.0.0.0/8", " 192.0.2.1", "::1", "fd00::/8"}}, false},
+		{"unknown header", Config{Header: "X-Real-IP"}, true},
+		{"empty header", Config{}, true},
+		{"invalid cidr", Config{Header: HeaderXForwardedFor, TrustedProxies: []string{"10.0.0.0/33"}}, true},
+		{"host name", Config{Header: HeaderXForwardedFor, TrustedProxies: []string{"proxy.local"}}, true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("expect an error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("expect no error; got %s", err)
+			}
+		})
+	}
+}
+
+func TestResolver_Middleware(t *testing.T) {
+
+	r, err := New(Config{Header: HeaderXForwardedFor, TrustedProxies: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:4711"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ip, ok := FromContext(req.Context()); !ok || ip != "198.51.100.1" {
+			t.Errorf("expect the client IP in the context; got %q", ip)
+		}
+
+		if got := Get(req); got != "198.51.100.1" {
+			t.Errorf("expect Get to return the resolved IP; got %s", got)
+		}
+	})).ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestGet(t *testing.T) {
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:4711"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	if got := Get(req); got != "203.0.113.7" {
+		t.Errorf("expect the peer address without the middleware; got %s", got)
+	}
+
+	req = req.WithContext(NewContext(context.Background(), "192.0.2.1"))
+	if got := Get(req); got != "192.0.2.1" {
+		t.Errorf("expect the address of the context; got %s", got)
+	}
+}
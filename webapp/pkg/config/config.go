@@ -0,0 +1,292 @@
+// Package config loads the settings of the applications. Every setting has a default, which can
+// be overridden by a YAML or TOML file, then by environment variables and finally by command
+// line flags.
+//
+// Settings are fields of a struct, described by tags:
+//
+//	yaml, toml  the key in the config file
+//	env         the environment variable, without the WEBAPP_ prefix
+//	flag        the command line flag
+//	usage       the help text of the flag
+//	redact      "true" to hide the value when printing, "dsn" to only hide the password
+//
+// Nested structs are sections of the config file; their environment variables and flags are not
+// prefixed. Lists are given as comma separated values in environment variables and flags.
+package config
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes the environment variables of every setting.
+const EnvPrefix = "WEBAPP_"
+
+// FileEnv names the config file when the -config flag is not given.
+const FileEnv = EnvPrefix + "CONFIG"
+
+// Validator is implemented by settings that can check themselves once loaded.
+type Validator interface {
+	Validate() error
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	stringsType         = reflect.TypeOf([]string(nil))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Load fills the struct cfg points to, which holds the defaults, from the config file, the
+// environment and args, in that order of precedence. Flags are registered on fs, which may
+// already hold flags of its own, and a -config flag naming the file is added. When cfg is a
+// Validator, the loaded settings are validated.
+func Load(cfg any, fs *flag.FlagSet, args []string) error {
+	rv := reflect.ValueOf(cfg)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: expected a pointer to a struct; got %T", cfg)
+	}
+
+	file := fs.String("config", os.Getenv(FileEnv), "YAML or TOML file with the settings, also read from "+FileEnv)
+
+	if err := registerFlags(fs, rv.Elem()); err != nil {
+		return err
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// the flags given on the command line win, so remember them before the file and the
+	// environment overwrite the fields they are bound to
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if *file != "" {
+		if err := loadFile(*file, cfg); err != nil {
+			return err
+		}
+	}
+
+	if err := loadEnv(rv.Elem()); err != nil {
+		return err
+	}
+
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config: -%s: %w", name, err)
+		}
+	}
+
+	if v, ok := cfg.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// loadFile decodes the YAML or TOML file at path into cfg; unknown keys are rejected, as they
+// are most likely typos
+func loadFile(path string, cfg any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(strings.NewReader(string(b)))
+		dec.KnownFields(true)
+
+		// an empty file is a valid, if useless, config
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config: %s: %w", path, err)
+		}
+
+	case ".toml":
+		md, err := toml.Decode(string(b), cfg)
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", path, err)
+		}
+
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			return fmt.Errorf("config: %s: unknown keys %s", path, strings.Join(keys, ", "))
+		}
+
+	default:
+		return fmt.Errorf("config: %s: use a .yaml, .yml or .toml file", path)
+	}
+
+	return nil
+}
+
+// loadEnv sets the fields with an env tag whose variable is set
+func loadEnv(v reflect.Value) error {
+	return walk(v, func(field reflect.Value, sf reflect.StructField) error {
+		name, ok := sf.Tag.Lookup("env")
+		if !ok {
+			return nil
+		}
+
+		value, ok := os.LookupEnv(EnvPrefix + name)
+		if !ok {
+			return nil
+		}
+
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("config: %s%s: %w", EnvPrefix, name, err)
+		}
+
+		return nil
+	})
+}
+
+// registerFlags adds a flag for every field with a flag tag, defaulting to its current value
+func registerFlags(fs *flag.FlagSet, v reflect.Value) error {
+	return walk(v, func(field reflect.Value, sf reflect.StructField) error {
+		name, ok := sf.Tag.Lookup("flag")
+		if !ok {
+			return nil
+		}
+
+		usage := sf.Tag.Get("usage")
+		ptr := field.Addr().Interface()
+
+		switch {
+		case field.Type() == durationType:
+			fs.DurationVar(ptr.(*time.Duration), name, field.Interface().(time.Duration), usage)
+		case reflect.PointerTo(field.Type()).Implements(textUnmarshalerType):
+			marshaler, ok := field.Interface().(encoding.TextMarshaler)
+			if !ok {
+				return fmt.Errorf("config: %s can not be printed", sf.Name)
+			}
+			fs.TextVar(ptr.(encoding.TextUnmarshaler), name, marshaler, usage)
+		case field.Kind() == reflect.String:
+			fs.StringVar(ptr.(*string), name, field.String(), usage)
+		case field.Kind() == reflect.Bool:
+			fs.BoolVar(ptr.(*bool), name, field.Bool(), usage)
+		case field.Kind() == reflect.Int:
+			fs.IntVar(ptr.(*int), name, int(field.Int()), usage)
+		case field.Type() == stringsType:
+			fs.Var((*listValue)(ptr.(*[]string)), name, usage)
+		default:
+			return fmt.Errorf("config: unsupported type %s for flag -%s", field.Type(), name)
+		}
+
+		return nil
+	})
+}
+
+// setField parses value into field
+func setField(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+
+	case reflect.PointerTo(field.Type()).Implements(textUnmarshalerType):
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+
+	case field.Type() == stringsType:
+		field.Set(reflect.ValueOf(splitList(value)))
+
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+
+	return nil
+}
+
+// walk calls fn for every exported field of the struct v, descending into nested structs
+func walk(v reflect.Value, fn func(field reflect.Value, sf reflect.StructField) error) error {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		field := v.Field(i)
+
+		if field.Kind() == reflect.Struct && !reflect.PointerTo(field.Type()).Implements(textUnmarshalerType) {
+			if err := walk(field, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := fn(field, sf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listValue is a flag holding a comma separated list
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = splitList(value)
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(value string) []string {
+	list := []string{}
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces secrets in the printed config.
+const Redacted = "[redacted]"
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Print writes the settings in cfg, a pointer to a struct, to w as YAML, with the secrets
+// redacted, so that the output can be logged or used as a config file template.
+func Print(w io.Writer, cfg any) error {
+	rv := reflect.ValueOf(cfg)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: expected a pointer to a struct; got %T", cfg)
+	}
+
+	// redact a copy, the caller keeps its secrets
+	clone := reflect.New(rv.Elem().Type())
+	clone.Elem().Set(rv.Elem())
+
+	err := walk(clone.Elem(), func(field reflect.Value, sf reflect.StructField) error {
+		if field.Kind() != reflect.String || field.String() == "" {
+			return nil
+		}
+
+		switch sf.Tag.Get("redact") {
+		case "true":
+			field.SetString(Redacted)
+		case "dsn":
+			field.SetString(RedactDSN(field.String()))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(clone.Interface()); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+// RedactDSN hides the password of a postgres connection string, in URL or key=value form.
+func RedactDSN(dsn string) string {
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return Redacted
+		}
+		return u.Redacted()
+	}
+
+	return dsnPassword.ReplaceAllString(dsn, "${1}xxxxx")
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+	"webapp/pkg/clientip"
+	"webapp/pkg/server"
+	"webapp/pkg/tracing"
+)
+
+// DefaultDSN connects to the postgres of docker-compose.yml.
+const DefaultDSN = "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5"
+
+// DefaultJWTSecret is only good for development; Auth.Validate rejects it outside dev mode.
+const DefaultJWTSecret = "b2xlIjoiQWRtaW4iLCJJc3N1ZXIiOiJJc3N1ZXIiLCJVc2VybmFtZSI6IkphdmFJblVzZSIsImV4cCI6MTY2OTY4MjE1NiwiaWF0IjoxNjY5NjgyMTU2fQ"
+
+// minJWTSecretLength is the shortest secret accepted outside dev mode, 256 bits for HS256
+const minJWTSecretLength = 32
+
+// maxJWTLeeway is the largest clock skew tolerated on the times of the tokens
+const maxJWTLeeway = 5 * time.Minute
+
+// Base holds the settings shared by the web and api applications.
+type Base struct {
+	Dev      bool       `yaml:"dev" toml:"dev" env:"DEV" flag:"dev" usage:"development mode: relaxed checks, and templates read from disk and reloaded on change"`
+	LogLevel slog.Level `yaml:"log_level" toml:"log_level" env:"LOG_LEVEL" flag:"log-level" usage:"minimum level of the logs: debug, info, warn or error"`
+	DSN      string     `yaml:"dsn" toml:"dsn" env:"DSN" flag:"dsn" usage:"postgres connection string" redact:"dsn"`
+	// MetricsAddr serves /metrics apart from the public routes of HTTP.Addr
+	MetricsAddr string `yaml:"metrics_addr" toml:"metrics_addr" env:"METRICS_ADDR" flag:"metrics-addr" usage:"address serving the Prometheus metrics, which must not be public, e.g. localhost:9090; empty disables it"`
+
+	HTTP     server.Config   `yaml:"http" toml:"http"`
+	ClientIP clientip.Config `yaml:"client_ip" toml:"client_ip"`
+	Tracing  tracing.Config  `yaml:"tracing" toml:"tracing"`
+}
+
+// NewBase returns the defaults of the service listening on addr, and serving its metrics on
+// metricsAddr.
+func NewBase(service, addr, metricsAddr string) Base {
+	return Base{
+		LogLevel:    slog.LevelInfo,
+		DSN:         DefaultDSN,
+		MetricsAddr: metricsAddr,
+		HTTP:        server.DefaultConfig(addr),
+		ClientIP:    clientip.DefaultConfig(),
+		Tracing:     tracing.Config{ServiceName: service, Exporter: tracing.ExporterNone},
+	}
+}
+
+// Validate checks the shared settings.
+func (b *Base) Validate() error {
+	var errs []error
+
+	if b.DSN == "" {
+		errs = append(errs, errors.New("dsn is required"))
+	}
+
+	if b.HTTP.Addr == "" {
+		errs = append(errs, errors.New("http.addr is required"))
+	}
+
+	if b.MetricsAddr != "" && (b.MetricsAddr == b.HTTP.Addr || b.MetricsAddr == b.HTTP.TLS.RedirectAddr) {
+		errs = append(errs, errors.New("metrics_addr must differ from http.addr and http.tls.redirect_addr"))
+	}
+
+	if b.HTTP.ReadHeaderTimeout <= 0 || b.HTTP.ReadTimeout <= 0 || b.HTTP.WriteTimeout <= 0 || b.HTTP.IdleTimeout <= 0 {
+		errs = append(errs, errors.New("http timeouts must be positive"))
+	}
+
+	if b.HTTP.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("http.shutdown_timeout must be positive"))
+	}
+
+	if b.HTTP.MaxHeaderBytes <= 0 {
+		errs = append(errs, errors.New("http.max_header_bytes must be positive"))
+	}
+
+	errs = append(errs, b.HTTP.TLS.Validate())
+
+	if b.HTTP.TLS.Enabled() && b.HTTP.TLS.RedirectAddr == b.HTTP.Addr {
+		errs = append(errs, errors.New("http.tls.redirect_addr must differ from http.addr"))
+	}
+
+	errs = append(errs, b.ClientIP.Validate())
+
+	switch b.Tracing.Exporter {
+	case "", tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be %s, %s or %s; got %q", tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP, b.Tracing.Exporter))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Auth holds the settings of the JWTs issued by the api.
+type Auth struct {
+	Domain    string        `yaml:"domain" toml:"domain" env:"DOMAIN" flag:"domain" usage:"domain of the application, the issuer and audience of the tokens, e.g. example.com"`
+	JWTSecret string        `yaml:"jwt_secret" toml:"jwt_secret" env:"JWT_SECRET" flag:"jwt-secret" usage:"secret signing the tokens" redact:"true"`
+	JWTLeeway time.Duration `yaml:"jwt_leeway" toml:"jwt_leeway" env:"JWT_LEEWAY" flag:"jwt-leeway" usage:"clock skew tolerated on the exp, nbf and iat of the tokens, e.g. 30s"`
+}
+
+// NewAuth returns the development defaults.
+func NewAuth() Auth {
+	return Auth{Domain: "example.com", JWTSecret: DefaultJWTSecret, JWTLeeway: 30 * time.Second}
+}
+
+// Validate checks the token settings; the default secret and short secrets are only accepted in
+// dev mode.
+func (a *Auth) Validate(dev bool) error {
+	var errs []error
+
+	if a.Domain == "" {
+		errs = append(errs, errors.New("domain is required"))
+	}
+
+	switch {
+	case a.JWTSecret == "":
+		errs = append(errs, errors.New("jwt_secret is required"))
+	case dev:
+	case a.JWTSecret == DefaultJWTSecret:
+		errs = append(errs, errors.New("the default jwt_secret may only be used in dev mode"))
+	case len(a.JWTSecret) < minJWTSecretLength:
+		errs = append(errs, fmt.Errorf("jwt_secret must have at least %d characters", minJWTSecretLength))
+	}
+
+	if a.JWTLeeway < 0 || a.JWTLeeway > maxJWTLeeway {
+		errs = append(errs, fmt.Errorf("jwt_leeway must be between 0 and %s; got %s", maxJWTLeeway, a.JWTLeeway))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,357 @@
+// Package cors implements Cross-Origin Resource Sharing: it answers preflight requests and adds
+// the CORS headers to the responses for the origins a policy allows.
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is a CORS policy. The tags are read by package config.
+type Config struct {
+	// AllowedOrigins lists origins such as https://app.example.com. An origin may start with a
+	// wildcard subdomain, https://*.example.com, which matches any subdomain but not the domain
+	// itself; * allows every origin, but not with AllowCredentials.
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins" env:"CORS_ORIGINS" flag:"cors-origins" usage:"comma separated origins allowed to call from a browser, e.g. https://*.example.com"`
+	AllowedMethods []string `yaml:"allowed_methods" toml:"allowed_methods" env:"CORS_METHODS" flag:"cors-methods" usage:"comma separated methods allowed in cross-origin requests"`
+	// AllowedHeaders lists the request headers browsers may send; * allows any header.
+	AllowedHeaders []string `yaml:"allowed_headers" toml:"allowed_headers" env:"CORS_HEADERS" flag:"cors-headers" usage:"comma separated request headers allowed in cross-origin requests"`
+	// ExposedHeaders lists the response headers scripts may read.
+	ExposedHeaders   []string      `yaml:"exposed_headers" toml:"exposed_headers" env:"CORS_EXPOSED_HEADERS" flag:"cors-exposed-headers" usage:"comma separated response headers readable by scripts"`
+	AllowCredentials bool          `yaml:"allow_credentials" toml:"allow_credentials" env:"CORS_CREDENTIALS" flag:"cors-credentials" usage:"allow cookies in cross-origin requests"`
+	MaxAge           time.Duration `yaml:"max_age" toml:"max_age" env:"CORS_MAX_AGE" flag:"cors-max-age" usage:"how long browsers may cache a preflight response"`
+
+	// Routes override the policy for the paths starting with their prefix; the longest prefix
+	// wins. They can only be set in the config file.
+	Routes []Route `yaml:"routes" toml:"routes"`
+}
+
+// Route overrides the fields of the policy it sets for the requests under Path.
+type Route struct {
+	Path             string        `yaml:"path" toml:"path"`
+	AllowedOrigins   []string      `yaml:"allowed_origins,omitempty" toml:"allowed_origins,omitempty"`
+	AllowedMethods   []string      `yaml:"allowed_methods,omitempty" toml:"allowed_methods,omitempty"`
+	AllowedHeaders   []string      `yaml:"allowed_headers,omitempty" toml:"allowed_headers,omitempty"`
+	ExposedHeaders   []string      `yaml:"exposed_headers,omitempty" toml:"exposed_headers,omitempty"`
+	AllowCredentials *bool         `yaml:"allow_credentials,omitempty" toml:"allow_credentials,omitempty"`
+	MaxAge           time.Duration `yaml:"max_age,omitempty" toml:"max_age,omitempty"`
+}
+
+// apply returns base with the fields set in r replaced
+func (r Route) apply(base Config) Config {
+	c := base
+	c.Routes = nil
+
+	if r.AllowedOrigins != nil {
+		c.AllowedOrigins = r.AllowedOrigins
+	}
+	if r.AllowedMethods != nil {
+		c.AllowedMethods = r.AllowedMethods
+	}
+	if r.AllowedHeaders != nil {
+		c.AllowedHeaders = r.AllowedHeaders
+	}
+	if r.ExposedHeaders != nil {
+		c.ExposedHeaders = r.ExposedHeaders
+	}
+	if r.AllowCredentials != nil {
+		c.AllowCredentials = *r.AllowCredentials
+	}
+	if r.MaxAge != 0 {
+		c.MaxAge = r.MaxAge
+	}
+
+	return c
+}
+
+// Validate checks the origin patterns of the policy and of its routes.
+func (c Config) Validate() error {
+	_, err := New(c)
+	return err
+}
+
+// CORS applies a policy, with its route overrides, to requests.
+type CORS struct {
+	policy *policy
+	// routes are sorted by decreasing prefix length, so that the first match is the longest
+	routes []route
+}
+
+type route struct {
+	prefix string
+	policy *policy
+}
+
+// New compiles the policy c.
+func New(c Config) (*CORS, error) {
+	p, err := compile(c)
+	if err != nil {
+		return nil, err
+	}
+
+	cors := &CORS{policy: p}
+
+	for _, r := range c.Routes {
+		if !strings.HasPrefix(r.Path, "/") {
+			return nil, fmt.Errorf("cors: route path %q must start with /", r.Path)
+		}
+
+		rp, err := compile(r.apply(c))
+		if err != nil {
+			return nil, fmt.Errorf("%w (route %s)", err, r.Path)
+		}
+
+		cors.routes = append(cors.routes, route{prefix: r.Path, policy: rp})
+	}
+
+	sort.SliceStable(cors.routes, func(i, j int) bool {
+		return len(cors.routes[i].prefix) > len(cors.routes[j].prefix)
+	})
+
+	return cors, nil
+}
+
+// Handler answers preflight requests and adds the CORS headers to the other responses. It must
+// run before the router, which would otherwise refuse OPTIONS requests to most routes.
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := c.policyFor(r.URL.Path)
+
+		// the response depends on the origin, so caches must not share it across origins
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			p.preflight(w, r, origin)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if p.allowsOrigin(origin) {
+			p.setOrigin(w, origin)
+			if len(p.exposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.exposedHeaders, ", "))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// policyFor returns the policy of the longest route prefix matching path
+func (c *CORS) policyFor(path string) *policy {
+	for _, r := range c.routes {
+		if path == r.prefix || strings.HasPrefix(path, strings.TrimSuffix(r.prefix, "/")+"/") {
+			return r.policy
+		}
+	}
+
+	return c.policy
+}
+
+// policy is a compiled Config
+type policy struct {
+	anyOrigin        bool
+	origins          map[string]bool
+	wildcards        []wildcard
+	methods          []string
+	anyHeader        bool
+	headers          map[string]bool
+	exposedHeaders   []string
+	allowCredentials bool
+	maxAge           time.Duration
+}
+
+// wildcard matches the subdomains of an origin, e.g. https://*.example.com
+type wildcard struct {
+	prefix string // scheme://
+	suffix string // .example.com[:port]
+}
+
+func (w wildcard) matches(origin string) bool {
+	if len(origin) <= len(w.prefix)+len(w.suffix) {
+		return false
+	}
+
+	if !strings.HasPrefix(origin, w.prefix) || !strings.HasSuffix(origin, w.suffix) {
+		return false
+	}
+
+	sub := origin[len(w.prefix) : len(origin)-len(w.suffix)]
+	return !strings.ContainsAny(sub, "/:@")
+}
+
+func compile(c Config) (*policy, error) {
+	p := &policy{
+		origins:          map[string]bool{},
+		headers:          map[string]bool{},
+		exposedHeaders:   trimmed(c.ExposedHeaders),
+		allowCredentials: c.AllowCredentials,
+		maxAge:           c.MaxAge,
+	}
+
+	if c.MaxAge < 0 {
+		return nil, errors.New("cors: max_age can not be negative")
+	}
+
+	for _, o := range c.AllowedOrigins {
+		o = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(o), "/"))
+
+		switch {
+		case o == "*":
+			p.anyOrigin = true
+
+		case strings.Contains(o, "*"):
+			scheme, host, ok := strings.Cut(o, "://")
+			if !ok || !strings.HasPrefix(host, "*.") || strings.Count(o, "*") != 1 {
+				return nil, fmt.Errorf("cors: invalid origin pattern %q, use e.g. https://*.example.com", o)
+			}
+			p.wildcards = append(p.wildcards, wildcard{prefix: scheme + "://", suffix: host[1:]})
+
+		default:
+			u, err := url.Parse(o)
+			if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+				return nil, fmt.Errorf("cors: invalid origin %q, use e.g. https://app.example.com", o)
+			}
+			p.origins[o] = true
+		}
+	}
+
+	// echoing every origin with credentials would let any site act with the user's cookies
+	if p.anyOrigin && p.allowCredentials {
+		return nil, errors.New("cors: the * origin can not be combined with allow_credentials, list the origins instead")
+	}
+
+	for _, m := range c.AllowedMethods {
+		p.methods = append(p.methods, strings.ToUpper(strings.TrimSpace(m)))
+	}
+
+	for _, h := range c.AllowedHeaders {
+		if h == "*" {
+			p.anyHeader = true
+			continue
+		}
+		p.headers[http.CanonicalHeaderKey(strings.TrimSpace(h))] = true
+	}
+
+	return p, nil
+}
+
+// allowsOrigin tells whether origin may use the resource
+func (p *policy) allowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+
+	for _, w := range p.wildcards {
+		if w.matches(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setOrigin allows origin in the response. Policies allowing any origin never allow
+// credentials, so they send the wildcard.
+func (p *policy) setOrigin(w http.ResponseWriter, origin string) {
+	if p.anyOrigin {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if p.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight allows the actual request described by the preflight r, if the policy permits it.
+// A refused preflight gets no CORS headers, which browsers report as a CORS error.
+func (p *policy) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	if !p.allowsOrigin(origin) {
+		return
+	}
+
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if !p.allowsMethod(method) {
+		return
+	}
+
+	requested := requestedHeaders(r)
+	for _, h := range requested {
+		if !p.anyHeader && !p.headers[h] {
+			return
+		}
+	}
+
+	p.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.methods, ", "))
+
+	if len(requested) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+
+	if p.maxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge.Seconds())))
+	}
+}
+
+// allowsMethod tells whether method may be used; simple methods are always allowed
+func (p *policy) allowsMethod(method string) bool {
+	if method == http.MethodGet || method == http.MethodHead || method == http.MethodPost {
+		return true
+	}
+
+	for _, m := range p.methods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestedHeaders returns the canonical names of the headers in Access-Control-Request-Headers
+func requestedHeaders(r *http.Request) []string {
+	var headers []string
+
+	for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range trimmed(strings.Split(value, ",")) {
+			headers = append(headers, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	return headers
+}
+
+// trimmed returns names without surrounding spaces, dropping empty ones
+func trimmed(names []string) []string {
+	var out []string
+
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			out = append(out, name)
+		}
+	}
+
+	return out
+}
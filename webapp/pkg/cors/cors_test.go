@@ -0,0 +1,201 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testConfig() Config {
+	credentials := true
+
+	return Config{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org", "http://localhost:8080"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		ExposedHeaders: []string{"X-Request-ID"},
+		MaxAge:         10 * time.Minute,
+		Routes: []Route{
+			{Path: "/web", AllowCredentials: &credentials},
+			{Path: "/public", AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}},
+		},
+	}
+}
+
+func TestCORS_preflight(t *testing.T) {
+
+	c, err := New(testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name              string
+		path              string
+		origin            string
+		method            string
+		headers           string
+		expectOrigin      string
+		expectHeaders     string
+		expectCredentials string
+	}{
+		{"exact origin", "/users", "https://app.example.com", "PUT", "authorization, content-type", "https://app.example.com", "Authorization, Content-Type", ""},
+		{"origin case", "/users", "HTTPS://APP.EXAMPLE.COM", "GET", "", "HTTPS://APP.EXAMPLE.COM", "", ""},
+		{"wildcard subdomain", "/users", "https://api.eu.example.org", "DELETE", "", "https://api.eu.example.org", "", ""},
+		{"wildcard does not match the domain", "/users", "https://example.org", "GET", "", "", "", ""},
+		{"wildcard does not match another scheme", "/users", "http://api.example.org", "GET", "", "", "", ""},
+		{"wildcard does not match a suffix", "/users", "https://evil-example.org", "GET", "", "", "", ""},
+		{"unknown origin", "/users", "https://evil.com", "GET", "", "", "", ""},
+		{"origin with another port", "/users", "http://localhost:9090", "GET", "", "", "", ""},
+		{"method not allowed", "/users", "https://app.example.com", "PATCH", "", "", "", ""},
+		{"simple method always allowed", "/users", "https://app.example.com", "HEAD", "", "https://app.example.com", "", ""},
+		{"header not allowed", "/users", "https://app.example.com", "GET", "Authorization, X-Secret", "", "", ""},
+		{"route with credentials", "/web/auth", "https://app.example.com", "POST", "Content-Type", "https://app.example.com", "Content-Type", "true"},
+		{"route prefix is a path segment", "/website", "https://app.example.com", "POST", "", "https://app.example.com", "", ""},
+		{"route with any origin", "/public/img", "https://anyone.net", "GET", "X-Anything", "*", "X-Anything", ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			if tt.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+			rr := httptest.NewRecorder()
+
+			c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Error("expect the preflight not to reach the handler")
+			})).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusNoContent {
+				t.Errorf("expect status 204; got %d", rr.Code)
+			}
+
+			h := rr.Header()
+
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.expectOrigin {
+				t.Errorf("expect allowed origin %q; got %q", tt.expectOrigin, got)
+			}
+
+			if got := h.Get("Access-Control-Allow-Headers"); got != tt.expectHeaders {
+				t.Errorf("expect allowed headers %q; got %q", tt.expectHeaders, got)
+			}
+
+			if got := h.Get("Access-Control-Allow-Credentials"); got != tt.expectCredentials {
+				t.Errorf("expect allow credentials %q; got %q", tt.expectCredentials, got)
+			}
+
+			if tt.expectOrigin != "" {
+				if got := h.Get("Access-Control-Allow-Methods"); got != "GET, POST, PUT, DELETE" {
+					t.Errorf("expect the allowed methods; got %q", got)
+				}
+				if got := h.Get("Access-Control-Max-Age"); got != "600" {
+					t.Errorf("expect max age 600; got %q", got)
+				}
+			} else if h.Get("Access-Control-Allow-Methods") != "" || h.Get("Access-Control-Max-Age") != "" {
+				t.Error("expect no CORS headers for a refused preflight")
+			}
+
+			vary := strings.Join(h.Values("Vary"), ", ")
+			for _, v := range []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"} {
+				if !strings.Contains(vary, v) {
+					t.Errorf("expect Vary to contain %s; got %q", v, vary)
+				}
+			}
+		})
+	}
+}
+
+func TestCORS_actualRequest(t *testing.T) {
+
+	c, err := New(testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name          string
+		method        string
+		path          string
+		origin        string
+		expectOrigin  string
+		expectExposed string
+	}{
+		{"allowed origin", http.MethodGet, "/users", "https://app.example.com", "https://app.example.com", "X-Request-ID"},
+		{"refused origin", http.MethodGet, "/users", "https://evil.com", "", ""},
+		{"no origin", http.MethodGet, "/users", "", "", ""},
+		{"options without preflight", http.MethodOptions, "/users", "https://app.example.com", "https://app.example.com", "X-Request-ID"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rr := httptest.NewRecorder()
+
+			reached := false
+			c.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			})).ServeHTTP(rr, req)
+
+			if !reached {
+				t.Error("expect the request to reach the handler")
+			}
+
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.expectOrigin {
+				t.Errorf("expect allowed origin %q; got %q", tt.expectOrigin, got)
+			}
+
+			if got := rr.Header().Get("Access-Control-Expose-Headers"); got != tt.expectExposed {
+				t.Errorf("expect exposed headers %q; got %q", tt.expectExposed, got)
+			}
+
+			if got := rr.Header().Get("Vary"); got != "Origin" {
+				t.Errorf("expect Vary: Origin; got %q", got)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+
+	yes, no := true, false
+
+	testCases := []struct {
+		name      string
+		cfg       Config
+		expectErr bool
+	}{
+		{"valid", testConfig(), false},
+		{"empty", Config{}, false},
+		{"origin without scheme", Config{AllowedOrigins: []string{"example.com"}}, true},
+		{"origin with path", Config{AllowedOrigins: []string{"https://example.com/app"}}, true},
+		{"wildcard in the middle", Config{AllowedOrigins: []string{"https://app.*.example.com"}}, true},
+		{"wildcard without scheme", Config{AllowedOrigins: []string{"*.example.com"}}, true},
+		{"negative max age", Config{MaxAge: -time.Second}, true},
+		{"route without slash", Config{Routes: []Route{{Path: "web"}}}, true},
+		{"invalid route origin", Config{Routes: []Route{{Path: "/web", AllowedOrigins: []string{"https://*"}}}}, true},
+		{"any origin with credentials", Config{AllowedOrigins: []string{"*"}, AllowCredentials: true}, true},
+		{"route with credentials for any origin", Config{AllowedOrigins: []string{"*"}, Routes: []Route{{Path: "/web", AllowCredentials: &yes}}}, true},
+		{"route with any origin and credentials", Config{AllowCredentials: true, Routes: []Route{{Path: "/web", AllowedOrigins: []string{"*"}}}}, true},
+		{"route without credentials for any origin", Config{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true, Routes: []Route{{Path: "/public", AllowedOrigins: []string{"*"}, AllowCredentials: &no}}}, false},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("expect an error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("expect no error; got %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package data
+
+import "time"
+
+// Actions recorded in the audit log.
+const (
+	AuditLogin           = "auth.login"
+	AuditLoginFailed     = "auth.login_failed"
+	AuditTokenRefreshed  = "auth.token_refreshed"
+	AuditUserCreated     = "user.created"
+	AuditUserUpdated     = "user.updated"
+	AuditUserDeleted     = "user.deleted"
+	AuditUserRestored    = "user.restored"
+	AuditPictureUploaded = "user.picture_uploaded"
+	AuditPasswordChanged = "user.password_changed"
+	AuditIdentityLinked  = "user.identity_linked"
+	AuditLogout          = "auth.logout"
+	AuditForcedLogout    = "user.logged_out"
+
+	AuditServiceAccountCreated = "service_account.created"
+	AuditAPIKeyCreated         = "api_key.created"
+	AuditAPIKeyRotated         = "api_key.rotated"
+	AuditAPIKeyRevoked         = "api_key.revoked"
+
+	AuditOAuthClientCreated = "oauth_client.created"
+	AuditOAuthClientDeleted = "oauth_client.deleted"
+	AuditOAuthConsent       = "oauth.consent_granted"
+)
+
+// AuditEvent describes who did what to which user, and from where. ActorID and TargetID are 0
+// when unknown, e.g. for a failed login; Email is the address used to authenticate. Requests
+// made with an API key have the ServiceAccountID of the key instead of an ActorID; events about
+// service accounts and keys target the ServiceAccountID. Events about OAuth clients record the
+// client_id in Changes.
+type AuditEvent struct {
+	ID               int                    `json:"id"`
+	Action           string                 `json:"action"`
+	ActorID          int                    `json:"actor_id,omitempty"`
+	TargetID         int                    `json:"target_id,omitempty"`
+	ServiceAccountID int                    `json:"service_account_id,omitempty"`
+	Email            string                 `json:"email,omitempty"`
+	IP               string                 `json:"ip"`
+	UserAgent        string                 `json:"user_agent"`
+	Changes          map[string]AuditChange `json:"changes,omitempty"`
+	CreatedAt        time.Time              `json:"created_at"`
+}
+
+// AuditChange is the old and the new value of a changed field.
+type AuditChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// DiffUsers returns the fields that differ between before and after, by their JSON name.
+// Passwords are never included.
+func DiffUsers(before, after User) map[string]AuditChange {
+	changes := map[string]AuditChange{}
+
+	add := func(field string, from, to any) {
+		if from != to {
+			changes[field] = AuditChange{From: from, To: to}
+		}
+	}
+
+	add("first_name", before.FirstName, after.FirstName)
+	add("last_name", before.LastName, after.LastName)
+	add("email", before.Email, after.Email)
+	add("is_admin", before.IsAdmin, after.IsAdmin)
+	add("profile_pic", before.ProfilePic.FileName, after.ProfilePic.FileName)
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return changes
+}
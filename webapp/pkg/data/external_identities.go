@@ -0,0 +1,15 @@
+package data
+
+import "time"
+
+// ExternalIdentity links a user to its account at an OpenID Connect provider. The provider is
+// identified by its Issuer URL, and the account by its Subject; Email is the address the
+// provider had verified when the identity was linked.
+type ExternalIdentity struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// Grant types an OAuth client can be allowed to use.
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+)
+
+// GrantTypes lists every grant type, for validation.
+var GrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials}
+
+// Scopes of OpenID Connect, which only release claims about the user at /oauth/userinfo and in
+// ID tokens.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// OAuthScopes lists every scope an OAuth client can be granted: the OpenID Connect ones and
+// those of the API keys.
+var OAuthScopes = append([]string{ScopeOpenID, ScopeProfile, ScopeEmail}, Scopes...)
+
+// OAuthClient is an application allowed to obtain tokens from the api, acting for a user who
+// consented to it or, with client_credentials, for itself. Public clients, such as single page
+// apps, have no secret and must use PKCE.
+type OAuthClient struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name" validate:"required,max=100"`
+	SecretHash   string    `json:"-"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	GrantTypes   []string  `json:"grant_types"`
+	CreatedBy    int       `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// NewOAuthClientID returns a random client id.
+func NewOAuthClientID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// NewOAuthClientSecret generates a client secret, and returns it with the hash to store.
+func NewOAuthClientSecret() (secret, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	secret = base64.RawURLEncoding.EncodeToString(b)
+	return secret, HashAPIKey(secret), nil
+}
+
+// Confidential tells whether c authenticates with a secret.
+func (c *OAuthClient) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+// SecretMatches tells in constant time whether secret is the one of c; public clients have none.
+func (c *OAuthClient) SecretMatches(secret string) bool {
+	k := APIKey{Hash: c.SecretHash}
+	return c.Confidential() && k.Matches(secret)
+}
+
+// HasRedirectURI tells whether uri was registered for c. URIs are compared as strings, as
+// RFC 6749 section 3.1.2.3 requires of registered URIs.
+func (c *OAuthClient) HasRedirectURI(uri string) bool {
+	return contains(c.RedirectURIs, uri)
+}
+
+// AllowsGrant tells whether c may use the grant type.
+func (c *OAuthClient) AllowsGrant(grantType string) bool {
+	return contains(c.GrantTypes, grantType)
+}
+
+// AllowsScopes tells whether c may be granted every one of scopes.
+func (c *OAuthClient) AllowsScopes(scopes []string) bool {
+	for _, s := range scopes {
+		if !contains(c.Scopes, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AuthorizationCode is issued by /oauth/authorize once the user consented, and exchanged once
+// at /oauth/token. Only the hash of the code is stored.
+type AuthorizationCode struct {
+	Hash          string
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	Nonce         string
+	ExpiresAt     time.Time
+}
+
+// contains tells whether values holds v
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,13 @@
+package data
+
+import "time"
+
+// Revocations are the tokens refused although they are signed and unexpired: those revoked one
+// by one, by their jti, and those of the users logged out everywhere, which were issued at or
+// before a time.
+type Revocations struct {
+	// Tokens holds the expiry of each revoked jti; the revocation can be forgotten then.
+	Tokens map[string]time.Time
+	// Users holds, per user id, the time at or before which the tokens of the user were issued.
+	Users map[int]time.Time
+}
@@ -0,0 +1,114 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Scopes an API key can be granted.
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	// ScopeAdmin grants what admin users may do: restoring users and reading the audit log.
+	ScopeAdmin = "admin"
+)
+
+// Scopes lists every scope, for validation.
+var Scopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeAdmin}
+
+// APIKeyPrefix starts every API key, so that leaked keys are easy to recognize.
+const APIKeyPrefix = "wak_"
+
+// ServiceAccount is a non-human client, e.g. a batch job, authenticating with API keys.
+type ServiceAccount struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name" validate:"required,max=100"`
+	Description string    `json:"description" validate:"max=255"`
+	CreatedBy   int       `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// APIKey is a credential of a service account. Only its hash is stored; Prefix identifies the
+// key and is safe to show.
+type APIKey struct {
+	ID               int        `json:"id"`
+	ServiceAccountID int        `json:"service_account_id"`
+	Name             string     `json:"name"`
+	Prefix           string     `json:"prefix"`
+	Hash             string     `json:"-"`
+	Scopes           []string   `json:"scopes"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+}
+
+// NewAPIKey generates a key of the form wak_<id>_<secret>, and returns it with the prefix and
+// the hash to store. The key itself can not be recovered from them.
+func NewAPIKey() (key, prefix, hash string, err error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+
+	if _, err := rand.Read(id); err != nil {
+		return "", "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = APIKeyPrefix + hex.EncodeToString(id)
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// APIKeyLookupPrefix returns the prefix of key, by which its record is found.
+func APIKeyLookupPrefix(key string) (string, bool) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", false
+	}
+
+	prefix, secret, ok := strings.Cut(key[len(APIKeyPrefix):], "_")
+	if !ok || len(prefix) != 12 || secret == "" {
+		return "", false
+	}
+
+	return APIKeyPrefix + prefix, true
+}
+
+// HashAPIKey returns the SHA-256 of key. Keys carry 256 random bits, so a slow hash such as
+// bcrypt would not make them any harder to guess.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches tells in constant time whether key is the one k was created for.
+func (k *APIKey) Matches(key string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(k.Hash)) == 1
+}
+
+// Active tells whether k is neither revoked nor expired at now.
+func (k *APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// HasScope tells whether k was granted scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
@@ -10,11 +10,12 @@ import (
 // User describes the data for the User type.
 type User struct {
 	ID         int       `json:"id"`
-	FirstName  string    `json:"first_name"`
-	LastName   string    `json:"last_name"`
-	Email      string    `json:"email"`
+	FirstName  string    `json:"first_name" validate:"max=255"`
+	LastName   string    `json:"last_name" validate:"max=255"`
+	Email      string    `json:"email" validate:"required,email,max=255"`
 	Password   string    `json:"-"`
 	IsAdmin    int       `json:"is_admin"`
+	Version    int       `json:"version"`
 	CreatedAt  time.Time `json:"-"`
 	UpdatedAt  time.Time `json:"-"`
 	ProfilePic UserImage `json:"-"`
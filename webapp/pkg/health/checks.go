@@ -0,0 +1,48 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"webapp/pkg/repository/dbrepo"
+)
+
+// Ping checks that the database answers.
+func Ping(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// Migrations checks that every migration embedded in the binary has been applied to db.
+func Migrations(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		pending, err := dbrepo.PendingMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		if len(pending) > 0 {
+			return fmt.Errorf("pending migrations: %s", strings.Join(pending, ", "))
+		}
+
+		return nil
+	}
+}
+
+// Writable checks that files can be created in dir, e.g. the upload directory.
+func Writable(dir string) Check {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return fmt.Errorf("%s is not writable", dir)
+		}
+
+		name := f.Name()
+		f.Close()
+
+		return os.Remove(name)
+	}
+}
@@ -0,0 +1,167 @@
+// Package health provides the liveness and readiness endpoints probed by orchestrators such as
+// Kubernetes: /healthz tells that the process is alive, /readyz runs the dependency checks.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Status of a check or of the whole report.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// DefaultTimeout bounds every check unless the Checker says otherwise.
+const DefaultTimeout = 2 * time.Second
+
+// ErrTimeout is reported for a check that did not finish in time.
+var ErrTimeout = errors.New("check timed out")
+
+// ErrUnavailable is reported for a check that failed. The report is public, so the error of the
+// check, which may name hosts, users or databases, is only logged.
+var ErrUnavailable = errors.New("unavailable")
+
+// Check reports whether a dependency is usable. It should give up when ctx is done.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of one check.
+type Result struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of all checks; its status is ok only if every check passed.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the readiness checks. It serves the report as JSON, with status 200 when every
+// check passed and 503 otherwise. Checks must be added before the checker is used.
+type Checker struct {
+	// Timeout bounds every check; zero means DefaultTimeout.
+	Timeout time.Duration
+
+	checks []namedCheck
+}
+
+// New returns a checker without any checks, which is always ready.
+func New(timeout time.Duration) *Checker {
+	return &Checker{Timeout: timeout}
+}
+
+// Add registers check under name.
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Run runs all checks concurrently, each within the timeout.
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, nc := range c.checks {
+		wg.Add(1)
+
+		go func(nc namedCheck) {
+			defer wg.Done()
+
+			result := c.run(ctx, nc.name, nc.check)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			report.Checks[nc.name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusFail
+			}
+		}(nc)
+	}
+
+	wg.Wait()
+
+	return report
+}
+
+// run runs one check, and stops waiting for it when the timeout expires even if the check
+// ignores its context
+func (c *Checker) run(ctx context.Context, name string, check Check) Result {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ErrTimeout
+	}
+
+	result := Result{
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	if err != nil {
+		slog.WarnContext(ctx, "readiness check failed", "check", name, "error", err)
+
+		result.Status = StatusFail
+		result.Error = ErrUnavailable.Error()
+		if errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+			result.Error = ErrTimeout.Error()
+		}
+	}
+
+	return result
+}
+
+// ServeHTTP serves the readiness report.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, report)
+}
+
+// Live answers the liveness probe: serving the request is proof enough that the process is alive.
+func Live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Report{Status: StatusOK, Checks: map[string]Result{}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	// probes must always see the current state
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,150 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChecker_ServeHTTP(t *testing.T) {
+
+	ok := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return errors.New("connection refused") }
+	slow := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	stuck := func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+
+	testCases := []struct {
+		name           string
+		checks         map[string]Check
+		expectedStatus int
+		expectedChecks map[string]string
+	}{
+		{"no checks", nil, http.StatusOK, map[string]string{}},
+		{"all ok", map[string]Check{"database": ok, "uploads": ok}, http.StatusOK, map[string]string{"database": StatusOK, "uploads": StatusOK}},
+		{"one failing", map[string]Check{"database": failing, "uploads": ok}, http.StatusServiceUnavailable, map[string]string{"database": StatusFail, "uploads": StatusOK}},
+		{"timeout", map[string]Check{"database": slow}, http.StatusServiceUnavailable, map[string]string{"database": StatusFail}},
+		{"check ignoring its context", map[string]Check{"database": stuck}, http.StatusServiceUnavailable, map[string]string{"database": StatusFail}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(20 * time.Millisecond)
+			for name, check := range tt.checks {
+				c.Add(name, check)
+			}
+
+			rr := httptest.NewRecorder()
+			c.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expect status %d; got %d", tt.expectedStatus, rr.Code)
+			}
+
+			if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expect JSON; got %q", ct)
+			}
+
+			var report Report
+			if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(report.Checks) != len(tt.expectedChecks) {
+				t.Errorf("expect %d checks; got %d", len(tt.expectedChecks), len(report.Checks))
+			}
+
+			for name, status := range tt.expectedChecks {
+				result := report.Checks[name]
+				if result.Status != status {
+					t.Errorf("expect %s to be %s; got %s", name, status, result.Status)
+				}
+
+				if status == StatusFail && result.Error == "" {
+					t.Errorf("expect an error for %s", name)
+				}
+			}
+		})
+	}
+}
+
+func TestChecker_errorMessage(t *testing.T) {
+
+	c := New(DefaultTimeout)
+	c.Add("database", func(ctx context.Context) error {
+		return errors.New(`failed to connect to host=db user=postgres database=users`)
+	})
+
+	report := c.Run(context.Background())
+
+	if got := report.Checks["database"].Error; got != ErrUnavailable.Error() {
+		t.Errorf("expect the error of the check to be hidden behind %q; got %q", ErrUnavailable, got)
+	}
+}
+
+func TestChecker_timeoutMessage(t *testing.T) {
+
+	c := New(10 * time.Millisecond)
+	c.Add("database", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	report := c.Run(context.Background())
+
+	if got := report.Checks["database"].Error; got != ErrTimeout.Error() {
+		t.Errorf("expect %q; got %q", ErrTimeout, got)
+	}
+
+	if got := report.Checks["database"].LatencyMS; got < 10 {
+		t.Errorf("expect the latency to cover the timeout; got %vms", got)
+	}
+}
+
+func TestLive(t *testing.T) {
+
+	rr := httptest.NewRecorder()
+	Live(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expect status 200; got %d", rr.Code)
+	}
+
+	var report Report
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Status != StatusOK {
+		t.Errorf("expect status ok; got %s", report.Status)
+	}
+}
+
+func TestWritable(t *testing.T) {
+
+	dir := t.TempDir()
+
+	if err := Writable(dir)(context.Background()); err != nil {
+		t.Errorf("expect %s to be writable; got %s", dir, err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("expect the probe file to be removed; found %d files", len(entries))
+	}
+
+	if err := Writable(filepath.Join(dir, "missing"))(context.Background()); err == nil {
+		t.Error("expect a missing directory not to be writable")
+	}
+}
@@ -0,0 +1,220 @@
+// Package i18n loads message catalogs and translates message keys for a negotiated locale.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// DefaultLanguage is used when none of the requested languages has a catalog.
+const DefaultLanguage = "en"
+
+var defaultBundle = MustLoad(locales, DefaultLanguage)
+
+// Default returns the bundle built from the catalogs shipped with the application.
+func Default() *Bundle {
+	return defaultBundle
+}
+
+// Params are the named values substituted into a message, e.g. {min} in "at least {min} characters".
+type Params map[string]any
+
+// Message is a translatable message key together with its parameters.
+type Message struct {
+	Key    string `json:"key"`
+	Params Params `json:"params,omitempty"`
+}
+
+// NewMessage returns a message for key; args are name/value pairs, e.g. NewMessage("form.min_length", "min", 3).
+func NewMessage(key string, args ...any) Message {
+	return Message{Key: key, Params: pairs(args)}
+}
+
+// String renders the message in the default language.
+func (m Message) String() string {
+	return defaultBundle.Localizer().Translate(m)
+}
+
+// Bundle holds one catalog per language.
+type Bundle struct {
+	fallback language.Tag
+	tags     []language.Tag
+	catalogs map[language.Tag]map[string]string
+	matcher  language.Matcher
+}
+
+// Load reads every locales/<lang>.json catalog from fsys. Each catalog is a flat JSON object
+// of message keys to translations. fallback must be one of the loaded languages.
+func Load(fsys fs.FS, fallback string) (*Bundle, error) {
+	files, err := fs.Glob(fsys, "locales/*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	fallbackTag, err := language.Parse(fallback)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{
+		fallback: fallbackTag,
+		catalogs: map[language.Tag]map[string]string{},
+	}
+
+	// the fallback goes first, so that the matcher picks it when nothing else matches
+	b.tags = append(b.tags, fallbackTag)
+
+	for _, f := range files {
+		tag, err := language.Parse(strings.TrimSuffix(path.Base(f), ".json"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+
+		content, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+
+		catalog := map[string]string{}
+		if err := json.Unmarshal(content, &catalog); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+
+		b.catalogs[tag] = catalog
+		if tag != fallbackTag {
+			b.tags = append(b.tags, tag)
+		}
+	}
+
+	if _, ok := b.catalogs[fallbackTag]; !ok {
+		return nil, fmt.Errorf("no catalog for fallback language %s", fallback)
+	}
+
+	b.matcher = language.NewMatcher(b.tags)
+
+	return b, nil
+}
+
+// MustLoad is like Load but panics on error.
+func MustLoad(fsys fs.FS, fallback string) *Bundle {
+	b, err := Load(fsys, fallback)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+// Languages returns the languages that have a catalog, the fallback first.
+func (b *Bundle) Languages() []string {
+	var langs []string
+	for _, t := range b.tags {
+		langs = append(langs, t.String())
+	}
+
+	return langs
+}
+
+// Supports reports whether lang has a catalog of its own.
+func (b *Bundle) Supports(lang string) bool {
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return false
+	}
+
+	_, ok := b.catalogs[tag]
+	return ok
+}
+
+// Localizer returns a localizer for the best match of the preferences, which are tried in
+// order. Each preference is either a language tag (e.g. from a cookie) or a complete
+// Accept-Language header. With no usable preference the fallback language is used.
+func (b *Bundle) Localizer(preferences ...string) *Localizer {
+	for _, pref := range preferences {
+		if pref == "" {
+			continue
+		}
+
+		tags, _, err := language.ParseAcceptLanguage(pref)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+
+		_, index, confidence := b.matcher.Match(tags...)
+		if confidence != language.No {
+			return &Localizer{bundle: b, tag: b.tags[index]}
+		}
+	}
+
+	return &Localizer{bundle: b, tag: b.fallback}
+}
+
+// Localizer translates messages into one language.
+type Localizer struct {
+	bundle *Bundle
+	tag    language.Tag
+}
+
+// Lang returns the language tag of the localizer, e.g. "fr".
+func (l *Localizer) Lang() string {
+	return l.tag.String()
+}
+
+// T translates key; args are name/value pairs used as message parameters. Unknown keys are
+// looked up in the fallback language and returned unchanged if still not found, so plain
+// text can be passed through T safely.
+func (l *Localizer) T(key string, args ...any) string {
+	return l.Translate(Message{Key: key, Params: pairs(args)})
+}
+
+// Translate renders m in the localizer's language.
+func (l *Localizer) Translate(m Message) string {
+	text, ok := l.bundle.catalogs[l.tag][m.Key]
+	if !ok {
+		text, ok = l.bundle.catalogs[l.bundle.fallback][m.Key]
+	}
+	if !ok {
+		text = m.Key
+	}
+
+	if len(m.Params) == 0 {
+		return text
+	}
+
+	replacements := make([]string, 0, len(m.Params)*2)
+	for name, value := range m.Params {
+		replacements = append(replacements, "{"+name+"}", fmt.Sprint(value))
+	}
+
+	return strings.NewReplacer(replacements...).Replace(text)
+}
+
+// pairs turns alternating name/value arguments into Params; a single Params argument is
+// used as is.
+func pairs(args []any) Params {
+	if len(args) == 0 {
+		return nil
+	}
+
+	if len(args) == 1 {
+		if p, ok := args[0].(Params); ok {
+			return p
+		}
+	}
+
+	p := Params{}
+	for i := 0; i+1 < len(args); i += 2 {
+		p[fmt.Sprint(args[i])] = args[i+1]
+	}
+
+	return p
+}
@@ -0,0 +1,104 @@
+package i18n
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func Test_Bundle_Localizer(t *testing.T) {
+
+	b := Default()
+
+	testCases := []struct {
+		name        string
+		preferences []string
+		expected    string
+	}{
+		{"no preference", nil, "en"},
+		{"empty header", []string{""}, "en"},
+		{"exact match", []string{"fr"}, "fr"},
+		{"regional variant", []string{"fr-CA"}, "fr"},
+		{"accept-language", []string{"de-DE,fr;q=0.8,en;q=0.5"}, "fr"},
+		{"unsupported", []string{"de"}, "en"},
+		{"cookie wins over header", []string{"en", "fr"}, "en"},
+		{"invalid cookie falls through", []string{"???", "fr"}, "fr"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			l := b.Localizer(tt.preferences...)
+			if l.Lang() != tt.expected {
+				t.Errorf("expect language %s; got %s", tt.expected, l.Lang())
+			}
+		})
+	}
+}
+
+func Test_Localizer_T(t *testing.T) {
+
+	fsys := fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{"greet": "Hello {name}", "only.en": "English only"}`)},
+		"locales/fr.json": {Data: []byte(`{"greet": "Bonjour {name}"}`)},
+	}
+
+	b, err := Load(fsys, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fr := b.Localizer("fr")
+
+	testCases := []struct {
+		name     string
+		key      string
+		args     []any
+		expected string
+	}{
+		{"with params", "greet", []any{"name", "Ada"}, "Bonjour Ada"},
+		{"params map", "greet", []any{Params{"name": "Ada"}}, "Bonjour Ada"},
+		{"falls back to default language", "only.en", nil, "English only"},
+		{"unknown key", "not a key", nil, "not a key"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fr.T(tt.key, tt.args...); got != tt.expected {
+				t.Errorf("expect %q; got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func Test_Load_errors(t *testing.T) {
+
+	testCases := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"no fallback catalog", fstest.MapFS{"locales/fr.json": {Data: []byte(`{}`)}}},
+		{"bad json", fstest.MapFS{"locales/en.json": {Data: []byte(`{`)}}},
+		{"bad language", fstest.MapFS{"locales/en.json": {Data: []byte(`{}`)}, "locales/not_a_lang!.json": {Data: []byte(`{}`)}}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load(tt.fsys, "en"); err == nil {
+				t.Error("expected Load() to return an error")
+			}
+		})
+	}
+}
+
+func Test_catalogs_complete(t *testing.T) {
+
+	b := Default()
+	en := b.catalogs[b.fallback]
+
+	for tag, catalog := range b.catalogs {
+		for key := range en {
+			if _, ok := catalog[key]; !ok {
+				t.Errorf("%s catalog is missing %s", tag, key)
+			}
+		}
+	}
+}
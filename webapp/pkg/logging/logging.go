@@ -0,0 +1,108 @@
+// Package logging sets up structured JSON logging with log/slog, and provides the HTTP
+// middleware that gives every request an ID, picks up W3C trace context and writes access logs.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey int
+
+const requestKey contextKey = 0
+
+// Request is what the logger knows about the request being served. It is created by the
+// RequestID middleware; the user ID is filled in later by whichever middleware authenticates
+// the user, so it is safe for concurrent use.
+type Request struct {
+	ID           string
+	TraceID      string
+	ParentSpanID string
+
+	mu     sync.Mutex
+	userID string
+}
+
+// SetUserID records the authenticated user.
+func (r *Request) SetUserID(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.userID = id
+}
+
+// UserID returns the authenticated user, or an empty string.
+func (r *Request) UserID() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.userID
+}
+
+// FromContext returns the request stored by the RequestID middleware, or nil.
+func FromContext(ctx context.Context) *Request {
+	req, _ := ctx.Value(requestKey).(*Request)
+	return req
+}
+
+// NewContext returns a copy of ctx carrying req.
+func NewContext(ctx context.Context, req *Request) context.Context {
+	return context.WithValue(ctx, requestKey, req)
+}
+
+// RequestIDFromContext returns the ID of the request being served, or an empty string.
+func RequestIDFromContext(ctx context.Context) string {
+	if req := FromContext(ctx); req != nil {
+		return req.ID
+	}
+
+	return ""
+}
+
+// SetUserID records the authenticated user of the request in ctx, if any, so that it shows
+// up in the access log.
+func SetUserID(ctx context.Context, id string) {
+	if req := FromContext(ctx); req != nil {
+		req.SetUserID(id)
+	}
+}
+
+// New returns a logger writing JSON lines to w. Records logged with a request context, e.g.
+// through slog.InfoContext, carry the request ID and trace ID, and the span ID when the
+// request is traced.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(&contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})})
+}
+
+// contextHandler adds the request found in the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	// an active span knows the trace better than the incoming headers do
+	span := trace.SpanContextFromContext(ctx)
+
+	if req := FromContext(ctx); req != nil {
+		record.AddAttrs(slog.String("request_id", req.ID))
+		if req.TraceID != "" && !span.IsValid() {
+			record.AddAttrs(slog.String("trace_id", req.TraceID))
+		}
+	}
+
+	if span.IsValid() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
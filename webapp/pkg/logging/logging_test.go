@@ -0,0 +1,183 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"webapp/pkg/clientip"
+)
+
+func TestParseTraceparent(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		header   string
+		expectOK bool
+	}{
+		{"valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"future version with extra field", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"version 00 with extra field", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01", false},
+		{"short trace id", "00-4bf92f3577b34da6-00f067aa0ba902b7-01", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			traceID, spanID, ok := ParseTraceparent(tt.header)
+			if ok != tt.expectOK {
+				t.Fatalf("expect ok to be %t; got %t", tt.expectOK, ok)
+			}
+
+			if ok && (traceID != "4bf92f3577b34da6a3ce929d0e0e4736" || spanID != "00f067aa0ba902b7") {
+				t.Errorf("unexpected trace id %s and span id %s", traceID, spanID)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+
+	testCases := []struct {
+		name          string
+		requestID     string
+		traceparent   string
+		expectedID    string
+		expectedTrace string
+	}{
+		{"from header", "abc-123", "", "abc-123", ""},
+		{"from traceparent", "", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"header wins over traceparent", "abc-123", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "abc-123", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"unsafe header is replaced", "abc\n123", "", "", ""},
+		{"generated", "", "", "", ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *Request
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = FromContext(r.Context())
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.requestID != "" {
+				req.Header.Set(RequestIDHeader, tt.requestID)
+			}
+			if tt.traceparent != "" {
+				req.Header.Set(TraceparentHeader, tt.traceparent)
+			}
+
+			rr := httptest.NewRecorder()
+			RequestID(next).ServeHTTP(rr, req)
+
+			if got == nil {
+				t.Fatal("expect a request in the context")
+			}
+
+			if tt.expectedID != "" && got.ID != tt.expectedID {
+				t.Errorf("expect request id %q; got %q", tt.expectedID, got.ID)
+			}
+
+			if tt.expectedID == "" && len(got.ID) != 32 {
+				t.Errorf("expect a generated request id; got %q", got.ID)
+			}
+
+			if got.TraceID != tt.expectedTrace {
+				t.Errorf("expect trace id %q; got %q", tt.expectedTrace, got.TraceID)
+			}
+
+			if rr.Header().Get(RequestIDHeader) != got.ID {
+				t.Errorf("expect the request id in the response; got %q", rr.Header().Get(RequestIDHeader))
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetUserID(r.Context(), "42")
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("short and stout"))
+	})
+
+	// the request comes through a trusted proxy
+	resolver, err := clientip.New(clientip.Config{TrustedProxies: []string{"192.0.2.0/24"}, Header: clientip.HeaderXForwardedFor})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/teapot", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	req.Header.Set(clientip.HeaderXForwardedFor, "203.0.113.7")
+
+	RequestID(resolver.Middleware(AccessLog(logger)(next))).ServeHTTP(httptest.NewRecorder(), req)
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expect a JSON line; got %q", buf.String())
+	}
+
+	expected := map[string]any{
+		"msg":        "request",
+		"method":     "POST",
+		"path":       "/teapot",
+		"status":     float64(http.StatusTeapot),
+		"bytes":      float64(15),
+		"user_id":    "42",
+		"request_id": "req-1",
+		"client_ip":  "203.0.113.7",
+	}
+
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("expect %s to be %v; got %v", key, value, line[key])
+		}
+	}
+
+	if _, ok := line["latency_ms"]; !ok {
+		t.Error("expect the latency to be logged")
+	}
+}
+
+func TestRecover(t *testing.T) {
+
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(New(&buf, slog.LevelInfo))
+	defer slog.SetDefault(old)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(NewContext(context.Background(), &Request{ID: "req-2"}))
+
+	rr := httptest.NewRecorder()
+	Recover(next).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expect status 500; got %d", rr.Code)
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expect a JSON line; got %q", buf.String())
+	}
+
+	if line["error"] != "boom" || line["request_id"] != "req-2" || line["stack"] == nil {
+		t.Errorf("expect the panic to be logged with the request id and stack; got %v", line)
+	}
+}
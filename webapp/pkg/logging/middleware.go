@@ -0,0 +1,188 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/clientip"
+	"webapp/pkg/recorder"
+)
+
+const (
+	// RequestIDHeader is read from requests and set on every response.
+	RequestIDHeader = "X-Request-ID"
+	// TraceparentHeader carries W3C trace context, see https://www.w3.org/TR/trace-context/
+	TraceparentHeader = "traceparent"
+
+	maxRequestIDLength = 128
+)
+
+// RequestID stores a Request in the context of every request. The ID comes from the
+// X-Request-ID header if it is well formed, else from the trace ID of a valid traceparent
+// header, else it is generated. It is sent back in the X-Request-ID response header.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &Request{}
+
+		if traceID, spanID, ok := ParseTraceparent(r.Header.Get(TraceparentHeader)); ok {
+			req.TraceID = traceID
+			req.ParentSpanID = spanID
+		}
+
+		switch id := r.Header.Get(RequestIDHeader); {
+		case validRequestID(id):
+			req.ID = id
+		case req.TraceID != "":
+			req.ID = req.TraceID
+		default:
+			req.ID = newID()
+		}
+
+		w.Header().Set(RequestIDHeader, req.ID)
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), req)))
+	})
+}
+
+// validRequestID only accepts short IDs of safe characters, so that clients can not inject
+// anything into logs or headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseTraceparent returns the trace ID and parent span ID of a W3C traceparent header, such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func ParseTraceparent(header string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 {
+		return "", "", false
+	}
+
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+
+	// version 00 has exactly four fields; later versions may add more
+	if !isHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return "", "", false
+	}
+
+	if !isHex(traceID, 32) || !isHex(spanID, 16) || !isHex(flags, 2) {
+		return "", "", false
+	}
+
+	if strings.Trim(traceID, "0") == "" || strings.Trim(spanID, "0") == "" {
+		return "", "", false
+	}
+
+	return traceID, spanID, true
+}
+
+// isHex reports whether s is n lowercase hex digits
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newID returns a random 128 bit ID in hex
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// AccessLog writes one line per request to logger, with the status, size, latency, the client
+// IP and the user set with SetUserID. Server errors are logged at error level. It must run after
+// the clientip middleware, so that the client IP is the one resolved behind trusted proxies.
+func AccessLog(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := recorder.New(w)
+
+			next.ServeHTTP(rec, r)
+
+			status := rec.Status()
+
+			level := slog.LevelInfo
+			if status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", status),
+				slog.Int64("bytes", rec.Bytes()),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("client_ip", clientip.Get(r)),
+				slog.String("user_agent", r.UserAgent()),
+			}
+
+			if req := FromContext(r.Context()); req != nil && req.UserID() != "" {
+				attrs = append(attrs, slog.String("user_id", req.UserID()))
+			}
+
+			logger.LogAttrs(r.Context(), level, "request", attrs...)
+		})
+	}
+}
+
+// Recover turns panics into a 500 response and logs them with their stack trace, replacing
+// chi's Recoverer which does not log structured lines.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+
+			// net/http uses this panic to abort a response on purpose
+			if err, ok := rvr.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(rvr)
+			}
+
+			slog.ErrorContext(r.Context(), "panic",
+				"error", fmt.Sprint(rvr),
+				"stack", string(debug.Stack()),
+			)
+
+			if r.Header.Get("Connection") != "Upgrade" {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
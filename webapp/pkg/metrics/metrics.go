@@ -0,0 +1,193 @@
+// Package metrics collects the Prometheus metrics of the servers: HTTP requests per chi route,
+// the database pool, repository calls and authentication events.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"webapp/pkg/recorder"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// unmatchedRoute labels requests which did not match any route, so that scanners probing
+// random paths can not blow up the number of series
+const unmatchedRoute = "unmatched"
+
+// Metrics holds the collectors of one server, in a registry of its own.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+	queryErrors     *prometheus.CounterVec
+	authAttempts    *prometheus.CounterVec
+	tokenRefreshes  prometheus.Counter
+	uploadBytes     prometheus.Counter
+	rateLimitErrors *prometheus.CounterVec
+}
+
+// New creates the metrics, together with the standard Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of HTTP requests, by route pattern, method and status code.",
+		}, []string{"route", "method", "code"}),
+
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Latency of HTTP requests, by route pattern and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Duration of DatabaseRepo calls, by method.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 3},
+		}, []string{"method"}),
+
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_query_errors_total",
+			Help: "Number of DatabaseRepo calls that returned an error, by method.",
+		}, []string{"method"}),
+
+		authAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_attempts_total",
+			Help: "Number of login attempts, by result (success or failure).",
+		}, []string{"result"}),
+
+		tokenRefreshes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "token_refreshes_total",
+			Help: "Number of refreshed token pairs.",
+		}),
+
+		uploadBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "upload_bytes_total",
+			Help: "Number of bytes of uploaded files.",
+		}),
+
+		rateLimitErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limit_errors_total",
+			Help: "Number of requests let through unlimited because the rate limit store failed, by group.",
+		}, []string{"group"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.queryDuration,
+		m.queryErrors,
+		m.authAttempts,
+		m.tokenRefreshes,
+		m.uploadBytes,
+		m.rateLimitErrors,
+	)
+
+	// both results are always exported, so that a rate of failures can be computed from the start
+	m.authAttempts.WithLabelValues("success")
+	m.authAttempts.WithLabelValues("failure")
+
+	return m
+}
+
+// Registry returns the registry the metrics are registered with.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Serve serves Handler at /metrics on ln until ctx is done. The metrics tell a lot about the
+// users and the load of the server, so they are kept off the public routes, on a listener that
+// only the scrapers reach.
+func (m *Metrics) Serve(ctx context.Context, ln net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	// scrapes are short, there is nothing to drain
+	stop := context.AfterFunc(ctx, func() { srv.Close() })
+	defer stop()
+
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// RegisterDB exports the connection pool statistics of db, see sql.DB.Stats.
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Middleware counts and times requests. Requests are labelled with the chi route pattern, such
+// as /users/{userID}, which is only known once the router has run the request.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := recorder.New(w)
+
+		next.ServeHTTP(rec, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.Status())).Inc()
+		m.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// AuthAttempt counts a successful or failed login.
+func (m *Metrics) AuthAttempt(success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+
+	m.authAttempts.WithLabelValues(result).Inc()
+}
+
+// TokenRefreshed counts a refreshed token pair.
+func (m *Metrics) TokenRefreshed() {
+	m.tokenRefreshes.Inc()
+}
+
+// Uploaded counts the bytes of uploaded files.
+func (m *Metrics) Uploaded(bytes int64) {
+	m.uploadBytes.Add(float64(bytes))
+}
+
+// RateLimitFailed counts a request of group let through because the rate limit store failed.
+func (m *Metrics) RateLimitFailed(group string) {
+	m.rateLimitErrors.WithLabelValues(group).Inc()
+}
+
+// observeQuery records the duration and the outcome of a repository call
+func (m *Metrics) observeQuery(method string, start time.Time, err error) {
+	m.queryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		m.queryErrors.WithLabelValues(method).Inc()
+	}
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics_Middleware(t *testing.T) {
+
+	m := New()
+
+	mux := chi.NewRouter()
+	mux.Use(m.Middleware)
+	mux.Route("/users", func(mux chi.Router) {
+		mux.Get("/{userID}", func(w http.ResponseWriter, r *http.Request) {
+			if chi.URLParam(r, "userID") == "2" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		})
+	})
+
+	for _, path := range []string{"/users/1", "/users/1", "/users/2", "/nowhere", "/nowhere/else"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	testCases := []struct {
+		route    string
+		code     string
+		expected float64
+	}{
+		{"/users/{userID}", "200", 2},
+		{"/users/{userID}", "404", 1},
+		{unmatchedRoute, "404", 2},
+	}
+
+	for _, tt := range testCases {
+		got := testutil.ToFloat64(m.requests.WithLabelValues(tt.route, http.MethodGet, tt.code))
+		if got != tt.expected {
+			t.Errorf("expect %v requests to %s with code %s; got %v", tt.expected, tt.route, tt.code, got)
+		}
+	}
+
+	if n := testutil.CollectAndCount(m.requestDuration); n != 2 {
+		t.Errorf("expect latencies of 2 routes; got %d", n)
+	}
+}
+
+func TestMetrics_ObserveRepo(t *testing.T) {
+
+	m := New()
+	repo := repository.Instrument(&dbrepo.MockDBRepo{}, m.ObserveRepo)
+
+	_, _ = repo.GetUser(context.Background(), 1)
+	_, _ = repo.GetUser(context.Background(), 2)
+	_ = repo.DeleteUser(context.Background(), 1, 0)
+
+	if n := testutil.CollectAndCount(m.queryDuration); n != 2 {
+		t.Errorf("expect durations of 2 methods; got %d", n)
+	}
+
+	if got := testutil.ToFloat64(m.queryErrors.WithLabelValues("GetUser")); got != 1 {
+		t.Errorf("expect 1 GetUser error; got %v", got)
+	}
+
+	if got := testutil.ToFloat64(m.queryErrors.WithLabelValues("DeleteUser")); got != 0 {
+		t.Errorf("expect no DeleteUser errors; got %v", got)
+	}
+}
+
+func TestMetrics_counters(t *testing.T) {
+
+	m := New()
+
+	m.AuthAttempt(true)
+	m.AuthAttempt(false)
+	m.AuthAttempt(false)
+	m.TokenRefreshed()
+	m.Uploaded(1024)
+	m.Uploaded(512)
+	m.RateLimitFailed("auth")
+
+	if got := testutil.ToFloat64(m.authAttempts.WithLabelValues("success")); got != 1 {
+		t.Errorf("expect 1 successful login; got %v", got)
+	}
+
+	if got := testutil.ToFloat64(m.authAttempts.WithLabelValues("failure")); got != 2 {
+		t.Errorf("expect 2 failed logins; got %v", got)
+	}
+
+	if got := testutil.ToFloat64(m.tokenRefreshes); got != 1 {
+		t.Errorf("expect 1 token refresh; got %v", got)
+	}
+
+	if got := testutil.ToFloat64(m.uploadBytes); got != 1536 {
+		t.Errorf("expect 1536 uploaded bytes; got %v", got)
+	}
+
+	if got := testutil.ToFloat64(m.rateLimitErrors.WithLabelValues("auth")); got != 1 {
+		t.Errorf("expect 1 rate limit error; got %v", got)
+	}
+}
+
+func TestMetrics_Handler(t *testing.T) {
+
+	m := New()
+	m.AuthAttempt(true)
+	m.observeQuery("AllUsers", time.Now(), errors.New("boom"))
+
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rr.Body.String()
+
+	for _, expected := range []string{
+		`auth_attempts_total{result="success"} 1`,
+		`auth_attempts_total{result="failure"} 0`,
+		`db_query_errors_total{method="AllUsers"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expect %q in the metrics", expected)
+		}
+	}
+}
+
+func TestMetrics_Serve(t *testing.T) {
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := New()
+	m.AuthAttempt(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- m.Serve(ctx, ln) }()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `auth_attempts_total{result="success"} 1`) {
+		t.Errorf("expect the metrics at /metrics; got %d", resp.StatusCode)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expect Serve to stop without error once ctx is done; got %v", err)
+	}
+}
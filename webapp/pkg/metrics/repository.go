@@ -0,0 +1,16 @@
+package metrics
+
+import (
+	"context"
+	"time"
+)
+
+// ObserveRepo is a repository.Observer recording the duration and the errors of every call of
+// the instrumented repository.
+func (m *Metrics) ObserveRepo(ctx context.Context, method string) (context.Context, func(error)) {
+	start := time.Now()
+
+	return ctx, func(err error) {
+		m.observeQuery(method, start, err)
+	}
+}
@@ -0,0 +1,64 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// DefaultScopes ask for the claims used to link the user: the email address and the name.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// Config is a client registered at an OpenID Connect provider. The tags are read by package
+// config; single sign-on is disabled while Issuer is empty.
+type Config struct {
+	Issuer       string   `yaml:"issuer" toml:"issuer" env:"OIDC_ISSUER" flag:"oidc-issuer" usage:"URL of the OpenID Connect provider used for single sign-on; empty disables it"`
+	ClientID     string   `yaml:"client_id" toml:"client_id" env:"OIDC_CLIENT_ID" flag:"oidc-client-id" usage:"client id registered at the OpenID Connect provider"`
+	ClientSecret string   `yaml:"client_secret" toml:"client_secret" env:"OIDC_CLIENT_SECRET" flag:"oidc-client-secret" usage:"client secret registered at the OpenID Connect provider, if any" redact:"true"`
+	RedirectURL  string   `yaml:"redirect_url" toml:"redirect_url" env:"OIDC_REDIRECT_URL" flag:"oidc-redirect-url" usage:"URL of the callback the provider redirects to after login"`
+	Scopes       []string `yaml:"scopes" toml:"scopes" env:"OIDC_SCOPES" flag:"oidc-scopes" usage:"comma separated scopes to ask for; openid is always added"`
+}
+
+// Enabled tells whether single sign-on is configured.
+func (c Config) Enabled() bool {
+	return c.Issuer != ""
+}
+
+// Validate checks the client settings, when single sign-on is enabled.
+func (c Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	var errs []error
+
+	if u, err := url.Parse(c.Issuer); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		errs = append(errs, fmt.Errorf("oidc.issuer must be an http(s) URL; got %q", c.Issuer))
+	}
+
+	if c.ClientID == "" {
+		errs = append(errs, errors.New("oidc.client_id is required"))
+	}
+
+	if u, err := url.Parse(c.RedirectURL); err != nil || !u.IsAbs() {
+		errs = append(errs, fmt.Errorf("oidc.redirect_url must be an absolute URL; got %q", c.RedirectURL))
+	}
+
+	return errors.Join(errs...)
+}
+
+// scopes returns the scopes to ask for, which always include openid
+func (c Config) scopes() []string {
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+
+	for _, s := range scopes {
+		if s == "openid" {
+			return scopes
+		}
+	}
+
+	return append([]string{"openid"}, scopes...)
+}
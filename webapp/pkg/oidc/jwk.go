@@ -0,0 +1,102 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a public key in the JSON Web Key format (RFC 7517). RSA and P-256 keys are supported.
+type JWK struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, as served at the jwks_uri of a provider.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK returns the signing key pub as a JWK identified by kid.
+func NewJWK(kid string, pub crypto.PublicKey) (JWK, error) {
+	enc := base64.RawURLEncoding
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyID:     kid,
+			KeyType:   "RSA",
+			Algorithm: "RS256",
+			Use:       "sig",
+			N:         enc.EncodeToString(k.N.Bytes()),
+			E:         enc.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return JWK{}, errors.New("oidc: only P-256 keys are supported")
+		}
+		return JWK{
+			KeyID:     kid,
+			KeyType:   "EC",
+			Algorithm: "ES256",
+			Use:       "sig",
+			Curve:     "P-256",
+			X:         enc.EncodeToString(k.X.FillBytes(make([]byte, 32))),
+			Y:         enc.EncodeToString(k.Y.FillBytes(make([]byte, 32))),
+		}, nil
+	}
+
+	return JWK{}, fmt.Errorf("oidc: unsupported key type %T", pub)
+}
+
+// PublicKey decodes the key.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	dec := base64.RawURLEncoding
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := dec.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("oidc: key %s: invalid modulus: %w", k.KeyID, err)
+		}
+		e, err := dec.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("oidc: key %s: invalid exponent", k.KeyID)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("oidc: key %s: unsupported curve %q", k.KeyID, k.Curve)
+		}
+		x, errX := dec.DecodeString(k.X)
+		y, errY := dec.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("oidc: key %s: invalid coordinates", k.KeyID)
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("oidc: key %s: point is not on the curve", k.KeyID)
+		}
+		return pub, nil
+	}
+
+	return nil, fmt.Errorf("oidc: key %s: unsupported key type %q", k.KeyID, k.KeyType)
+}
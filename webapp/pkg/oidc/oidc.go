@@ -0,0 +1,375 @@
+// Package oidc signs users in with an OpenID Connect provider, using the authorization code
+// flow with PKCE, and links them to the local users by verified email address.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	// ErrInvalidState is returned when the callback does not belong to the login in progress.
+	ErrInvalidState = errors.New("oidc: the state does not match the login in progress")
+	// ErrInvalidIDToken wraps the reasons an ID token is refused.
+	ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+	// ErrEmailNotVerified is returned for identities which can not be linked to a user, since the
+	// provider does not vouch for their email address.
+	ErrEmailNotVerified = errors.New("oidc: the provider has not verified the email address")
+	// ErrAccountDeleted is returned for identities linked to a deleted user: an admin may
+	// restore the user until it is purged, so no other user is created for the identity.
+	ErrAccountDeleted = errors.New("oidc: the account linked to the identity was deleted")
+)
+
+// Leeway tolerates the clock skew between the provider and us.
+const Leeway = time.Minute
+
+// keysRefreshInterval limits how often an unknown key id makes us fetch the keys again
+const keysRefreshInterval = 10 * time.Second
+
+// Metadata is the discovery document of a provider, served at
+// <issuer>/.well-known/openid-configuration. The client only reads the endpoints; the other
+// fields are served by the api as a provider.
+type Metadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint,omitempty"`
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported,omitempty"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	SubjectTypesSupported             []string `json:"subject_types_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+}
+
+// IDToken holds the claims of an ID token used to find or create the user. The claims are
+// omitted when empty, so that the api can issue the claims of the scopes it was granted.
+type IDToken struct {
+	Email           string `json:"email,omitempty"`
+	EmailVerified   bool   `json:"email_verified,omitempty"`
+	Name            string `json:"name,omitempty"`
+	GivenName       string `json:"given_name,omitempty"`
+	FamilyName      string `json:"family_name,omitempty"`
+	Nonce           string `json:"nonce,omitempty"`
+	AuthorizedParty string `json:"azp,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Valid checks the times of the token, allowing for Leeway. The parser calls it.
+func (t *IDToken) Valid() error {
+	now := time.Now()
+
+	if t.ExpiresAt == nil || now.After(t.ExpiresAt.Add(Leeway)) {
+		return errors.New("token is expired")
+	}
+
+	if t.IssuedAt != nil && t.IssuedAt.After(now.Add(Leeway)) {
+		return errors.New("token used before issued")
+	}
+
+	if t.NotBefore != nil && t.NotBefore.After(now.Add(Leeway)) {
+		return errors.New("token is not valid yet")
+	}
+
+	return nil
+}
+
+// Provider is an OpenID Connect provider, as seen by one client. The discovery document and
+// the signing keys are fetched on first use, so that the application starts while the provider
+// is unreachable.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu          sync.Mutex
+	metadata    *Metadata
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// New returns the provider of c. A nil client uses one with a 10 second timeout.
+func New(c Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Provider{config: c, client: client}
+}
+
+// Issuer identifies the provider; it is stored with the identities it vouches for.
+func (p *Provider) Issuer() string {
+	return strings.TrimSuffix(p.config.Issuer, "/")
+}
+
+// Metadata returns the discovery document of the provider.
+func (p *Provider) Metadata(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.discover(ctx)
+}
+
+// discover fetches the discovery document once it succeeds; p.mu must be held
+func (p *Provider) discover(ctx context.Context) (*Metadata, error) {
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var m Metadata
+	if err := p.get(ctx, p.Issuer()+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+
+	// a document claiming another issuer could make us accept its tokens (OpenID Connect
+	// Discovery 1.0, section 4.3)
+	if strings.TrimSuffix(m.Issuer, "/") != p.Issuer() {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q does not match %q", m.Issuer, p.Issuer())
+	}
+
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: the authorization, token and jwks endpoints are required")
+	}
+
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+// AuthCodeURL returns the URL of the provider's login page, for the login f.
+func (p *Provider) AuthCodeURL(ctx context.Context, f Flow) (string, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: invalid authorization endpoint: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURL)
+	q.Set("scope", strings.Join(p.config.scopes(), " "))
+	q.Set("state", f.State)
+	q.Set("nonce", f.Nonce)
+	q.Set("code_challenge", S256Challenge(f.Verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// tokenResponse is the answer of the token endpoint, successful or not
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Callback completes the login f: it checks the state of the callback request r, exchanges the
+// code for tokens and returns the verified ID token.
+func (p *Provider) Callback(ctx context.Context, r *http.Request, f Flow) (*IDToken, error) {
+	q := r.URL.Query()
+
+	if f.State == "" || subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(f.State)) != 1 {
+		return nil, ErrInvalidState
+	}
+
+	if e := q.Get("error"); e != "" {
+		return nil, fmt.Errorf("oidc: login refused: %s %s", e, q.Get("error_description"))
+	}
+
+	code := q.Get("code")
+	if code == "" {
+		return nil, errors.New("oidc: the callback has no code")
+	}
+
+	raw, err := p.exchange(ctx, code, f.Verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Verify(ctx, raw, f.Nonce)
+}
+
+// exchange trades code for tokens at the token endpoint, and returns the raw ID token
+func (p *Provider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+
+	// public clients identify themselves in the body, confidential ones authenticate with
+	// client_secret_basic
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc: token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("oidc: token endpoint: status %d: %w", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return "", fmt.Errorf("oidc: token endpoint: status %d: %s %s", resp.StatusCode, token.Error, token.ErrorDescription)
+	}
+
+	if token.IDToken == "" {
+		return "", errors.New("oidc: token endpoint: no id_token; is the openid scope granted?")
+	}
+
+	return token.IDToken, nil
+}
+
+// Verify checks the signature and the claims of the ID token raw, which must carry nonce.
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (*IDToken, error) {
+	m, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "ES256"}))
+
+	claims := &IDToken{}
+	_, err = parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	switch {
+	case claims.Issuer != m.Issuer:
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.VerifyAudience(p.config.ClientID, true):
+		return nil, fmt.Errorf("%w: not meant for this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return nil, fmt.Errorf("%w: authorized party %q", ErrInvalidIDToken, claims.AuthorizedParty)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+// key returns the signing key kid, fetching the keys again when it is unknown, since providers
+// rotate their keys. An empty kid is accepted when the provider has a single key.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+
+	if time.Since(p.keysFetched) < keysRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set JWKS
+	if err := p.get(ctx, m.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc: keys: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// skip the keys we can not use, rather than refusing the whole set
+		if pub, err := jwk.PublicKey(); err == nil {
+			keys[jwk.KeyID] = pub
+		}
+	}
+
+	p.keys = keys
+	p.keysFetched = time.Now()
+
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// lookup finds a cached key; p.mu must be held
+func (p *Provider) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+// get decodes the JSON document at url into v
+func (p *Provider) get(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,287 @@
+// the tests are in their own package, since oidctest imports oidc
+package oidc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/oidc"
+	"webapp/pkg/oidc/oidctest"
+	"webapp/pkg/repository/dbrepo"
+)
+
+const redirectURL = "https://app.example.com/login/oidc/callback"
+
+// login runs the flow f up to the callback, and returns the callback request
+func login(t *testing.T, s *oidctest.Server, p *oidc.Provider, f oidc.Flow) *url.URL {
+	t.Helper()
+
+	authURL, err := p.AuthCodeURL(context.Background(), f)
+	if err != nil {
+		t.Fatalf("unable to build the authorization URL: %s", err)
+	}
+
+	callback, err := s.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("unable to authorize: %s", err)
+	}
+
+	return callback
+}
+
+func Test_Provider_Callback(t *testing.T) {
+
+	s := oidctest.NewServer()
+	defer s.Close()
+
+	testCases := []struct {
+		name        string
+		tamper      func(callback *url.URL, f *oidc.Flow)
+		expectedErr error
+	}{
+		{"valid", func(*url.URL, *oidc.Flow) {}, nil},
+		{"state mismatch", func(_ *url.URL, f *oidc.Flow) { f.State = "other" }, oidc.ErrInvalidState},
+		{"nonce mismatch", func(_ *url.URL, f *oidc.Flow) { f.Nonce = "other" }, oidc.ErrInvalidIDToken},
+		{"verifier mismatch", func(_ *url.URL, f *oidc.Flow) { f.Verifier = strings.Repeat("a", 43) }, errors.New("code_verifier mismatch")},
+		{"no code", func(callback *url.URL, _ *oidc.Flow) {
+			q := callback.Query()
+			q.Del("code")
+			callback.RawQuery = q.Encode()
+		}, errors.New("no code")},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			p := oidc.New(s.Config(redirectURL), s.Client())
+
+			f, _ := oidc.NewFlow()
+			callback := login(t, s, p, f)
+
+			if callback.Host != "app.example.com" || callback.Query().Get("state") != f.State {
+				t.Fatalf("expect a redirect to the callback with the state; got %s", callback)
+			}
+
+			tt.tamper(callback, &f)
+
+			token, err := p.Callback(context.Background(), httptest.NewRequest("GET", callback.String(), nil), f)
+
+			switch {
+			case tt.expectedErr == nil && err != nil:
+				t.Fatalf("expect no error; got %s", err)
+			case tt.expectedErr == nil:
+				if token.Subject != "248289761001" || token.Email != "jane@example.com" || !token.EmailVerified {
+					t.Errorf("expect the claims of the test user; got %+v", token)
+				}
+			case err == nil:
+				t.Errorf("expect error %q; got none", tt.expectedErr)
+			case !errors.Is(err, tt.expectedErr) && !strings.Contains(err.Error(), tt.expectedErr.Error()):
+				t.Errorf("expect error %q; got %q", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func Test_Provider_Verify_audience(t *testing.T) {
+
+	s := oidctest.NewServer()
+	defer s.Close()
+
+	p := oidc.New(s.Config(redirectURL), s.Client())
+	f, _ := oidc.NewFlow()
+	raw := exchangeRaw(t, s, login(t, s, p, f), f)
+
+	if _, err := p.Verify(context.Background(), raw, f.Nonce); err != nil {
+		t.Fatalf("expect the token to be valid; got %s", err)
+	}
+
+	// another client of the same provider must not accept the token
+	cfg := s.Config(redirectURL)
+	cfg.ClientID = "other"
+	other := oidc.New(cfg, s.Client())
+
+	if _, err := other.Verify(context.Background(), raw, f.Nonce); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Errorf("expect ErrInvalidIDToken for another audience; got %v", err)
+	}
+
+	if _, err := p.Verify(context.Background(), raw[:len(raw)-4]+"AAAA", f.Nonce); !errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Errorf("expect ErrInvalidIDToken for a bad signature; got %v", err)
+	}
+}
+
+// exchangeRaw returns the raw ID token of the callback, as the token endpoint issues it
+func exchangeRaw(t *testing.T, s *oidctest.Server, callback *url.URL, f oidc.Flow) string {
+	t.Helper()
+
+	resp, err := s.Client().PostForm(s.URL+"/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {oidctest.ClientID},
+		"code":          {callback.Query().Get("code")},
+		"redirect_uri":  {redirectURL},
+		"code_verifier": {f.Verifier},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.IDToken == "" {
+		t.Fatalf("expect an id_token; got %v", err)
+	}
+
+	return body.IDToken
+}
+
+func Test_Provider_discoveryIssuerMismatch(t *testing.T) {
+
+	s := oidctest.NewServer()
+	defer s.Close()
+
+	cfg := s.Config(redirectURL)
+	cfg.Issuer = strings.Replace(s.URL, "127.0.0.1", "localhost", 1)
+
+	if _, err := oidc.New(cfg, s.Client()).Metadata(context.Background()); err == nil {
+		t.Error("expect the discovery of another issuer to fail")
+	}
+}
+
+func Test_Provider_SignIn(t *testing.T) {
+
+	s := oidctest.NewServer()
+	defer s.Close()
+
+	testCases := []struct {
+		name          string
+		user          oidctest.User
+		expectErr     error
+		expectUserID  int
+		expectLinked  bool
+		expectCreated bool
+	}{
+		{"existing user by email", oidctest.User{Subject: "1", Email: "Admin@example.com", EmailVerified: true}, nil, 1, true, false},
+		{"new user", oidctest.User{Subject: "2", Email: "jane@example.com", EmailVerified: true, GivenName: "Jane", FamilyName: "Doe"}, nil, 100, true, true},
+		{"already linked", oidctest.User{Subject: "2", Email: "changed@example.com"}, nil, 100, false, false},
+		{"unverified email", oidctest.User{Subject: "3", Email: "admin@example.com"}, oidc.ErrEmailNotVerified, 0, false, false},
+	}
+
+	db := &dbrepo.MockDBRepo{}
+	p := oidc.New(s.Config(redirectURL), s.Client())
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			s.Login(tt.user)
+
+			f, _ := oidc.NewFlow()
+			callback := login(t, s, p, f)
+
+			token, err := p.Callback(context.Background(), httptest.NewRequest("GET", callback.String(), nil), f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			user, linked, created, err := p.SignIn(context.Background(), db, token)
+			if !errors.Is(err, tt.expectErr) {
+				t.Fatalf("expect error %v; got %v", tt.expectErr, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if user.ID != tt.expectUserID || linked != tt.expectLinked || created != tt.expectCreated {
+				t.Errorf("expect user %d, linked %v, created %v; got %d, %v, %v", tt.expectUserID, tt.expectLinked, tt.expectCreated, user.ID, linked, created)
+			}
+
+			if created && (user.FirstName != "Jane" || user.LastName != "Doe" || len(user.Password) < 43) {
+				t.Errorf("expect the new user to get the name and a random password; got %+v", user)
+			}
+		})
+	}
+}
+
+func Test_Provider_SignIn_deletedUser(t *testing.T) {
+
+	s := oidctest.NewServer()
+	defer s.Close()
+
+	db := &dbrepo.MockDBRepo{}
+	p := oidc.New(s.Config(redirectURL), s.Client())
+	s.Login(oidctest.User{Subject: "4", Email: "gone@example.com", EmailVerified: true})
+
+	signIn := func() (*data.User, error) {
+		f, _ := oidc.NewFlow()
+		token, err := p.Callback(context.Background(), httptest.NewRequest("GET", login(t, s, p, f).String(), nil), f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user, _, _, err := p.SignIn(context.Background(), db, token)
+		return user, err
+	}
+
+	user, err := signIn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteUser(context.Background(), user.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the identity is still linked to the deleted user, which an admin may restore
+	if _, err := signIn(); !errors.Is(err, oidc.ErrAccountDeleted) {
+		t.Errorf("expect ErrAccountDeleted for the identity of a deleted user; got %v", err)
+	}
+}
+
+func Test_Config_Validate(t *testing.T) {
+
+	testCases := []struct {
+		name      string
+		config    oidc.Config
+		expectErr bool
+	}{
+		{"disabled", oidc.Config{}, false},
+		{"valid", oidc.Config{Issuer: "https://accounts.example.com", ClientID: "webapp", RedirectURL: redirectURL}, false},
+		{"no client id", oidc.Config{Issuer: "https://accounts.example.com", RedirectURL: redirectURL}, true},
+		{"relative redirect", oidc.Config{Issuer: "https://accounts.example.com", ClientID: "webapp", RedirectURL: "/callback"}, true},
+		{"invalid issuer", oidc.Config{Issuer: "accounts.example.com", ClientID: "webapp", RedirectURL: redirectURL}, true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.expectErr {
+				t.Errorf("expect error %v; got %v", tt.expectErr, err)
+			}
+		})
+	}
+}
+
+func Test_JWK_ec(t *testing.T) {
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	jwk, err := oidc.NewJWK("ec", &key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !key.PublicKey.Equal(pub) {
+		t.Error("expect the decoded key to equal the original one")
+	}
+}
@@ -0,0 +1,266 @@
+// Package oidctest runs an OpenID Connect provider in memory, for the end to end tests of the
+// login flow. It logs in whichever user was set with Login, without asking for a password.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+	"webapp/pkg/oidc"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ClientID is the only client the server knows.
+const ClientID = "webapp-test"
+
+// User is the account at the provider which logs in.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+// grant is an authorization code waiting to be exchanged
+type grant struct {
+	user        User
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+// Server is the provider; URL is its issuer.
+type Server struct {
+	*httptest.Server
+
+	key *rsa.PrivateKey
+	kid string
+
+	mu     sync.Mutex
+	user   User
+	grants map[string]grant
+}
+
+// NewServer starts a provider, which logs in a verified user by default.
+func NewServer() *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("oidctest: %v", err))
+	}
+
+	s := &Server{
+		key:    key,
+		kid:    "test-key",
+		user:   User{Subject: "248289761001", Email: "jane@example.com", EmailVerified: true, GivenName: "Jane", FamilyName: "Doe"},
+		grants: map[string]grant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jwks", s.jwks)
+
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// Config returns the settings of a client of the server, called back at redirectURL.
+func (s *Server) Config(redirectURL string) oidc.Config {
+	return oidc.Config{Issuer: s.URL, ClientID: ClientID, RedirectURL: redirectURL}
+}
+
+// Login sets the user logged in by the next authorization requests.
+func (s *Server) Login(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.user = u
+}
+
+// Authorize follows the redirect of a client to the login page of the provider, and returns
+// the callback URL the provider redirects the browser to.
+func (s *Server) Authorize(authURL string) (*url.URL, error) {
+	client := s.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("oidctest: authorize: status %d", resp.StatusCode)
+	}
+
+	return url.Parse(resp.Header.Get("Location"))
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, oidc.Metadata{
+		Issuer:                s.URL,
+		AuthorizationEndpoint: s.URL + "/authorize",
+		TokenEndpoint:         s.URL + "/token",
+		JWKSURI:               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	jwk, err := oidc.NewJWK(s.kid, &s.key.PublicKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, oidc.JWKS{Keys: []oidc.JWK{jwk}})
+}
+
+// authorize logs the user in at once, and redirects back to the client with a code
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	switch {
+	case q.Get("client_id") != ClientID:
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	case q.Get("response_type") != "code":
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	case q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	s.grants[code] = grant{user: s.user, redirectURI: redirectURI.String(), challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	s.mu.Unlock()
+
+	callback := redirectURI.Query()
+	callback.Set("code", code)
+	callback.Set("state", q.Get("state"))
+	redirectURI.RawQuery = callback.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token exchanges a code for an ID token, once
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "")
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if id, _, ok := r.BasicAuth(); ok {
+		clientID = id
+	}
+	if clientID != ClientID {
+		tokenError(w, "invalid_client", "")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+
+	s.mu.Lock()
+	g, ok := s.grants[code]
+	delete(s.grants, code)
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		tokenError(w, "invalid_grant", "unknown or used code")
+		return
+	case r.PostForm.Get("redirect_uri") != g.redirectURI:
+		tokenError(w, "invalid_grant", "redirect_uri mismatch")
+		return
+	case oidc.S256Challenge(r.PostForm.Get("code_verifier")) != g.challenge:
+		tokenError(w, "invalid_grant", "code_verifier mismatch")
+		return
+	}
+
+	idToken, err := s.sign(g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	accessToken, _ := oidc.RandomString()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// sign issues the ID token of g
+func (s *Server) sign(g grant) (string, error) {
+	if g.user.Subject == "" {
+		return "", errors.New("oidctest: the user has no subject")
+	}
+
+	now := time.Now()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &oidc.IDToken{
+		Email:         g.user.Email,
+		EmailVerified: g.user.EmailVerified,
+		Name:          g.user.GivenName + " " + g.user.FamilyName,
+		GivenName:     g.user.GivenName,
+		FamilyName:    g.user.FamilyName,
+		Nonce:         g.nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.URL,
+			Subject:   g.user.Subject,
+			Audience:  jwt.ClaimStrings{ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	})
+	token.Header["kid"] = s.kid
+
+	return token.SignedString(s.key)
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,49 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// Flow holds the secrets of one login, kept by the client between the redirect to the provider
+// and the callback: State protects the callback from CSRF, Nonce binds the ID token to the
+// login, and Verifier proves to the token endpoint that the code was asked for by this client
+// (PKCE, RFC 7636).
+type Flow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// NewFlow generates the secrets of a login.
+func NewFlow() (Flow, error) {
+	var f Flow
+
+	for _, s := range []*string{&f.State, &f.Nonce, &f.Verifier} {
+		v, err := RandomString()
+		if err != nil {
+			return Flow{}, err
+		}
+		*s = v
+	}
+
+	return f, nil
+}
+
+// RandomString returns 256 random bits, base64url encoded: 43 characters, which is also a valid
+// PKCE verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// S256Challenge is the PKCE code challenge of verifier.
+func S256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,51 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// SignIn returns the local user of the identity vouched for by token: the user already linked
+// to it, else the user with the same email address, which gets linked, else a new user. Only an
+// address the provider has verified can link or create a user, so that nobody takes over an
+// account by registering its address at the provider. linked is true when the identity was
+// linked by this call, and created when the user was created too. An identity linked to a
+// deleted user signs in no one, see ErrAccountDeleted.
+//
+// Created users get a random password: they sign in with the provider until they reset it.
+func (p *Provider) SignIn(ctx context.Context, db repository.DatabaseRepo, token *IDToken) (user *data.User, linked, created bool, err error) {
+	user, err = db.GetUserByExternalIdentity(ctx, p.Issuer(), token.Subject)
+	switch {
+	case errors.Is(err, repository.ErrDeleted):
+		return nil, false, false, ErrAccountDeleted
+	case err == nil || !errors.Is(err, repository.ErrNotFound):
+		return user, false, false, err
+	}
+
+	if token.Email == "" || !token.EmailVerified {
+		return nil, false, false, ErrEmailNotVerified
+	}
+
+	password, err := RandomString()
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	firstName, lastName := token.GivenName, token.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(token.Name), " ")
+	}
+
+	identity := data.ExternalIdentity{Issuer: p.Issuer(), Subject: token.Subject, Email: token.Email}
+	newUser := data.User{Email: token.Email, FirstName: firstName, LastName: lastName, Password: password}
+
+	user, created, err = db.LinkExternalIdentity(ctx, identity, newUser)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	return user, true, created, nil
+}
@@ -0,0 +1,92 @@
+// Package purge permanently removes the users deleted longer than the retention period ago,
+// together with their profile pictures. The web app and the api both run it, so deleted users
+// are purged whichever of them is deployed; running it in several instances does no harm.
+package purge
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Config tells how long deleted users can be restored, and how often they are purged once
+// they no longer can.
+type Config struct {
+	Retention time.Duration `yaml:"retention" toml:"retention" env:"RETENTION" flag:"retention" usage:"how long deleted users are kept before they are purged"`
+	Interval  time.Duration `yaml:"purge_interval" toml:"purge_interval" env:"PURGE_INTERVAL" flag:"purge-interval" usage:"how often deleted users are purged"`
+}
+
+// NewConfig returns the defaults: deleted users are kept 30 days and purged every hour.
+func NewConfig() Config {
+	return Config{Retention: 30 * 24 * time.Hour, Interval: time.Hour}
+}
+
+// Validate checks that both durations are positive.
+func (c Config) Validate() error {
+	if c.Retention <= 0 || c.Interval <= 0 {
+		return errors.New("retention and purge_interval must be positive")
+	}
+
+	return nil
+}
+
+// Store deletes the users, e.g. the repository.
+type Store interface {
+	// PurgeDeletedUsers deletes the users deleted before the given time, and returns the file
+	// names of their profile pictures.
+	PurgeDeletedUsers(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// Run purges the users deleted longer than c.Retention ago from store, and their pictures from
+// dir, every c.Interval until ctx is done.
+func Run(ctx context.Context, store Store, dir string, c Config) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := DeletedUsers(ctx, store, time.Now().Add(-c.Retention), dir)
+			if err != nil {
+				slog.Error("unable to purge deleted users", "error", err)
+				continue
+			}
+
+			if n > 0 {
+				slog.Info("purged deleted users", "pictures", n)
+			}
+		}
+	}
+}
+
+// DeletedUsers removes the users deleted before the given time from store, and their pictures
+// from dir; it returns the number of pictures removed.
+func DeletedUsers(ctx context.Context, store Store, before time.Time, dir string) (int, error) {
+	files, err := store.PurgeDeletedUsers(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+
+	for _, file := range files {
+		// file names come from uploads, never let them point outside of dir
+		err := os.Remove(filepath.Join(dir, filepath.Base(file)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("unable to remove profile picture", "file", file, "error", err)
+			continue
+		}
+
+		if err == nil {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,123 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStore purges the users whose pictures are files, or fails with err
+type testStore struct {
+	files  []string
+	err    error
+	before time.Time
+}
+
+func (s *testStore) PurgeDeletedUsers(_ context.Context, before time.Time) ([]string, error) {
+	s.before = before
+	return s.files, s.err
+}
+
+func TestDeletedUsers(t *testing.T) {
+
+	dir := t.TempDir()
+
+	picture := filepath.Join(dir, "deleted-user.png")
+	if err := os.WriteFile(picture, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	other := filepath.Join(dir, "other.png")
+	if err := os.WriteFile(other, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outside := filepath.Join(t.TempDir(), "outside.png")
+	if err := os.WriteFile(outside, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &testStore{files: []string{"deleted-user.png", "../" + filepath.Base(filepath.Dir(outside)) + "/outside.png"}}
+	before := time.Now()
+
+	n, err := DeletedUsers(context.Background(), store, before, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 || !store.before.Equal(before) {
+		t.Errorf("expect 1 picture of the users deleted before %v to be removed; got %d before %v", before, n, store.before)
+	}
+
+	if _, err := os.Stat(picture); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("expect the picture of the purged user to be removed")
+	}
+
+	if _, err := os.Stat(other); err != nil {
+		t.Error("expect other pictures to be kept")
+	}
+
+	if _, err := os.Stat(outside); err != nil {
+		t.Error("expect the files outside of dir to be kept")
+	}
+
+	// running again must not fail on the missing file
+	n, err = DeletedUsers(context.Background(), store, time.Now(), dir)
+	if err != nil || n != 0 {
+		t.Errorf("expect nothing to be removed and no error; got %d, %v", n, err)
+	}
+
+	store.err = errors.New("database down")
+	if _, err := DeletedUsers(context.Background(), store, time.Now(), dir); !errors.Is(err, store.err) {
+		t.Errorf("expect the error of the store; got %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+
+	dir := t.TempDir()
+	picture := filepath.Join(dir, "deleted-user.png")
+	if err := os.WriteFile(picture, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		Run(ctx, &testStore{files: []string{"deleted-user.png"}}, dir, Config{Retention: time.Hour, Interval: time.Millisecond})
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(picture); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expect the picture to be purged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestConfig_Validate(t *testing.T) {
+
+	if err := NewConfig().Validate(); err != nil {
+		t.Errorf("expect the defaults to be valid; got %v", err)
+	}
+
+	for _, c := range []Config{{Retention: 0, Interval: time.Hour}, {Retention: time.Hour, Interval: -time.Second}} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expect %+v to be refused", c)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests per Per, with bursts of up to Requests. It is written as requests/period,
+// e.g. 10/1m, or off.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// Off disables rate limiting.
+var Off = Limit{}
+
+// Every returns the limit of requests per period.
+func Every(requests int, per time.Duration) Limit {
+	return Limit{Requests: requests, Per: per}
+}
+
+// Enabled tells whether the limit restricts anything.
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// String returns the limit as requests/period.
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+
+	return strconv.Itoa(l.Requests) + "/" + l.Per.String()
+}
+
+// MarshalText writes the limit as requests/period.
+func (l Limit) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText parses requests/period, e.g. 10/1m, or off.
+func (l *Limit) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	if s == "" || s == "off" || s == "0" {
+		*l = Off
+		return nil
+	}
+
+	requests, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return fmt.Errorf("ratelimit: invalid limit %q, use e.g. 10/1m or off", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("ratelimit: invalid number of requests in %q", s)
+	}
+
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("ratelimit: invalid period in %q", s)
+	}
+
+	*l = Every(n, d)
+	return nil
+}
+
+// rate is the number of tokens added to a bucket per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long a refused client must wait for a token.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// bucket is a token bucket, refilled continuously at the rate of its limit
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// fullBucket returns the bucket of a client seen for the first time
+func fullBucket(l Limit, now time.Time) bucket {
+	return bucket{tokens: float64(l.Requests), updated: now}
+}
+
+// take refills the bucket up to now and takes a token if there is one
+func (b *bucket) take(l Limit, now time.Time) Result {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(l.Requests), b.tokens+elapsed.Seconds()*l.rate())
+	}
+	b.updated = now
+
+	res := Result{Limit: l.Requests}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / l.rate())
+	}
+
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((float64(l.Requests) - b.tokens) / l.rate())
+
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// PostgresStore keeps the buckets in the rate_limits table, so that all the instances of the
+// servers share the limits.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+// Take takes a token from the bucket of key. The row is locked for the duration of the
+// transaction, so that concurrent requests take tokens one after the other.
+func (s *PostgresStore) Take(ctx context.Context, key string, l Limit, now time.Time) (Result, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	full := fullBucket(l, now)
+
+	// create the bucket first, so that there is always a row to lock
+	if _, err := tx.ExecContext(ctx, `insert into rate_limits (key, tokens, updated_at) values ($1, $2, $3)
+		on conflict (key) do nothing`, key, full.tokens, full.updated); err != nil {
+		return Result{}, err
+	}
+
+	var b bucket
+	err = tx.QueryRowContext(ctx, `select tokens, updated_at from rate_limits where key = $1 for update`, key).Scan(&b.tokens, &b.updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		// swept in the meantime
+		b = full
+	} else if err != nil {
+		return Result{}, err
+	}
+
+	res := b.take(l, now)
+
+	if _, err := tx.ExecContext(ctx, `update rate_limits set tokens = $2, updated_at = $3 where key = $1`, key, b.tokens, b.updated); err != nil {
+		return Result{}, err
+	}
+
+	return res, tx.Commit()
+}
+
+// Sweep deletes the buckets untouched since before; they have refilled by then.
+func (s *PostgresStore) Sweep(ctx context.Context, before time.Time) error {
+	_, err := s.DB.ExecContext(ctx, `delete from rate_limits where updated_at < $1`, before)
+	return err
+}
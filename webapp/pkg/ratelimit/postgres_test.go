@@ -0,0 +1,134 @@
+//go:build integration
+
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+)
+
+// testPostgres starts a postgres holding the rate_limits table of the migrations
+func testPostgres(t *testing.T) *sql.DB {
+	t.Helper()
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Fatalf("could not connect to docker; is it running? %s", err)
+	}
+
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository:   "postgres",
+		Tag:          "14.5",
+		Env:          []string{"POSTGRES_USER=postgres", "POSTGRES_PASSWORD=postgres", "POSTGRES_DB=ratelimit_test"},
+		ExposedPorts: []string{"5432"},
+		PortBindings: map[docker.Port][]docker.PortBinding{
+			"5432": {{HostIP: "0.0.0.0", HostPort: "5436"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not start postgres: %s", err)
+	}
+	t.Cleanup(func() { _ = pool.Purge(resource) })
+
+	var db *sql.DB
+	if err := pool.Retry(func() error {
+		var err error
+		db, err = sql.Open("pgx", "host=localhost port=5436 user=postgres password=postgres dbname=ratelimit_test sslmode=disable timezone=UTC connect_timeout=5")
+		if err != nil {
+			return err
+		}
+		return db.Ping()
+	}); err != nil {
+		t.Fatalf("could not connect to postgres: %s", err)
+	}
+
+	migration, err := os.ReadFile("../repository/dbrepo/migrations/0005_rate_limits.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec(string(migration)); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestPostgresStore(t *testing.T) {
+
+	db := testPostgres(t)
+	s := &PostgresStore{DB: db}
+	ctx := context.Background()
+	l := Every(10, time.Minute)
+	now := time.Now().UTC()
+
+	t.Run("concurrent requests share the bucket", func(t *testing.T) {
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			allowed int
+		)
+
+		for i := 0; i < 25; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				res, err := s.Take(ctx, "auth:ip:192.0.2.1", l, now)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if res.Allowed {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if allowed != 10 {
+			t.Errorf("expect exactly 10 requests allowed; got %d", allowed)
+		}
+	})
+
+	t.Run("refill", func(t *testing.T) {
+		res, err := s.Take(ctx, "auth:ip:192.0.2.1", l, now.Add(6*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !res.Allowed {
+			t.Errorf("expect a token after 6s at 10/1m; got %+v", res)
+		}
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		if _, err := s.Take(ctx, "auth:ip:192.0.2.2", l, now.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := s.Sweep(ctx, now.Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+		if err := db.QueryRow(`select count(*) from rate_limits`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+
+		if n != 1 {
+			t.Errorf("expect only the recent bucket to be kept; got %d buckets", n)
+		}
+	})
+}
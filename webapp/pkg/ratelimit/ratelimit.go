@@ -0,0 +1,152 @@
+// Package ratelimit limits the rate of requests with token buckets, kept in memory or in
+// postgres, and answers the clients over their limit with 429 Too Many Requests.
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Stores.
+const (
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+)
+
+// DefaultSweepInterval is how often Limiter.Sweep deletes the full buckets.
+const DefaultSweepInterval = time.Minute
+
+// Config selects the store of the buckets. The tags are read by package config.
+type Config struct {
+	Store string `yaml:"store" toml:"store" env:"RATE_LIMIT_STORE" flag:"rate-limit-store" usage:"where the rate limits are kept: memory, per instance, or postgres, shared by all instances"`
+}
+
+// Validate checks the store.
+func (c Config) Validate() error {
+	switch c.Store {
+	case StoreMemory, StorePostgres:
+		return nil
+	default:
+		return fmt.Errorf("rate_limit.store must be %s or %s; got %q", StoreMemory, StorePostgres, c.Store)
+	}
+}
+
+// NewStore returns the store of the config; db is only used by the postgres store.
+func (c Config) NewStore(db *sql.DB) Store {
+	if c.Store == StorePostgres {
+		return &PostgresStore{DB: db}
+	}
+
+	return NewMemoryStore()
+}
+
+// Store keeps the token buckets.
+type Store interface {
+	// Take takes a token from the bucket of key, created full with limit l if needed.
+	Take(ctx context.Context, key string, l Limit, now time.Time) (Result, error)
+	// Sweep deletes the buckets untouched since before.
+	Sweep(ctx context.Context, before time.Time) error
+}
+
+// KeyFunc returns the client a request is counted against, e.g. its IP address.
+type KeyFunc func(r *http.Request) string
+
+// Limiter enforces limits per group of routes.
+type Limiter struct {
+	store Store
+	// Denied answers the requests over the limit; the rate limit headers are already set. It
+	// defaults to a plain 429.
+	Denied http.HandlerFunc
+	// Failed is called, besides the warning logged, when the store fails and a request of group
+	// is let through, e.g. to count the failures.
+	Failed func(group string)
+
+	now       func() time.Time
+	maxPeriod time.Duration
+}
+
+// New returns a limiter keeping its buckets in store. limits are those the middleware are built
+// with: Sweep only deletes the buckets untouched for the longest of their periods, which have
+// refilled.
+func New(store Store, limits ...Limit) *Limiter {
+	lim := &Limiter{store: store, now: time.Now}
+
+	for _, l := range limits {
+		lim.maxPeriod = max(lim.maxPeriod, l.Per)
+	}
+
+	return lim
+}
+
+// Middleware limits the requests of the group to l per client, as identified by key. Groups
+// have buckets of their own. A store error lets the request through.
+func (lim *Limiter) Middleware(group string, l Limit, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !l.Enabled() {
+			return next
+		}
+
+		policy := strconv.Itoa(l.Requests) + ";w=" + strconv.Itoa(int(math.Ceil(l.Per.Seconds())))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := lim.store.Take(r.Context(), group+":"+key(r), l, lim.now())
+			if err != nil {
+				slog.WarnContext(r.Context(), "rate limit unavailable", "group", group, "error", err)
+				if lim.Failed != nil {
+					lim.Failed(group)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Policy", policy)
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+
+			if !res.Allowed {
+				h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+				slog.InfoContext(r.Context(), "rate limited", "group", group)
+
+				if lim.Denied != nil {
+					lim.Denied(w, r)
+				} else {
+					http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Sweep deletes the full buckets every interval until ctx is done.
+func (lim *Limiter) Sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// a bucket untouched for the longest period has refilled
+			if err := lim.store.Sweep(ctx, lim.now().Add(-lim.maxPeriod)); err != nil {
+				slog.WarnContext(ctx, "unable to sweep the rate limits", "error", err)
+			}
+		}
+	}
+}
+
+// ceilSeconds returns d in whole seconds, rounded up, as the headers require
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,235 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimit_UnmarshalText(t *testing.T) {
+
+	testCases := []struct {
+		name      string
+		text      string
+		expected  Limit
+		expectErr bool
+	}{
+		{"per minute", "10/1m", Every(10, time.Minute), false},
+		{"per second", " 5/1s ", Every(5, time.Second), false},
+		{"off", "off", Off, false},
+		{"empty", "", Off, false},
+		{"zero", "0", Off, false},
+		{"no period", "10", Off, true},
+		{"invalid requests", "ten/1m", Off, true},
+		{"negative requests", "-1/1m", Off, true},
+		{"invalid period", "10/minute", Off, true},
+		{"zero period", "10/0s", Off, true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var l Limit
+			err := l.UnmarshalText([]byte(tt.text))
+
+			if tt.expectErr && err == nil {
+				t.Error("expect an error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("expect no error; got %s", err)
+			}
+			if l != tt.expected {
+				t.Errorf("expect %s; got %s", tt.expected, l)
+			}
+		})
+	}
+
+	if got := Every(10, time.Minute).String(); got != "10/1m0s" {
+		t.Errorf("expect 10/1m0s; got %s", got)
+	}
+}
+
+func Test_bucket_take(t *testing.T) {
+
+	l := Every(2, 2*time.Second) // one token per second
+	now := time.Now()
+	b := fullBucket(l, now)
+
+	steps := []struct {
+		after           time.Duration
+		expectAllowed   bool
+		expectRemaining int
+		expectRetry     time.Duration
+		expectReset     time.Duration
+	}{
+		{0, true, 1, 0, time.Second},
+		{0, true, 0, 0, 2 * time.Second},
+		{0, false, 0, time.Second, 2 * time.Second},
+		{500 * time.Millisecond, false, 0, 500 * time.Millisecond, 1500 * time.Millisecond},
+		{500 * time.Millisecond, true, 0, 0, 2 * time.Second},
+		{time.Hour, true, 1, 0, time.Second},
+	}
+
+	for i, s := range steps {
+		now = now.Add(s.after)
+		res := b.take(l, now)
+
+		if res.Allowed != s.expectAllowed || res.Remaining != s.expectRemaining || res.Limit != 2 {
+			t.Errorf("step %d: expect allowed %t with %d remaining; got %+v", i, s.expectAllowed, s.expectRemaining, res)
+		}
+
+		if res.RetryAfter.Round(time.Millisecond) != s.expectRetry || res.Reset.Round(time.Millisecond) != s.expectReset {
+			t.Errorf("step %d: expect retry after %s and reset %s; got %s and %s", i, s.expectRetry, s.expectReset, res.RetryAfter, res.Reset)
+		}
+	}
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+
+	now := time.Now()
+	lim := New(NewMemoryStore())
+	lim.now = func() time.Time { return now }
+
+	key := func(r *http.Request) string { return r.Header.Get("X-Client") }
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	auth := lim.Middleware("auth", Every(2, time.Minute), key)(next)
+	users := lim.Middleware("users", Every(1, time.Minute), key)(next)
+	off := lim.Middleware("off", Off, key)(next)
+
+	testCases := []struct {
+		name            string
+		handler         http.Handler
+		client          string
+		expectStatus    int
+		expectRemaining string
+		expectRetry     string
+	}{
+		{"first", auth, "a", http.StatusOK, "1", ""},
+		{"second", auth, "a", http.StatusOK, "0", ""},
+		{"over the limit", auth, "a", http.StatusTooManyRequests, "0", "30"},
+		{"other client", auth, "b", http.StatusOK, "1", ""},
+		{"other group", users, "a", http.StatusOK, "0", ""},
+		{"off", off, "a", http.StatusOK, "", ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header.Set("X-Client", tt.client)
+			rr := httptest.NewRecorder()
+
+			tt.handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectStatus {
+				t.Errorf("expect status %d; got %d", tt.expectStatus, rr.Code)
+			}
+
+			if got := rr.Header().Get("RateLimit-Remaining"); got != tt.expectRemaining {
+				t.Errorf("expect %q remaining; got %q", tt.expectRemaining, got)
+			}
+
+			if got := rr.Header().Get("Retry-After"); got != tt.expectRetry {
+				t.Errorf("expect Retry-After %q; got %q", tt.expectRetry, got)
+			}
+
+			if tt.expectRemaining != "" && rr.Header().Get("RateLimit-Limit") == "" {
+				t.Error("expect a RateLimit-Limit header")
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+
+	lim := New(NewMemoryStore(), Every(10, time.Second), Every(2, time.Minute), Off)
+
+	if got := lim.maxPeriod; got != time.Minute {
+		t.Errorf("expect the longest period to be kept; got %s", got)
+	}
+}
+
+func TestLimiter_Denied(t *testing.T) {
+
+	lim := New(NewMemoryStore())
+	lim.Denied = func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}
+
+	h := lim.Middleware("auth", Every(1, time.Hour), func(*http.Request) string { return "a" })(http.NotFoundHandler())
+
+	for _, expected := range []int{http.StatusNotFound, http.StatusTeapot} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rr.Code != expected {
+			t.Errorf("expect status %d; got %d", expected, rr.Code)
+		}
+	}
+}
+
+// failingStore is a store whose database is down
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit, time.Time) (Result, error) {
+	return Result{}, errors.New("database unavailable")
+}
+
+func (failingStore) Sweep(context.Context, time.Time) error {
+	return errors.New("database unavailable")
+}
+
+func TestLimiter_storeError(t *testing.T) {
+
+	failed := map[string]int{}
+	lim := New(failingStore{})
+	lim.Failed = func(group string) { failed[group]++ }
+	h := lim.Middleware("auth", Every(1, time.Hour), func(*http.Request) string { return "a" })(http.NotFoundHandler())
+
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expect the request to go through when the store fails; got %d", rr.Code)
+		}
+	}
+
+	if failed["auth"] != 3 {
+		t.Errorf("expect 3 failures of the auth group; got %v", failed)
+	}
+}
+
+func TestMemoryStore_Sweep(t *testing.T) {
+
+	s := NewMemoryStore()
+	now := time.Now()
+	l := Every(1, time.Minute)
+
+	_, _ = s.Take(context.Background(), "old", l, now.Add(-2*time.Minute))
+	_, _ = s.Take(context.Background(), "recent", l, now)
+
+	if err := s.Sweep(context.Background(), now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.buckets["old"]; ok {
+		t.Error("expect the old bucket to be swept")
+	}
+
+	if _, ok := s.buckets["recent"]; !ok {
+		t.Error("expect the recent bucket to be kept")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+
+	for store, valid := range map[string]bool{StoreMemory: true, StorePostgres: true, "": false, "redis": false} {
+		err := Config{Store: store}.Validate()
+		if valid != (err == nil) {
+			t.Errorf("store %q: expect valid %t; got %v", store, valid, err)
+		}
+	}
+}
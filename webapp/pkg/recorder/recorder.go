@@ -0,0 +1,62 @@
+// Package recorder wraps the http.ResponseWriter of a request to remember the status and the
+// size of the response, for the middleware logging, measuring and tracing requests.
+package recorder
+
+import "net/http"
+
+// Response records the response written through it.
+type Response struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// New returns a recorder writing to w.
+func New(w http.ResponseWriter) *Response {
+	return &Response{ResponseWriter: w}
+}
+
+// WriteHeader records the first status sent.
+func (r *Response) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write counts the bytes of the body; writing sends the 200 status if none was sent yet.
+func (r *Response) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the status sent, which is 200 if the handler did not write anything.
+func (r *Response) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+
+	return r.status
+}
+
+// Bytes returns the size of the body written so far.
+func (r *Response) Bytes() int64 {
+	return r.bytes
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *Response) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Flush keeps streaming responses working.
+func (r *Response) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
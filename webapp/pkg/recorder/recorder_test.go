@@ -0,0 +1,54 @@
+package recorder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponse(t *testing.T) {
+
+	testCases := []struct {
+		name           string
+		handler        http.HandlerFunc
+		expectedStatus int
+		expectedBytes  int64
+	}{
+		{"nothing written", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, 0},
+		{"body only", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("hello")) }, http.StatusOK, 5},
+		{"status and body", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("not found"))
+		}, http.StatusNotFound, 9},
+		{"status sent twice", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusCreated, 0},
+		{"status after the body", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusOK, 2},
+	}
+
+	for _, tt := range testCases {
+		rec := New(httptest.NewRecorder())
+		tt.handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Status() != tt.expectedStatus || rec.Bytes() != tt.expectedBytes {
+			t.Errorf("%s: expect status %d and %d bytes; got %d and %d", tt.name, tt.expectedStatus, tt.expectedBytes, rec.Status(), rec.Bytes())
+		}
+	}
+}
+
+func TestResponse_Flush(t *testing.T) {
+
+	w := httptest.NewRecorder()
+
+	if err := http.NewResponseController(New(w)).Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !w.Flushed {
+		t.Error("expect the underlying writer to be flushed")
+	}
+}
@@ -0,0 +1,31 @@
+package repository
+
+// DefaultAuditPageSize is used when an AuditFilter does not set a page size.
+const DefaultAuditPageSize = 50
+
+// AuditFilter selects a page of the audit log, newest events first. Zero fields do not filter.
+type AuditFilter struct {
+	Action   string
+	ActorID  int
+	TargetID int
+	Page     int
+	PageSize int
+}
+
+// Limit returns the number of events on a page.
+func (f AuditFilter) Limit() int {
+	if f.PageSize <= 0 {
+		return DefaultAuditPageSize
+	}
+
+	return f.PageSize
+}
+
+// Offset returns the number of events before the page; pages start at 1.
+func (f AuditFilter) Offset() int {
+	if f.Page <= 1 {
+		return 0
+	}
+
+	return (f.Page - 1) * f.Limit()
+}
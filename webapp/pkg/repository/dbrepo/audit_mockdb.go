@@ -0,0 +1,50 @@
+package dbrepo
+
+import (
+	"context"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertAuditEvent keeps the event in memory, so that tests can check what was recorded
+func (m *MockDBRepo) InsertAuditEvent(ctx context.Context, e data.AuditEvent) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ID = len(m.auditLog) + 1
+	m.auditLog = append(m.auditLog, e)
+
+	return e.ID, nil
+}
+
+// AuditEvents returns the recorded events matching f, newest first
+func (m *MockDBRepo) AuditEvents(ctx context.Context, f repository.AuditFilter) ([]*data.AuditEvent, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matching []*data.AuditEvent
+
+	for i := len(m.auditLog) - 1; i >= 0; i-- {
+		e := m.auditLog[i]
+		if (f.Action != "" && e.Action != f.Action) ||
+			(f.ActorID != 0 && e.ActorID != f.ActorID) ||
+			(f.TargetID != 0 && e.TargetID != f.TargetID) {
+			continue
+		}
+		matching = append(matching, &e)
+	}
+
+	total := len(matching)
+
+	start := f.Offset()
+	if start > total {
+		start = total
+	}
+
+	end := start + f.Limit()
+	if end > total {
+		end = total
+	}
+
+	return matching[start:end], total, nil
+}
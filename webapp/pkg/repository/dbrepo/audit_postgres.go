@@ -0,0 +1,143 @@
+package dbrepo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertAuditEvent appends an event to the audit log, and returns the ID of the new row
+func (m *PostgresDBRepo) InsertAuditEvent(ctx context.Context, e data.AuditEvent) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var changes []byte
+	if len(e.Changes) > 0 {
+		var err error
+		if changes, err = json.Marshal(e.Changes); err != nil {
+			return 0, err
+		}
+	}
+
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	var newID int
+	stmt := `insert into audit_log (action, actor_id, target_id, service_account_id, email, ip, user_agent, changes, created_at)
+		values ($1, nullif($2, 0), nullif($3, 0), nullif($4, 0), $5, $6, $7, $8, $9) returning id`
+
+	traceStatements(ctx, stmt)
+	err := m.DB.QueryRowContext(ctx, stmt,
+		e.Action,
+		e.ActorID,
+		e.TargetID,
+		e.ServiceAccountID,
+		e.Email,
+		e.IP,
+		e.UserAgent,
+		changes,
+		e.CreatedAt,
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	return newID, nil
+}
+
+// AuditEvents returns one page of the audit log, newest events first, and the number of events
+// matching the filter
+func (m *PostgresDBRepo) AuditEvents(ctx context.Context, f repository.AuditFilter) ([]*data.AuditEvent, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var where []string
+	var args []any
+
+	if f.Action != "" {
+		args = append(args, f.Action)
+		where = append(where, fmt.Sprintf("action = $%d", len(args)))
+	}
+	if f.ActorID != 0 {
+		args = append(args, f.ActorID)
+		where = append(where, fmt.Sprintf("actor_id = $%d", len(args)))
+	}
+	if f.TargetID != 0 {
+		args = append(args, f.TargetID)
+		where = append(where, fmt.Sprintf("target_id = $%d", len(args)))
+	}
+
+	conditions := ""
+	if len(where) > 0 {
+		conditions = "where " + strings.Join(where, " and ")
+	}
+
+	// the total is counted apart: count(*) over() has no row to report it on past the last page
+	countQuery := fmt.Sprintf(`select count(*) from audit_log %s`, conditions)
+
+	query := fmt.Sprintf(`
+		select
+			id, action, coalesce(actor_id, 0), coalesce(target_id, 0),
+			coalesce(service_account_id, 0), email, ip, user_agent, changes, created_at
+		from
+			audit_log
+		%s
+		order by created_at desc, id desc
+		limit $%d offset $%d`, conditions, len(args)+1, len(args)+2)
+
+	traceStatements(ctx, countQuery, query)
+
+	var total int
+	if err := m.DB.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, translateError(err)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, append(args, f.Limit(), f.Offset())...)
+	if err != nil {
+		return nil, 0, translateError(err)
+	}
+	defer rows.Close()
+
+	var events []*data.AuditEvent
+
+	for rows.Next() {
+		var e data.AuditEvent
+		var changes []byte
+
+		err := rows.Scan(
+			&e.ID,
+			&e.Action,
+			&e.ActorID,
+			&e.TargetID,
+			&e.ServiceAccountID,
+			&e.Email,
+			&e.IP,
+			&e.UserAgent,
+			&changes,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return nil, 0, translateError(err)
+		}
+
+		if len(changes) > 0 {
+			if err := json.Unmarshal(changes, &e.Changes); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		events = append(events, &e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, translateError(err)
+	}
+
+	return events, total, nil
+}
@@ -0,0 +1,86 @@
+//go:build integration
+
+package dbrepo
+
+import (
+	"context"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+func Test_PostgresDBRepo_InsertAuditEvent(t *testing.T) {
+
+	events := []data.AuditEvent{
+		{Action: data.AuditLoginFailed, Email: "nobody@localhost.com", IP: "10.0.0.1", UserAgent: "test"},
+		{Action: data.AuditLogin, ActorID: 1, TargetID: 1, IP: "10.0.0.1", UserAgent: "test"},
+		{
+			Action:   data.AuditUserUpdated,
+			ActorID:  1,
+			TargetID: 2,
+			Changes:  map[string]data.AuditChange{"first_name": {From: "Admin", To: "Jane"}},
+		},
+	}
+
+	for _, e := range events {
+		if _, err := testRepo.InsertAuditEvent(context.Background(), e); err != nil {
+			t.Fatalf("unable to insert audit event %s: %s", e.Action, err)
+		}
+	}
+}
+
+func Test_PostgresDBRepo_AuditEvents(t *testing.T) {
+
+	testCases := []struct {
+		name          string
+		filter        repository.AuditFilter
+		expectedCount int
+		expectedTotal int
+		expectedFirst string
+	}{
+		{"all, newest first", repository.AuditFilter{}, 3, 3, data.AuditUserUpdated},
+		{"by action", repository.AuditFilter{Action: data.AuditLogin}, 1, 1, data.AuditLogin},
+		{"by actor", repository.AuditFilter{ActorID: 1}, 2, 2, data.AuditUserUpdated},
+		{"by target", repository.AuditFilter{TargetID: 2}, 1, 1, data.AuditUserUpdated},
+		{"second page", repository.AuditFilter{Page: 2, PageSize: 2}, 1, 3, data.AuditLoginFailed},
+		{"past the end", repository.AuditFilter{Page: 3, PageSize: 2}, 0, 3, ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			events, total, err := testRepo.AuditEvents(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(events) != tt.expectedCount {
+				t.Errorf("expect %d events; got %d", tt.expectedCount, len(events))
+			}
+
+			// the total does not depend on the page, even past the last one
+			if total != tt.expectedTotal {
+				t.Errorf("expect a total of %d; got %d", tt.expectedTotal, total)
+			}
+
+			if len(events) > 0 && events[0].Action != tt.expectedFirst {
+				t.Errorf("expect %s first; got %s", tt.expectedFirst, events[0].Action)
+			}
+		})
+	}
+
+	events, _, _ := testRepo.AuditEvents(context.Background(), repository.AuditFilter{Action: data.AuditUserUpdated})
+	if len(events) == 1 && events[0].Changes["first_name"].To != "Jane" {
+		t.Errorf("expect the changes to be stored; got %v", events[0].Changes)
+	}
+}
+
+func Test_PostgresDBRepo_auditLogAppendOnly(t *testing.T) {
+
+	if _, err := testDB.Exec(`update audit_log set action = 'tampered'`); err == nil {
+		t.Error("expect updates of the audit log to fail")
+	}
+
+	if _, err := testDB.Exec(`delete from audit_log`); err == nil {
+		t.Error("expect deletes from the audit log to fail")
+	}
+}
@@ -0,0 +1,84 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"webapp/pkg/repository"
+
+	"github.com/jackc/pgconn"
+)
+
+// Postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation      = "23505"
+	pgForeignKeyViolation  = "23503"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+	pgQueryCanceled        = "57014"
+	pgAdminShutdown        = "57P01"
+	pgCannotConnectNow     = "57P03"
+)
+
+// translateError maps database/sql and Postgres errors onto the repository errors. The original
+// error is kept in the chain, for logging.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pgErr *pgconn.PgError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w: %w", repository.ErrNotFound, err)
+
+	case errors.As(err, &pgErr):
+		switch {
+		case pgErr.Code == pgUniqueViolation && strings.Contains(pgErr.ConstraintName, "email"):
+			return fmt.Errorf("%w: %w", repository.ErrDuplicateEmail, err)
+		case pgErr.Code == pgUniqueViolation,
+			pgErr.Code == pgForeignKeyViolation,
+			pgErr.Code == pgSerializationFailure,
+			pgErr.Code == pgDeadlockDetected:
+			return fmt.Errorf("%w: %w", repository.ErrConflict, err)
+		// class 08 are connection exceptions, class 53 insufficient resources
+		case strings.HasPrefix(pgErr.Code, "08"),
+			strings.HasPrefix(pgErr.Code, "53"),
+			pgErr.Code == pgQueryCanceled,
+			pgErr.Code == pgAdminShutdown,
+			pgErr.Code == pgCannotConnectNow:
+			return fmt.Errorf("%w: %w", repository.ErrUnavailable, err)
+		}
+
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, sql.ErrConnDone),
+		pgconn.Timeout(err),
+		errors.As(err, &netErr),
+		// pgconn does not export its connect error
+		strings.Contains(err.Error(), "failed to connect to"):
+		return fmt.Errorf("%w: %w", repository.ErrUnavailable, err)
+	}
+
+	return err
+}
+
+// expectRows returns repository.ErrNotFound when an update or delete did not affect any row
+func expectRows(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return translateError(err)
+	}
+
+	if n == 0 {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"webapp/pkg/repository"
+
+	"github.com/jackc/pgconn"
+)
+
+func Test_translateError(t *testing.T) {
+
+	other := errors.New("something else")
+
+	testCases := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{"nil", nil, nil},
+		{"no rows", sql.ErrNoRows, repository.ErrNotFound},
+		{"wrapped no rows", fmt.Errorf("scan: %w", sql.ErrNoRows), repository.ErrNotFound},
+		{"duplicate email", &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: "users_email_key"}, repository.ErrDuplicateEmail},
+		{"duplicate active email", &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: "users_email_active_key"}, repository.ErrDuplicateEmail},
+		{"other unique violation", &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: "users_pkey"}, repository.ErrConflict},
+		{"foreign key", &pgconn.PgError{Code: pgForeignKeyViolation}, repository.ErrConflict},
+		{"serialization", &pgconn.PgError{Code: pgSerializationFailure}, repository.ErrConflict},
+		{"connection exception", &pgconn.PgError{Code: "08006"}, repository.ErrUnavailable},
+		{"too many connections", &pgconn.PgError{Code: "53300"}, repository.ErrUnavailable},
+		{"timeout", context.DeadlineExceeded, repository.ErrUnavailable},
+		{"connection done", sql.ErrConnDone, repository.ErrUnavailable},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, nil},
+		{"unknown", other, other},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := translateError(tt.err)
+
+			if tt.err == nil {
+				if got != nil {
+					t.Errorf("expect nil; got %v", got)
+				}
+				return
+			}
+
+			if tt.expected == nil {
+				for _, e := range []error{repository.ErrNotFound, repository.ErrDuplicateEmail, repository.ErrConflict, repository.ErrUnavailable} {
+					if errors.Is(got, e) {
+						t.Errorf("expect error to stay untranslated; got %v", got)
+					}
+				}
+				return
+			}
+
+			if !errors.Is(got, tt.expected) {
+				t.Errorf("expect %v; got %v", tt.expected, got)
+			}
+
+			// the driver error is kept for the callers logging it or looking at the Postgres code
+			if !errors.Is(got, tt.err) {
+				t.Errorf("expect %v to stay in the chain; got %v", tt.err, got)
+			}
+		})
+	}
+}
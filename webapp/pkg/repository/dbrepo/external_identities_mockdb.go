@@ -0,0 +1,72 @@
+package dbrepo
+
+import (
+	"context"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// GetUserByExternalIdentity returns the user linked by LinkExternalIdentity, or
+// repository.ErrDeleted once DeleteUser deleted it
+func (m *MockDBRepo) GetUserByExternalIdentity(ctx context.Context, issuer, subject string) (*data.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, i := range m.identities {
+		if i.Issuer == issuer && i.Subject == subject {
+			if m.deletedUsers[i.UserID] {
+				return nil, repository.ErrDeleted
+			}
+
+			return m.identityUser(i.UserID), nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// LinkExternalIdentity links the identity to the admin user of the mock when it has its email
+// address, and to a new user otherwise
+func (m *MockDBRepo) LinkExternalIdentity(ctx context.Context, identity data.ExternalIdentity, u data.User) (*data.User, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, i := range m.identities {
+		if i.Issuer == identity.Issuer && i.Subject == identity.Subject {
+			return nil, false, repository.ErrConflict
+		}
+	}
+
+	created := false
+	if strings.EqualFold(identity.Email, mockUser().Email) {
+		identity.UserID = mockUser().ID
+	} else {
+		u.ID = 100 + len(m.createdUsers)
+		u.Version = 1
+		u.CreatedAt = time.Now()
+		u.UpdatedAt = u.CreatedAt
+		m.createdUsers = append(m.createdUsers, u)
+		identity.UserID = u.ID
+		created = true
+	}
+
+	identity.ID = len(m.identities) + 1
+	identity.CreatedAt = time.Now()
+	m.identities = append(m.identities, identity)
+
+	return m.identityUser(identity.UserID), created, nil
+}
+
+// identityUser returns the admin user or a user created by LinkExternalIdentity; m.mu must be held
+func (m *MockDBRepo) identityUser(id int) *data.User {
+	for _, u := range m.createdUsers {
+		if u.ID == id {
+			return &u
+		}
+	}
+
+	u := mockUser()
+	return &u
+}
@@ -0,0 +1,93 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GetUserByExternalIdentity returns the user linked to the subject of issuer, or
+// repository.ErrDeleted when that user is deleted
+func (m *PostgresDBRepo) GetUserByExternalIdentity(ctx context.Context, issuer, subject string) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select i.user_id, u.deleted_at is not null from external_identities i
+		join users u on u.id = i.user_id
+		where i.issuer = $1 and i.subject = $2`
+
+	traceStatements(ctx, query)
+	var userID int
+	var deleted bool
+	if err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(&userID, &deleted); err != nil {
+		return nil, translateError(err)
+	}
+
+	// the identity stays linked until the user is purged, so that a restored user keeps it
+	if deleted {
+		return nil, repository.ErrDeleted
+	}
+
+	return m.GetUser(ctx, userID)
+}
+
+// LinkExternalIdentity links the identity to the user having its email address, whatever its
+// case, or to u, which is created when there is no such user
+func (m *PostgresDBRepo) LinkExternalIdentity(ctx context.Context, identity data.ExternalIdentity, u data.User) (*data.User, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	selectStmt := `select id from users where lower(email) = lower($1) and deleted_at is null for update`
+	insertUserStmt := `insert into users (email, first_name, last_name, password, is_admin, created_at, updated_at)
+		values ($1, $2, $3, $4, 0, $5, $5) returning id`
+	insertIdentityStmt := `insert into external_identities (user_id, issuer, subject, email, created_at)
+		values ($1, $2, $3, $4, $5)`
+
+	traceStatements(ctx, selectStmt, insertUserStmt, insertIdentityStmt)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, translateError(err)
+	}
+	defer tx.Rollback()
+
+	var userID int
+	created := false
+
+	err = tx.QueryRowContext(ctx, selectStmt, identity.Email).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), 12)
+		if err != nil {
+			return nil, false, err
+		}
+
+		err = tx.QueryRowContext(ctx, insertUserStmt, u.Email, u.FirstName, u.LastName, hashedPassword, time.Now()).Scan(&userID)
+		if err != nil {
+			return nil, false, translateError(err)
+		}
+		created = true
+	} else if err != nil {
+		return nil, false, translateError(err)
+	}
+
+	_, err = tx.ExecContext(ctx, insertIdentityStmt, userID, identity.Issuer, identity.Subject, identity.Email, time.Now())
+	if err != nil {
+		return nil, false, translateError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, translateError(err)
+	}
+
+	user, err := m.GetUser(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return user, created, nil
+}
@@ -0,0 +1,71 @@
+//go:build integration
+
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+func Test_PostgresDBRepo_LinkExternalIdentity(t *testing.T) {
+
+	ctx := context.Background()
+	issuer := "https://accounts.example.com"
+
+	existingID, err := testRepo.InsertUser(ctx, data.User{FirstName: "Linked", LastName: "User", Email: "linked@localhost.com", Password: "secret"})
+	if err != nil {
+		t.Fatalf("unable to insert user: %s", err)
+	}
+
+	// the address of an existing user, in another case, links to it
+	existing, created, err := testRepo.LinkExternalIdentity(ctx, data.ExternalIdentity{Issuer: issuer, Subject: "1", Email: "LINKED@localhost.com"}, data.User{Email: "LINKED@localhost.com", Password: "random"})
+	if err != nil {
+		t.Fatalf("unable to link identity: %s", err)
+	}
+
+	if created || existing.ID != existingID {
+		t.Errorf("expect the identity to be linked to user %d; got %+v, created %v", existingID, existing, created)
+	}
+
+	user, created, err := testRepo.LinkExternalIdentity(ctx, data.ExternalIdentity{Issuer: issuer, Subject: "2", Email: "sso@localhost.com"}, data.User{Email: "sso@localhost.com", FirstName: "Jane", Password: "random"})
+	if err != nil {
+		t.Fatalf("unable to link identity: %s", err)
+	}
+
+	if !created || user.FirstName != "Jane" || user.IsAdmin != 0 {
+		t.Errorf("expect a new user who is not an admin; got %+v, created %v", user, created)
+	}
+
+	linked, err := testRepo.GetUserByExternalIdentity(ctx, issuer, "2")
+	if err != nil || linked.ID != user.ID {
+		t.Errorf("expect user %d for the identity; got %v %v", user.ID, linked, err)
+	}
+
+	if _, err := testRepo.GetUserByExternalIdentity(ctx, "https://other.example.com", "2"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expect ErrNotFound for another issuer; got %v", err)
+	}
+
+	if _, _, err := testRepo.LinkExternalIdentity(ctx, data.ExternalIdentity{Issuer: issuer, Subject: "2", Email: "sso@localhost.com"}, data.User{Email: "sso@localhost.com", Password: "random"}); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expect ErrConflict for an identity linked twice; got %v", err)
+	}
+
+	// the identity of a deleted user stays linked to it, until it is restored or purged
+	if err := testRepo.DeleteUser(ctx, user.ID, 0); err != nil {
+		t.Fatalf("unable to delete user: %s", err)
+	}
+
+	if _, err := testRepo.GetUserByExternalIdentity(ctx, issuer, "2"); !errors.Is(err, repository.ErrDeleted) || !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expect ErrDeleted, matching ErrNotFound, for the identity of a deleted user; got %v", err)
+	}
+
+	if err := testRepo.RestoreUser(ctx, user.ID); err != nil {
+		t.Fatalf("unable to restore user: %s", err)
+	}
+
+	if restored, err := testRepo.GetUserByExternalIdentity(ctx, issuer, "2"); err != nil || restored.ID != user.ID {
+		t.Errorf("expect the restored user %d for the identity; got %v %v", user.ID, restored, err)
+	}
+}
@@ -0,0 +1,151 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"sort"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLock is the key of the advisory lock that keeps the api and the web server from
+// migrating at the same time
+const migrationLock = 4242
+
+const migrationTimeout = time.Minute
+
+const createMigrationsTable = `create table if not exists public.schema_migrations (
+	version character varying(255) primary key,
+	applied_at timestamp without time zone not null default now()
+)`
+
+// migrationNames returns the embedded migrations in the order they must be applied
+func migrationNames() ([]string, error) {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// Migrate applies the embedded migrations that have not been applied yet, each one in its own
+// transaction. sql/users.sql is the baseline schema the migrations build upon.
+func Migrate(db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return translateError(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `select pg_advisory_lock($1)`, migrationLock); err != nil {
+		return translateError(err)
+	}
+	defer conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, migrationLock)
+
+	if _, err := conn.ExecContext(ctx, createMigrationsTable); err != nil {
+		return translateError(err)
+	}
+
+	pending, err := pendingMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range pending {
+		content, err := migrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return translateError(err)
+		}
+
+		if _, err := tx.ExecContext(ctx, string(content)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `insert into public.schema_migrations (version) values ($1)`, path.Base(name)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+
+		slog.Info("applied migration", "migration", name)
+	}
+
+	return nil
+}
+
+// PendingMigrations returns the migrations that have not been applied to db yet, e.g. for a readiness check.
+func PendingMigrations(ctx context.Context, db *sql.DB) ([]string, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer conn.Close()
+
+	var exists bool
+	err = conn.QueryRowContext(ctx, `select to_regclass('public.schema_migrations') is not null`).Scan(&exists)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	if !exists {
+		return migrationNames()
+	}
+
+	return pendingMigrations(ctx, conn)
+}
+
+func pendingMigrations(ctx context.Context, conn *sql.Conn) ([]string, error) {
+	names, err := migrationNames()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `select version from public.schema_migrations`)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	applied := map[string]bool{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, translateError(err)
+		}
+		applied[version] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	var pending []string
+	for _, name := range names {
+		if !applied[path.Base(name)] {
+			pending = append(pending, name)
+		}
+	}
+
+	return pending, nil
+}
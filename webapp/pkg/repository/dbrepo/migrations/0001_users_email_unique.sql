@@ -0,0 +1,7 @@
+-- users created before the unique constraint was added to sql/users.sql
+do $$
+begin
+    if not exists (select 1 from pg_constraint where conname = 'users_email_key') then
+        alter table public.users add constraint users_email_key unique (email);
+    end if;
+end $$;
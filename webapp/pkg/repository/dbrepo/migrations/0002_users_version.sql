@@ -0,0 +1,2 @@
+-- version is incremented on every update, for optimistic concurrency control
+alter table public.users add column if not exists version integer not null default 1;
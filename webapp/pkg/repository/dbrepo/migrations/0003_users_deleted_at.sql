@@ -0,0 +1,4 @@
+-- deleted users are kept until the retention period has passed, see PurgeDeletedUsers
+alter table public.users add column if not exists deleted_at timestamp;
+
+create index if not exists users_deleted_at_idx on public.users (deleted_at) where deleted_at is not null;
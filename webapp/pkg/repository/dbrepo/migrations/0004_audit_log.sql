@@ -0,0 +1,28 @@
+-- the audit log is append-only: rows can be inserted, but never updated or deleted
+create table if not exists public.audit_log (
+    id bigserial primary key,
+    action character varying(64) not null,
+    actor_id integer,
+    target_id integer,
+    email character varying(255) not null default '',
+    ip character varying(64) not null default '',
+    user_agent text not null default '',
+    changes jsonb,
+    created_at timestamp without time zone not null default now()
+);
+
+create index if not exists audit_log_created_at_idx on public.audit_log (created_at desc);
+create index if not exists audit_log_actor_id_idx on public.audit_log (actor_id);
+create index if not exists audit_log_target_id_idx on public.audit_log (target_id);
+
+create or replace function public.audit_log_append_only() returns trigger
+    language plpgsql as $$
+begin
+    raise exception 'audit_log is append-only';
+end $$;
+
+drop trigger if exists audit_log_append_only on public.audit_log;
+
+create trigger audit_log_append_only
+    before update or delete or truncate on public.audit_log
+    for each statement execute function public.audit_log_append_only();
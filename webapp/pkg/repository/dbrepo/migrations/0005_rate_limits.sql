@@ -0,0 +1,8 @@
+-- token buckets of the rate limiter, shared by all the instances of the servers
+create table if not exists public.rate_limits (
+    key character varying(255) primary key,
+    tokens double precision not null,
+    updated_at timestamp with time zone not null
+);
+
+create index if not exists rate_limits_updated_at_idx on public.rate_limits (updated_at);
@@ -0,0 +1,29 @@
+-- service accounts authenticate with API keys, of which only a hash is stored
+create table if not exists public.service_accounts (
+    id integer generated always as identity primary key,
+    name character varying(100) not null,
+    description character varying(255) not null default '',
+    created_by integer references public.users (id) on delete set null,
+    created_at timestamp without time zone not null default now(),
+    constraint service_accounts_name_key unique (name)
+);
+
+create table if not exists public.api_keys (
+    id integer generated always as identity primary key,
+    service_account_id integer not null references public.service_accounts (id) on delete cascade,
+    name character varying(100) not null default '',
+    prefix character varying(32) not null,
+    hash character(64) not null,
+    -- space separated, as in OAuth
+    scopes text not null default '',
+    created_at timestamp without time zone not null default now(),
+    expires_at timestamp without time zone,
+    last_used_at timestamp without time zone,
+    revoked_at timestamp without time zone,
+    constraint api_keys_prefix_key unique (prefix)
+);
+
+create index if not exists api_keys_service_account_id_idx on public.api_keys (service_account_id);
+
+-- requests made with an API key are recorded against its service account
+alter table public.audit_log add column if not exists service_account_id integer;
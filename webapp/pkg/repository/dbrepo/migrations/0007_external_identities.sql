@@ -0,0 +1,13 @@
+-- identities of the users at OpenID Connect providers; a provider identifies a user by its
+-- subject, which unlike the email address never changes
+create table if not exists public.external_identities (
+    id integer generated always as identity primary key,
+    user_id integer not null references public.users (id) on delete cascade,
+    issuer character varying(255) not null,
+    subject character varying(255) not null,
+    email character varying(255) not null default '',
+    created_at timestamp without time zone not null default now(),
+    constraint external_identities_issuer_subject_key unique (issuer, subject)
+);
+
+create index if not exists external_identities_user_id_idx on public.external_identities (user_id);
@@ -0,0 +1,36 @@
+-- applications obtaining tokens from the api as an OAuth 2.0 authorization server
+create table if not exists public.oauth_clients (
+    client_id character varying(64) primary key,
+    name character varying(100) not null,
+    -- empty for public clients
+    secret_hash character varying(64) not null default '',
+    -- space separated, as in OAuth
+    redirect_uris text not null default '',
+    scopes text not null default '',
+    grant_types text not null default '',
+    created_by integer references public.users (id) on delete set null,
+    created_at timestamp without time zone not null default now()
+);
+
+-- codes live for a minute and are deleted when exchanged
+create table if not exists public.oauth_authorization_codes (
+    hash character(64) primary key,
+    client_id character varying(64) not null references public.oauth_clients (client_id) on delete cascade,
+    user_id integer not null references public.users (id) on delete cascade,
+    redirect_uri text not null,
+    scopes text not null default '',
+    code_challenge character varying(128) not null,
+    nonce character varying(255) not null default '',
+    expires_at timestamp without time zone not null
+);
+
+create index if not exists oauth_authorization_codes_expires_at_idx on public.oauth_authorization_codes (expires_at);
+
+-- the scopes each user granted each client, so that consent is only asked for new scopes
+create table if not exists public.oauth_consents (
+    user_id integer not null references public.users (id) on delete cascade,
+    client_id character varying(64) not null references public.oauth_clients (client_id) on delete cascade,
+    scopes text not null default '',
+    updated_at timestamp without time zone not null default now(),
+    primary key (user_id, client_id)
+);
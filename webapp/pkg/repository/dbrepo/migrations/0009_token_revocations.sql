@@ -0,0 +1,16 @@
+-- access and refresh tokens revoked before they expire, by jti; a row is only kept until the
+-- token would have expired
+create table if not exists public.revoked_tokens (
+    jti character varying(64) primary key,
+    expires_at timestamp without time zone not null,
+    revoked_at timestamp without time zone not null default now()
+);
+
+create index if not exists revoked_tokens_expires_at_idx on public.revoked_tokens (expires_at);
+
+-- the tokens of a user issued at or before issued_before are refused, e.g. after a forced
+-- logout, a password change or the deletion of the user
+create table if not exists public.user_token_cutoffs (
+    user_id integer primary key references public.users (id) on delete cascade,
+    issued_before timestamp without time zone not null
+);
@@ -0,0 +1,6 @@
+-- soft-deleted users no longer reserve their email address until they are purged, and
+-- addresses are unique without regard to case, as the OpenID Connect sign-in matches them.
+-- RestoreUser fails with ErrDuplicateEmail when the address was taken in the meantime.
+alter table public.users drop constraint if exists users_email_key;
+
+create unique index if not exists users_email_active_key on public.users (lower(email)) where deleted_at is null;
@@ -0,0 +1,122 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertOAuthClient keeps the client in memory; client ids are unique, as in postgres
+func (m *MockDBRepo) InsertOAuthClient(ctx context.Context, c data.OAuthClient) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, other := range m.oauthClients {
+		if other.ClientID == c.ClientID {
+			return repository.ErrConflict
+		}
+	}
+
+	c.CreatedAt = time.Now()
+	m.oauthClients = append(m.oauthClients, c)
+
+	return nil
+}
+
+// AllOAuthClients returns the clients in the order they were registered
+func (m *MockDBRepo) AllOAuthClients(ctx context.Context) ([]*data.OAuthClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var clients []*data.OAuthClient
+	for _, c := range m.oauthClients {
+		c := c
+		clients = append(clients, &c)
+	}
+
+	return clients, nil
+}
+
+// GetOAuthClient returns one client by client id
+func (m *MockDBRepo) GetOAuthClient(ctx context.Context, clientID string) (*data.OAuthClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range m.oauthClients {
+		if c.ClientID == clientID {
+			return &c, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// DeleteOAuthClient forgets a client
+func (m *MockDBRepo) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, c := range m.oauthClients {
+		if c.ClientID == clientID {
+			m.oauthClients = append(m.oauthClients[:i], m.oauthClients[i+1:]...)
+			return nil
+		}
+	}
+
+	return repository.ErrNotFound
+}
+
+// InsertAuthorizationCode keeps the code in memory
+func (m *MockDBRepo) InsertAuthorizationCode(ctx context.Context, c data.AuthorizationCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.authorizationCodes == nil {
+		m.authorizationCodes = map[string]data.AuthorizationCode{}
+	}
+	m.authorizationCodes[c.Hash] = c
+
+	return nil
+}
+
+// ConsumeAuthorizationCode returns a code once
+func (m *MockDBRepo) ConsumeAuthorizationCode(ctx context.Context, hash string) (*data.AuthorizationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.authorizationCodes[hash]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	delete(m.authorizationCodes, hash)
+
+	return &c, nil
+}
+
+// OAuthConsent returns the scopes saved by SaveOAuthConsent
+func (m *MockDBRepo) OAuthConsent(ctx context.Context, userID int, clientID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.consents[consentKey{userID, clientID}], nil
+}
+
+// SaveOAuthConsent keeps the consent in memory
+func (m *MockDBRepo) SaveOAuthConsent(ctx context.Context, userID int, clientID string, scopes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.consents == nil {
+		m.consents = map[consentKey][]string{}
+	}
+	m.consents[consentKey{userID, clientID}] = scopes
+
+	return nil
+}
+
+// consentKey identifies the consent of a user to a client
+type consentKey struct {
+	userID   int
+	clientID string
+}
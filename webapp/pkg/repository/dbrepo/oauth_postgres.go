@@ -0,0 +1,196 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+)
+
+// InsertOAuthClient registers an OAuth client, whose id was generated by the caller
+func (m *PostgresDBRepo) InsertOAuthClient(ctx context.Context, c data.OAuthClient) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into oauth_clients (client_id, name, secret_hash, redirect_uris, scopes, grant_types, created_by, created_at)
+		values ($1, $2, $3, $4, $5, $6, nullif($7, 0), $8)`
+
+	traceStatements(ctx, stmt)
+	_, err := m.DB.ExecContext(ctx, stmt,
+		c.ClientID,
+		c.Name,
+		c.SecretHash,
+		strings.Join(c.RedirectURIs, " "),
+		strings.Join(c.Scopes, " "),
+		strings.Join(c.GrantTypes, " "),
+		c.CreatedBy,
+		time.Now(),
+	)
+
+	return translateError(err)
+}
+
+const selectOAuthClient = `select client_id, name, secret_hash, redirect_uris, scopes, grant_types, coalesce(created_by, 0), created_at
+	from oauth_clients`
+
+// scanOAuthClient reads a row of selectOAuthClient
+func scanOAuthClient(scan func(dest ...any) error) (*data.OAuthClient, error) {
+	var c data.OAuthClient
+	var redirectURIs, scopes, grantTypes string
+
+	err := scan(&c.ClientID, &c.Name, &c.SecretHash, &redirectURIs, &scopes, &grantTypes, &c.CreatedBy, &c.CreatedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	c.RedirectURIs = strings.Fields(redirectURIs)
+	c.Scopes = strings.Fields(scopes)
+	c.GrantTypes = strings.Fields(grantTypes)
+
+	return &c, nil
+}
+
+// AllOAuthClients returns the OAuth clients ordered by name
+func (m *PostgresDBRepo) AllOAuthClients(ctx context.Context) ([]*data.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := selectOAuthClient + ` order by name, client_id`
+
+	traceStatements(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var clients []*data.OAuthClient
+
+	for rows.Next() {
+		c, err := scanOAuthClient(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return clients, nil
+}
+
+// GetOAuthClient returns one OAuth client by client id
+func (m *PostgresDBRepo) GetOAuthClient(ctx context.Context, clientID string) (*data.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := selectOAuthClient + ` where client_id = $1`
+
+	traceStatements(ctx, query)
+	return scanOAuthClient(m.DB.QueryRowContext(ctx, query, clientID).Scan)
+}
+
+// DeleteOAuthClient removes an OAuth client, with its codes and the consents given to it
+func (m *PostgresDBRepo) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from oauth_clients where client_id = $1`
+
+	traceStatements(ctx, stmt)
+	res, err := m.DB.ExecContext(ctx, stmt, clientID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return expectRows(res)
+}
+
+// InsertAuthorizationCode stores a code, and deletes the expired ones which were never exchanged
+func (m *PostgresDBRepo) InsertAuthorizationCode(ctx context.Context, c data.AuthorizationCode) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	purgeStmt := `delete from oauth_authorization_codes where expires_at < $1`
+	insertStmt := `insert into oauth_authorization_codes (hash, client_id, user_id, redirect_uri, scopes, code_challenge, nonce, expires_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	traceStatements(ctx, purgeStmt, insertStmt)
+
+	if _, err := m.DB.ExecContext(ctx, purgeStmt, time.Now()); err != nil {
+		return translateError(err)
+	}
+
+	_, err := m.DB.ExecContext(ctx, insertStmt,
+		c.Hash,
+		c.ClientID,
+		c.UserID,
+		c.RedirectURI,
+		strings.Join(c.Scopes, " "),
+		c.CodeChallenge,
+		c.Nonce,
+		c.ExpiresAt,
+	)
+
+	return translateError(err)
+}
+
+// ConsumeAuthorizationCode deletes a code and returns it; a second call for the same code
+// returns ErrNotFound
+func (m *PostgresDBRepo) ConsumeAuthorizationCode(ctx context.Context, hash string) (*data.AuthorizationCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from oauth_authorization_codes where hash = $1
+		returning hash, client_id, user_id, redirect_uri, scopes, code_challenge, nonce, expires_at`
+
+	traceStatements(ctx, stmt)
+	var c data.AuthorizationCode
+	var scopes string
+
+	err := m.DB.QueryRowContext(ctx, stmt, hash).Scan(&c.Hash, &c.ClientID, &c.UserID, &c.RedirectURI, &scopes, &c.CodeChallenge, &c.Nonce, &c.ExpiresAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	c.Scopes = strings.Fields(scopes)
+
+	return &c, nil
+}
+
+// OAuthConsent returns the scopes a user granted a client
+func (m *PostgresDBRepo) OAuthConsent(ctx context.Context, userID int, clientID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select scopes from oauth_consents where user_id = $1 and client_id = $2`
+
+	traceStatements(ctx, query)
+	var scopes string
+	err := m.DB.QueryRowContext(ctx, query, userID, clientID).Scan(&scopes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, translateError(err)
+	}
+
+	return strings.Fields(scopes), nil
+}
+
+// SaveOAuthConsent records the scopes a user granted a client, replacing those granted before
+func (m *PostgresDBRepo) SaveOAuthConsent(ctx context.Context, userID int, clientID string, scopes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into oauth_consents (user_id, client_id, scopes, updated_at) values ($1, $2, $3, $4)
+		on conflict (user_id, client_id) do update set scopes = excluded.scopes, updated_at = excluded.updated_at`
+
+	traceStatements(ctx, stmt)
+	_, err := m.DB.ExecContext(ctx, stmt, userID, clientID, strings.Join(scopes, " "), time.Now())
+
+	return translateError(err)
+}
@@ -0,0 +1,102 @@
+//go:build integration
+
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+func Test_PostgresDBRepo_OAuth(t *testing.T) {
+
+	ctx := context.Background()
+
+	userID, err := testRepo.InsertUser(ctx, data.User{FirstName: "OAuth", LastName: "User", Email: "oauth@localhost.com", Password: "secret"})
+	if err != nil {
+		t.Fatalf("unable to insert user: %s", err)
+	}
+
+	client := data.OAuthClient{
+		ClientID:     "reports",
+		Name:         "Reports",
+		SecretHash:   data.HashAPIKey("secret"),
+		RedirectURIs: []string{"https://app.example.com/callback", "http://localhost:3000/callback"},
+		Scopes:       []string{"openid", "users:read"},
+		GrantTypes:   []string{data.GrantAuthorizationCode, data.GrantRefreshToken},
+		CreatedBy:    userID,
+	}
+
+	if err := testRepo.InsertOAuthClient(ctx, client); err != nil {
+		t.Fatalf("unable to insert client: %s", err)
+	}
+
+	if err := testRepo.InsertOAuthClient(ctx, client); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expect ErrConflict for a client id used twice; got %v", err)
+	}
+
+	got, err := testRepo.GetOAuthClient(ctx, "reports")
+	if err != nil {
+		t.Fatalf("unable to get client: %s", err)
+	}
+
+	if !reflect.DeepEqual(got.RedirectURIs, client.RedirectURIs) || !reflect.DeepEqual(got.GrantTypes, client.GrantTypes) || !got.SecretMatches("secret") || got.CreatedBy != userID {
+		t.Errorf("expect the client as inserted; got %+v", got)
+	}
+
+	// a code is consumed once
+	code := data.AuthorizationCode{
+		Hash:          data.HashAPIKey("code"),
+		ClientID:      "reports",
+		UserID:        userID,
+		RedirectURI:   "https://app.example.com/callback",
+		Scopes:        []string{"openid"},
+		CodeChallenge: "challenge",
+		ExpiresAt:     time.Now().Add(time.Minute),
+	}
+
+	if err := testRepo.InsertAuthorizationCode(ctx, code); err != nil {
+		t.Fatalf("unable to insert code: %s", err)
+	}
+
+	consumed, err := testRepo.ConsumeAuthorizationCode(ctx, code.Hash)
+	if err != nil || consumed.UserID != userID || consumed.CodeChallenge != "challenge" || !reflect.DeepEqual(consumed.Scopes, code.Scopes) {
+		t.Errorf("expect the code as inserted; got %+v %v", consumed, err)
+	}
+
+	if _, err := testRepo.ConsumeAuthorizationCode(ctx, code.Hash); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expect ErrNotFound for a code consumed twice; got %v", err)
+	}
+
+	// consents are replaced
+	if scopes, err := testRepo.OAuthConsent(ctx, userID, "reports"); err != nil || scopes != nil {
+		t.Errorf("expect no consent yet; got %v %v", scopes, err)
+	}
+
+	for _, scopes := range [][]string{{"openid"}, {"openid", "users:read"}} {
+		if err := testRepo.SaveOAuthConsent(ctx, userID, "reports", scopes); err != nil {
+			t.Fatalf("unable to save consent: %s", err)
+		}
+	}
+
+	if scopes, err := testRepo.OAuthConsent(ctx, userID, "reports"); err != nil || !reflect.DeepEqual(scopes, []string{"openid", "users:read"}) {
+		t.Errorf("expect the last consent; got %v %v", scopes, err)
+	}
+
+	// deleting the client deletes its consents
+	if err := testRepo.DeleteOAuthClient(ctx, "reports"); err != nil {
+		t.Fatalf("unable to delete client: %s", err)
+	}
+
+	if err := testRepo.DeleteOAuthClient(ctx, "reports"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expect ErrNotFound for a client deleted twice; got %v", err)
+	}
+
+	if scopes, err := testRepo.OAuthConsent(ctx, userID, "reports"); err != nil || scopes != nil {
+		t.Errorf("expect the consent to be deleted with the client; got %v %v", scopes, err)
+	}
+}
@@ -0,0 +1,57 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+)
+
+// RevokeToken keeps the revocation in memory
+func (m *MockDBRepo) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.revokedTokens == nil {
+		m.revokedTokens = map[string]time.Time{}
+	}
+	m.revokedTokens[jti] = expiresAt
+
+	return nil
+}
+
+// RevokeUserTokens keeps the latest cutoff of the user in memory
+func (m *MockDBRepo) RevokeUserTokens(ctx context.Context, userID int, issuedBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tokenCutoffs == nil {
+		m.tokenCutoffs = map[int]time.Time{}
+	}
+	if issuedBefore.After(m.tokenCutoffs[userID]) {
+		m.tokenCutoffs[userID] = issuedBefore
+	}
+
+	return nil
+}
+
+// Revocations returns copies of the revocations after since
+func (m *MockDBRepo) Revocations(ctx context.Context, since time.Time) (*data.Revocations, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revocations := &data.Revocations{Tokens: map[string]time.Time{}, Users: map[int]time.Time{}}
+
+	for jti, expiresAt := range m.revokedTokens {
+		if expiresAt.After(since) {
+			revocations.Tokens[jti] = expiresAt
+		}
+	}
+
+	for userID, issuedBefore := range m.tokenCutoffs {
+		if issuedBefore.After(since) {
+			revocations.Users[userID] = issuedBefore
+		}
+	}
+
+	return revocations, nil
+}
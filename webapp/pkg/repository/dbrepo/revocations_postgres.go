@@ -0,0 +1,96 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+)
+
+// RevokeToken refuses a token until it expires, and forgets the revocations of expired tokens
+func (m *PostgresDBRepo) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	purgeStmt := `delete from revoked_tokens where expires_at < $1`
+	insertStmt := `insert into revoked_tokens (jti, expires_at, revoked_at) values ($1, $2, $3)
+		on conflict (jti) do nothing`
+
+	traceStatements(ctx, purgeStmt, insertStmt)
+
+	// the columns have no time zone, so every time is stored in UTC
+	now := time.Now().UTC()
+	if _, err := m.DB.ExecContext(ctx, purgeStmt, now); err != nil {
+		return translateError(err)
+	}
+
+	_, err := m.DB.ExecContext(ctx, insertStmt, jti, expiresAt.UTC(), now)
+
+	return translateError(err)
+}
+
+// RevokeUserTokens refuses the tokens of a user issued at or before issuedBefore
+func (m *PostgresDBRepo) RevokeUserTokens(ctx context.Context, userID int, issuedBefore time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `insert into user_token_cutoffs (user_id, issued_before) values ($1, $2)
+		on conflict (user_id) do update set issued_before = greatest(user_token_cutoffs.issued_before, excluded.issued_before)`
+
+	traceStatements(ctx, stmt)
+	_, err := m.DB.ExecContext(ctx, stmt, userID, issuedBefore.UTC())
+
+	return translateError(err)
+}
+
+// Revocations returns the revocations which still matter for the tokens valid after since
+func (m *PostgresDBRepo) Revocations(ctx context.Context, since time.Time) (*data.Revocations, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	tokensQuery := `select jti, expires_at from revoked_tokens where expires_at > $1`
+	usersQuery := `select user_id, issued_before from user_token_cutoffs where issued_before > $1`
+
+	traceStatements(ctx, tokensQuery, usersQuery)
+
+	revocations := &data.Revocations{Tokens: map[string]time.Time{}, Users: map[int]time.Time{}}
+
+	rows, err := m.DB.QueryContext(ctx, tokensQuery, since.UTC())
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, translateError(err)
+		}
+		revocations.Tokens[jti] = expiresAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	rows, err = m.DB.QueryContext(ctx, usersQuery, since.UTC())
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int
+		var issuedBefore time.Time
+		if err := rows.Scan(&userID, &issuedBefore); err != nil {
+			return nil, translateError(err)
+		}
+		revocations.Users[userID] = issuedBefore
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return revocations, nil
+}
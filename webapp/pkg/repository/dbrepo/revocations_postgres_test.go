@@ -0,0 +1,62 @@
+//go:build integration
+
+package dbrepo
+
+import (
+	"context"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+)
+
+func Test_PostgresDBRepo_Revocations(t *testing.T) {
+
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	userID, err := testRepo.InsertUser(ctx, data.User{FirstName: "Revoked", LastName: "User", Email: "revoked@localhost.com", Password: "secret"})
+	if err != nil {
+		t.Fatalf("unable to insert user: %s", err)
+	}
+
+	if err := testRepo.RevokeToken(ctx, "expired", now.Add(-time.Hour)); err != nil {
+		t.Fatalf("unable to revoke token: %s", err)
+	}
+
+	// revoking again, e.g. from another instance, is no error; the expired token is purged
+	for i := 0; i < 2; i++ {
+		if err := testRepo.RevokeToken(ctx, "live", now.Add(time.Hour)); err != nil {
+			t.Fatalf("unable to revoke token: %s", err)
+		}
+	}
+
+	// the cutoff only moves forward
+	for _, cutoff := range []time.Time{now, now.Add(-time.Minute)} {
+		if err := testRepo.RevokeUserTokens(ctx, userID, cutoff); err != nil {
+			t.Fatalf("unable to revoke the tokens of the user: %s", err)
+		}
+	}
+
+	revocations, err := testRepo.Revocations(ctx, now.Add(-30*time.Minute))
+	if err != nil {
+		t.Fatalf("unable to read revocations: %s", err)
+	}
+
+	if _, ok := revocations.Tokens["expired"]; ok || !revocations.Tokens["live"].Equal(now.Add(time.Hour)) {
+		t.Errorf("expect only the live token; got %v", revocations.Tokens)
+	}
+
+	if !revocations.Users[userID].Equal(now) {
+		t.Errorf("expect the latest cutoff %s; got %s", now, revocations.Users[userID])
+	}
+
+	// cutoffs older than since no longer matter
+	revocations, err = testRepo.Revocations(ctx, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("unable to read revocations: %s", err)
+	}
+
+	if _, ok := revocations.Users[userID]; ok {
+		t.Errorf("expect no cutoff after since; got %v", revocations.Users)
+	}
+}
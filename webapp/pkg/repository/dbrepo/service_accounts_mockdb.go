@@ -0,0 +1,164 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertServiceAccount keeps the account in memory; names are unique, as in postgres
+func (m *MockDBRepo) InsertServiceAccount(ctx context.Context, a data.ServiceAccount) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, other := range m.serviceAccounts {
+		if other.Name == a.Name {
+			return 0, repository.ErrConflict
+		}
+	}
+
+	a.ID = len(m.serviceAccounts) + 1
+	a.CreatedAt = time.Now()
+	m.serviceAccounts = append(m.serviceAccounts, a)
+
+	return a.ID, nil
+}
+
+// AllServiceAccounts returns the accounts in the order they were created
+func (m *MockDBRepo) AllServiceAccounts(ctx context.Context) ([]*data.ServiceAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var accounts []*data.ServiceAccount
+	for _, a := range m.serviceAccounts {
+		a := a
+		accounts = append(accounts, &a)
+	}
+
+	return accounts, nil
+}
+
+// GetServiceAccount returns one account by id
+func (m *MockDBRepo) GetServiceAccount(ctx context.Context, id int) (*data.ServiceAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, a := range m.serviceAccounts {
+		if a.ID == id {
+			return &a, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// InsertAPIKey keeps the key in memory
+func (m *MockDBRepo) InsertAPIKey(ctx context.Context, k data.APIKey) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertAPIKey(k), nil
+}
+
+func (m *MockDBRepo) insertAPIKey(k data.APIKey) int {
+	k.ID = len(m.apiKeys) + 1
+	k.CreatedAt = time.Now()
+	m.apiKeys = append(m.apiKeys, k)
+
+	return k.ID
+}
+
+// APIKeys returns the keys of an account, newest first
+func (m *MockDBRepo) APIKeys(ctx context.Context, serviceAccountID int) ([]*data.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []*data.APIKey
+	for i := len(m.apiKeys) - 1; i >= 0; i-- {
+		if k := m.apiKeys[i]; k.ServiceAccountID == serviceAccountID {
+			keys = append(keys, &k)
+		}
+	}
+
+	return keys, nil
+}
+
+// GetAPIKeyByPrefix returns the key with the given prefix
+func (m *MockDBRepo) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*data.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.apiKeys {
+		if k.Prefix == prefix {
+			return &k, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// RotateAPIKey expires the old key at graceUntil and stores k in its place
+func (m *MockDBRepo) RotateAPIKey(ctx context.Context, serviceAccountID, id int, graceUntil time.Time, k data.APIKey) (*data.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old := m.apiKey(serviceAccountID, id)
+	if old == nil {
+		return nil, repository.ErrNotFound
+	}
+
+	if old.ExpiresAt == nil || graceUntil.Before(*old.ExpiresAt) {
+		old.ExpiresAt = &graceUntil
+	}
+
+	k.ServiceAccountID = serviceAccountID
+	k.Name = old.Name
+	k.Scopes = old.Scopes
+	k.ID = m.insertAPIKey(k)
+
+	return &k, nil
+}
+
+// RevokeAPIKey marks a key of an account as revoked
+func (m *MockDBRepo) RevokeAPIKey(ctx context.Context, serviceAccountID, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := m.apiKey(serviceAccountID, id)
+	if k == nil {
+		return repository.ErrNotFound
+	}
+
+	now := time.Now()
+	k.RevokedAt = &now
+
+	return nil
+}
+
+// TouchAPIKey records when a key was last used
+func (m *MockDBRepo) TouchAPIKey(ctx context.Context, id int, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.apiKeys {
+		if m.apiKeys[i].ID == id {
+			m.apiKeys[i].LastUsedAt = &at
+			return nil
+		}
+	}
+
+	return repository.ErrNotFound
+}
+
+// apiKey returns the key id of an account that is not revoked, or nil; m.mu must be held
+func (m *MockDBRepo) apiKey(serviceAccountID, id int) *data.APIKey {
+	for i := range m.apiKeys {
+		k := &m.apiKeys[i]
+		if k.ID == id && k.ServiceAccountID == serviceAccountID && k.RevokedAt == nil {
+			return k
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+)
+
+// InsertServiceAccount creates a service account, and returns its ID
+func (m *PostgresDBRepo) InsertServiceAccount(ctx context.Context, a data.ServiceAccount) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into service_accounts (name, description, created_by, created_at)
+		values ($1, $2, nullif($3, 0), $4) returning id`
+
+	traceStatements(ctx, stmt)
+	err := m.DB.QueryRowContext(ctx, stmt, a.Name, a.Description, a.CreatedBy, time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	return newID, nil
+}
+
+const selectServiceAccount = `select id, name, description, coalesce(created_by, 0), created_at from service_accounts`
+
+// AllServiceAccounts returns the service accounts ordered by name
+func (m *PostgresDBRepo) AllServiceAccounts(ctx context.Context) ([]*data.ServiceAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := selectServiceAccount + ` order by name`
+
+	traceStatements(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var accounts []*data.ServiceAccount
+
+	for rows.Next() {
+		var a data.ServiceAccount
+		if err := rows.Scan(&a.ID, &a.Name, &a.Description, &a.CreatedBy, &a.CreatedAt); err != nil {
+			return nil, translateError(err)
+		}
+		accounts = append(accounts, &a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return accounts, nil
+}
+
+// GetServiceAccount returns one service account by id
+func (m *PostgresDBRepo) GetServiceAccount(ctx context.Context, id int) (*data.ServiceAccount, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := selectServiceAccount + ` where id = $1`
+
+	traceStatements(ctx, query)
+	var a data.ServiceAccount
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&a.ID, &a.Name, &a.Description, &a.CreatedBy, &a.CreatedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &a, nil
+}
+
+// InsertAPIKey stores a key of a service account, and returns its ID
+func (m *PostgresDBRepo) InsertAPIKey(ctx context.Context, k data.APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	k.CreatedAt = time.Now()
+
+	traceStatements(ctx, insertAPIKey)
+	return insertAPIKeyWith(ctx, m.DB, k)
+}
+
+const insertAPIKey = `insert into api_keys (service_account_id, name, prefix, hash, scopes, created_at, expires_at)
+	values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+// queryRower is a *sql.DB or a *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertAPIKeyWith(ctx context.Context, db queryRower, k data.APIKey) (int, error) {
+	var newID int
+	err := db.QueryRowContext(ctx, insertAPIKey,
+		k.ServiceAccountID,
+		k.Name,
+		k.Prefix,
+		k.Hash,
+		strings.Join(k.Scopes, " "),
+		k.CreatedAt,
+		k.ExpiresAt,
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	return newID, nil
+}
+
+const selectAPIKey = `select id, service_account_id, name, prefix, hash, scopes, created_at, expires_at, last_used_at, revoked_at
+	from api_keys`
+
+// scanAPIKey reads a row of selectAPIKey
+func scanAPIKey(scan func(dest ...any) error) (*data.APIKey, error) {
+	var k data.APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	err := scan(&k.ID, &k.ServiceAccountID, &k.Name, &k.Prefix, &k.Hash, &scopes, &k.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	k.Scopes = strings.Fields(scopes)
+	k.ExpiresAt = nullTime(expiresAt)
+	k.LastUsedAt = nullTime(lastUsedAt)
+	k.RevokedAt = nullTime(revokedAt)
+
+	return &k, nil
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+
+	return &t.Time
+}
+
+// APIKeys returns the keys of a service account, newest first
+func (m *PostgresDBRepo) APIKeys(ctx context.Context, serviceAccountID int) ([]*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := selectAPIKey + ` where service_account_id = $1 order by created_at desc, id desc`
+
+	traceStatements(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, serviceAccountID)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var keys []*data.APIKey
+
+	for rows.Next() {
+		k, err := scanAPIKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return keys, nil
+}
+
+// GetAPIKeyByPrefix returns the key with the given prefix, even if it is expired or revoked
+func (m *PostgresDBRepo) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := selectAPIKey + ` where prefix = $1`
+
+	traceStatements(ctx, query)
+	return scanAPIKey(m.DB.QueryRowContext(ctx, query, prefix).Scan)
+}
+
+// RotateAPIKey replaces a key that is not revoked with k, which gets its name and scopes. The
+// old key keeps working until graceUntil, unless it expires earlier.
+func (m *PostgresDBRepo) RotateAPIKey(ctx context.Context, serviceAccountID, id int, graceUntil time.Time, k data.APIKey) (*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	expireStmt := `update api_keys set expires_at = least(coalesce(expires_at, $3), $3)
+		where id = $1 and service_account_id = $2 and revoked_at is null
+		returning name, scopes`
+
+	traceStatements(ctx, expireStmt, insertAPIKey)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer tx.Rollback()
+
+	var scopes string
+	if err := tx.QueryRowContext(ctx, expireStmt, id, serviceAccountID, graceUntil).Scan(&k.Name, &scopes); err != nil {
+		return nil, translateError(err)
+	}
+
+	k.ServiceAccountID = serviceAccountID
+	k.Scopes = strings.Fields(scopes)
+	k.CreatedAt = time.Now()
+
+	newID, err := insertAPIKeyWith(ctx, tx, k)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, translateError(err)
+	}
+
+	k.ID = newID
+	return &k, nil
+}
+
+// RevokeAPIKey revokes a key of a service account for good
+func (m *PostgresDBRepo) RevokeAPIKey(ctx context.Context, serviceAccountID, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update api_keys set revoked_at = $3 where id = $1 and service_account_id = $2 and revoked_at is null`
+
+	traceStatements(ctx, stmt)
+	res, err := m.DB.ExecContext(ctx, stmt, id, serviceAccountID, time.Now())
+	if err != nil {
+		return translateError(err)
+	}
+
+	return expectRows(res)
+}
+
+// TouchAPIKey records that a key was used at, at most once a minute, to spare writes
+func (m *PostgresDBRepo) TouchAPIKey(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update api_keys set last_used_at = $2
+		where id = $1 and (last_used_at is null or last_used_at < $2 - interval '1 minute')`
+
+	traceStatements(ctx, stmt)
+	_, err := m.DB.ExecContext(ctx, stmt, id, at)
+	return translateError(err)
+}
@@ -0,0 +1,86 @@
+//go:build integration
+
+package dbrepo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+func Test_PostgresDBRepo_APIKeys(t *testing.T) {
+
+	ctx := context.Background()
+
+	accountID, err := testRepo.InsertServiceAccount(ctx, data.ServiceAccount{Name: "importer", CreatedBy: 1})
+	if err != nil {
+		t.Fatalf("unable to insert service account: %s", err)
+	}
+
+	if _, err := testRepo.InsertServiceAccount(ctx, data.ServiceAccount{Name: "importer"}); !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expect ErrConflict for a duplicate name; got %v", err)
+	}
+
+	key, prefix, hash, _ := data.NewAPIKey()
+	expiresAt := time.Now().Add(time.Hour)
+	keyID, err := testRepo.InsertAPIKey(ctx, data.APIKey{
+		ServiceAccountID: accountID,
+		Name:             "reader",
+		Prefix:           prefix,
+		Hash:             hash,
+		Scopes:           []string{data.ScopeUsersRead, data.ScopeUsersWrite},
+		ExpiresAt:        &expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("unable to insert API key: %s", err)
+	}
+
+	stored, err := testRepo.GetAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		t.Fatalf("unable to get API key: %s", err)
+	}
+
+	if !stored.Matches(key) || !stored.Active(time.Now()) || len(stored.Scopes) != 2 {
+		t.Errorf("expect an active key matching its secret with two scopes; got %+v", stored)
+	}
+
+	if err := testRepo.TouchAPIKey(ctx, keyID, time.Now()); err != nil {
+		t.Fatalf("unable to touch API key: %s", err)
+	}
+
+	_, newPrefix, newHash, _ := data.NewAPIKey()
+	graceUntil := time.Now().Add(time.Minute)
+	rotated, err := testRepo.RotateAPIKey(ctx, accountID, keyID, graceUntil, data.APIKey{Prefix: newPrefix, Hash: newHash})
+	if err != nil {
+		t.Fatalf("unable to rotate API key: %s", err)
+	}
+
+	if rotated.Name != "reader" || len(rotated.Scopes) != 2 {
+		t.Errorf("expect the rotated key to keep the name and scopes; got %+v", rotated)
+	}
+
+	keys, err := testRepo.APIKeys(ctx, accountID)
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("expect two keys; got %d (%v)", len(keys), err)
+	}
+
+	old := keys[1]
+	if old.LastUsedAt == nil || old.ExpiresAt == nil || old.ExpiresAt.After(graceUntil.Add(time.Second)) {
+		t.Errorf("expect the old key to be used and to expire with the grace period; got %+v", old)
+	}
+
+	if err := testRepo.RevokeAPIKey(ctx, accountID, keyID); err != nil {
+		t.Fatalf("unable to revoke API key: %s", err)
+	}
+
+	if err := testRepo.RevokeAPIKey(ctx, accountID, keyID); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expect ErrNotFound revoking twice; got %v", err)
+	}
+
+	if _, err := testRepo.RotateAPIKey(ctx, accountID, keyID, graceUntil, data.APIKey{Prefix: "wak_revoked", Hash: newHash}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expect ErrNotFound rotating a revoked key; got %v", err)
+	}
+}
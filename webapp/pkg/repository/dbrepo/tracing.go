@@ -0,0 +1,25 @@
+package dbrepo
+
+import (
+	"context"
+	"strings"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceStatements records the SQL run by a repository call on the span in ctx, which is
+// started by tracing.ObserveRepo. Without a recording span it does nothing.
+func traceStatements(ctx context.Context, statements ...string) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	normalized := make([]string, len(statements))
+	for i, stmt := range statements {
+		normalized[i] = strings.Join(strings.Fields(stmt), " ")
+	}
+
+	span.SetAttributes(semconv.DBStatement(strings.Join(normalized, "; ")))
+}
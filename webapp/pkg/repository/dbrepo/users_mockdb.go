@@ -1,15 +1,35 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"sync"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/repository"
 )
 
 type MockDBRepo struct {
+	mu              sync.Mutex
+	auditLog        []data.AuditEvent
+	serviceAccounts []data.ServiceAccount
+	apiKeys         []data.APIKey
+	identities      []data.ExternalIdentity
+	createdUsers    []data.User
+	deletedUsers    map[int]bool
+
+	oauthClients       []data.OAuthClient
+	authorizationCodes map[string]data.AuthorizationCode
+	consents           map[consentKey][]string
+
+	revokedTokens map[string]time.Time
+	tokenCutoffs  map[int]time.Time
 }
 
+// mockPasswordHash is the bcrypt hash of "secret", the password of admin@example.com
+const mockPasswordHash = "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK"
+
 func mockUser() data.User {
 	return data.User{
 		ID:        1,
@@ -17,6 +37,7 @@ func mockUser() data.User {
 		Password:  "secret",
 		FirstName: "Admin",
 		LastName:  "User",
+		Version:   1,
 	}
 }
 
@@ -25,7 +46,7 @@ func (m *MockDBRepo) Connection() *sql.DB {
 }
 
 // AllUsers returns all users as a slice of *data.User
-func (m *MockDBRepo) AllUsers() ([]*data.User, error) {
+func (m *MockDBRepo) AllUsers(ctx context.Context) ([]*data.User, error) {
 
 	var users []*data.User
 
@@ -33,17 +54,18 @@ func (m *MockDBRepo) AllUsers() ([]*data.User, error) {
 }
 
 // GetUser returns one user by id
-func (m *MockDBRepo) GetUser(id int) (*data.User, error) {
+func (m *MockDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
 	if id == 1 {
 		u := mockUser()
+		u.Password = mockPasswordHash
 		return &u, nil
 	}
 
-	return nil, errors.New("user not found")
+	return nil, repository.ErrNotFound
 }
 
 // GetUserByEmail returns one user by email address
-func (m *MockDBRepo) GetUserByEmail(email string) (*data.User, error) {
+func (m *MockDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
 
 	if email == "admin@example.com" {
 		return &data.User{
@@ -51,8 +73,9 @@ func (m *MockDBRepo) GetUserByEmail(email string) (*data.User, error) {
 			FirstName: "Admin",
 			LastName:  "User",
 			Email:     "admin@example.com",
-			Password:  "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
+			Password:  mockPasswordHash,
 			IsAdmin:   1,
+			Version:   1,
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		}, nil
@@ -67,36 +90,82 @@ func (m *MockDBRepo) GetUserByEmail(email string) (*data.User, error) {
 }
 
 // UpdateUser updates one user in the database
-func (m *MockDBRepo) UpdateUser(u data.User) error {
-	if u.ID == 1 {
-		return nil
+func (m *MockDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	if u.ID != 1 {
+		return repository.ErrNotFound
+	}
+
+	if u.Version != 0 && u.Version != mockUser().Version {
+		return repository.ErrVersionMismatch
 	}
-	return errors.New("update failed")
+
+	return nil
 }
 
-// DeleteUser deletes one user from the database, by id
-func (m *MockDBRepo) DeleteUser(id int) error {
+// DeleteUser deletes one user from the database, by id; the users created by
+// LinkExternalIdentity stay deleted
+func (m *MockDBRepo) DeleteUser(ctx context.Context, id, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.createdUsers {
+		if u.ID == id {
+			if m.deletedUsers == nil {
+				m.deletedUsers = map[int]bool{}
+			}
+			m.deletedUsers[id] = true
+			return nil
+		}
+	}
+
 	if id != 1 {
-		return errors.New("user not found")
+		return repository.ErrNotFound
+	}
+
+	if version != 0 && version != mockUser().Version {
+		return repository.ErrVersionMismatch
 	}
+
 	return nil
 }
 
+// RestoreUser undoes DeleteUser; user 2 is the only deleted user of the mock
+func (m *MockDBRepo) RestoreUser(ctx context.Context, id int) error {
+	if id != 2 {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
+
+// PurgeDeletedUsers pretends that one user with a profile picture was purged
+func (m *MockDBRepo) PurgeDeletedUsers(ctx context.Context, before time.Time) ([]string, error) {
+	return []string{"deleted-user.png"}, nil
+}
+
 // InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
-func (m *MockDBRepo) InsertUser(user data.User) (int, error) {
+func (m *MockDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
 	if user.Email == "neo@example.com" {
 		return 1, nil
 	}
 
+	if user.Email == "admin@example.com" {
+		return 0, repository.ErrDuplicateEmail
+	}
+
+	if user.Email == "unavailable@example.com" {
+		return 0, repository.ErrUnavailable
+	}
+
 	return 0, errors.New("unable to insert user")
 }
 
 // ResetPassword is the method we will use to change a user's password.
-func (m *MockDBRepo) ResetPassword(id int, password string) error {
+func (m *MockDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
 	return nil
 }
 
 // InsertUserImage inserts a user profile image into the database.
-func (m *MockDBRepo) InsertUserImage(i data.UserImage) (int, error) {
+func (m *MockDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
 	return 1, nil
 }
@@ -3,10 +3,12 @@ package dbrepo
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/repository"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -22,16 +24,17 @@ func (m *PostgresDBRepo) Connection() *sql.DB {
 }
 
 // AllUsers returns all users as a slice of *data.User
-func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) AllUsers(ctx context.Context) ([]*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at
-	from users order by last_name`
+	query := `select id, email, first_name, last_name, password, is_admin, version, created_at, updated_at
+	from users where deleted_at is null order by last_name`
 
+	traceStatements(ctx, query)
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	defer rows.Close()
 
@@ -46,40 +49,46 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 			&user.LastName,
 			&user.Password,
 			&user.IsAdmin,
+			&user.Version,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
 		if err != nil {
-			log.Println("Error scanning", err)
-			return nil, err
+			slog.Error("unable to scan user", "error", err)
+			return nil, translateError(err)
 		}
 
 		users = append(users, &user)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
 	return users, nil
 }
 
 // GetUser returns one user by id
-func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
-	return m.getOneByField("u.id", id)
+func (m *PostgresDBRepo) GetUser(ctx context.Context, id int) (*data.User, error) {
+	return m.getOneByField(ctx, "u.id", id)
 }
 
-func (m *PostgresDBRepo) getOneByField(field string, value any) (*data.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) getOneByField(ctx context.Context, field string, value any) (*data.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	query := fmt.Sprintf(`
 		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
+			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.version, u.created_at, u.updated_at,
 			coalesce(ui.file_name, '')
 		from 
 			users u
 			left join user_images ui on (ui.user_id = u.id)
 		where 
-		    %s = $1`, field)
+		    %s = $1 and u.deleted_at is null`, field)
 
 	var user data.User
+	traceStatements(ctx, query)
 	row := m.DB.QueryRowContext(ctx, query, value)
 
 	err := row.Scan(
@@ -89,26 +98,27 @@ func (m *PostgresDBRepo) getOneByField(field string, value any) (*data.User, err
 		&user.LastName,
 		&user.Password,
 		&user.IsAdmin,
+		&user.Version,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.ProfilePic.FileName,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	return &user, nil
 }
 
 // GetUserByEmail returns one user by email address
-func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
-	return m.getOneByField("u.email", email)
+func (m *PostgresDBRepo) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	return m.getOneByField(ctx, "u.email", email)
 }
 
-// UpdateUser updates one user in the database
-func (m *PostgresDBRepo) UpdateUser(u data.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// UpdateUser updates one user in the database; repository.ErrNotFound is returned if there is no such user
+func (m *PostgresDBRepo) UpdateUser(ctx context.Context, u data.User) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	stmt := `update users set
@@ -116,44 +126,145 @@ func (m *PostgresDBRepo) UpdateUser(u data.User) error {
 		first_name = $2,
 		last_name = $3,
 		is_admin = $4,
-		updated_at = $5
-		where id = $6
+		updated_at = $5,
+		version = version + 1
+		where id = $6 and ($7 = 0 or version = $7) and deleted_at is null
 	`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	traceStatements(ctx, stmt)
+	res, err := m.DB.ExecContext(ctx, stmt,
 		u.Email,
 		u.FirstName,
 		u.LastName,
 		u.IsAdmin,
 		time.Now(),
 		u.ID,
+		u.Version,
 	)
 
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
-	return nil
+	return m.expectVersion(ctx, res, u.ID)
 }
 
-// DeleteUser deletes one user from the database, by id
-func (m *PostgresDBRepo) DeleteUser(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// DeleteUser marks one user as deleted, by id; repository.ErrNotFound is returned if there is no such user.
+// The row is only removed by PurgeDeletedUsers, so that the user can be restored until then.
+func (m *PostgresDBRepo) DeleteUser(ctx context.Context, id, version int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	stmt := `delete from users where id = $1`
+	stmt := `update users set
+		deleted_at = $1,
+		version = version + 1
+		where id = $2 and ($3 = 0 or version = $3) and deleted_at is null
+	`
+
+	traceStatements(ctx, stmt)
+	res, err := m.DB.ExecContext(ctx, stmt, time.Now(), id, version)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return m.expectVersion(ctx, res, id)
+}
+
+// RestoreUser undoes DeleteUser; repository.ErrNotFound is returned if there is no such deleted user
+func (m *PostgresDBRepo) RestoreUser(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update users set
+		deleted_at = null,
+		updated_at = $1,
+		version = version + 1
+		where id = $2 and deleted_at is not null
+	`
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	traceStatements(ctx, stmt)
+	res, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
 	if err != nil {
+		return translateError(err)
+	}
+
+	return expectRows(res)
+}
+
+// PurgeDeletedUsers permanently removes the users deleted before the given time and their images,
+// in one transaction, and returns the file names of the removed images
+func (m *PostgresDBRepo) PurgeDeletedUsers(ctx context.Context, before time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	// user_images has no cascading foreign key, so the images go first
+	imagesStmt := `delete from user_images where user_id in (
+			select id from users where deleted_at is not null and deleted_at < $1
+		) returning file_name`
+	usersStmt := `delete from users where deleted_at is not null and deleted_at < $1`
+
+	traceStatements(ctx, imagesStmt, usersStmt)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, imagesStmt, before)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var files []string
+
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			return nil, translateError(err)
+		}
+		files = append(files, file)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	if _, err := tx.ExecContext(ctx, usersStmt, before); err != nil {
+		return nil, translateError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, translateError(err)
+	}
+
+	return files, nil
+}
+
+// expectVersion tells apart why a versioned update or delete did not affect any row: the user
+// either does not exist, or is at another version
+func (m *PostgresDBRepo) expectVersion(ctx context.Context, res sql.Result, id int) error {
+	err := expectRows(res)
+	if !errors.Is(err, repository.ErrNotFound) {
 		return err
 	}
 
-	return nil
+	var exists bool
+	if err := m.DB.QueryRowContext(ctx, `select exists(select 1 from users where id = $1 and deleted_at is null)`, id).Scan(&exists); err != nil {
+		return translateError(err)
+	}
+
+	if exists {
+		return repository.ErrVersionMismatch
+	}
+
+	return repository.ErrNotFound
 }
 
 // InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
-func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) InsertUser(ctx context.Context, user data.User) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
@@ -165,6 +276,7 @@ func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
 	stmt := `insert into users (email, first_name, last_name, password, is_admin, created_at, updated_at)
 		values ($1, $2, $3, $4, $5, $6, $7) returning id`
 
+	traceStatements(ctx, stmt)
 	err = m.DB.QueryRowContext(ctx, stmt,
 		user.Email,
 		user.FirstName,
@@ -176,15 +288,15 @@ func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
 	).Scan(&newID)
 
 	if err != nil {
-		return 0, err
+		return 0, translateError(err)
 	}
 
 	return newID, nil
 }
 
 // ResetPassword is the method we will use to change a user's password.
-func (m *PostgresDBRepo) ResetPassword(id int, password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) ResetPassword(ctx context.Context, id int, password string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
@@ -192,31 +304,34 @@ func (m *PostgresDBRepo) ResetPassword(id int, password string) error {
 		return err
 	}
 
-	stmt := `update users set password = $1 where id = $2`
-	_, err = m.DB.ExecContext(ctx, stmt, hashedPassword, id)
+	stmt := `update users set password = $1 where id = $2 and deleted_at is null`
+	traceStatements(ctx, stmt)
+	res, err := m.DB.ExecContext(ctx, stmt, hashedPassword, id)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
-	return nil
+	return expectRows(res)
 }
 
 // InsertUserImage inserts a user profile image into the database.
-func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	stmt := `delete from user_images where user_id = $1`
-	_, err := m.DB.ExecContext(ctx, stmt, i.UserID)
+	deleteStmt := `delete from user_images where user_id = $1`
+	insertStmt := `insert into user_images (user_id, file_name, created_at, updated_at)
+		values ($1, $2, $3, $4) returning id`
+
+	traceStatements(ctx, deleteStmt, insertStmt)
+
+	_, err := m.DB.ExecContext(ctx, deleteStmt, i.UserID)
 	if err != nil {
-		return 0, err
+		return 0, translateError(err)
 	}
 
 	var newID int
-	stmt = `insert into user_images (user_id, file_name, created_at, updated_at)
-		values ($1, $2, $3, $4) returning id`
-
-	err = m.DB.QueryRowContext(ctx, stmt,
+	err = m.DB.QueryRowContext(ctx, insertStmt,
 		i.UserID,
 		i.FileName,
 		time.Now(),
@@ -224,7 +339,7 @@ func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
 	).Scan(&newID)
 
 	if err != nil {
-		return 0, err
+		return 0, translateError(err)
 	}
 
 	return newID, nil
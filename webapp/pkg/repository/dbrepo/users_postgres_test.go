@@ -3,7 +3,9 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -89,6 +91,12 @@ func TestMain(m *testing.M) {
 		log.Fatalf("unable to create tables: %s", err)
 	}
 
+	err = Migrate(testDB)
+	if err != nil {
+		pool.Purge(resource)
+		log.Fatalf("unable to migrate tables: %s", err)
+	}
+
 	testRepo = &PostgresDBRepo{DB: testDB}
 
 	// run tests
@@ -135,7 +143,7 @@ func Test_PostgresDBRepo_InsertUser(t *testing.T) {
 		UpdatedAt: time.Now(),
 	}
 
-	id, err := testRepo.InsertUser(testUser)
+	id, err := testRepo.InsertUser(context.Background(), testUser)
 	if err != nil {
 		t.Errorf("unable to insert user: %s", err)
 	}
@@ -147,7 +155,7 @@ func Test_PostgresDBRepo_InsertUser(t *testing.T) {
 
 func Test_PostgresDBRepo_AllUsers(t *testing.T) {
 
-	users, err := testRepo.AllUsers()
+	users, err := testRepo.AllUsers(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -168,9 +176,9 @@ func Test_PostgresDBRepo_AllUsers(t *testing.T) {
 		UpdatedAt: time.Now(),
 	}
 
-	_, _ = testRepo.InsertUser(testUser)
+	_, _ = testRepo.InsertUser(context.Background(), testUser)
 
-	users, err = testRepo.AllUsers()
+	users, err = testRepo.AllUsers(context.Background())
 	if err != nil {
 		t.Error(err)
 	}
@@ -187,7 +195,7 @@ func Test_PostgresDBRepo_AllUsers(t *testing.T) {
 
 func Test_PostgresDBRepo_GetUser(t *testing.T) {
 
-	user, err := testRepo.GetUser(1)
+	user, err := testRepo.GetUser(context.Background(), 1)
 	if err != nil {
 		t.Error(err)
 	}
@@ -219,7 +227,7 @@ func Test_PostgresDBRepo_GetUserByEmail(t *testing.T) {
 
 	for _, tt := range testCases {
 		t.Run(tt.user.Email, func(t *testing.T) {
-			u, err := testRepo.GetUserByEmail(tt.user.Email)
+			u, err := testRepo.GetUserByEmail(context.Background(), tt.user.Email)
 			if tt.expectNil {
 
 				if err == nil {
@@ -253,7 +261,7 @@ func Test_PostgresDBRepo_GetUserByEmail(t *testing.T) {
 
 func Test_PostgresDBRepo_UpdateUser(t *testing.T) {
 
-	user, err := testRepo.GetUser(1)
+	user, err := testRepo.GetUser(context.Background(), 1)
 	if err != nil {
 		t.Fatalf("GetUser(1) returned an error: %s", err)
 	}
@@ -262,13 +270,13 @@ func Test_PostgresDBRepo_UpdateUser(t *testing.T) {
 	user.LastName = "AB"
 	user.Email = "aj@admin.com"
 
-	err = testRepo.UpdateUser(*user)
+	err = testRepo.UpdateUser(context.Background(), *user)
 
 	if err != nil {
 		t.Errorf("UpdateUser() returned an error: %s", err)
 	}
 
-	newData, _ := testRepo.GetUser(1)
+	newData, _ := testRepo.GetUser(context.Background(), 1)
 	if newData.FirstName != user.FirstName {
 		t.Errorf("failed to update user;")
 	}
@@ -284,25 +292,95 @@ func Test_PostgresDBRepo_UpdateUser(t *testing.T) {
 
 func Test_PostgresDBRepo_DeleteUser(t *testing.T) {
 
-	err := testRepo.DeleteUser(2)
+	err := testRepo.DeleteUser(context.Background(), 2, 0)
 	if err != nil {
 		t.Errorf("DeleteUser(2) returned an error when it shouldn`t")
 	}
 
-	_, err = testRepo.GetUser(2)
-	if err == nil {
-		t.Errorf("user with ID 2 was retrived even though it was deleted")
+	_, err = testRepo.GetUser(context.Background(), 2)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expect GetUser(2) to return ErrNotFound after delete; got %v", err)
+	}
+
+	err = testRepo.DeleteUser(context.Background(), 2, 0)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expect deleting a missing user to return ErrNotFound; got %v", err)
+	}
+}
+
+func Test_PostgresDBRepo_InsertUser_duplicateEmail(t *testing.T) {
+
+	testUser := data.User{
+		FirstName: "Admin",
+		LastName:  "User",
+		Password:  "password",
+		Email:     "admin2@localhost.com",
+	}
+
+	_, err := testRepo.InsertUser(context.Background(), testUser)
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expect ErrDuplicateEmail; got %v", err)
+	}
+}
+
+func Test_PostgresDBRepo_UpdateUser_version(t *testing.T) {
+
+	user, err := testRepo.GetUser(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale := *user
+
+	err = testRepo.UpdateUser(context.Background(), *user)
+	if err != nil {
+		t.Fatalf("UpdateUser() at the current version returned an error: %s", err)
+	}
+
+	updated, _ := testRepo.GetUser(context.Background(), 1)
+	if updated.Version != user.Version+1 {
+		t.Errorf("expect version %d after update; got %d", user.Version+1, updated.Version)
+	}
+
+	err = testRepo.UpdateUser(context.Background(), stale)
+	if !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("expect ErrVersionMismatch for a stale version; got %v", err)
+	}
+
+	err = testRepo.DeleteUser(context.Background(), 1, stale.Version)
+	if !errors.Is(err, repository.ErrVersionMismatch) {
+		t.Errorf("expect ErrVersionMismatch when deleting a stale version; got %v", err)
+	}
+}
+
+func Test_PostgresDBRepo_PendingMigrations(t *testing.T) {
+
+	pending, err := PendingMigrations(context.Background(), testDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pending) != 0 {
+		t.Errorf("expect no pending migrations; got %v", pending)
+	}
+}
+
+func Test_PostgresDBRepo_UpdateUser_missing(t *testing.T) {
+
+	err := testRepo.UpdateUser(context.Background(), data.User{ID: 100, Email: "missing@localhost.com"})
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expect ErrNotFound for a missing user; got %v", err)
 	}
 }
 
 func Test_PostgresDBRepo_ResetPassword(t *testing.T) {
 
-	err := testRepo.ResetPassword(1, "password")
+	err := testRepo.ResetPassword(context.Background(), 1, "password")
 	if err != nil {
 		t.Error(err)
 	}
 
-	u, _ := testRepo.GetUser(1)
+	u, _ := testRepo.GetUser(context.Background(), 1)
 
 	ok, err := u.PasswordMatches("password")
 	if err != nil {
@@ -322,7 +400,7 @@ func Test_PostgresDBRepo_InsertUserImage(t *testing.T) {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	id, err := testRepo.InsertUserImage(img)
+	id, err := testRepo.InsertUserImage(context.Background(), img)
 	if err != nil {
 		t.Error(err)
 	}
@@ -333,12 +411,113 @@ func Test_PostgresDBRepo_InsertUserImage(t *testing.T) {
 
 	img.UserID = 100 // invalid user id
 
-	id, err = testRepo.InsertUserImage(img)
-	if err == nil {
-		t.Errorf("expect InsertUserImage() to return an error for invalid user ID")
+	id, err = testRepo.InsertUserImage(context.Background(), img)
+	if !errors.Is(err, repository.ErrConflict) {
+		t.Errorf("expect InsertUserImage() to return ErrConflict for invalid user ID; got %v", err)
 	}
 
 	if id != 0 {
 		t.Errorf("expect InsertUserImage() to return 0 for an id of invalid user; got %d", id)
 	}
 }
+
+func Test_PostgresDBRepo_RestoreUser(t *testing.T) {
+
+	// user 2 was deleted by Test_PostgresDBRepo_DeleteUser
+	err := testRepo.RestoreUser(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("RestoreUser(2) returned an error: %s", err)
+	}
+
+	if _, err := testRepo.GetUser(context.Background(), 2); err != nil {
+		t.Errorf("expect GetUser(2) to find the restored user; got %v", err)
+	}
+
+	err = testRepo.RestoreUser(context.Background(), 2)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expect restoring a user which is not deleted to return ErrNotFound; got %v", err)
+	}
+}
+
+func Test_PostgresDBRepo_softDeletedEmail(t *testing.T) {
+
+	ctx := context.Background()
+	user := data.User{FirstName: "Deleted", LastName: "User", Password: "password", Email: "reused@localhost.com"}
+
+	deletedID, err := testRepo.InsertUser(ctx, user)
+	if err != nil {
+		t.Fatalf("unable to insert user: %s", err)
+	}
+
+	// the address is unique without regard to case
+	user.Email = "Reused@localhost.com"
+	if _, err := testRepo.InsertUser(ctx, user); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expect ErrDuplicateEmail for the address in another case; got %v", err)
+	}
+
+	if err := testRepo.DeleteUser(ctx, deletedID, 0); err != nil {
+		t.Fatalf("unable to delete user: %s", err)
+	}
+
+	// a deleted user does not reserve the address until the purge
+	newID, err := testRepo.InsertUser(ctx, user)
+	if err != nil {
+		t.Fatalf("expect the address of a deleted user to be free; got %v", err)
+	}
+
+	if err := testRepo.RestoreUser(ctx, deletedID); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expect ErrDuplicateEmail when restoring a user whose address was taken; got %v", err)
+	}
+
+	if err := testRepo.DeleteUser(ctx, newID, 0); err != nil {
+		t.Fatalf("unable to delete user: %s", err)
+	}
+
+	if err := testRepo.RestoreUser(ctx, deletedID); err != nil {
+		t.Errorf("expect the user to be restored once the address is free again; got %v", err)
+	}
+}
+
+func Test_PostgresDBRepo_PurgeDeletedUsers(t *testing.T) {
+
+	_, err := testRepo.InsertUserImage(context.Background(), data.UserImage{UserID: 2, FileName: "admin2.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := testRepo.DeleteUser(context.Background(), 2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := testRepo.PurgeDeletedUsers(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 0 {
+		t.Errorf("expect users within the retention period to be kept; got %v", files)
+	}
+
+	files, err = testRepo.PurgeDeletedUsers(context.Background(), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0] != "admin2.png" {
+		t.Errorf("expect the image of the purged user to be returned; got %v", files)
+	}
+
+	err = testRepo.RestoreUser(context.Background(), 2)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expect a purged user not to be restorable; got %v", err)
+	}
+
+	users, err := testRepo.AllUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 1 {
+		t.Errorf("expect 1 user after the purge; got %d", len(users))
+	}
+}
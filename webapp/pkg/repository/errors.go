@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+)
+
+// These errors are returned, possibly wrapped, by every DatabaseRepo implementation, so that
+// callers can use errors.Is without knowing which database is behind the repository.
+var (
+	// ErrNotFound means that no record matched, or that an update or delete affected no rows.
+	ErrNotFound = errors.New("record not found")
+	// ErrDeleted means that the record exists but was soft deleted, e.g. a user waiting to be
+	// purged. It matches ErrNotFound too, for the callers to whom a deleted record is gone.
+	ErrDeleted = fmt.Errorf("%w: the record was deleted", ErrNotFound)
+	// ErrDuplicateEmail means that another user already has the email address.
+	ErrDuplicateEmail = errors.New("duplicate email")
+	// ErrConflict means that the change clashes with the current state of the data, e.g. a
+	// missing referenced record or a concurrent transaction.
+	ErrConflict = errors.New("conflict")
+	// ErrVersionMismatch means that the record was changed since the version the caller read.
+	ErrVersionMismatch = errors.New("record was modified")
+	// ErrUnavailable means that the database could not be reached or did not answer in time.
+	ErrUnavailable = errors.New("database unavailable")
+)
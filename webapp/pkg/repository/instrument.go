@@ -0,0 +1,281 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"webapp/pkg/data"
+)
+
+// Observer watches the calls of an instrumented DatabaseRepo, e.g. to time or to trace them. It
+// is called with the name of the method before each call, and returns the context the call runs
+// with and a function called with the error of the call once it returned.
+type Observer func(ctx context.Context, method string) (context.Context, func(err error))
+
+// Instrument returns a DatabaseRepo that reports every call to repo to the observers. The first
+// observer is the outermost one: it sees each call start before and end after the others.
+func Instrument(repo DatabaseRepo, observers ...Observer) DatabaseRepo {
+	return &instrumented{next: repo, observers: observers}
+}
+
+type instrumented struct {
+	next      DatabaseRepo
+	observers []Observer
+}
+
+// call reports the start of a call of method to the observers, and returns the context of the
+// call and the function reporting its end
+func (r *instrumented) call(ctx context.Context, method string) (context.Context, func(error)) {
+	ends := make([]func(error), len(r.observers))
+	for i, observe := range r.observers {
+		ctx, ends[i] = observe(ctx, method)
+	}
+
+	return ctx, func(err error) {
+		for i := len(ends) - 1; i >= 0; i-- {
+			ends[i](err)
+		}
+	}
+}
+
+func (r *instrumented) Connection() *sql.DB {
+	return r.next.Connection()
+}
+
+func (r *instrumented) AllUsers(ctx context.Context) ([]*data.User, error) {
+	ctx, done := r.call(ctx, "AllUsers")
+	users, err := r.next.AllUsers(ctx)
+	done(err)
+	return users, err
+}
+
+func (r *instrumented) GetUser(ctx context.Context, id int) (*data.User, error) {
+	ctx, done := r.call(ctx, "GetUser")
+	user, err := r.next.GetUser(ctx, id)
+	done(err)
+	return user, err
+}
+
+func (r *instrumented) GetUserByEmail(ctx context.Context, email string) (*data.User, error) {
+	ctx, done := r.call(ctx, "GetUserByEmail")
+	user, err := r.next.GetUserByEmail(ctx, email)
+	done(err)
+	return user, err
+}
+
+func (r *instrumented) UpdateUser(ctx context.Context, u data.User) error {
+	ctx, done := r.call(ctx, "UpdateUser")
+	err := r.next.UpdateUser(ctx, u)
+	done(err)
+	return err
+}
+
+func (r *instrumented) DeleteUser(ctx context.Context, id, version int) error {
+	ctx, done := r.call(ctx, "DeleteUser")
+	err := r.next.DeleteUser(ctx, id, version)
+	done(err)
+	return err
+}
+
+func (r *instrumented) RestoreUser(ctx context.Context, id int) error {
+	ctx, done := r.call(ctx, "RestoreUser")
+	err := r.next.RestoreUser(ctx, id)
+	done(err)
+	return err
+}
+
+func (r *instrumented) PurgeDeletedUsers(ctx context.Context, before time.Time) ([]string, error) {
+	ctx, done := r.call(ctx, "PurgeDeletedUsers")
+	files, err := r.next.PurgeDeletedUsers(ctx, before)
+	done(err)
+	return files, err
+}
+
+func (r *instrumented) InsertUser(ctx context.Context, user data.User) (int, error) {
+	ctx, done := r.call(ctx, "InsertUser")
+	id, err := r.next.InsertUser(ctx, user)
+	done(err)
+	return id, err
+}
+
+func (r *instrumented) ResetPassword(ctx context.Context, id int, password string) error {
+	ctx, done := r.call(ctx, "ResetPassword")
+	err := r.next.ResetPassword(ctx, id, password)
+	done(err)
+	return err
+}
+
+func (r *instrumented) InsertUserImage(ctx context.Context, i data.UserImage) (int, error) {
+	ctx, done := r.call(ctx, "InsertUserImage")
+	id, err := r.next.InsertUserImage(ctx, i)
+	done(err)
+	return id, err
+}
+
+func (r *instrumented) InsertAuditEvent(ctx context.Context, e data.AuditEvent) (int, error) {
+	ctx, done := r.call(ctx, "InsertAuditEvent")
+	id, err := r.next.InsertAuditEvent(ctx, e)
+	done(err)
+	return id, err
+}
+
+func (r *instrumented) AuditEvents(ctx context.Context, f AuditFilter) ([]*data.AuditEvent, int, error) {
+	ctx, done := r.call(ctx, "AuditEvents")
+	events, total, err := r.next.AuditEvents(ctx, f)
+	done(err)
+	return events, total, err
+}
+
+func (r *instrumented) InsertServiceAccount(ctx context.Context, a data.ServiceAccount) (int, error) {
+	ctx, done := r.call(ctx, "InsertServiceAccount")
+	id, err := r.next.InsertServiceAccount(ctx, a)
+	done(err)
+	return id, err
+}
+
+func (r *instrumented) AllServiceAccounts(ctx context.Context) ([]*data.ServiceAccount, error) {
+	ctx, done := r.call(ctx, "AllServiceAccounts")
+	accounts, err := r.next.AllServiceAccounts(ctx)
+	done(err)
+	return accounts, err
+}
+
+func (r *instrumented) GetServiceAccount(ctx context.Context, id int) (*data.ServiceAccount, error) {
+	ctx, done := r.call(ctx, "GetServiceAccount")
+	account, err := r.next.GetServiceAccount(ctx, id)
+	done(err)
+	return account, err
+}
+
+func (r *instrumented) InsertAPIKey(ctx context.Context, k data.APIKey) (int, error) {
+	ctx, done := r.call(ctx, "InsertAPIKey")
+	id, err := r.next.InsertAPIKey(ctx, k)
+	done(err)
+	return id, err
+}
+
+func (r *instrumented) APIKeys(ctx context.Context, serviceAccountID int) ([]*data.APIKey, error) {
+	ctx, done := r.call(ctx, "APIKeys")
+	keys, err := r.next.APIKeys(ctx, serviceAccountID)
+	done(err)
+	return keys, err
+}
+
+func (r *instrumented) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*data.APIKey, error) {
+	ctx, done := r.call(ctx, "GetAPIKeyByPrefix")
+	key, err := r.next.GetAPIKeyByPrefix(ctx, prefix)
+	done(err)
+	return key, err
+}
+
+func (r *instrumented) RotateAPIKey(ctx context.Context, serviceAccountID, id int, graceUntil time.Time, k data.APIKey) (*data.APIKey, error) {
+	ctx, done := r.call(ctx, "RotateAPIKey")
+	key, err := r.next.RotateAPIKey(ctx, serviceAccountID, id, graceUntil, k)
+	done(err)
+	return key, err
+}
+
+func (r *instrumented) RevokeAPIKey(ctx context.Context, serviceAccountID, id int) error {
+	ctx, done := r.call(ctx, "RevokeAPIKey")
+	err := r.next.RevokeAPIKey(ctx, serviceAccountID, id)
+	done(err)
+	return err
+}
+
+func (r *instrumented) TouchAPIKey(ctx context.Context, id int, at time.Time) error {
+	ctx, done := r.call(ctx, "TouchAPIKey")
+	err := r.next.TouchAPIKey(ctx, id, at)
+	done(err)
+	return err
+}
+
+func (r *instrumented) GetUserByExternalIdentity(ctx context.Context, issuer, subject string) (*data.User, error) {
+	ctx, done := r.call(ctx, "GetUserByExternalIdentity")
+	user, err := r.next.GetUserByExternalIdentity(ctx, issuer, subject)
+	done(err)
+	return user, err
+}
+
+func (r *instrumented) LinkExternalIdentity(ctx context.Context, identity data.ExternalIdentity, u data.User) (*data.User, bool, error) {
+	ctx, done := r.call(ctx, "LinkExternalIdentity")
+	user, created, err := r.next.LinkExternalIdentity(ctx, identity, u)
+	done(err)
+	return user, created, err
+}
+
+func (r *instrumented) InsertOAuthClient(ctx context.Context, c data.OAuthClient) error {
+	ctx, done := r.call(ctx, "InsertOAuthClient")
+	err := r.next.InsertOAuthClient(ctx, c)
+	done(err)
+	return err
+}
+
+func (r *instrumented) AllOAuthClients(ctx context.Context) ([]*data.OAuthClient, error) {
+	ctx, done := r.call(ctx, "AllOAuthClients")
+	clients, err := r.next.AllOAuthClients(ctx)
+	done(err)
+	return clients, err
+}
+
+func (r *instrumented) GetOAuthClient(ctx context.Context, clientID string) (*data.OAuthClient, error) {
+	ctx, done := r.call(ctx, "GetOAuthClient")
+	client, err := r.next.GetOAuthClient(ctx, clientID)
+	done(err)
+	return client, err
+}
+
+func (r *instrumented) DeleteOAuthClient(ctx context.Context, clientID string) error {
+	ctx, done := r.call(ctx, "DeleteOAuthClient")
+	err := r.next.DeleteOAuthClient(ctx, clientID)
+	done(err)
+	return err
+}
+
+func (r *instrumented) InsertAuthorizationCode(ctx context.Context, c data.AuthorizationCode) error {
+	ctx, done := r.call(ctx, "InsertAuthorizationCode")
+	err := r.next.InsertAuthorizationCode(ctx, c)
+	done(err)
+	return err
+}
+
+func (r *instrumented) ConsumeAuthorizationCode(ctx context.Context, hash string) (*data.AuthorizationCode, error) {
+	ctx, done := r.call(ctx, "ConsumeAuthorizationCode")
+	code, err := r.next.ConsumeAuthorizationCode(ctx, hash)
+	done(err)
+	return code, err
+}
+
+func (r *instrumented) OAuthConsent(ctx context.Context, userID int, clientID string) ([]string, error) {
+	ctx, done := r.call(ctx, "OAuthConsent")
+	scopes, err := r.next.OAuthConsent(ctx, userID, clientID)
+	done(err)
+	return scopes, err
+}
+
+func (r *instrumented) SaveOAuthConsent(ctx context.Context, userID int, clientID string, scopes []string) error {
+	ctx, done := r.call(ctx, "SaveOAuthConsent")
+	err := r.next.SaveOAuthConsent(ctx, userID, clientID, scopes)
+	done(err)
+	return err
+}
+
+func (r *instrumented) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, done := r.call(ctx, "RevokeToken")
+	err := r.next.RevokeToken(ctx, jti, expiresAt)
+	done(err)
+	return err
+}
+
+func (r *instrumented) RevokeUserTokens(ctx context.Context, userID int, issuedBefore time.Time) error {
+	ctx, done := r.call(ctx, "RevokeUserTokens")
+	err := r.next.RevokeUserTokens(ctx, userID, issuedBefore)
+	done(err)
+	return err
+}
+
+func (r *instrumented) Revocations(ctx context.Context, since time.Time) (*data.Revocations, error) {
+	ctx, done := r.call(ctx, "Revocations")
+	revocations, err := r.next.Revocations(ctx, since)
+	done(err)
+	return revocations, err
+}
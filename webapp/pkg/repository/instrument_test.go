@@ -0,0 +1,49 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
+)
+
+type callKey struct{}
+
+func TestInstrument(t *testing.T) {
+
+	var events []string
+
+	// observe records the calls seen by the observer name, and passes its name down in ctx
+	observe := func(name string) repository.Observer {
+		return func(ctx context.Context, method string) (context.Context, func(error)) {
+			event := name + " start " + method
+			if outer, ok := ctx.Value(callKey{}).(string); ok {
+				event += " in " + outer
+			}
+			events = append(events, event)
+
+			return context.WithValue(ctx, callKey{}, name), func(err error) {
+				events = append(events, name+" end "+method+" "+strconv.FormatBool(errors.Is(err, repository.ErrNotFound)))
+			}
+		}
+	}
+
+	repo := repository.Instrument(&dbrepo.MockDBRepo{}, observe("outer"), observe("inner"))
+
+	if _, err := repo.GetUser(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = repo.GetUser(context.Background(), 2)
+
+	expected := []string{
+		"outer start GetUser", "inner start GetUser in outer", "inner end GetUser false", "outer end GetUser false",
+		"outer start GetUser", "inner start GetUser in outer", "inner end GetUser true", "outer end GetUser true",
+	}
+
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expect the first observer to be the outermost one:\n%v\ngot:\n%v", expected, events)
+	}
+}
@@ -1,18 +1,75 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"time"
 	"webapp/pkg/data"
 )
 
+// DatabaseRepo is the storage of the application. Deleted users are kept, hidden from every
+// read, until they are restored or purged.
 type DatabaseRepo interface {
 	Connection() *sql.DB
-	AllUsers() ([]*data.User, error)
-	GetUser(id int) (*data.User, error)
-	GetUserByEmail(email string) (*data.User, error)
-	UpdateUser(u data.User) error
-	DeleteUser(id int) error
-	InsertUser(user data.User) (int, error)
-	ResetPassword(id int, password string) error
-	InsertUserImage(i data.UserImage) (int, error)
+	AllUsers(ctx context.Context) ([]*data.User, error)
+	GetUser(ctx context.Context, id int) (*data.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*data.User, error)
+	// UpdateUser and DeleteUser only succeed if the user is still at the given version, unless
+	// the version is 0. The version is incremented on every update.
+	UpdateUser(ctx context.Context, u data.User) error
+	DeleteUser(ctx context.Context, id, version int) error
+	// RestoreUser undoes DeleteUser; it returns ErrDuplicateEmail when another user took the
+	// email address of the deleted user in the meantime.
+	RestoreUser(ctx context.Context, id int) error
+	// PurgeDeletedUsers permanently removes the users deleted before the given time, together
+	// with their images, and returns the file names of those images.
+	PurgeDeletedUsers(ctx context.Context, before time.Time) ([]string, error)
+	InsertUser(ctx context.Context, user data.User) (int, error)
+	ResetPassword(ctx context.Context, id int, password string) error
+	InsertUserImage(ctx context.Context, i data.UserImage) (int, error)
+	InsertAuditEvent(ctx context.Context, e data.AuditEvent) (int, error)
+	// AuditEvents returns a page of the audit log and the number of events matching the filter
+	AuditEvents(ctx context.Context, f AuditFilter) ([]*data.AuditEvent, int, error)
+
+	InsertServiceAccount(ctx context.Context, a data.ServiceAccount) (int, error)
+	AllServiceAccounts(ctx context.Context) ([]*data.ServiceAccount, error)
+	GetServiceAccount(ctx context.Context, id int) (*data.ServiceAccount, error)
+	InsertAPIKey(ctx context.Context, k data.APIKey) (int, error)
+	APIKeys(ctx context.Context, serviceAccountID int) ([]*data.APIKey, error)
+	// GetAPIKeyByPrefix returns the key whatever its state; callers check APIKey.Active
+	GetAPIKeyByPrefix(ctx context.Context, prefix string) (*data.APIKey, error)
+	// RotateAPIKey inserts k with the name and scopes of the key id, which expires at graceUntil
+	// at the latest, and returns k as stored. Revoked keys can not be rotated.
+	RotateAPIKey(ctx context.Context, serviceAccountID, id int, graceUntil time.Time, k data.APIKey) (*data.APIKey, error)
+	RevokeAPIKey(ctx context.Context, serviceAccountID, id int) error
+	// TouchAPIKey records when the key was last used
+	TouchAPIKey(ctx context.Context, id int, at time.Time) error
+
+	// GetUserByExternalIdentity returns the user linked to the subject of issuer, or ErrDeleted
+	// when that user is deleted
+	GetUserByExternalIdentity(ctx context.Context, issuer, subject string) (*data.User, error)
+	// LinkExternalIdentity links the identity to the user with its email address, creating u
+	// when there is none, and returns the user and whether it was created
+	LinkExternalIdentity(ctx context.Context, identity data.ExternalIdentity, u data.User) (*data.User, bool, error)
+
+	InsertOAuthClient(ctx context.Context, c data.OAuthClient) error
+	AllOAuthClients(ctx context.Context) ([]*data.OAuthClient, error)
+	GetOAuthClient(ctx context.Context, clientID string) (*data.OAuthClient, error)
+	DeleteOAuthClient(ctx context.Context, clientID string) error
+	InsertAuthorizationCode(ctx context.Context, c data.AuthorizationCode) error
+	// ConsumeAuthorizationCode deletes the code with the given hash and returns it, expired or
+	// not, so that no code is exchanged twice
+	ConsumeAuthorizationCode(ctx context.Context, hash string) (*data.AuthorizationCode, error)
+	// OAuthConsent returns the scopes the user granted the client, none if the user never did
+	OAuthConsent(ctx context.Context, userID int, clientID string) ([]string, error)
+	SaveOAuthConsent(ctx context.Context, userID int, clientID string, scopes []string) error
+
+	// RevokeToken refuses the token with the given jti until it expires
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	// RevokeUserTokens refuses the tokens of the user issued at or before the given time; a later
+	// time replaces an earlier one, never the reverse
+	RevokeUserTokens(ctx context.Context, userID int, issuedBefore time.Time) error
+	// Revocations returns the revoked tokens expiring after since, and the cutoffs of the users
+	// which are after since
+	Revocations(ctx context.Context, since time.Time) (*data.Revocations, error)
 }
@@ -0,0 +1,151 @@
+// Package revocation keeps the revoked tokens in memory, so that authentication checks them
+// without a query. The list is written through to the database and synced from it
+// periodically, so that each instance applies the revocations of the others within the sync
+// interval.
+package revocation
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+	"webapp/pkg/data"
+)
+
+// DefaultSyncInterval is how often List.Run syncs the list from the database.
+const DefaultSyncInterval = 30 * time.Second
+
+// Store keeps the revocations of every instance, e.g. the repository.
+type Store interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, userID int, issuedBefore time.Time) error
+	Revocations(ctx context.Context, since time.Time) (*data.Revocations, error)
+}
+
+// List is the revocation list. Revocations are never undone: a revoked token stays revoked
+// until it expires, and the cutoff of a user only moves forward.
+type List struct {
+	store Store
+	// maxAge is the longest lifetime of a token; older cutoffs no longer match any token
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+	users  map[int]time.Time
+}
+
+// New returns an empty list writing to store, for tokens living at most maxAge.
+func New(store Store, maxAge time.Duration) *List {
+	return &List{
+		store:  store,
+		maxAge: maxAge,
+		now:    time.Now,
+		tokens: map[string]time.Time{},
+		users:  map[int]time.Time{},
+	}
+}
+
+// Revoked tells whether the token with the id jti, issued at issuedAt to the user userID, is
+// revoked. userID is 0 for tokens which are not about a user.
+func (l *List) Revoked(jti string, userID int, issuedAt time.Time) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if _, ok := l.tokens[jti]; ok && jti != "" {
+		return true
+	}
+
+	cutoff, ok := l.users[userID]
+	return ok && userID != 0 && !issuedAt.After(cutoff)
+}
+
+// RevokeToken revokes the token with the id jti until it expires at expiresAt.
+func (l *List) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := l.store.RevokeToken(ctx, jti, expiresAt); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens[jti] = expiresAt
+
+	return nil
+}
+
+// RevokeUser revokes the tokens of the user issued until now. Tokens carry their issue time in
+// whole seconds, so those issued later in the same second are revoked as well.
+func (l *List) RevokeUser(ctx context.Context, userID int) error {
+	cutoff := l.now().Truncate(time.Second)
+
+	if err := l.store.RevokeUserTokens(ctx, userID, cutoff); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.addUser(userID, cutoff)
+
+	return nil
+}
+
+// Sync adds the revocations of the store to the list, and forgets those which no longer match
+// any unexpired token.
+func (l *List) Sync(ctx context.Context) error {
+	now := l.now()
+
+	revocations, err := l.store.Revocations(ctx, now.Add(-l.maxAge))
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the revocations made here while the store was read are kept, since they are merged
+	for jti, expiresAt := range revocations.Tokens {
+		l.tokens[jti] = expiresAt
+	}
+	for userID, cutoff := range revocations.Users {
+		l.addUser(userID, cutoff)
+	}
+
+	for jti, expiresAt := range l.tokens {
+		if expiresAt.Before(now) {
+			delete(l.tokens, jti)
+		}
+	}
+	for userID, cutoff := range l.users {
+		if cutoff.Before(now.Add(-l.maxAge)) {
+			delete(l.users, userID)
+		}
+	}
+
+	return nil
+}
+
+// Run syncs the list every interval until ctx is done.
+func (l *List) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.Sync(ctx); err != nil {
+				slog.WarnContext(ctx, "unable to sync the revoked tokens", "error", err)
+			}
+		}
+	}
+}
+
+// addUser moves the cutoff of the user forward to cutoff; l.mu must be held
+func (l *List) addUser(userID int, cutoff time.Time) {
+	if cutoff.After(l.users[userID]) {
+		l.users[userID] = cutoff
+	}
+}
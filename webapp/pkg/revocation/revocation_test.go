@@ -0,0 +1,104 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func TestList_Revoked(t *testing.T) {
+
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+
+	l := New(&dbrepo.MockDBRepo{}, 24*time.Hour)
+	l.now = func() time.Time { return now }
+
+	if err := l.RevokeToken(ctx, "revoked", now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.RevokeUser(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		jti      string
+		userID   int
+		issuedAt time.Time
+		expected bool
+	}{
+		{"other token", "other", 1, now.Add(-time.Hour), false},
+		{"revoked token", "revoked", 1, now.Add(-time.Hour), true},
+		{"token of the user issued before", "other", 2, now.Add(-time.Hour), true},
+		{"token of the user issued in the same second", "other", 2, now.Truncate(time.Second), true},
+		{"token of the user issued after", "other", 2, now.Add(time.Second), false},
+		{"token without user", "other", 0, now.Add(-time.Hour), false},
+		{"token without id", "", 1, now.Add(-time.Hour), false},
+	}
+
+	for _, tt := range testCases {
+		if revoked := l.Revoked(tt.jti, tt.userID, tt.issuedAt); revoked != tt.expected {
+			t.Errorf("%s: expect revoked %v; got %v", tt.name, tt.expected, revoked)
+		}
+	}
+}
+
+func TestList_Sync(t *testing.T) {
+
+	ctx := context.Background()
+	now := time.Now()
+	store := &dbrepo.MockDBRepo{}
+
+	// another instance revokes tokens in the shared store
+	other := New(store, time.Hour)
+	_ = other.RevokeToken(ctx, "revoked", now.Add(time.Minute))
+	_ = other.RevokeUser(ctx, 2)
+
+	l := New(store, time.Hour)
+	_ = l.RevokeToken(ctx, "local", now.Add(time.Minute))
+
+	if l.Revoked("revoked", 0, now) || l.Revoked("other", 2, now.Add(-time.Minute)) {
+		t.Fatal("expect the revocations of another instance to wait for a sync")
+	}
+
+	if err := l.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !l.Revoked("revoked", 0, now) || !l.Revoked("other", 2, now.Add(-time.Minute)) || !l.Revoked("local", 0, now) {
+		t.Error("expect the list to hold the revocations of both instances")
+	}
+
+	// once the tokens expire, their revocations are forgotten
+	l.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if err := l.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(l.tokens) != 0 || len(l.users) != 0 {
+		t.Errorf("expect the expired revocations to be forgotten; got %v %v", l.tokens, l.users)
+	}
+}
+
+type failingStore struct{ *dbrepo.MockDBRepo }
+
+func (failingStore) RevokeToken(context.Context, string, time.Time) error {
+	return errors.New("database down")
+}
+
+func TestList_RevokeToken_storeError(t *testing.T) {
+
+	l := New(failingStore{&dbrepo.MockDBRepo{}}, time.Hour)
+
+	if err := l.RevokeToken(context.Background(), "revoked", time.Now().Add(time.Minute)); err == nil {
+		t.Fatal("expect the error of the store")
+	}
+
+	if l.Revoked("revoked", 0, time.Now()) {
+		t.Error("expect a revocation which was not stored not to be applied either")
+	}
+}
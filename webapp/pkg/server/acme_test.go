@@ -0,0 +1,88 @@
+//go:build integration
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+)
+
+// TestTLSConfig_acmePebble obtains a certificate from Pebble, the test ACME server of Let's
+// Encrypt. Pebble is told to accept every challenge, so it does not need to reach this host.
+func TestTLSConfig_acmePebble(t *testing.T) {
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Fatalf("could not connect to docker; is it running? %s", err)
+	}
+
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository:   "ghcr.io/letsencrypt/pebble",
+		Tag:          "latest",
+		Env:          []string{"PEBBLE_VA_ALWAYS_VALID=1", "PEBBLE_VA_NOSLEEP=1"},
+		ExposedPorts: []string{"14000"},
+		PortBindings: map[docker.Port][]docker.PortBinding{
+			"14000": {{HostIP: "0.0.0.0", HostPort: "14000"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not start pebble: %s", err)
+	}
+	defer func() { _ = pool.Purge(resource) }()
+
+	// pebble serves its directory with a certificate of its own test CA
+	insecure := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   10 * time.Second,
+	}
+
+	directory := "https://localhost:14000/dir"
+
+	if err := pool.Retry(func() error {
+		resp, err := insecure.Get(directory)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}); err != nil {
+		t.Fatalf("pebble did not start: %s", err)
+	}
+
+	cfg := DefaultTLSConfig()
+	cfg.Mode = TLSACME
+	cfg.Hosts = []string{"app.test"}
+	cfg.ACMEDirectory = directory
+	cfg.ACMECacheDir = t.TempDir()
+	cfg.ACMEEmail = "admin@app.test"
+
+	m, err := cfg.acmeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Client.HTTPClient = insecure
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.test"})
+	if err != nil {
+		t.Fatalf("expect a certificate from pebble; got %s", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := leaf.VerifyHostname("app.test"); err != nil {
+		t.Errorf("expect the certificate to be valid for app.test; got %s", err)
+	}
+
+	if !strings.Contains(leaf.Issuer.CommonName, "Pebble") {
+		t.Errorf("expect the certificate to be issued by pebble; got %s", leaf.Issuer.CommonName)
+	}
+}
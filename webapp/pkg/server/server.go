@@ -0,0 +1,211 @@
+// Package server runs the HTTP servers of the web and api applications with sane timeouts,
+// and shuts them down gracefully: on SIGINT or SIGTERM in-flight requests are drained, the
+// background workers are stopped and the resources, such as the database pool, are released.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Config holds the settings of the HTTP server. The tags are read by package config.
+type Config struct {
+	// Addr is the address to listen on, e.g. :8080.
+	Addr string `yaml:"addr" toml:"addr" env:"ADDR" flag:"addr" usage:"address to listen on, e.g. :8080"`
+	// ReadTimeout bounds reading a whole request, body included.
+	ReadTimeout time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"READ_TIMEOUT" flag:"read-timeout" usage:"maximum duration for reading a request, body included"`
+	// ReadHeaderTimeout bounds reading the request headers, against slow clients.
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" toml:"read_header_timeout" env:"READ_HEADER_TIMEOUT" flag:"read-header-timeout" usage:"maximum duration for reading the request headers"`
+	// WriteTimeout bounds the time from the end of the request headers to the end of the response.
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"WRITE_TIMEOUT" flag:"write-timeout" usage:"maximum duration for writing a response"`
+	// IdleTimeout bounds how long keep-alive connections wait for the next request.
+	IdleTimeout time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"IDLE_TIMEOUT" flag:"idle-timeout" usage:"how long idle keep-alive connections are kept open"`
+	// MaxHeaderBytes limits the size of the request headers.
+	MaxHeaderBytes int `yaml:"max_header_bytes" toml:"max_header_bytes" env:"MAX_HEADER_BYTES" flag:"max-header-bytes" usage:"maximum size of the request headers, in bytes"`
+	// ShutdownTimeout is how long in-flight requests may take to finish once shutdown started.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"how long in-flight requests may take to finish on shutdown"`
+
+	TLS TLSConfig `yaml:"tls" toml:"tls"`
+}
+
+// DefaultConfig returns the settings used unless configured otherwise.
+func DefaultConfig(addr string) Config {
+	return Config{
+		Addr:              addr,
+		ReadTimeout:       15 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    1 << 20,
+		ShutdownTimeout:   15 * time.Second,
+		TLS:               DefaultTLSConfig(),
+	}
+}
+
+type closer struct {
+	name string
+	fn   func(context.Context) error
+}
+
+// Server serves an HTTP handler together with the background workers of the application.
+type Server struct {
+	cfg     Config
+	handler http.Handler
+	logger  *slog.Logger
+
+	workers []func(context.Context)
+	closers []closer
+}
+
+// New returns a server for handler. Workers and closers are registered before Run.
+func New(cfg Config, handler http.Handler) *Server {
+	return &Server{cfg: cfg, handler: handler, logger: slog.Default()}
+}
+
+// Go runs fn in the background while the server runs. The context passed to fn is cancelled
+// when shutdown starts, and the server waits for fn to return before releasing resources.
+func (s *Server) Go(fn func(ctx context.Context)) {
+	s.workers = append(s.workers, fn)
+}
+
+// OnShutdown registers fn to release a resource once the requests are drained and the workers
+// have stopped. Like deferred calls, closers run in the reverse order of their registration.
+func (s *Server) OnShutdown(name string, fn func(context.Context) error) {
+	s.closers = append(s.closers, closer{name: name, fn: fn})
+}
+
+// Run listens on the configured address and serves until SIGINT or SIGTERM is received or ctx
+// is cancelled, then shuts down gracefully.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ctx, ln)
+}
+
+// Serve serves on ln until ctx is cancelled, then shuts down gracefully. It returns the error
+// that stopped the server, if any, joined with the errors of the shutdown. With TLS enabled, ln
+// serves HTTPS and the redirect listener, if configured, is opened too.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	tlsConfig, challenges, err := s.cfg.TLS.build()
+	if err != nil {
+		ln.Close()
+		return err
+	}
+
+	handler := s.handler
+	if tlsConfig != nil && s.cfg.TLS.HSTSMaxAge > 0 && s.cfg.TLS.Mode != TLSSelfSigned {
+		handler = hsts(s.cfg.TLS.HSTSMaxAge, handler)
+	}
+
+	srv := &http.Server{
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       s.cfg.ReadTimeout,
+		ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+		WriteTimeout:      s.cfg.WriteTimeout,
+		IdleTimeout:       s.cfg.IdleTimeout,
+		MaxHeaderBytes:    s.cfg.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(s.logger.Handler(), slog.LevelWarn),
+	}
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	var workers sync.WaitGroup
+	for _, fn := range s.workers {
+		workers.Add(1)
+		go func(fn func(context.Context)) {
+			defer workers.Done()
+			fn(workerCtx)
+		}(fn)
+	}
+
+	serveErr := make(chan error, 2)
+	go func() {
+		if tlsConfig != nil {
+			serveErr <- srv.ServeTLS(ln, "", "")
+			return
+		}
+		serveErr <- srv.Serve(ln)
+	}()
+
+	// redirect is the plain HTTP server sending clients to HTTPS
+	var redirect *http.Server
+	if tlsConfig != nil && s.cfg.TLS.RedirectAddr != "" {
+		redirect = &http.Server{
+			Addr:              s.cfg.TLS.RedirectAddr,
+			Handler:           challenges(redirectToHTTPS(ln.Addr().String())),
+			ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+			IdleTimeout:       s.cfg.IdleTimeout,
+			MaxHeaderBytes:    s.cfg.MaxHeaderBytes,
+			ErrorLog:          srv.ErrorLog,
+		}
+
+		go func() {
+			if err := redirect.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- fmt.Errorf("redirect: %w", err)
+			}
+		}()
+	}
+
+	s.logger.Info("server started", "addr", ln.Addr().String(), "tls", s.cfg.TLS.Mode, "redirect_addr", s.cfg.TLS.RedirectAddr)
+
+	var errs []error
+
+	select {
+	case err := <-serveErr:
+		// the listener failed before we were asked to stop
+		s.logger.Error("server failed", "error", err)
+		errs = append(errs, err)
+	case <-ctx.Done():
+		s.logger.Info("shutting down", "timeout", s.cfg.ShutdownTimeout.String())
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	if redirect != nil {
+		// redirects are answered at once, there is nothing to drain
+		redirect.Close()
+	}
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		s.logger.Warn("requests did not finish in time, closing connections", "error", err)
+		errs = append(errs, fmt.Errorf("drain requests: %w", err))
+		srv.Close()
+	}
+
+	stopWorkers()
+	workers.Wait()
+
+	// resources get their own deadline, so that slow requests do not prevent flushing e.g. traces
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancelClose()
+
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		c := s.closers[i]
+		if err := c.fn(closeCtx); err != nil {
+			s.logger.Error("unable to shut down", "resource", c.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+
+	s.logger.Info("server stopped")
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// start serves handler on a random port and returns its URL and a function stopping the server
+// and returning the error of Serve
+func start(t *testing.T, s *Server) (string, func() error) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- s.Serve(ctx, ln)
+	}()
+
+	return "http://" + ln.Addr().String(), func() error {
+		cancel()
+
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("server did not stop")
+			return nil
+		}
+	}
+}
+
+func TestServer_drainsRequests(t *testing.T) {
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = io.WriteString(w, "done")
+	})
+
+	cfg := DefaultConfig("")
+	cfg.ShutdownTimeout = 2 * time.Second
+	s := New(cfg, handler)
+
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	s.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		record("worker stopped")
+	})
+	s.OnShutdown("first", func(context.Context) error {
+		record("first closed")
+		return nil
+	})
+	s.OnShutdown("second", func(context.Context) error {
+		record("second closed")
+		return nil
+	})
+
+	url, stop := start(t, s)
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		record("request finished")
+		body <- string(b)
+	}()
+
+	<-started
+	if err := stop(); err != nil {
+		t.Errorf("expect a clean shutdown; got %s", err)
+	}
+
+	if got := <-body; got != "done" {
+		t.Errorf("expect the in-flight request to finish; got %q", got)
+	}
+
+	expected := []string{"request finished", "worker stopped", "second closed", "first closed"}
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Errorf("expect shutdown steps %v; got %v", expected, events)
+	}
+}
+
+func TestServer_shutdownDeadline(t *testing.T) {
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	cfg := DefaultConfig("")
+	cfg.ShutdownTimeout = 50 * time.Millisecond
+	s := New(cfg, handler)
+
+	closed := false
+	s.OnShutdown("database", func(context.Context) error {
+		closed = true
+		return errors.New("already closed")
+	})
+
+	url, stop := start(t, s)
+
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	<-started
+	err := stop()
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expect the drain to time out; got %v", err)
+	}
+
+	if err == nil || !strings.Contains(err.Error(), "database: already closed") {
+		t.Errorf("expect the error of the closer; got %v", err)
+	}
+
+	if !closed {
+		t.Error("expect resources to be released even when requests did not finish")
+	}
+}
+
+func TestConfig_timeouts(t *testing.T) {
+
+	cfg := DefaultConfig(":0")
+
+	if cfg.ReadHeaderTimeout <= 0 || cfg.ReadTimeout <= 0 || cfg.WriteTimeout <= 0 || cfg.IdleTimeout <= 0 {
+		t.Errorf("expect every timeout to be set; got %+v", cfg)
+	}
+
+	if cfg.MaxHeaderBytes <= 0 {
+		t.Errorf("expect a header limit; got %d", cfg.MaxHeaderBytes)
+	}
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLS modes.
+const (
+	// TLSOff serves plain HTTP, e.g. behind a proxy terminating TLS.
+	TLSOff = "off"
+	// TLSFiles serves the certificate and key of CertFile and KeyFile.
+	TLSFiles = "files"
+	// TLSSelfSigned serves a self-signed certificate for Hosts, for development.
+	TLSSelfSigned = "self-signed"
+	// TLSACME obtains certificates for Hosts from an ACME CA such as Let's Encrypt.
+	TLSACME = "acme"
+)
+
+// TLSConfig selects how the server serves HTTPS. The tags are read by package config.
+type TLSConfig struct {
+	Mode string `yaml:"mode" toml:"mode" env:"TLS_MODE" flag:"tls" usage:"how to serve HTTPS: off, files, self-signed or acme"`
+	// CertFile and KeyFile are PEM files. In self-signed mode they are optional, and keep the
+	// generated certificate across restarts.
+	CertFile string `yaml:"cert_file" toml:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert" usage:"PEM certificate file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file" env:"TLS_KEY_FILE" flag:"tls-key" usage:"PEM private key file"`
+	// Hosts are the names certificates are issued for.
+	Hosts []string `yaml:"hosts" toml:"hosts" env:"TLS_HOSTS" flag:"tls-hosts" usage:"comma separated host names of the self-signed or ACME certificates"`
+
+	ACMEDirectory string `yaml:"acme_directory" toml:"acme_directory" env:"ACME_DIRECTORY" flag:"acme-directory" usage:"directory URL of the ACME CA"`
+	ACMEEmail     string `yaml:"acme_email" toml:"acme_email" env:"ACME_EMAIL" flag:"acme-email" usage:"contact address for the ACME account"`
+	ACMECacheDir  string `yaml:"acme_cache_dir" toml:"acme_cache_dir" env:"ACME_CACHE_DIR" flag:"acme-cache-dir" usage:"directory keeping the ACME account and certificates"`
+	// ACMECAFile is a PEM bundle trusted for the ACME directory, e.g. the root of a local Pebble.
+	ACMECAFile string `yaml:"acme_ca_file" toml:"acme_ca_file" env:"ACME_CA_FILE" flag:"acme-ca-file" usage:"PEM bundle trusted when talking to the ACME directory, e.g. for Pebble"`
+
+	// RedirectAddr, when set, listens for plain HTTP, redirects it to HTTPS and answers the
+	// ACME http-01 challenges.
+	RedirectAddr string `yaml:"redirect_addr" toml:"redirect_addr" env:"HTTP_REDIRECT_ADDR" flag:"redirect-addr" usage:"address redirecting plain HTTP to HTTPS, e.g. :80; empty disables it"`
+	// HSTSMaxAge is sent in Strict-Transport-Security; zero disables the header. It is never
+	// sent for self-signed certificates, so that browsers do not pin them.
+	HSTSMaxAge time.Duration `yaml:"hsts_max_age" toml:"hsts_max_age" env:"HSTS_MAX_AGE" flag:"hsts-max-age" usage:"max-age of the Strict-Transport-Security header; 0 disables it"`
+}
+
+// DefaultTLSConfig serves plain HTTP; the other defaults apply once a mode is chosen.
+func DefaultTLSConfig() TLSConfig {
+	return TLSConfig{
+		Mode:          TLSOff,
+		Hosts:         []string{"localhost"},
+		ACMEDirectory: autocert.DefaultACMEDirectory,
+		ACMECacheDir:  "./certs",
+		HSTSMaxAge:    365 * 24 * time.Hour,
+	}
+}
+
+// Enabled tells whether the server serves HTTPS.
+func (c TLSConfig) Enabled() bool {
+	return c.Mode != "" && c.Mode != TLSOff
+}
+
+// Validate checks that the settings needed by the mode are present.
+func (c TLSConfig) Validate() error {
+	var errs []error
+
+	switch c.Mode {
+	case "", TLSOff, TLSSelfSigned:
+	case TLSFiles:
+		if c.CertFile == "" || c.KeyFile == "" {
+			errs = append(errs, errors.New("tls.cert_file and tls.key_file are required in files mode"))
+		}
+	case TLSACME:
+		if len(c.Hosts) == 0 {
+			errs = append(errs, errors.New("tls.hosts is required in acme mode"))
+		}
+		if c.ACMEDirectory == "" || c.ACMECacheDir == "" {
+			errs = append(errs, errors.New("tls.acme_directory and tls.acme_cache_dir are required in acme mode"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tls.mode must be %s, %s, %s or %s; got %q", TLSOff, TLSFiles, TLSSelfSigned, TLSACME, c.Mode))
+	}
+
+	if c.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("tls.hsts_max_age can not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// build returns the TLS configuration of the mode, and, in ACME mode, a wrapper answering the
+// http-01 challenges on the redirect listener. It returns nil when TLS is off.
+func (c TLSConfig) build() (*tls.Config, func(http.Handler) http.Handler, error) {
+	var cfg *tls.Config
+	challenges := func(h http.Handler) http.Handler { return h }
+
+	switch c.Mode {
+	case "", TLSOff:
+		return nil, challenges, nil
+
+	case TLSFiles:
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tls: %w", err)
+		}
+		cfg = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	case TLSSelfSigned:
+		cert, err := selfSignedCertificate(c.Hosts, c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tls: %w", err)
+		}
+		cfg = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	case TLSACME:
+		m, err := c.acmeManager()
+		if err != nil {
+			return nil, nil, fmt.Errorf("tls: %w", err)
+		}
+		cfg = m.TLSConfig()
+		challenges = m.HTTPHandler
+
+	default:
+		return nil, nil, fmt.Errorf("tls: unknown mode %q", c.Mode)
+	}
+
+	cfg.MinVersion = tls.VersionTLS12
+	if len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	return cfg, challenges, nil
+}
+
+// acmeManager returns the autocert manager obtaining and renewing the certificates of Hosts
+func (c TLSConfig) acmeManager() (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: c.ACMEDirectory}
+
+	if c.ACMECAFile != "" {
+		pem, err := os.ReadFile(c.ACMECAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.ACMECAFile)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		client.HTTPClient = &http.Client{Transport: transport, Timeout: 30 * time.Second}
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(c.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(c.Hosts...),
+		Email:      c.ACMEEmail,
+		Client:     client,
+	}, nil
+}
+
+// selfSignedCertificate returns a certificate for hosts and the loopback addresses. When the
+// files are given, a certificate found there is reused, and a new one is saved there.
+func selfSignedCertificate(hosts []string, certFile, keyFile string) (tls.Certificate, error) {
+	persist := certFile != "" && keyFile != ""
+
+	if persist {
+		if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+			return cert, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"webapp development"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	if persist {
+		if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL over HTTPS on the port of httpsAddr
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		// 308 keeps the method and body of e.g. a POST
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// hsts tells browsers to only use HTTPS for maxAge
+func hsts(maxAge time.Duration, next http.Handler) http.Handler {
+	value := "max-age=" + strconv.FormatInt(int64(maxAge.Seconds()), 10) + "; includeSubDomains"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// insecureClient trusts any certificate, as the test certificates are self-signed
+func insecureClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		Timeout:   5 * time.Second,
+	}
+}
+
+func TestServer_TLS(t *testing.T) {
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	// a self-signed certificate saved to files doubles as the certificate of files mode
+	if _, err := selfSignedCertificate([]string{"localhost"}, certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		tls        TLSConfig
+		expectHSTS string
+	}{
+		{"self-signed", TLSConfig{Mode: TLSSelfSigned, Hosts: []string{"localhost"}, HSTSMaxAge: time.Hour}, ""},
+		{"files", TLSConfig{Mode: TLSFiles, CertFile: certFile, KeyFile: keyFile, HSTSMaxAge: time.Hour}, "max-age=3600; includeSubDomains"},
+		{"files without hsts", TLSConfig{Mode: TLSFiles, CertFile: certFile, KeyFile: keyFile}, ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig("")
+			cfg.TLS = tt.tls
+
+			s := New(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.TLS == nil {
+					t.Error("expect the request to come over TLS")
+				}
+			}))
+
+			url, stop := start(t, s)
+			defer stop()
+
+			resp, err := insecureClient().Get(strings.Replace(url, "http://", "https://", 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("expect status 200; got %d", resp.StatusCode)
+			}
+
+			if got := resp.Header.Get("Strict-Transport-Security"); got != tt.expectHSTS {
+				t.Errorf("expect HSTS %q; got %q", tt.expectHSTS, got)
+			}
+
+			if resp.TLS == nil || resp.TLS.Version < tls.VersionTLS12 {
+				t.Error("expect at least TLS 1.2")
+			}
+		})
+	}
+}
+
+func TestServer_TLS_invalidFiles(t *testing.T) {
+
+	cfg := DefaultConfig("")
+	cfg.TLS = TLSConfig{Mode: TLSFiles, CertFile: "missing.pem", KeyFile: "missing.pem"}
+
+	_, stop := start(t, New(cfg, http.NotFoundHandler()))
+
+	if err := stop(); err == nil {
+		t.Error("expect an error for missing certificate files")
+	}
+}
+
+func Test_selfSignedCertificate(t *testing.T) {
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	cert, err := selfSignedCertificate([]string{"app.test", "10.0.0.1"}, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, host := range []string{"app.test", "10.0.0.1", "127.0.0.1", "::1"} {
+		if err := leaf.VerifyHostname(host); err != nil {
+			t.Errorf("expect the certificate to be valid for %s; got %s", host, err)
+		}
+	}
+
+	if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expect the key to be saved readable by the owner only; got %v", err)
+	}
+
+	again, err := selfSignedCertificate([]string{"app.test"}, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(again.Certificate[0]) != string(cert.Certificate[0]) {
+		t.Error("expect the saved certificate to be reused")
+	}
+}
+
+func Test_redirectToHTTPS(t *testing.T) {
+
+	testCases := []struct {
+		name      string
+		httpsAddr string
+		host      string
+		target    string
+		expected  string
+	}{
+		{"default port", ":443", "example.com", "/users?page=2", "https://example.com/users?page=2"},
+		{"other port", ":8443", "example.com:8080", "/login", "https://example.com:8443/login"},
+		{"ipv6", "[::]:8443", "[::1]:8080", "/", "https://[::1]:8443/"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			req.Host = tt.host
+			rr := httptest.NewRecorder()
+
+			redirectToHTTPS(tt.httpsAddr).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusPermanentRedirect {
+				t.Errorf("expect status 308; got %d", rr.Code)
+			}
+
+			if got := rr.Header().Get("Location"); got != tt.expected {
+				t.Errorf("expect redirect to %s; got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTLSConfig_Validate(t *testing.T) {
+
+	acmeDefaults := DefaultTLSConfig()
+	acmeDefaults.Mode = TLSACME
+
+	testCases := []struct {
+		name      string
+		cfg       TLSConfig
+		expectErr bool
+	}{
+		{"off", TLSConfig{Mode: TLSOff}, false},
+		{"empty mode", TLSConfig{}, false},
+		{"self-signed", TLSConfig{Mode: TLSSelfSigned}, false},
+		{"files", TLSConfig{Mode: TLSFiles, CertFile: "c", KeyFile: "k"}, false},
+		{"files without key", TLSConfig{Mode: TLSFiles, CertFile: "c"}, true},
+		{"acme", acmeDefaults, false},
+		{"acme without hosts", TLSConfig{Mode: TLSACME, ACMEDirectory: "https://acme", ACMECacheDir: "certs"}, true},
+		{"unknown mode", TLSConfig{Mode: "on"}, true},
+		{"negative hsts", TLSConfig{Mode: TLSOff, HSTSMaxAge: -time.Second}, true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.expectErr && err == nil {
+				t.Error("expect an error")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("expect no error; got %s", err)
+			}
+		})
+	}
+}
+
+func TestTLSConfig_acmeManager(t *testing.T) {
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if _, err := selfSignedCertificate(nil, caFile, filepath.Join(dir, "ca-key.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultTLSConfig()
+	cfg.Mode = TLSACME
+	cfg.Hosts = []string{"app.test"}
+	cfg.ACMEDirectory = "https://localhost:14000/dir"
+	cfg.ACMECAFile = caFile
+	cfg.ACMECacheDir = dir
+
+	m, err := cfg.acmeManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Client.DirectoryURL != cfg.ACMEDirectory {
+		t.Errorf("expect directory %s; got %s", cfg.ACMEDirectory, m.Client.DirectoryURL)
+	}
+
+	if m.Client.HTTPClient == nil {
+		t.Error("expect a client trusting the CA file")
+	}
+
+	if err := m.HostPolicy(context.Background(), "app.test"); err != nil {
+		t.Errorf("expect app.test to be allowed; got %s", err)
+	}
+
+	if err := m.HostPolicy(context.Background(), "other.test"); err == nil {
+		t.Error("expect other hosts to be refused")
+	}
+
+	cfg.ACMECAFile = filepath.Join(dir, "missing.pem")
+	if _, err := cfg.acmeManager(); err == nil {
+		t.Error("expect an error for a missing CA file")
+	}
+}
@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"webapp/pkg/recorder"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware starts a server span for every request, continuing the trace of an incoming
+// traceparent header. The span is named after the chi route pattern, e.g. GET /users/{userID},
+// once the router has matched the request.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		rec := recorder.New(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(fmt.Sprintf("%s %s", r.Method, rctx.RoutePattern()))
+			span.SetAttributes(semconv.HTTPRoute(rctx.RoutePattern()))
+		}
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.Status()))
+		if rec.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status()))
+		}
+	})
+}
+
+type stageKey struct{}
+
+// stage is the span of a middleware, ended when the middleware calls the next handler
+type stage struct {
+	span   trace.Span
+	parent trace.Span
+}
+
+// Stage wraps the middleware mw so that the time spent in it, until it calls the next
+// handler or responds itself, shows up as a span named "middleware <name>".
+func Stage(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		inner := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			if s, ok := ctx.Value(stageKey{}).(*stage); ok {
+				s.span.End()
+				// the rest of the request belongs to the request span, not to the middleware
+				ctx = trace.ContextWithSpan(ctx, s.parent)
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parent := trace.SpanFromContext(r.Context())
+
+			ctx, span := Start(r.Context(), "middleware "+name)
+			ctx = context.WithValue(ctx, stageKey{}, &stage{span: span, parent: parent})
+
+			inner.ServeHTTP(w, r.WithContext(ctx))
+
+			// ending twice is harmless, and covers middleware that responded without calling next
+			span.End()
+		})
+	}
+}
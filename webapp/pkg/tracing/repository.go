@@ -0,0 +1,26 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ObserveRepo is a repository.Observer running every call of the instrumented repository in a
+// span named DatabaseRepo.<method>. The SQL statements are added to the span by the repository.
+func ObserveRepo(ctx context.Context, method string) (context.Context, func(error)) {
+	ctx, span := Start(ctx, "DatabaseRepo."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperation(method)),
+	)
+
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,102 @@
+// Package tracing sets up OpenTelemetry tracing and provides the spans of HTTP requests,
+// middleware stages and repository calls.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer of this module
+const instrumentationName = "webapp"
+
+// Exporters supported by Setup.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Config selects where spans are sent. The tags are read by package config.
+type Config struct {
+	ServiceName string `yaml:"-" toml:"-"`
+	// Exporter is one of ExporterNone, ExporterStdout or ExporterOTLP.
+	Exporter string `yaml:"exporter" toml:"exporter" env:"TRACE_EXPORTER" flag:"trace-exporter" usage:"where to send traces: none, stdout or otlp"`
+	// Endpoint is the host:port of an OTLP/HTTP collector; when empty the standard
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable is used.
+	Endpoint string `yaml:"otlp_endpoint" toml:"otlp_endpoint" env:"OTLP_ENDPOINT" flag:"otlp-endpoint" usage:"host:port of the OTLP/HTTP collector, defaults to OTEL_EXPORTER_OTLP_ENDPOINT"`
+	// Insecure sends OTLP over plain HTTP.
+	Insecure bool `yaml:"otlp_insecure" toml:"otlp_insecure" env:"OTLP_INSECURE" flag:"otlp-insecure" usage:"send traces to the OTLP collector without TLS"`
+}
+
+// Setup installs a global tracer provider exporting spans as configured, and the W3C trace
+// context propagator. The returned function flushes pending spans and must be called on exit.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch cfg.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", cfg.Exporter)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	tp := NewProvider(cfg.ServiceName, sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// NewProvider returns a tracer provider for the service, sampling every trace unless the
+// parent was not sampled. Tests pass sdktrace.WithSyncer with an in-memory exporter.
+func NewProvider(serviceName string, opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))
+
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	}, opts...)
+
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+// Tracer returns the tracer of this module, from the global tracer provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start starts a span named name as a child of the span in ctx.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, opts...)
+}
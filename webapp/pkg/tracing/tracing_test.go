@@ -0,0 +1,156 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider keeping the ended spans in memory for the test
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := NewProvider("test", sdktrace.WithSyncer(exporter))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+		otel.SetTracerProvider(previous)
+	})
+
+	return exporter
+}
+
+// findSpan returns the span called name
+func findSpan(spans tracetest.SpanStubs, name string) (tracetest.SpanStub, bool) {
+	for _, s := range spans {
+		if s.Name == name {
+			return s, true
+		}
+	}
+
+	return tracetest.SpanStub{}, false
+}
+
+func TestMiddleware(t *testing.T) {
+
+	exporter := recordSpans(t)
+
+	passThrough := func(next http.Handler) http.Handler { return next }
+	reject := func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+	}
+
+	mux := chi.NewRouter()
+	mux.Use(Middleware)
+	mux.Route("/users", func(mux chi.Router) {
+		mux.Use(Stage("auth", passThrough))
+		mux.Get("/{userID}", func(w http.ResponseWriter, r *http.Request) {
+			_, span := Start(r.Context(), "handler")
+			span.End()
+		})
+	})
+	mux.With(Stage("reject", reject)).Get("/broken", func(w http.ResponseWriter, r *http.Request) {})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+
+	server, ok := findSpan(spans, "GET /users/{userID}")
+	if !ok {
+		t.Fatalf("expect a span named after the route; got %d spans", len(spans))
+	}
+
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("expect a server span; got %s", server.SpanKind)
+	}
+
+	if server.SpanContext.TraceID().String() != traceID {
+		t.Errorf("expect the trace of the traceparent header; got %s", server.SpanContext.TraceID())
+	}
+
+	if server.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("expect the span of the traceparent header as parent; got %s", server.Parent.SpanID())
+	}
+
+	for _, name := range []string{"middleware auth", "handler"} {
+		s, ok := findSpan(spans, name)
+		if !ok {
+			t.Errorf("expect a span %q", name)
+			continue
+		}
+
+		// the handler runs after the middleware stage ended, so both are children of the request
+		if s.Parent.SpanID() != server.SpanContext.SpanID() {
+			t.Errorf("expect %q to be a child of the request span", name)
+		}
+	}
+
+	exporter.Reset()
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/broken", nil))
+
+	spans = exporter.GetSpans()
+
+	if _, ok := findSpan(spans, "middleware reject"); !ok {
+		t.Error("expect a span for a middleware which responded itself")
+	}
+
+	server, ok = findSpan(spans, "GET /broken")
+	if !ok {
+		t.Fatal("expect a span for the broken route")
+	}
+
+	if server.Status.Code != codes.Error {
+		t.Errorf("expect a 500 to mark the span as failed; got %s", server.Status.Code)
+	}
+}
+
+func TestObserveRepo(t *testing.T) {
+
+	exporter := recordSpans(t)
+
+	repo := repository.Instrument(&dbrepo.MockDBRepo{}, ObserveRepo)
+
+	_, _ = repo.GetUser(context.Background(), 1)
+	_, _ = repo.GetUser(context.Background(), 2)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expect 2 spans; got %d", len(spans))
+	}
+
+	for i, expected := range []codes.Code{codes.Unset, codes.Error} {
+		if spans[i].Name != "DatabaseRepo.GetUser" {
+			t.Errorf("expect span DatabaseRepo.GetUser; got %s", spans[i].Name)
+		}
+
+		if spans[i].SpanKind != trace.SpanKindClient {
+			t.Errorf("expect a client span; got %s", spans[i].SpanKind)
+		}
+
+		if spans[i].Status.Code != expected {
+			t.Errorf("expect status %s for call %d; got %s", expected, i, spans[i].Status.Code)
+		}
+	}
+}
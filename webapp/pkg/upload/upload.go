@@ -0,0 +1,137 @@
+// Package upload saves the images posted in multipart forms, e.g. profile pictures, so that the
+// web app and the api store them the same way.
+package upload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// MaxSize is the largest request body accepted by Image, 5 MB.
+const MaxSize = 5 << 20
+
+var (
+	// ErrNoFile is returned by Image when the form holds no file in the field.
+	ErrNoFile = errors.New("no file was uploaded")
+	// ErrNotImage is returned by Image when the file is not one of ImageTypes.
+	ErrNotImage = errors.New("the file is not a PNG, JPEG, GIF or WebP image")
+)
+
+// ImageTypes maps the content types accepted by Image, as sniffed from the content, to the
+// extension of the saved files.
+var ImageTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// File is a file saved by Image. FileName is the name it was saved under; OriginalFileName is
+// the name sent by the client, which is only informative.
+type File struct {
+	FileName         string
+	OriginalFileName string
+	ContentType      string
+	FileSize         int64
+}
+
+// Image saves the image posted in field of the multipart form of r in dir. The file gets a new
+// random name, so that clients can neither choose nor overwrite the files of others, with the
+// extension of the type sniffed from its content. Bodies over MaxSize and files which are no
+// image are refused. Only the first file of field is saved, nothing else is written to dir.
+func Image(r *http.Request, dir, field string) (*File, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, MaxSize)
+
+	if err := r.ParseMultipartForm(MaxSize); err != nil {
+		return nil, err
+	}
+	// the parts too large for memory were spooled to temporary files
+	defer r.MultipartForm.RemoveAll()
+
+	headers := r.MultipartForm.File[field]
+	if len(headers) == 0 {
+		return nil, ErrNoFile
+	}
+
+	return save(headers[0], dir)
+}
+
+// save copies the uploaded file to dir under a random name, once its content proved to be an
+// image
+func save(hdr *multipart.FileHeader, dir string) (*File, error) {
+	in, err := hdr.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	// DetectContentType looks at no more than the first 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(in, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	ext, ok := ImageTypes[contentType]
+	if !ok {
+		return nil, ErrNotImage
+	}
+
+	name, err := randomName()
+	if err != nil {
+		return nil, err
+	}
+	name += ext
+
+	out, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := io.Copy(out, io.MultiReader(bytes.NewReader(head), in))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(filepath.Join(dir, name))
+		return nil, err
+	}
+
+	return &File{FileName: name, OriginalFileName: hdr.Filename, ContentType: contentType, FileSize: size}, nil
+}
+
+// randomName returns 32 random hex digits
+func randomName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Remove removes the file name, as saved by Image, from dir. Names come from the database, so
+// they are never allowed to point outside of dir. A missing file is not an error.
+func Remove(dir, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	err := os.Remove(filepath.Join(dir, filepath.Base(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
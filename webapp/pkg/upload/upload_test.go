@@ -0,0 +1,150 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pngImage returns the content of a 1x1 PNG
+func pngImage(t *testing.T) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+// postFiles posts the files, by form field, as a multipart form
+func postFiles(t *testing.T, files map[string]string) *http.Request {
+	t.Helper()
+
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	_ = mw.WriteField("name", "value")
+	for field, content := range files {
+		part, err := mw.CreateFormFile(field, "me.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = part.Write([]byte(content))
+	}
+	_ = mw.Close()
+
+	req := httptest.NewRequest("POST", "/", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	return req
+}
+
+func TestImage(t *testing.T) {
+
+	png := pngImage(t)
+
+	testCases := []struct {
+		name          string
+		files         map[string]string
+		expectedSaved bool
+		expectedErr   error
+	}{
+		{"image", map[string]string{"image": png}, true, nil},
+		{"files in other fields", map[string]string{"image": png, "other": png}, true, nil},
+		{"not an image", map[string]string{"image": "<script>alert(1)</script>"}, false, ErrNotImage},
+		{"other field only", map[string]string{"other": png}, false, ErrNoFile},
+		{"no file", map[string]string{}, false, ErrNoFile},
+	}
+
+	for _, tt := range testCases {
+		dir := t.TempDir()
+
+		f, err := Image(postFiles(t, tt.files), dir, "image")
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("%s: expect error %v; got %v", tt.name, tt.expectedErr, err)
+		}
+
+		if (f != nil) != tt.expectedSaved {
+			t.Errorf("%s: expect saved %t; got %+v", tt.name, tt.expectedSaved, f)
+		}
+
+		if f != nil {
+			content, err := os.ReadFile(filepath.Join(dir, f.FileName))
+			if err != nil || string(content) != png || f.FileSize != int64(len(content)) {
+				t.Errorf("%s: expect %s to be saved in the directory; got %v", tt.name, f.FileName, err)
+			}
+
+			if f.OriginalFileName != "me.png" || f.FileName == f.OriginalFileName || filepath.Ext(f.FileName) != ".png" || f.ContentType != "image/png" {
+				t.Errorf("%s: expect a random name with the extension of the image; got %+v", tt.name, f)
+			}
+		}
+
+		// the files of the other fields, and the refused ones, are never written
+		expectedEntries := 0
+		if tt.expectedSaved {
+			expectedEntries = 1
+		}
+
+		if entries, _ := os.ReadDir(dir); len(entries) != expectedEntries {
+			t.Errorf("%s: expect %d files in the directory; got %d", tt.name, expectedEntries, len(entries))
+		}
+	}
+}
+
+func TestImage_tooLarge(t *testing.T) {
+
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	part, _ := mw.CreateFormFile("image", "large.png")
+	_, _ = part.Write([]byte(strings.Repeat("x", MaxSize)))
+	_ = mw.Close()
+
+	req := httptest.NewRequest("POST", "/", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var tooLarge *http.MaxBytesError
+	if _, err := Image(req, t.TempDir(), "image"); !errors.As(err, &tooLarge) {
+		t.Errorf("expect a body over MaxSize to be refused; got %v", err)
+	}
+}
+
+func TestImage_notMultipart(t *testing.T) {
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"file": "test.png"}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	if _, err := Image(req, t.TempDir(), "image"); err == nil {
+		t.Error("expect a request which is no multipart form to be refused")
+	}
+}
+
+func TestRemove(t *testing.T) {
+
+	dir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "outside.png")
+	_ = os.WriteFile(filepath.Join(dir, "me.png"), []byte("png"), 0644)
+	_ = os.WriteFile(outside, []byte("png"), 0644)
+
+	for _, name := range []string{"me.png", "me.png", "", "../" + filepath.Base(filepath.Dir(outside)) + "/outside.png"} {
+		if err := Remove(dir, name); err != nil {
+			t.Errorf("expect %q to be removed or missing; got %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "me.png")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("expect the file to be removed")
+	}
+
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("expect the files outside of dir to be kept; got %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package validator
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Validator applies the rules declared in `validate` struct tags. Rules are separated by commas:
+//
+//	required      the value must not be blank (strings) or zero (other types)
+//	email         the value must be an email address
+//	min=N, max=N  the length of a string, or the value of a number, must be within the bound
+//	matches=RX    the string must match the regular expression RX, which may not contain commas
+//	eqfield=F     the value must equal the value of the struct field F
+//	oneof=a b c   the value must be one of the space separated values
+//	unique=NAME   the value must not be in use, according to the UniqueFunc registered as NAME
+//
+// Empty values are only checked by required, so optional fields may be left out.
+//
+// A Validator is safe for concurrent use once all unique checks are registered.
+type Validator struct {
+	unique map[string]UniqueFunc
+	rx     sync.Map
+}
+
+// New returns a validator without any unique checks.
+func New() *Validator {
+	return &Validator{unique: map[string]UniqueFunc{}}
+}
+
+// RegisterUnique makes fn available to the unique=name rule.
+func (v *Validator) RegisterUnique(name string, fn UniqueFunc) {
+	v.unique[name] = fn
+}
+
+// Struct validates the struct s points to. Fields are reported under the name found in the
+// nameTag struct tag (e.g. "json" or "form"), or under their Go name. The returned error is
+// only set when a rule could not be evaluated, e.g. because of a malformed tag or a failing
+// unique check.
+func (v *Validator) Struct(s any, nameTag string) (Errors, error) {
+	errs := Errors{}
+
+	rv := reflect.Indirect(reflect.ValueOf(s))
+	if rv.Kind() != reflect.Struct {
+		return errs, nil
+	}
+
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+
+		tag := sf.Tag.Get("validate")
+		if tag == "" || !sf.IsExported() {
+			continue
+		}
+
+		name := fieldName(sf, nameTag)
+		value := rv.Field(i)
+
+		for _, rule := range strings.Split(tag, ",") {
+			ok, key, args, err := v.check(rv, value, rule, nameTag)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", sf.Name, err)
+			}
+
+			if !ok {
+				errs.Add(name, key, args...)
+				// one message per field is enough; the first failing rule is the most relevant one
+				break
+			}
+		}
+	}
+
+	return errs, nil
+}
+
+// check evaluates one rule against value and returns the message key and parameters on failure.
+func (v *Validator) check(parent, value reflect.Value, rule, nameTag string) (bool, string, []any, error) {
+	name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+
+	str := fmt.Sprint(value.Interface())
+	empty := value.IsZero() || (value.Kind() == reflect.String && !NotBlank(str))
+
+	if name == "required" {
+		return !empty, "form.required", nil, nil
+	}
+
+	if empty {
+		return true, "", nil, nil
+	}
+
+	switch name {
+	case "email":
+		return IsEmail(str), "form.email", nil, nil
+
+	case "min", "max":
+		bound, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return false, "", nil, fmt.Errorf("invalid %s bound %q", name, param)
+		}
+
+		if value.Kind() == reflect.String {
+			if name == "min" {
+				return MinLength(str, int(bound)), "form.min_length", []any{"min", param}, nil
+			}
+			return MaxLength(str, int(bound)), "form.max_length", []any{"max", param}, nil
+		}
+
+		n, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return false, "", nil, fmt.Errorf("%s only applies to strings and numbers", name)
+		}
+
+		if name == "min" {
+			return n >= bound, "form.min", []any{"min", param}, nil
+		}
+		return n <= bound, "form.max", []any{"max", param}, nil
+
+	case "matches":
+		rx, err := v.regexp(param)
+		if err != nil {
+			return false, "", nil, err
+		}
+		return Matches(str, rx), "form.matches", nil, nil
+
+	case "eqfield":
+		other, ok := parent.Type().FieldByName(param)
+		if !ok {
+			return false, "", nil, fmt.Errorf("unknown field %q", param)
+		}
+		otherValue := fmt.Sprint(parent.FieldByIndex(other.Index).Interface())
+		return str == otherValue, "form.equal_to", []any{"field", fieldName(other, nameTag)}, nil
+
+	case "oneof":
+		permitted := strings.Fields(param)
+		return In(str, permitted...), "form.in", []any{"values", strings.Join(permitted, ", ")}, nil
+
+	case "unique":
+		fn, ok := v.unique[param]
+		if !ok {
+			return false, "", nil, fmt.Errorf("no unique check registered as %q", param)
+		}
+		unique, err := fn(str)
+		if err != nil {
+			return false, "", nil, err
+		}
+		return unique, "form.unique", nil, nil
+	}
+
+	return false, "", nil, fmt.Errorf("unknown rule %q", name)
+}
+
+// regexp compiles pattern once and caches it
+func (v *Validator) regexp(pattern string) (*regexp.Regexp, error) {
+	if rx, ok := v.rx.Load(pattern); ok {
+		return rx.(*regexp.Regexp), nil
+	}
+
+	rx, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	v.rx.Store(pattern, rx)
+	return rx, nil
+}
+
+// Decode copies form values into the struct dst points to, using the `form` struct tag as the
+// field name. Strings, integers, floats and booleans are supported. Values that can not be
+// converted are reported in the returned Errors.
+func Decode(values url.Values, dst any) (Errors, error) {
+	errs := Errors{}
+
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("decode: expected a pointer to a struct; got %T", dst)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+
+		name, ok := sf.Tag.Lookup("form")
+		if !ok || name == "-" || !sf.IsExported() {
+			continue
+		}
+
+		if _, ok := values[name]; !ok {
+			continue
+		}
+
+		raw := strings.TrimSpace(values.Get(name))
+		field := rv.Field(i)
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(values.Get(name))
+
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if raw == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+			if err != nil {
+				errs.Add(name, "form.number")
+				continue
+			}
+			field.SetInt(n)
+
+		case reflect.Float32, reflect.Float64:
+			if raw == "" {
+				continue
+			}
+			n, err := strconv.ParseFloat(raw, field.Type().Bits())
+			if err != nil {
+				errs.Add(name, "form.number")
+				continue
+			}
+			field.SetFloat(n)
+
+		case reflect.Bool:
+			// checkboxes send "on" when ticked
+			field.SetBool(raw == "on" || raw == "true" || raw == "1")
+
+		default:
+			return nil, fmt.Errorf("decode: unsupported type %s for field %s", field.Type(), sf.Name)
+		}
+	}
+
+	return errs, nil
+}
+
+// fieldName returns the name of sf in the nameTag struct tag, or the Go field name.
+func fieldName(sf reflect.StructField, nameTag string) string {
+	if nameTag != "" {
+		name, _, _ := strings.Cut(sf.Tag.Get(nameTag), ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+
+	return sf.Name
+}
@@ -0,0 +1,117 @@
+// Package validator holds the validation rules shared by the web forms and the JSON API, so that
+// both report the same errors for the same input.
+package validator
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"webapp/pkg/i18n"
+)
+
+// EmailRX is a pragmatic email pattern, following the one recommended by the WHATWG for <input type="email">.
+var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// Errors maps a field name to its validation messages. The messages are keys with parameters,
+// so that they can be translated by the caller.
+type Errors map[string][]i18n.Message
+
+// Add a message key for field; args are name/value pairs for the message parameters.
+func (e Errors) Add(field, key string, args ...any) {
+	e[field] = append(e[field], i18n.NewMessage(key, args...))
+}
+
+// Get the first error of field, in the default language.
+func (e Errors) Get(field string) string {
+	errorSlice := e[field]
+	if len(errorSlice) == 0 {
+		return ""
+	}
+
+	return errorSlice[0].String()
+}
+
+// Translate returns the first error of field in the localizer's language.
+func (e Errors) Translate(field string, l *i18n.Localizer) string {
+	errorSlice := e[field]
+	if len(errorSlice) == 0 {
+		return ""
+	}
+
+	return l.Translate(errorSlice[0])
+}
+
+// Merge adds all errors of other to e.
+func (e Errors) Merge(other Errors) {
+	for field, messages := range other {
+		e[field] = append(e[field], messages...)
+	}
+}
+
+// Error lists every field with its first message, so that Errors can be returned as an error.
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		parts = append(parts, fmt.Sprintf("%s: %s", field, e.Get(field)))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// NotBlank reports whether value contains anything but white space.
+func NotBlank(value string) bool {
+	return strings.TrimSpace(value) != ""
+}
+
+// IsEmail reports whether value looks like an email address.
+func IsEmail(value string) bool {
+	return len(value) <= 254 && EmailRX.MatchString(value)
+}
+
+// MinLength reports whether value has at least n characters.
+func MinLength(value string, n int) bool {
+	return utf8.RuneCountInString(value) >= n
+}
+
+// MaxLength reports whether value has at most n characters.
+func MaxLength(value string, n int) bool {
+	return utf8.RuneCountInString(value) <= n
+}
+
+// InRange reports whether value is a number between min and max, inclusive.
+func InRange(value string, min, max float64) bool {
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return false
+	}
+
+	return n >= min && n <= max
+}
+
+// Matches reports whether value matches rx.
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
+
+// In reports whether value is one of the permitted values.
+func In(value string, permitted ...string) bool {
+	for _, p := range permitted {
+		if value == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UniqueFunc reports whether value is not in use yet, e.g. by looking an email address up in the database.
+type UniqueFunc func(value string) (bool, error)
@@ -0,0 +1,197 @@
+package validator
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func Test_rules(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		ok       bool
+		expected bool
+	}{
+		{"NotBlank", NotBlank("  a "), true},
+		{"NotBlank white space", NotBlank(" \t"), false},
+		{"IsEmail", IsEmail("john@doe.com"), true},
+		{"IsEmail no domain", IsEmail("john@"), false},
+		{"IsEmail no at", IsEmail("john.doe.com"), false},
+		{"MinLength counts characters", MinLength("héé", 3), true},
+		{"MinLength too short", MinLength("ab", 3), false},
+		{"MaxLength", MaxLength("abc", 3), true},
+		{"MaxLength too long", MaxLength("abcd", 3), false},
+		{"InRange", InRange("5", 1, 10), true},
+		{"InRange out of range", InRange("11", 1, 10), false},
+		{"InRange not a number", InRange("five", 1, 10), false},
+		{"Matches", Matches("abc", regexp.MustCompile("^[a-z]+$")), true},
+		{"Matches no match", Matches("ab1", regexp.MustCompile("^[a-z]+$")), false},
+		{"In", In("b", "a", "b"), true},
+		{"In not permitted", In("c", "a", "b"), false},
+	}
+
+	for _, tt := range testCases {
+		if tt.ok != tt.expected {
+			t.Errorf("%s: expect %v; got %v", tt.name, tt.expected, tt.ok)
+		}
+	}
+}
+
+type signup struct {
+	Email    string  `json:"email" validate:"required,email,unique=email"`
+	Name     string  `json:"name" validate:"min=2,max=5"`
+	Age      int     `json:"age" validate:"min=18,max=130"`
+	Code     string  `json:"code" validate:"matches=^[A-Z]{3}$"`
+	Password string  `json:"password" validate:"required"`
+	Confirm  string  `json:"confirm" validate:"eqfield=Password"`
+	Plan     string  `json:"plan" validate:"oneof=free pro"`
+	Score    float64 `json:"-" validate:"max=1"`
+}
+
+func Test_Validator_Struct(t *testing.T) {
+
+	v := New()
+	v.RegisterUnique("email", func(value string) (bool, error) {
+		return value != "taken@example.com", nil
+	})
+
+	valid := signup{Email: "jane@example.com", Name: "Jane", Age: 30, Code: "ABC", Password: "secret", Confirm: "secret", Plan: "pro"}
+
+	testCases := []struct {
+		name     string
+		modify   func(s *signup)
+		field    string
+		expected string
+	}{
+		{"valid", func(s *signup) {}, "", ""},
+		{"optional fields empty", func(s *signup) { s.Name, s.Age, s.Code, s.Confirm, s.Plan = "", 0, "", "", "" }, "", ""},
+		{"required", func(s *signup) { s.Email = " " }, "email", "form.required"},
+		{"email", func(s *signup) { s.Email = "jane" }, "email", "form.email"},
+		{"unique", func(s *signup) { s.Email = "taken@example.com" }, "email", "form.unique"},
+		{"min length", func(s *signup) { s.Name = "J" }, "name", "form.min_length"},
+		{"max length", func(s *signup) { s.Name = "Janette" }, "name", "form.max_length"},
+		{"min number", func(s *signup) { s.Age = 17 }, "age", "form.min"},
+		{"max number", func(s *signup) { s.Age = 131 }, "age", "form.max"},
+		{"matches", func(s *signup) { s.Code = "abc" }, "code", "form.matches"},
+		{"eqfield", func(s *signup) { s.Confirm = "other" }, "confirm", "form.equal_to"},
+		{"oneof", func(s *signup) { s.Plan = "gold" }, "plan", "form.in"},
+		{"go name without name tag", func(s *signup) { s.Score = 2 }, "Score", "form.max"},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid
+			tt.modify(&s)
+
+			errs, err := v.Struct(&s, "json")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.field == "" {
+				if len(errs) > 0 {
+					t.Errorf("expect no errors; got %v", errs)
+				}
+				return
+			}
+
+			if len(errs) != 1 || len(errs[tt.field]) != 1 {
+				t.Fatalf("expect exactly one error for %s; got %v", tt.field, errs)
+			}
+
+			if errs[tt.field][0].Key != tt.expected {
+				t.Errorf("expect %s; got %s", tt.expected, errs[tt.field][0].Key)
+			}
+		})
+	}
+}
+
+func Test_Validator_Struct_errors(t *testing.T) {
+
+	v := New()
+	v.RegisterUnique("failing", func(value string) (bool, error) {
+		return false, errors.New("database is down")
+	})
+
+	testCases := []struct {
+		name string
+		s    any
+	}{
+		{"unknown rule", &struct {
+			A string `validate:"shiny"`
+		}{"a"}},
+		{"bad bound", &struct {
+			A string `validate:"min=x"`
+		}{"a"}},
+		{"unknown field", &struct {
+			A string `validate:"eqfield=B"`
+		}{"a"}},
+		{"unregistered unique", &struct {
+			A string `validate:"unique=nope"`
+		}{"a"}},
+		{"failing unique", &struct {
+			A string `validate:"unique=failing"`
+		}{"a"}},
+		{"bad regexp", &struct {
+			A string `validate:"matches=("`
+		}{"a"}},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.Struct(tt.s, ""); err == nil {
+				t.Error("expect an error")
+			}
+		})
+	}
+}
+
+func Test_Decode(t *testing.T) {
+
+	var dst struct {
+		Name     string  `form:"name"`
+		Age      int     `form:"age"`
+		Ratio    float64 `form:"ratio"`
+		Remember bool    `form:"remember"`
+		Skipped  string
+	}
+
+	values := url.Values{
+		"name":     {"Jane"},
+		"age":      {"thirty"},
+		"ratio":    {"0.5"},
+		"remember": {"on"},
+		"Skipped":  {"x"},
+	}
+
+	errs, err := Decode(values, &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dst.Name != "Jane" || dst.Ratio != 0.5 || !dst.Remember || dst.Skipped != "" {
+		t.Errorf("values not decoded as expected: %+v", dst)
+	}
+
+	if errs.Get("age") != "Must be a number" {
+		t.Errorf("expect a number error for age; got %q", errs.Get("age"))
+	}
+
+	if _, err := Decode(values, dst); err == nil {
+		t.Error("expect an error when not passing a pointer")
+	}
+}
+
+func Test_Errors_Error(t *testing.T) {
+
+	errs := Errors{}
+	errs.Add("name", "form.required")
+	errs.Add("email", "form.email")
+
+	expected := "email: Enter a valid email address; name: This field cannot be blank"
+	if errs.Error() != expected {
+		t.Errorf("expect %q; got %q", expected, errs.Error())
+	}
+}
@@ -0,0 +1,10 @@
+// Package template embeds the web templates so that cmd/web can be shipped as
+// a single binary.
+package template
+
+import "embed"
+
+// Files holds every page and layout in this directory.
+//
+//go:embed *.gohtml
+var Files embed.FS